@@ -0,0 +1,135 @@
+package heic
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"slices"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+// Auxiliary types of an alpha plane, as used by HEVC and by MPEG-B (CICP) files.
+const (
+	alphaURN      = "urn:mpeg:hevc:2015:auxid:1"
+	alphaURNMPEGB = "urn:mpeg:mpegB:cicp:systems:auxiliary:alpha"
+)
+
+// isAlpha reports whether the auxiliary type aux names an alpha plane.
+func isAlpha(aux string) bool {
+	return aux == alphaURN || aux == alphaURNMPEGB
+}
+
+// fileAlpha reports whether item id (the primary image, or the sequence when 0) of f has an alpha plane,
+// and whether its colour is premultiplied.
+func fileAlpha(f *isobmff.File, id uint32) (alpha, premultiplied bool) {
+	if id == 0 && f.Movie != nil {
+		if pict := f.Movie.Track("pict"); pict != nil {
+			return alphaTrack(f.Movie, pict.ID), false
+		}
+	}
+
+	if f.Meta == nil {
+		return false, false
+	}
+	if id == 0 {
+		id = f.Meta.PrimaryItemID()
+	}
+
+	aid, prem := alphaItem(f.Meta, id)
+
+	return aid != 0, prem
+}
+
+// alphaItem returns the alpha plane of item id, resolved through the auxl references and the auxC
+// property, or 0, and whether the colour of id is premultiplied by it.
+func alphaItem(meta *isobmff.Meta, id uint32) (uint32, bool) {
+	for _, aid := range meta.ReferencedBy(id, "auxl") {
+		aux, ok := meta.ItemProperty(aid, "auxC").(*isobmff.AuxiliaryType)
+		if !ok || !isAlpha(aux.AuxType) {
+			continue
+		}
+
+		// The prem reference goes from the colour image to its alpha plane; accept the reverse as well.
+		prem := slices.Contains(meta.References(id, "prem"), aid) || slices.Contains(meta.References(aid, "prem"), id)
+
+		return aid, prem
+	}
+
+	return 0, false
+}
+
+// alphaTrack reports whether an auxv track with an alpha auxi box references track id.
+func alphaTrack(movie *isobmff.Movie, id uint32) bool {
+	for _, t := range movie.Tracks {
+		if t.HandlerType != "auxv" || t.SampleEntry == nil || t.SampleEntry.AuxType == nil || !isAlpha(t.SampleEntry.AuxType.AuxType) {
+			continue
+		}
+
+		for _, ref := range t.References {
+			if ref.Type == "auxl" && slices.Contains(ref.TrackIDs, id) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// decodeWasm decodes the primary image of data with the WASM decoder. The decoder composites the alpha
+// plane into the colour image; with a premultiplied alpha the result is returned as *image.RGBA. The decoder
+// always applies the transformations and decodes a grid in one module instance, so with IgnoreTransformations,
+// or to decode the tiles of a grid concurrently, the image is decoded as an item instead.
+func decodeWasm(ctx context.Context, data []byte, configOnly bool, opts *Options) (image.Image, image.Config, error) {
+	f, perr := isobmff.Parse(data)
+
+	if opts.IgnoreTransformations || perr == nil && !configOnly && opts.concurrency() > 1 && isGrid(f.Meta) {
+		return decodeItem(ctx, data, configOnly, opts)
+	}
+
+	if !configOnly {
+		if err := checkItemData(f, perr, len(data)); err != nil {
+			return nil, image.Config{}, err
+		}
+	}
+
+	img, cfg, err := decode(ctx, bytes.NewReader(data), configOnly, opts)
+	if err != nil {
+		return nil, cfg, err
+	}
+
+	if perr != nil {
+		return img, cfg, nil
+	}
+
+	if _, prem := fileAlpha(f, 0); prem {
+		cfg.ColorModel = color.RGBAModel
+		if img != nil {
+			img = premultiplied(img.(*image.NRGBA))
+		}
+	}
+
+	return img, cfg, nil
+}
+
+// premultiplied returns img, whose colour samples are already premultiplied by alpha, as *image.RGBA sharing its pixels.
+func premultiplied(img *image.NRGBA) *image.RGBA {
+	return &image.RGBA{Pix: img.Pix, Stride: img.Stride, Rect: img.Rect}
+}
+
+// setAlpha sets the alpha channel of img from the first channel of the decoded alpha plane a, scaled to img with
+// nearest-neighbour sampling when their sizes differ.
+func setAlpha(img, a *image.NRGBA) {
+	b, ab := img.Bounds(), a.Bounds()
+	w, h := b.Dx(), b.Dy()
+	aw, ah := ab.Dx(), ab.Dy()
+
+	for y := 0; y < h; y++ {
+		sy := y * ah / h
+		for x := 0; x < w; x++ {
+			sx := x * aw / w
+			img.Pix[img.PixOffset(b.Min.X+x, b.Min.Y+y)+3] = a.Pix[a.PixOffset(ab.Min.X+sx, ab.Min.Y+sy)]
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package heic
+
+import (
+	"bytes"
+	_ "embed"
+	"image"
+	"image/color"
+	"testing"
+)
+
+//go:embed testdata/alpha.heic
+var testAlpha []byte
+
+//go:embed testdata/alpha_prem.heic
+var testAlphaPrem []byte
+
+func TestDecodeAlpha(t *testing.T) {
+	testBackends(t, func(t *testing.T, backend Backend) {
+		img, err := DecodeWithOptions(bytes.NewReader(testAlpha), &Options{Backend: backend})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		nrgba, ok := img.(*image.NRGBA)
+		if !ok {
+			t.Fatalf("image = %T, want *image.NRGBA", img)
+		}
+		if nrgba.Rect != image.Rect(0, 0, 512, 512) {
+			t.Errorf("bounds = %v", nrgba.Rect)
+		}
+
+		opaque := true
+		for i := 3; i < len(nrgba.Pix); i += 4 {
+			if nrgba.Pix[i] != 0xff {
+				opaque = false
+				break
+			}
+		}
+		if opaque {
+			t.Error("alpha plane not applied")
+		}
+
+		cfg, err := DecodeConfigWithOptions(bytes.NewReader(testAlpha), &Options{Backend: backend})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.ColorModel != color.NRGBAModel {
+			t.Errorf("color model = %v, want NRGBA", cfg.ColorModel)
+		}
+	})
+}
+
+func TestDecodeAlphaPremultiplied(t *testing.T) {
+	testBackends(t, func(t *testing.T, backend Backend) {
+		img, err := DecodeWithOptions(bytes.NewReader(testAlphaPrem), &Options{Backend: backend})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, ok := img.(*image.RGBA)
+		if !ok {
+			t.Fatalf("image = %T, want *image.RGBA", img)
+		}
+
+		cfg, err := DecodeConfigWithOptions(bytes.NewReader(testAlphaPrem), &Options{Backend: backend})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.ColorModel != color.RGBAModel {
+			t.Errorf("color model = %v, want RGBA", cfg.ColorModel)
+		}
+
+		// The samples are stored premultiplied, so no color exceeds alpha.
+		for y := 0; y < m.Rect.Dy(); y++ {
+			for x := 0; x < m.Rect.Dx(); x++ {
+				if c := m.RGBAAt(x, y); c.R > c.A || c.G > c.A || c.B > c.A {
+					t.Fatalf("pixel %d,%d = %v, color exceeds alpha", x, y, c)
+				}
+			}
+		}
+
+		// The top right pixel is opaque magenta at 200/255.
+		if c := m.RGBAAt(127, 0); c.A != 255 || c.R < 196 || c.R > 204 || c.G > 4 || c.B < 196 || c.B > 204 {
+			t.Errorf("pixel 127,0 = %v", c)
+		}
+	})
+}
+
+func TestDecodeAlphaItem(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"straight", testAlpha},
+		{"premultiplied", testAlphaPrem},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			want, err := DecodeWithOptions(bytes.NewReader(tc.data), &Options{Backend: BackendWASM})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := DecodeWithOptions(bytes.NewReader(tc.data), &Options{Backend: BackendWASM, ItemID: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.ColorModel() != want.ColorModel() {
+				t.Fatalf("color model = %v, want %v", got.ColorModel(), want.ColorModel())
+			}
+			if !bytes.Equal(pix(got), pix(want)) {
+				t.Error("pixels differ from the primary image")
+			}
+		})
+	}
+}
+
+func TestDecodeInfo(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		data          []byte
+		alpha, prem   bool
+		width, height int
+	}{
+		{"alpha", testAlpha, true, false, 512, 512},
+		{"premultiplied", testAlphaPrem, true, true, 128, 128},
+		{"opaque", testHeic8, false, false, 512, 512},
+		{"sequence", testAnim, true, false, 176, 128},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			info, err := DecodeInfoWithOptions(bytes.NewReader(tc.data), &Options{Backend: BackendWASM})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if info.Alpha != tc.alpha || info.Premultiplied != tc.prem {
+				t.Errorf("alpha, premultiplied = %v, %v, want %v, %v", info.Alpha, info.Premultiplied, tc.alpha, tc.prem)
+			}
+			if info.Width != tc.width || info.Height != tc.height {
+				t.Errorf("size = %dx%d", info.Width, info.Height)
+			}
+		})
+	}
+
+	// The alpha plane itself has none.
+	info, err := DecodeInfoWithOptions(bytes.NewReader(testAlpha), &Options{Backend: BackendWASM, ItemID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Alpha {
+		t.Error("alpha item reports alpha")
+	}
+}
+
+func pix(img image.Image) []byte {
+	switch img := img.(type) {
+	case *image.NRGBA:
+		return img.Pix
+	case *image.RGBA:
+		return img.Pix
+	}
+
+	return nil
+}
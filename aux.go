@@ -0,0 +1,154 @@
+package heic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+// Auxiliary types of the portrait mattes of Apple cameras.
+const (
+	AuxPortraitEffectsMatte = "urn:com:apple:photo:2018:aux:portraiteffectsmatte"
+	AuxSemanticSkinMatte    = "urn:com:apple:photo:2019:aux:semanticskinmatte"
+	AuxSemanticHairMatte    = "urn:com:apple:photo:2019:aux:semantichairmatte"
+	AuxSemanticTeethMatte   = "urn:com:apple:photo:2019:aux:semanticteethmatte"
+	AuxSemanticGlassesMatte = "urn:com:apple:photo:2020:aux:semanticglassesmatte"
+)
+
+// ErrNoAux is returned by DecodeAux when the primary image has no auxiliary image of the given type.
+var ErrNoAux = errors.New("heic: no auxiliary image")
+
+// AuxImage describes an auxiliary image of the primary image, e.g. an alpha plane, a depth map or a matte.
+type AuxImage struct {
+	ID      uint32
+	Type    string // Auxiliary type URN.
+	Width   int
+	Height  int
+	Hidden  bool
+	Subtype []byte // Type-specific data of the auxC property, e.g. SEI messages.
+}
+
+// AuxImages lists the auxiliary images of the primary image, resolved through the auxl item references.
+func AuxImages(r io.Reader) ([]AuxImage, error) {
+	meta, _, err := readMeta(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return auxImages(meta, meta.PrimaryItemID()), nil
+}
+
+// DecodeAux decodes the first auxiliary image of the primary image with the auxiliary type auxType as
+// *image.Gray, or returns ErrNoAux if there is none.
+func DecodeAux(r io.Reader, auxType string) (*image.Gray, error) {
+	return decodeAuxType(context.Background(), r, auxType, nil)
+}
+
+// DecodeAuxWithOptions is like DecodeAux using the backend, limits and transformations of opts; the auxiliary
+// image is always decoded as 8-bit gray without colour conversion.
+func DecodeAuxWithOptions(r io.Reader, auxType string, opts *Options) (*image.Gray, error) {
+	return decodeAuxType(context.Background(), r, auxType, opts)
+}
+
+// DecodeAuxWithOptionsContext is like DecodeAuxWithOptions, but aborts the decoding and returns ctx.Err() when
+// ctx is done, as DecodeContext does.
+func DecodeAuxWithOptionsContext(ctx context.Context, r io.Reader, auxType string, opts *Options) (*image.Gray, error) {
+	return decodeAuxType(ctx, r, auxType, opts)
+}
+
+// DecodeAuxImages decodes the auxiliary images of the primary image other than its alpha plane as *image.Gray,
+// by auxiliary type, e.g. mattes and depth maps. Of several images with the same type, the first one is
+// returned; one that fails to decode is left out, as it may use a coding the decoder does not support.
+func DecodeAuxImages(r io.Reader) (map[string]*image.Gray, error) {
+	return decodeAux(context.Background(), r, "", nil)
+}
+
+// DecodeAuxImagesWithOptions is like DecodeAuxImages using the backend, limits and transformations of opts, as
+// DecodeAuxWithOptions does.
+func DecodeAuxImagesWithOptions(r io.Reader, opts *Options) (map[string]*image.Gray, error) {
+	return decodeAux(context.Background(), r, "", opts)
+}
+
+// DecodeAuxImagesWithOptionsContext is like DecodeAuxImagesWithOptions, but aborts the decoding and returns
+// ctx.Err() when ctx is done, as DecodeContext does.
+func DecodeAuxImagesWithOptionsContext(ctx context.Context, r io.Reader, opts *Options) (map[string]*image.Gray, error) {
+	return decodeAux(ctx, r, "", opts)
+}
+
+// decodeAuxType decodes the first auxiliary image of the primary image with type auxType.
+func decodeAuxType(ctx context.Context, r io.Reader, auxType string, opts *Options) (*image.Gray, error) {
+	images, err := decodeAux(ctx, r, auxType, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	img, ok := images[auxType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoAux, auxType)
+	}
+
+	return img, nil
+}
+
+// decodeAux decodes the auxiliary images of the primary image with type auxType, or all but alpha planes that
+// decode when empty.
+func decodeAux(ctx context.Context, r io.Reader, auxType string, opts *Options) (map[string]*image.Gray, error) {
+	if opts == nil {
+		opts = defaultOptions
+	}
+
+	data, err := opts.readInput(r)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := isobmff.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("heic: %w", err)
+	}
+	if f.Meta == nil {
+		return nil, ErrNoMeta
+	}
+
+	images := make(map[string]*image.Gray)
+	for _, aux := range auxImages(f.Meta, f.Meta.PrimaryItemID()) {
+		if _, ok := images[aux.Type]; ok || (auxType != "" && aux.Type != auxType) || (auxType == "" && isAlpha(aux.Type)) {
+			continue
+		}
+
+		img, err := decodeImage(ctx, bytes.NewReader(data), opts.auxOptions(aux.ID, FormatGray, 0))
+		switch {
+		case err == nil:
+			images[aux.Type] = img.(*image.Gray)
+		case auxType != "" || ctx.Err() != nil:
+			return nil, err
+		}
+	}
+
+	return images, nil
+}
+
+// auxImages returns the auxiliary images of item id of meta.
+func auxImages(meta *isobmff.Meta, id uint32) []AuxImage {
+	var out []AuxImage
+
+	for _, aid := range meta.ReferencedBy(id, "auxl") {
+		e := meta.Item(aid)
+		aux, ok := meta.ItemProperty(aid, "auxC").(*isobmff.AuxiliaryType)
+		if e == nil || !ok || !imageItemTypes[e.ItemType] {
+			continue
+		}
+
+		a := AuxImage{ID: aid, Type: aux.AuxType, Hidden: e.Hidden(), Subtype: aux.Subtype}
+		a.Width, a.Height = itemSize(meta, aid)
+
+		out = append(out, a)
+	}
+
+	return out
+}
@@ -0,0 +1,128 @@
+package heic
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"image"
+	"testing"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+//go:embed testdata/mattes.heic
+var testMattes []byte
+
+func TestAuxImages(t *testing.T) {
+	aux, err := AuxImages(bytes.NewReader(testMattes))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{AuxPortraitEffectsMatte, AuxSemanticSkinMatte, AuxSemanticHairMatte}
+	if len(aux) != len(want) {
+		t.Fatalf("aux images = %+v", aux)
+	}
+	for i, a := range aux {
+		if a.ID != uint32(i+2) || a.Type != want[i] || a.Width != 176 || a.Height != 128 || !a.Hidden {
+			t.Errorf("aux image %d = %+v", i, a)
+		}
+	}
+
+	// The alpha plane is an auxiliary image too.
+	aux, err = AuxImages(bytes.NewReader(testAlpha))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aux) != 1 || aux[0].Type != alphaURN {
+		t.Errorf("alpha aux images = %+v", aux)
+	}
+
+	items, err := Items(bytes.NewReader(testMattes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items[0].AuxiliaryOf != 0 || items[0].AuxType != "" || items[2].AuxiliaryOf != 1 || items[2].AuxType != AuxSemanticSkinMatte {
+		t.Errorf("items = %+v", items)
+	}
+}
+
+func TestDecodeAux(t *testing.T) {
+	img, err := DecodeAux(bytes.NewReader(testMattes), AuxSemanticHairMatte)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Rect != image.Rect(0, 0, 176, 128) {
+		t.Errorf("bounds %v", img.Rect)
+	}
+
+	if _, err := DecodeAux(bytes.NewReader(testMattes), AuxSemanticTeethMatte); !errors.Is(err, ErrNoAux) {
+		t.Errorf("err = %v, want ErrNoAux", err)
+	}
+
+	images, err := DecodeAuxImages(bytes.NewReader(testMattes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 3 || !bytes.Equal(images[AuxSemanticHairMatte].Pix, img.Pix) {
+		t.Errorf("aux images = %d", len(images))
+	}
+
+	// The alpha plane is left out.
+	if images, err := DecodeAuxImages(bytes.NewReader(testAlpha)); err != nil || len(images) != 0 {
+		t.Errorf("alpha aux images = %d, %v", len(images), err)
+	}
+}
+
+func TestDecodeAuxImagesError(t *testing.T) {
+	f, err := isobmff.Parse(testMattes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc := f.Meta.ItemLocation(3)
+
+	// A skin matte that does not decode does not hide the others.
+	data := bytes.Clone(testMattes)
+	off := loc.BaseOffset + loc.Extents[0].Offset
+	for i := off + 6; i < off+loc.Extents[0].Length; i++ {
+		data[i] = 0xff
+	}
+
+	if _, err := DecodeAux(bytes.NewReader(data), AuxSemanticSkinMatte); err == nil {
+		t.Error("skin matte: no error")
+	}
+
+	images, err := DecodeAuxImages(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := images[AuxSemanticSkinMatte]; len(images) != 2 || ok {
+		t.Errorf("aux images = %d", len(images))
+	}
+}
+
+func TestDecodeAuxWithOptions(t *testing.T) {
+	// The limits apply to the 176x128 mattes, not to the 512x512 primary image.
+	opts := &Options{Backend: BackendWASM, MaxPixels: 176 * 128}
+	if _, err := DecodeAuxWithOptions(bytes.NewReader(testMattes), AuxSemanticHairMatte, opts); err != nil {
+		t.Fatal(err)
+	}
+	if images, err := DecodeAuxImagesWithOptions(bytes.NewReader(testMattes), opts); err != nil || len(images) != 3 {
+		t.Errorf("got %d images, %v", len(images), err)
+	}
+
+	opts.MaxPixels--
+	if _, err := DecodeAuxWithOptions(bytes.NewReader(testMattes), AuxSemanticHairMatte, opts); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("got %v, want %v", err, ErrLimitExceeded)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := DecodeAuxWithOptionsContext(ctx, bytes.NewReader(testMattes), AuxSemanticHairMatte, opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if _, err := DecodeAuxImagesWithOptionsContext(ctx, bytes.NewReader(testMattes), opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
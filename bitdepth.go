@@ -0,0 +1,96 @@
+package heic
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+// widen scales a sample of depth bits to 16 bits, replicating the high bits into the low ones.
+func widen(v uint16, depth int) uint16 {
+	if depth >= 16 || depth <= 0 {
+		return v
+	}
+
+	return v<<(16-depth) | v>>(2*depth-16)
+}
+
+// gray16 returns the host-endian plane pix of depth-bit samples as *image.Gray16.
+func gray16(pix []byte, stride int, rect image.Rectangle, depth int) *image.Gray16 {
+	img := image.NewGray16(rect)
+	w, h := rect.Dx(), rect.Dy()
+
+	for y := 0; y < h; y++ {
+		src := pix[y*stride:]
+		dst := img.Pix[y*img.Stride:]
+		for x := 0; x < w; x++ {
+			binary.BigEndian.PutUint16(dst[2*x:], widen(binary.NativeEndian.Uint16(src[2*x:]), depth))
+		}
+	}
+
+	return img
+}
+
+// rgba64 returns the big-endian interleaved RRGGBBAA plane pix of depth-bit samples as *image.NRGBA64,
+// or as *image.RGBA64 when the colour is premultiplied.
+func rgba64(pix []byte, stride int, rect image.Rectangle, depth int, premultiplied bool) image.Image {
+	var out []byte
+	var outStride int
+	var img image.Image
+
+	if premultiplied {
+		i := image.NewRGBA64(rect)
+		out, outStride, img = i.Pix, i.Stride, i
+	} else {
+		i := image.NewNRGBA64(rect)
+		out, outStride, img = i.Pix, i.Stride, i
+	}
+
+	w, h := rect.Dx(), rect.Dy()
+	for y := 0; y < h; y++ {
+		src := pix[y*stride:]
+		dst := out[y*outStride:]
+		for i := 0; i < w*8; i += 2 {
+			binary.BigEndian.PutUint16(dst[i:], widen(binary.BigEndian.Uint16(src[i:]), depth))
+		}
+	}
+
+	return img
+}
+
+// bitDepth returns the luma bit depth coded in the hvcC of item id, or of the first tile of a grid, or 0.
+func bitDepth(meta *isobmff.Meta, id uint32) int {
+	if e := meta.Item(id); e != nil && e.ItemType == "grid" {
+		tiles := meta.References(id, "dimg")
+		if len(tiles) == 0 {
+			return 0
+		}
+		id = tiles[0]
+	}
+
+	if hvcC, ok := meta.ItemProperty(id, "hvcC").(*isobmff.HEVCConfig); ok {
+		return hvcC.BitDepthLuma
+	}
+
+	return 0
+}
+
+// wasmBitDepth returns ErrUnsupported when opts asks for 16-bit samples of an image in data coded with more
+// than 8 bits, which the WASM decoder only outputs as 8-bit samples.
+func wasmBitDepth(data []byte, opts *Options) error {
+	if opts.BitDepth != 16 {
+		return nil
+	}
+
+	f, err := isobmff.Parse(data)
+	if err != nil {
+		return nil
+	}
+	if depth := fileBitDepth(f, opts.ItemID); depth > 8 {
+		return fmt.Errorf("heic: 16-bit samples of a %d-bit image need libheif: %w", depth, ErrUnsupported)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package heic
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestWiden(t *testing.T) {
+	for _, tt := range []struct {
+		v     uint16
+		depth int
+		want  uint16
+	}{
+		{0, 12, 0},
+		{0xfff, 12, 0xffff},
+		{0x800, 12, 0x8008},
+		{0x3ff, 10, 0xffff},
+		{0x200, 10, 0x8020},
+		{0xabcd, 16, 0xabcd},
+	} {
+		if got := widen(tt.v, tt.depth); got != tt.want {
+			t.Errorf("widen(%#x, %d) = %#x, want %#x", tt.v, tt.depth, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeHighBitDepth(t *testing.T) {
+	testBackends(t, func(t *testing.T, backend Backend) {
+		for _, tt := range []struct {
+			opts Options
+			want image.Image
+		}{
+			{Options{BitDepth: 16}, &image.NRGBA64{}},
+			{Options{Format: FormatNRGBA, BitDepth: 16}, &image.NRGBA64{}},
+			{Options{Format: FormatGray, BitDepth: 16}, &image.Gray16{}},
+		} {
+			opts := tt.opts
+			opts.Backend = backend
+
+			// The WASM decoder only outputs 8 bits, which it does not pass off as 12.
+			if backend == BackendWASM {
+				if _, err := DecodeWithOptions(bytes.NewReader(testHeic12), &opts); !errors.Is(err, ErrUnsupported) {
+					t.Errorf("format=%d: err = %v, want ErrUnsupported", opts.Format, err)
+				}
+				if _, err := DecodeConfigWithOptions(bytes.NewReader(testHeic12), &opts); !errors.Is(err, ErrUnsupported) {
+					t.Errorf("format=%d: config err = %v, want ErrUnsupported", opts.Format, err)
+				}
+				continue
+			}
+
+			img, err := DecodeWithOptions(bytes.NewReader(testHeic12), &opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if g, w := reflect.TypeOf(img), reflect.TypeOf(tt.want); g != w {
+				t.Errorf("format=%d: got %v, want %v", opts.Format, g, w)
+			}
+
+			cfg, err := DecodeConfigWithOptions(bytes.NewReader(testHeic12), &opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.ColorModel != img.ColorModel() {
+				t.Errorf("format=%d: config color model does not match image", opts.Format)
+			}
+		}
+	})
+
+	// libheif keeps the 12 bits, so the low byte of a sample is not a copy of the high byte.
+	t.Run("precision", func(t *testing.T) {
+		requireDynamic(t)
+
+		img, err := DecodeWithOptions(bytes.NewReader(testHeic12), &Options{Backend: BackendDynamic, BitDepth: 16})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pix := img.(*image.NRGBA64).Pix
+		widened := true
+		for i := 0; i < len(pix); i += 2 {
+			if pix[i] != pix[i+1] {
+				widened = false
+				break
+			}
+		}
+		if widened {
+			t.Error("12-bit samples decoded as 8 bits")
+		}
+
+		// The 8-bit decode is the same image.
+		img8, err := DecodeWithOptions(bytes.NewReader(testHeic12), &Options{Backend: BackendDynamic, Format: FormatNRGBA})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c8 := img8.At(100, 100).(color.NRGBA)
+		c16 := img.At(100, 100).(color.NRGBA64)
+		if d := int(c8.G) - int(c16.G>>8); d < -1 || d > 1 {
+			t.Errorf("green = %d (8-bit), %d (16-bit)", c8.G, c16.G)
+		}
+	})
+}
+
+func TestDecodeInfoBitDepth(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"8-bit", testHeic8, 8},
+		{"12-bit", testHeic12, 12},
+		{"grid", testHeic, 8},
+		{"sequence", testAnim, 8},
+	} {
+		info, err := DecodeInfoWithOptions(bytes.NewReader(tt.data), &Options{Backend: BackendWASM})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.BitDepth != tt.want {
+			t.Errorf("%s: bit depth = %d, want %d", tt.name, info.BitDepth, tt.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package heic
+
+import (
+	"io"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+// ColorInfo is the colour information of an image from its colr properties. An image may have
+// an ICC profile, nclx parameters, both or neither.
+type ColorInfo struct {
+	ICC        []byte // ICC profile of a prof or rICC colr property, or nil.
+	Restricted bool   // The ICC profile is a restricted one (rICC).
+
+	NCLX *NCLX // nclx colr property, or nil.
+}
+
+// NCLX holds the colour parameters of an nclx colr property, coded as in ITU-T H.273.
+type NCLX struct {
+	ColorPrimaries          uint16 // e.g. 1 BT.709, 9 BT.2020, 12 Display P3.
+	TransferCharacteristics uint16 // e.g. 13 sRGB, 16 PQ, 18 HLG.
+	MatrixCoefficients      uint16 // e.g. 1 BT.709, 6 BT.601, 9 BT.2020.
+	FullRange               bool
+}
+
+// DecodeColorInfo returns the colour information of the primary image without decoding it.
+// A grid without colr properties takes those of its first tile.
+func DecodeColorInfo(r io.Reader) (ColorInfo, error) {
+	meta, _, err := readMeta(r)
+	if err != nil {
+		return ColorInfo{}, err
+	}
+
+	return colorInfo(meta, meta.PrimaryItemID()), nil
+}
+
+// colorInfo returns the colour information of item id, or of the first tile of a grid without any.
+func colorInfo(meta *isobmff.Meta, id uint32) ColorInfo {
+	var ci ColorInfo
+	var found bool
+
+	for _, p := range meta.ItemProperties(id) {
+		c, ok := p.(*isobmff.ColourInformation)
+		if !ok {
+			continue
+		}
+
+		switch c.ColourType {
+		case isobmff.ColourTypeICC, isobmff.ColourTypeRICC:
+			if ci.ICC == nil {
+				ci.ICC = c.ICC
+				ci.Restricted = c.ColourType == isobmff.ColourTypeRICC
+				found = true
+			}
+		case isobmff.ColourTypeNCLX:
+			if ci.NCLX == nil {
+				ci.NCLX = &NCLX{
+					ColorPrimaries:          c.ColourPrimaries,
+					TransferCharacteristics: c.TransferCharacteristics,
+					MatrixCoefficients:      c.MatrixCoefficients,
+					FullRange:               c.FullRange,
+				}
+				found = true
+			}
+		}
+	}
+
+	if !found {
+		if e := meta.Item(id); e != nil && e.ItemType == "grid" {
+			if tiles := meta.References(id, "dimg"); len(tiles) > 0 {
+				return colorInfo(meta, tiles[0])
+			}
+		}
+	}
+
+	return ci
+}
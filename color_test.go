@@ -0,0 +1,47 @@
+package heic
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"testing"
+)
+
+//go:embed testdata/p3.heic
+var testP3 []byte
+
+func TestDecodeColorInfo(t *testing.T) {
+	ci, err := DecodeColorInfo(bytes.NewReader(testP3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ci.ICC) != 452 || string(ci.ICC[36:40]) != "acsp" || ci.Restricted {
+		t.Errorf("ICC = %d bytes, restricted %v", len(ci.ICC), ci.Restricted)
+	}
+	want := NCLX{ColorPrimaries: 12, TransferCharacteristics: 13, MatrixCoefficients: 6, FullRange: true}
+	if ci.NCLX == nil || *ci.NCLX != want {
+		t.Errorf("nclx = %+v, want %+v", ci.NCLX, want)
+	}
+
+	// The grid of test.heic has its own nclx.
+	ci, err = DecodeColorInfo(bytes.NewReader(testHeic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ci.ICC != nil || ci.NCLX == nil || ci.NCLX.MatrixCoefficients != 6 {
+		t.Errorf("grid = %+v", ci)
+	}
+
+	ci, err = DecodeColorInfo(bytes.NewReader(testHeic8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ci.ICC != nil || ci.NCLX != nil {
+		t.Errorf("no colr = %+v", ci)
+	}
+
+	if _, err := DecodeColorInfo(bytes.NewReader(testAnim)); !errors.Is(err, ErrNoMeta) {
+		t.Errorf("sequence: err = %v, want ErrNoMeta", err)
+	}
+}
@@ -0,0 +1,519 @@
+package heic
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+type mat3 [3][3]float64
+
+func (m mat3) mul(n mat3) mat3 {
+	var r mat3
+	for i := range 3 {
+		for j := range 3 {
+			r[i][j] = m[i][0]*n[0][j] + m[i][1]*n[1][j] + m[i][2]*n[2][j]
+		}
+	}
+
+	return r
+}
+
+func (m mat3) apply(v [3]float64) [3]float64 {
+	return [3]float64{
+		m[0][0]*v[0] + m[0][1]*v[1] + m[0][2]*v[2],
+		m[1][0]*v[0] + m[1][1]*v[1] + m[1][2]*v[2],
+		m[2][0]*v[0] + m[2][1]*v[1] + m[2][2]*v[2],
+	}
+}
+
+func (m mat3) inverse() mat3 {
+	a, b, c := m[0][0], m[0][1], m[0][2]
+	d, e, f := m[1][0], m[1][1], m[1][2]
+	g, h, i := m[2][0], m[2][1], m[2][2]
+
+	det := a*(e*i-f*h) - b*(d*i-f*g) + c*(d*h-e*g)
+
+	return mat3{
+		{(e*i - f*h) / det, (c*h - b*i) / det, (b*f - c*e) / det},
+		{(f*g - d*i) / det, (a*i - c*g) / det, (c*d - a*f) / det},
+		{(d*h - e*g) / det, (b*g - a*h) / det, (a*e - b*d) / det},
+	}
+}
+
+// xy is a CIE 1931 chromaticity.
+type xy struct{ x, y float64 }
+
+func (c xy) xyz() [3]float64 {
+	return [3]float64{c.x / c.y, 1, (1 - c.x - c.y) / c.y}
+}
+
+var (
+	whiteD65 = xy{0.3127, 0.3290}
+	whiteD50 = [3]float64{0.9642, 1, 0.8249} // The ICC profile connection space illuminant.
+)
+
+// bradford is the cone response matrix of the Bradford chromatic adaptation.
+var bradford = mat3{
+	{0.8951, 0.2664, -0.1614},
+	{-0.7502, 1.7135, 0.0367},
+	{0.0389, -0.0685, 1.0296},
+}
+
+// adaptD50 returns the Bradford adaptation from white w to the D50 illuminant.
+func adaptD50(w [3]float64) mat3 {
+	s, d := bradford.apply(w), bradford.apply(whiteD50)
+
+	return bradford.inverse().mul(mat3{{d[0] / s[0], 0, 0}, {0, d[1] / s[1], 0}, {0, 0, d[2] / s[2]}}).mul(bradford)
+}
+
+// rgbSpace is an RGB colour space: linear RGB to D50 XYZ, and its transfer functions.
+type rgbSpace struct {
+	toPCS      mat3
+	toLinear   [3]func(float64) float64
+	fromLinear func(float64) float64 // nil for a profile that is only converted from.
+}
+
+// primaries returns the linear RGB to D50 XYZ matrix of red, green and blue primaries and a white point.
+func primaries(r, g, b, w xy) mat3 {
+	p := mat3{}
+	for i, c := range []xy{r, g, b} {
+		v := c.xyz()
+		for j := range 3 {
+			p[j][i] = v[j]
+		}
+	}
+
+	// Scale the primaries so that they add up to the white point.
+	s := p.inverse().apply(w.xyz())
+	for i := range 3 {
+		for j := range 3 {
+			p[j][i] *= s[i]
+		}
+	}
+
+	return adaptD50(w.xyz()).mul(p)
+}
+
+// Primaries by ITU-T H.273 colour_primaries code.
+var nclxPrimaries = map[uint16]mat3{
+	1:  primaries(xy{0.640, 0.330}, xy{0.300, 0.600}, xy{0.150, 0.060}, whiteD65),
+	4:  primaries(xy{0.67, 0.33}, xy{0.21, 0.71}, xy{0.14, 0.08}, xy{0.310, 0.316}),
+	5:  primaries(xy{0.64, 0.33}, xy{0.29, 0.60}, xy{0.15, 0.06}, whiteD65),
+	6:  primaries(xy{0.630, 0.340}, xy{0.310, 0.595}, xy{0.155, 0.070}, whiteD65),
+	7:  primaries(xy{0.630, 0.340}, xy{0.310, 0.595}, xy{0.155, 0.070}, whiteD65),
+	9:  primaries(xy{0.708, 0.292}, xy{0.170, 0.797}, xy{0.131, 0.046}, whiteD65),
+	11: primaries(xy{0.680, 0.320}, xy{0.265, 0.690}, xy{0.150, 0.060}, xy{0.314, 0.351}),
+	12: primaries(xy{0.680, 0.320}, xy{0.265, 0.690}, xy{0.150, 0.060}, whiteD65),
+}
+
+// transfer is a pair of transfer functions, from encoded to linear values and back.
+type transfer struct {
+	toLinear, fromLinear func(float64) float64
+}
+
+func gamma(g float64) transfer {
+	return transfer{
+		func(v float64) float64 { return math.Pow(max(v, 0), g) },
+		func(v float64) float64 { return math.Pow(max(v, 0), 1/g) },
+	}
+}
+
+var (
+	transferSRGB = transfer{
+		func(v float64) float64 {
+			if v <= 0.04045 {
+				return v / 12.92
+			}
+			return math.Pow((v+0.055)/1.055, 2.4)
+		},
+		func(v float64) float64 {
+			if v <= 0.0031308 {
+				return v * 12.92
+			}
+			return 1.055*math.Pow(v, 1/2.4) - 0.055
+		},
+	}
+
+	transferBT709 = transfer{
+		func(v float64) float64 {
+			if v < 0.081 {
+				return v / 4.5
+			}
+			return math.Pow((v+0.099)/1.099, 1/0.45)
+		},
+		func(v float64) float64 {
+			if v < 0.018 {
+				return v * 4.5
+			}
+			return 1.099*math.Pow(v, 0.45) - 0.099
+		},
+	}
+
+	transferLinear = transfer{
+		func(v float64) float64 { return v },
+		func(v float64) float64 { return v },
+	}
+)
+
+// Transfer functions by ITU-T H.273 transfer_characteristics code.
+var nclxTransfers = map[uint16]transfer{
+	1:  transferBT709,
+	4:  gamma(2.2),
+	5:  gamma(2.8),
+	6:  transferBT709,
+	8:  transferLinear,
+	13: transferSRGB,
+	14: transferBT709,
+	15: transferBT709,
+	16: transferPQ,
+	18: transferHLG,
+}
+
+func newSpace(m mat3, t transfer) *rgbSpace {
+	return &rgbSpace{toPCS: m, toLinear: [3]func(float64) float64{t.toLinear, t.toLinear, t.toLinear}, fromLinear: t.fromLinear}
+}
+
+// targetSpace returns the colour space cs.
+func targetSpace(cs ColorSpace) *rgbSpace {
+	switch cs {
+	case ColorSpaceSRGB:
+		return newSpace(nclxPrimaries[1], transferSRGB)
+	case ColorSpaceLinearSRGB:
+		return newSpace(nclxPrimaries[1], transferLinear)
+	case ColorSpaceDisplayP3:
+		return newSpace(nclxPrimaries[12], transferSRGB)
+	case ColorSpaceBT2020:
+		return newSpace(nclxPrimaries[9], transferBT709)
+	}
+
+	return nil
+}
+
+// sourceSpace returns the colour space of an image from its ICC profile, or else its nclx parameters, and
+// the nclx-coded primaries and transfer it matches, if any. It returns nil when the colour space is unknown
+// or not supported, e.g. a profile with lookup tables only.
+func sourceSpace(ci ColorInfo) (*rgbSpace, uint16, uint16) {
+	if ci.ICC != nil {
+		if s, err := parseICC(ci.ICC); err == nil {
+			return s, 0, 0
+		}
+	}
+
+	// Images without colour information, and unspecified (2) parameters, are taken as sRGB.
+	var p, t uint16 = 1, 13
+	switch {
+	case ci.NCLX != nil:
+		p, t = ci.NCLX.ColorPrimaries, ci.NCLX.TransferCharacteristics
+	case ci.ICC != nil:
+		return nil, 0, 0
+	}
+
+	if p == 2 {
+		p = 1
+	}
+	if t == 2 {
+		t = 13
+	}
+
+	m, ok := nclxPrimaries[p]
+	if !ok {
+		return nil, 0, 0
+	}
+	tf, ok := nclxTransfers[t]
+	if !ok {
+		return nil, 0, 0
+	}
+
+	return newSpace(m, tf), p, t
+}
+
+// nclxOf returns the nclx primaries and transfer codes of the colour space cs.
+func nclxOf(cs ColorSpace) (uint16, uint16) {
+	switch cs {
+	case ColorSpaceSRGB:
+		return 1, 13
+	case ColorSpaceLinearSRGB:
+		return 1, 8
+	case ColorSpaceDisplayP3:
+		return 12, 13
+	case ColorSpaceBT2020:
+		return 9, 1
+	}
+
+	return 0, 0
+}
+
+// itemColorInfo returns the colour information of image item id of f, the primary image when 0, or of
+// the visual track of a sequence.
+func itemColorInfo(f *isobmff.File, id uint32, sequence bool) ColorInfo {
+	if sequence {
+		var ci ColorInfo
+		if pict := f.Movie.Track("pict"); pict != nil && pict.SampleEntry != nil {
+			for _, c := range pict.SampleEntry.Colours {
+				switch {
+				case c.ColourType == isobmff.ColourTypeNCLX && ci.NCLX == nil:
+					ci.NCLX = &NCLX{c.ColourPrimaries, c.TransferCharacteristics, c.MatrixCoefficients, c.FullRange}
+				case c.ColourType != isobmff.ColourTypeNCLX && ci.ICC == nil:
+					ci.ICC, ci.Restricted = c.ICC, c.ColourType == isobmff.ColourTypeRICC
+				}
+			}
+		}
+		return ci
+	}
+
+	if f.Meta == nil {
+		return ColorInfo{}
+	}
+	if id == 0 {
+		id = f.Meta.PrimaryItemID()
+	}
+
+	return colorInfo(f.Meta, id)
+}
+
+// colorConverter returns the converter of the colours of the image in data as selected by opts: to
+// opts.ColorSpace, with HDR images tone mapped by opts.ToneMapping, or to linear light for FormatLinear.
+// It returns nil when there is no conversion to do.
+func colorConverter(data []byte, sequence bool, opts *Options) *converter {
+	linear := opts.Format == FormatLinear
+	if opts.ColorSpace == ColorSpaceNone && opts.ToneMapping == ToneMapNone && !linear {
+		return nil
+	}
+
+	var src *rgbSpace
+	var p, t uint16
+	if f, err := isobmff.Parse(data); err == nil {
+		src, p, t = sourceSpace(itemColorInfo(f, opts.ItemID, sequence))
+	}
+	if src == nil {
+		if !linear {
+			return nil
+		}
+		src, p, t = targetSpace(ColorSpaceSRGB), 1, 13
+	}
+
+	hdr := isHDR(t)
+	cs := opts.ColorSpace
+
+	switch {
+	case linear:
+	case hdr && opts.ToneMapping == ToneMapNone:
+		// HDR images are only converted when tone mapped.
+		return nil
+	case hdr && cs == ColorSpaceNone:
+		cs = ColorSpaceSRGB
+	case !hdr:
+		if tp, tt := nclxOf(cs); cs == ColorSpaceNone || p == tp && t == tt {
+			return nil
+		}
+	}
+
+	var dst *rgbSpace
+	switch {
+	case linear && cs == ColorSpaceNone:
+		dst = newSpace(src.toPCS, transferLinear)
+	case linear:
+		dst = newSpace(targetSpace(cs).toPCS, transferLinear)
+	default:
+		dst = targetSpace(cs)
+	}
+
+	c := newConverter(src, dst)
+	if hdr {
+		c.hdr = true
+		c.tone = toneMap(opts.ToneMapping, dst)
+		if t == 18 {
+			c.ootf = hlgOOTF(src)
+		}
+	}
+
+	return c
+}
+
+// converter converts colours between two RGB colour spaces through linear light.
+type converter struct {
+	m    mat3 // Linear source RGB to linear target RGB.
+	src  *rgbSpace
+	dst  *rgbSpace
+	hdr  bool                        // The source has an HDR transfer function.
+	ootf func([3]float64) [3]float64 // Scene to display light of an HLG source, or nil.
+	tone func([3]float64) [3]float64 // Tone mapping operator on linear target RGB, or nil.
+
+	in8  [3][256]float64
+	in16 *[3][65536]float32
+	out  []uint16 // Linear values in 1/65535 steps to encoded 16-bit values.
+}
+
+func newConverter(src, dst *rgbSpace) *converter {
+	c := &converter{m: dst.toPCS.inverse().mul(src.toPCS), src: src, dst: dst}
+
+	for ch := range 3 {
+		for i := range 256 {
+			c.in8[ch][i] = src.toLinear[ch](float64(i) / 255)
+		}
+	}
+
+	c.out = make([]uint16, 65536)
+	for i := range c.out {
+		c.out[i] = uint16(math.Round(min(max(dst.fromLinear(float64(i)/65535), 0), 1) * 65535))
+	}
+
+	return c
+}
+
+func (c *converter) table16() *[3][65536]float32 {
+	if c.in16 == nil {
+		c.in16 = new([3][65536]float32)
+		for ch := range 3 {
+			for i := range 65536 {
+				c.in16[ch][i] = float32(c.src.toLinear[ch](float64(i) / 65535))
+			}
+		}
+	}
+
+	return c.in16
+}
+
+// encode returns the 16-bit encoded value of linear v.
+func (c *converter) encode(v float64) uint16 {
+	return c.out[int(min(max(v, 0), 1)*65535+0.5)]
+}
+
+// apply returns linear source RGB as linear target RGB.
+func (c *converter) apply(lin [3]float64) [3]float64 {
+	if c.ootf != nil {
+		lin = c.ootf(lin)
+	}
+
+	v := c.m.apply(lin)
+	if c.tone != nil {
+		v = c.tone(v)
+	}
+
+	return v
+}
+
+func (c *converter) rgb(lin [3]float64) (uint16, uint16, uint16) {
+	v := c.apply(lin)
+
+	return c.encode(v[0]), c.encode(v[1]), c.encode(v[2])
+}
+
+func to8(v uint16) uint8 {
+	return uint8((uint32(v) + 128) / 257)
+}
+
+// convert converts the colours of img. NRGBA, RGBA and gray images are converted in place; other
+// images, e.g. YCbCr, are returned as *image.NRGBA or *image.NRGBA64.
+func (c *converter) convert(img image.Image) image.Image {
+	switch img := img.(type) {
+	case *image.NRGBA:
+		c.convert8(img.Pix, img.Stride, img.Rect, false)
+		return img
+	case *image.RGBA:
+		c.convert8(img.Pix, img.Stride, img.Rect, true)
+		return img
+	case *image.NRGBA64:
+		c.convert16(img.Pix, img.Stride, img.Rect, false)
+		return img
+	case *image.RGBA64:
+		c.convert16(img.Pix, img.Stride, img.Rect, true)
+		return img
+	case *image.Gray:
+		for i, v := range img.Pix {
+			r, _, _ := c.rgb([3]float64{c.in8[0][v], c.in8[1][v], c.in8[2][v]})
+			img.Pix[i] = to8(r)
+		}
+		return img
+	case *image.Gray16:
+		t := c.table16()
+		for i := 0; i+1 < len(img.Pix); i += 2 {
+			v := uint16(img.Pix[i])<<8 | uint16(img.Pix[i+1])
+			r, _, _ := c.rgb([3]float64{float64(t[0][v]), float64(t[1][v]), float64(t[2][v])})
+			img.Pix[i], img.Pix[i+1] = uint8(r>>8), uint8(r)
+		}
+		return img
+	}
+
+	var dst draw.Image
+	if img.ColorModel() == color.NRGBA64Model || img.ColorModel() == color.RGBA64Model || img.ColorModel() == color.Gray16Model {
+		dst = image.NewNRGBA64(img.Bounds())
+	} else {
+		dst = image.NewNRGBA(img.Bounds())
+	}
+	draw.Draw(dst, img.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	return c.convert(dst)
+}
+
+func (c *converter) convert8(pix []byte, stride int, rect image.Rectangle, premultiplied bool) {
+	w, h := rect.Dx(), rect.Dy()
+
+	for y := 0; y < h; y++ {
+		p := pix[y*stride : y*stride+4*w]
+		for i := 0; i < len(p); i += 4 {
+			r, g, b, a := p[i], p[i+1], p[i+2], p[i+3]
+			if premultiplied {
+				if a == 0 {
+					continue
+				}
+				r, g, b = unpremul8(r, a), unpremul8(g, a), unpremul8(b, a)
+			}
+
+			cr, cg, cb := c.rgb([3]float64{c.in8[0][r], c.in8[1][g], c.in8[2][b]})
+			r, g, b = to8(cr), to8(cg), to8(cb)
+
+			if premultiplied {
+				r, g, b = premul8(r, a), premul8(g, a), premul8(b, a)
+			}
+			p[i], p[i+1], p[i+2] = r, g, b
+		}
+	}
+}
+
+func (c *converter) convert16(pix []byte, stride int, rect image.Rectangle, premultiplied bool) {
+	t := c.table16()
+	w, h := rect.Dx(), rect.Dy()
+
+	for y := 0; y < h; y++ {
+		p := pix[y*stride : y*stride+8*w]
+		for i := 0; i < len(p); i += 8 {
+			var v [4]uint32
+			for k := range 4 {
+				v[k] = uint32(p[i+2*k])<<8 | uint32(p[i+2*k+1])
+			}
+
+			a := v[3]
+			if premultiplied {
+				if a == 0 {
+					continue
+				}
+				for k := range 3 {
+					v[k] = min(v[k]*0xffff/a, 0xffff)
+				}
+			}
+
+			r, g, b := c.rgb([3]float64{float64(t[0][v[0]]), float64(t[1][v[1]]), float64(t[2][v[2]])})
+			v[0], v[1], v[2] = uint32(r), uint32(g), uint32(b)
+
+			for k := range 3 {
+				if premultiplied {
+					v[k] = v[k] * a / 0xffff
+				}
+				p[i+2*k], p[i+2*k+1] = uint8(v[k]>>8), uint8(v[k])
+			}
+		}
+	}
+}
+
+func unpremul8(v, a uint8) uint8 {
+	return uint8(min((uint32(v)*255+uint32(a)/2)/uint32(a), 255))
+}
+
+func premul8(v, a uint8) uint8 {
+	return uint8((uint32(v)*uint32(a) + 127) / 255)
+}
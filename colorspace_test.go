@@ -0,0 +1,151 @@
+package heic
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"testing"
+)
+
+func TestParseICC(t *testing.T) {
+	ci, err := DecodeColorInfo(bytes.NewReader(testP3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := parseICC(ci.ICC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The D50-adapted colorants of the profile are those of the P3 primaries with a D65 white.
+	want := nclxPrimaries[12]
+	for i := range 3 {
+		for j := range 3 {
+			if math.Abs(s.toPCS[i][j]-want[i][j]) > 2e-3 {
+				t.Errorf("toPCS[%d][%d] = %.4f, want %.4f", i, j, s.toPCS[i][j], want[i][j])
+			}
+		}
+	}
+
+	for _, v := range []float64{0, 0.02, 0.2, 0.5, 1} {
+		if got, want := s.toLinear[1](v), transferSRGB.toLinear(v); math.Abs(got-want) > 1e-3 {
+			t.Errorf("curve(%v) = %v, want %v", v, got, want)
+		}
+	}
+
+	if _, err := parseICC(ci.ICC[:100]); err == nil {
+		t.Error("truncated profile: no error")
+	}
+}
+
+func TestConverter(t *testing.T) {
+	srgb, p3 := targetSpace(ColorSpaceSRGB), targetSpace(ColorSpaceDisplayP3)
+	toP3, toSRGB := newConverter(srgb, p3), newConverter(p3, srgb)
+
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 1))
+	copy(img.Pix, []byte{
+		255, 255, 255, 255,
+		128, 128, 128, 255,
+		200, 100, 50, 128,
+		20, 180, 90, 0,
+	})
+	orig := bytes.Clone(img.Pix)
+
+	toP3.convert(img)
+
+	// Neutrals and alpha are kept; sRGB colours are less saturated in P3.
+	if !bytes.Equal(img.Pix[:8], orig[:8]) || img.Pix[11] != 128 || img.Pix[15] != 0 {
+		t.Errorf("P3 = %v", img.Pix)
+	}
+	if img.Pix[8] >= 200 || img.Pix[10] <= 50 {
+		t.Errorf("P3 orange = %v", img.Pix[8:12])
+	}
+
+	toSRGB.convert(img)
+
+	for i, v := range img.Pix {
+		if d := int(v) - int(orig[i]); d < -1 || d > 1 {
+			t.Errorf("round trip = %v, want %v", img.Pix, orig)
+			break
+		}
+	}
+
+	// Premultiplied and 16-bit images give the same colours.
+	rgba := image.NewRGBA64(image.Rect(0, 0, 1, 1))
+	rgba.Pix = []byte{100, 0, 50, 0, 25, 0, 128, 0}
+	toP3.convert(rgba)
+	c := rgba.RGBA64At(0, 0)
+	if c.A != 0x8000 || c.R >= 0x6400 || c.B <= 0x1900 {
+		t.Errorf("RGBA64 = %v", c)
+	}
+}
+
+func TestDecodeColorSpace(t *testing.T) {
+	testBackends(t, func(t *testing.T, backend Backend) {
+		plain, err := DecodeWithOptions(bytes.NewReader(testP3), &Options{Backend: backend, Format: FormatNRGBA})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts := &Options{Backend: backend, ColorSpace: ColorSpaceSRGB}
+		img, err := DecodeWithOptions(bytes.NewReader(testP3), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cfg, err := DecodeConfigWithOptions(bytes.NewReader(testP3), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.ColorModel != img.ColorModel() {
+			t.Errorf("config color model %v, image %v", cfg.ColorModel, img.ColorModel())
+		}
+
+		opts.Format = FormatNRGBA
+		img, err = DecodeWithOptions(bytes.NewReader(testP3), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The ICC profile and the nclx parameters describe the same colour space.
+		want := newConverter(sourceNCLX(t, 12, 13), targetSpace(ColorSpaceSRGB)).convert(plain).(*image.NRGBA)
+		got := img.(*image.NRGBA)
+		if bytes.Equal(got.Pix, plain.(*image.NRGBA).Pix) {
+			t.Fatal("colours not converted")
+		}
+		for i := range got.Pix {
+			if d := int(got.Pix[i]) - int(want.Pix[i]); d < -2 || d > 2 {
+				t.Fatalf("sample %d = %d, want %d", i, got.Pix[i], want.Pix[i])
+			}
+		}
+
+		// An image without colour information is sRGB.
+		a, err := DecodeWithOptions(bytes.NewReader(testHeic8), &Options{Backend: backend, Format: FormatNRGBA})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := DecodeWithOptions(bytes.NewReader(testHeic8), &Options{Backend: backend, Format: FormatNRGBA, ColorSpace: ColorSpaceSRGB})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(a.(*image.NRGBA).Pix, b.(*image.NRGBA).Pix) {
+			t.Error("sRGB image converted to sRGB")
+		}
+	})
+
+	if _, err := DecodeWithOptions(bytes.NewReader(testP3), &Options{ColorSpace: 100}); err == nil {
+		t.Error("invalid color space: no error")
+	}
+}
+
+func sourceNCLX(t *testing.T, primaries, transfer uint16) *rgbSpace {
+	t.Helper()
+
+	s, _, _ := sourceSpace(ColorInfo{NCLX: &NCLX{ColorPrimaries: primaries, TransferCharacteristics: transfer}})
+	if s == nil {
+		t.Fatalf("no colour space for %d/%d", primaries, transfer)
+	}
+
+	return s
+}
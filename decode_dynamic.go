@@ -4,6 +4,7 @@ package heic
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"image"
 	"image/color"
@@ -12,6 +13,8 @@ import (
 	"unsafe"
 
 	"github.com/ebitengine/purego"
+
+	"github.com/gen2brain/heic/isobmff"
 )
 
 const (
@@ -19,7 +22,7 @@ const (
 	heifErrorEndOfSequence = 13
 )
 
-func decodeDynamic(r io.Reader, configOnly bool) (image.Image, image.Config, error) {
+func decodeDynamic(ctx context.Context, r io.Reader, configOnly bool, opts *Options) (image.Image, image.Config, error) {
 	var err error
 	var cfg image.Config
 	var data []byte
@@ -30,45 +33,85 @@ func decodeDynamic(r io.Reader, configOnly bool) (image.Image, image.Config, err
 			return nil, cfg, fmt.Errorf("read: %w", err)
 		}
 	} else {
-		data, err = io.ReadAll(r)
+		data, err = opts.readInput(r)
 		if err != nil {
-			return nil, cfg, fmt.Errorf("read: %w", err)
+			return nil, cfg, err
 		}
 	}
 
 	check := heifCheckFiletype(data)
 	if check != heifFiletypeYesSupported {
-		return nil, cfg, ErrDecode
+		return nil, cfg, &DecodeError{Backend: "libheif", Stage: StageParse, Code: CodeUnsupportedFiletype, Message: "unsupported file type"}
 	}
 
-	ctx := heifContextAlloc()
-	defer heifContextFree(ctx)
+	hctx := heifContextAlloc()
+	defer heifContextFree(hctx)
+
+	// libheif decodes the tiles of a grid on its own threads.
+	heifContextSetMaxDecodingThreads(hctx, opts.concurrency())
 
 	var e heifError
 
-	e = heifContextReadFromMemoryWithoutCopy(ctx, data)
+	e = heifContextReadFromMemoryWithoutCopy(hctx, data)
 	if e.Code != 0 {
-		return nil, cfg, ErrDecode
+		return nil, cfg, e.err(StageParse)
 	}
 
 	handle := new(heifImageHandle)
 
-	e = heifContextGetPrimaryImageHandle(ctx, &handle)
+	if opts.ItemID == 0 {
+		e = heifContextGetPrimaryImageHandle(hctx, &handle)
+	} else {
+		// Recent libheif versions resolve any image item, older ones only the top-level images.
+		e = heifContextGetImageHandle(hctx, opts.ItemID, &handle)
+		if e.Code != 0 && !heifContextIsTopLevelImageID(hctx, opts.ItemID) {
+			var ok bool
+			if handle, ok = dependentImageHandle(hctx, opts.ItemID); !ok {
+				if f, err := isobmff.Parse(data); err == nil && f.Meta != nil {
+					if item := f.Meta.Item(opts.ItemID); item == nil || !imageItemTypes[item.ItemType] {
+						return nil, cfg, fmt.Errorf("%w: %d", ErrNoItem, opts.ItemID)
+					}
+				}
+
+				return nil, cfg, &DecodeError{
+					Backend: "libheif",
+					Stage:   StageParse,
+					Code:    CodeUnsupportedFeature,
+					Message: fmt.Sprintf("item %d is not a top-level, thumbnail or auxiliary image", opts.ItemID),
+				}
+			}
+			e = heifError{}
+		}
+	}
 	if e.Code != 0 {
-		return nil, cfg, ErrDecode
+		return nil, cfg, e.err(StageParse)
 	}
 	defer heifImageHandleRelease(handle)
 
 	cfg.Width = heifImageHandleGetWidth(handle)
 	cfg.Height = heifImageHandleGetHeight(handle)
+	if opts.IgnoreTransformations {
+		// libheif reports the size after the transformations; the coded size is that of ispe.
+		if meta, e, err := parseItemMeta(data, opts); err == nil {
+			if w, h := codedSize(meta, e.ItemID); w > 0 && h > 0 {
+				cfg.Width, cfg.Height = w, h
+			}
+		}
+	}
+
+	if err := opts.checkSize(cfg.Width, cfg.Height); err != nil {
+		return nil, image.Config{}, err
+	}
 
+	hasAlpha := heifImageHandleHasAlphaChannel(handle)
+	bitDepth := heifImageHandleGetLumaBitsPerPixel(handle)
 	isPremultiplied := heifImageHandleIsPremultipliedAlpha(handle)
 
 	var colorspace, chroma int
 	if versionMajor == 1 && versionMinor >= 17 {
 		e = heifImageHandleGetPreferredDecodingColorspace(handle, &colorspace, &chroma)
 		if e.Code != 0 {
-			return nil, cfg, ErrDecode
+			return nil, cfg, e.err(StageParse)
 		}
 
 		if colorspace == heifColorspaceUndefined || chroma == heifChromaUndefined {
@@ -90,19 +133,63 @@ func decodeDynamic(r io.Reader, configOnly bool) (image.Image, image.Config, err
 		cfg.ColorModel = color.YCbCrModel
 	}
 
+	// YCbCr has no alpha channel, so an image with alpha is decoded as RGBA.
+	if (opts.Format == FormatNRGBA || hasAlpha) && colorspace != heifColorspaceRGB {
+		colorspace = heifColorspaceRGB
+		chroma = heifChromaInterleavedRGBA
+		if isPremultiplied {
+			cfg.ColorModel = color.RGBAModel
+		} else {
+			cfg.ColorModel = color.NRGBAModel
+		}
+	}
+
+	// With a 16-bit depth, samples above 8 bits are decoded as such; Go has no 16-bit YCbCr image, so RGB is used.
+	highBitDepth := opts.BitDepth == 16 && bitDepth > 8
+	if highBitDepth {
+		switch colorspace {
+		case heifColorspaceMonochrome:
+			cfg.ColorModel = color.Gray16Model
+		default:
+			colorspace = heifColorspaceRGB
+			chroma = heifChromaInterleavedRRGGBBAABE
+			if isPremultiplied {
+				cfg.ColorModel = color.RGBA64Model
+			} else {
+				cfg.ColorModel = color.NRGBA64Model
+			}
+		}
+	}
+
 	if configOnly {
 		return nil, cfg, nil
 	}
 
 	options := heifDecodingOptionsAlloc()
 	options.ConvertHdrTo8bit = 1
+	if highBitDepth {
+		options.ConvertHdrTo8bit = 0
+	}
+	if opts.IgnoreTransformations {
+		options.IgnoreTransformations = 1
+	}
 	defer heifDecodingOptionsFree(options)
 
+	if err := ctx.Err(); err != nil {
+		return nil, cfg, err
+	}
+
 	heifImg := new(heifImage)
 
 	e = heifDecodeImage(handle, &heifImg, colorspace, chroma, options)
 	if e.Code != 0 {
-		return nil, cfg, ErrDecode
+		return nil, cfg, e.err(StageDecode)
+	}
+	defer heifImageRelease(heifImg)
+
+	// libheif cannot be interrupted while decoding a still image; report the cancellation afterwards.
+	if err := ctx.Err(); err != nil {
+		return nil, cfg, err
 	}
 
 	var img image.Image
@@ -151,6 +238,11 @@ func decodeDynamic(r io.Reader, configOnly bool) (image.Image, image.Config, err
 		grayData := heifImageGetPlaneReadonly(heifImg, heifChannelY, &stride)
 		size := cfg.Height * stride
 
+		if highBitDepth {
+			img = gray16(unsafe.Slice(grayData, size), stride, rect, bitDepth)
+			break
+		}
+
 		i := &image.Gray{
 			Pix:    make([]uint8, size),
 			Stride: stride,
@@ -164,6 +256,11 @@ func decodeDynamic(r io.Reader, configOnly bool) (image.Image, image.Config, err
 		rgbaData := heifImageGetPlaneReadonly(heifImg, heifChannelInterleaved, &stride)
 		size := cfg.Height * stride
 
+		if highBitDepth {
+			img = rgba64(unsafe.Slice(rgbaData, size), stride, rect, bitDepth, isPremultiplied)
+			break
+		}
+
 		if isPremultiplied {
 			i := &image.RGBA{
 				Pix:    make([]uint8, size),
@@ -192,6 +289,88 @@ func decodeDynamic(r io.Reader, configOnly bool) (image.Image, image.Config, err
 	return img, cfg, nil
 }
 
+// dependentImageHandle returns the handle of item id when it is a thumbnail or an auxiliary image, such as a
+// depth map, of a top-level image, which older libheif versions do not expose through heif_context_get_image_handle.
+func dependentImageHandle(hctx *heifContext, id uint32) (*heifImageHandle, bool) {
+	n := heifContextGetNumberOfTopLevelImages(hctx)
+	if n <= 0 {
+		return nil, false
+	}
+
+	ids := make([]uint32, n)
+	n = heifContextGetListOfTopLevelImageIDs(hctx, ids)
+
+	for _, top := range ids[:n] {
+		var parent *heifImageHandle
+		if e := heifContextGetImageHandle(hctx, top, &parent); e.Code != 0 {
+			continue
+		}
+
+		handle, ok := thumbnailHandle(parent, id)
+		if !ok {
+			handle, ok = auxiliaryHandle(parent, id)
+		}
+		heifImageHandleRelease(parent)
+		if ok {
+			return handle, true
+		}
+	}
+
+	return nil, false
+}
+
+// thumbnailHandle returns the handle of thumbnail id of parent.
+func thumbnailHandle(parent *heifImageHandle, id uint32) (*heifImageHandle, bool) {
+	n := heifImageHandleGetNumberOfThumbnails(parent)
+	if n <= 0 {
+		return nil, false
+	}
+
+	ids := make([]uint32, n)
+	n = heifImageHandleGetListOfThumbnailIDs(parent, ids)
+
+	for _, tid := range ids[:n] {
+		if tid != id {
+			continue
+		}
+
+		var handle *heifImageHandle
+		if e := heifImageHandleGetThumbnail(parent, id, &handle); e.Code != 0 {
+			return nil, false
+		}
+
+		return handle, true
+	}
+
+	return nil, false
+}
+
+// auxiliaryHandle returns the handle of auxiliary image id of parent, including an alpha plane or depth map.
+func auxiliaryHandle(parent *heifImageHandle, id uint32) (*heifImageHandle, bool) {
+	n := heifImageHandleGetNumberOfAuxiliaryImages(parent, 0)
+	if n <= 0 {
+		return nil, false
+	}
+
+	ids := make([]uint32, n)
+	n = heifImageHandleGetListOfAuxiliaryImageIDs(parent, 0, ids)
+
+	for _, aid := range ids[:n] {
+		if aid != id {
+			continue
+		}
+
+		var handle *heifImageHandle
+		if e := heifImageHandleGetAuxiliaryImageHandle(parent, id, &handle); e.Code != 0 {
+			return nil, false
+		}
+
+		return handle, true
+	}
+
+	return nil, false
+}
+
 func init() {
 	var err error
 	defer func() {
@@ -225,8 +404,20 @@ func init() {
 	purego.RegisterLibFunc(&_heifContextFree, libheif, "heif_context_free")
 	purego.RegisterLibFunc(&_heifContextReadFromMemoryWithoutCopy, libheif, "heif_context_read_from_memory_without_copy")
 	purego.RegisterLibFunc(&_heifContextGetPrimaryImageHandle, libheif, "heif_context_get_primary_image_handle")
+	purego.RegisterLibFunc(&_heifContextGetImageHandle, libheif, "heif_context_get_image_handle")
+	purego.RegisterLibFunc(&_heifContextIsTopLevelImageID, libheif, "heif_context_is_top_level_image_ID")
+	purego.RegisterLibFunc(&_heifContextGetNumberOfTopLevelImages, libheif, "heif_context_get_number_of_top_level_images")
+	purego.RegisterLibFunc(&_heifContextGetListOfTopLevelImageIDs, libheif, "heif_context_get_list_of_top_level_image_IDs")
+	purego.RegisterLibFunc(&_heifImageHandleGetNumberOfThumbnails, libheif, "heif_image_handle_get_number_of_thumbnails")
+	purego.RegisterLibFunc(&_heifImageHandleGetListOfThumbnailIDs, libheif, "heif_image_handle_get_list_of_thumbnail_IDs")
+	purego.RegisterLibFunc(&_heifImageHandleGetThumbnail, libheif, "heif_image_handle_get_thumbnail")
+	purego.RegisterLibFunc(&_heifImageHandleGetNumberOfAuxiliaryImages, libheif, "heif_image_handle_get_number_of_auxiliary_images")
+	purego.RegisterLibFunc(&_heifImageHandleGetListOfAuxiliaryImageIDs, libheif, "heif_image_handle_get_list_of_auxiliary_image_IDs")
+	purego.RegisterLibFunc(&_heifImageHandleGetAuxiliaryImageHandle, libheif, "heif_image_handle_get_auxiliary_image_handle")
 	purego.RegisterLibFunc(&_heifImageHandleGetWidth, libheif, "heif_image_handle_get_width")
 	purego.RegisterLibFunc(&_heifImageHandleGetHeight, libheif, "heif_image_handle_get_height")
+	purego.RegisterLibFunc(&_heifImageHandleHasAlphaChannel, libheif, "heif_image_handle_has_alpha_channel")
+	purego.RegisterLibFunc(&_heifImageHandleGetLumaBitsPerPixel, libheif, "heif_image_handle_get_luma_bits_per_pixel")
 	purego.RegisterLibFunc(&_heifImageHandleIsPremultipliedAlpha, libheif, "heif_image_handle_is_premultiplied_alpha")
 	purego.RegisterLibFunc(&_heifImageHandleRelease, libheif, "heif_image_handle_release")
 	purego.RegisterLibFunc(&_heifDecodingOptionsAlloc, libheif, "heif_decoding_options_alloc")
@@ -237,6 +428,21 @@ func init() {
 	if versionMajor == 1 && versionMinor >= 19 {
 		registerSequence()
 	}
+
+	registerThreads()
+}
+
+// registerThreads registers heif_context_set_max_decoding_threads, which older libheif versions lack.
+func registerThreads() {
+	defer func() {
+		if recover() != nil {
+			hasThreads = false
+		}
+	}()
+
+	purego.RegisterLibFunc(&_heifContextSetMaxDecodingThreads, libheif, "heif_context_set_max_decoding_threads")
+
+	hasThreads = true
 }
 
 func registerSequence() {
@@ -267,24 +473,35 @@ var (
 	dynamic     bool
 	dynamicErr  error
 	hasSequence bool
+	hasThreads  bool
 
 	versionMajor int
 	versionMinor int
 )
 
 var (
-	_heifGetVersionNumberMajor           func() uint32
-	_heifGetVersionNumberMinor           func() uint32
-	_heifCheckFiletype                   func(*uint8, uint64) int
-	_heifContextAlloc                    func() *heifContext
-	_heifContextFree                     func(*heifContext)
-	_heifImageHandleGetWidth             func(*heifImageHandle) int
-	_heifImageHandleGetHeight            func(*heifImageHandle) int
-	_heifImageHandleIsPremultipliedAlpha func(*heifImageHandle) int
-	_heifImageHandleRelease              func(*heifImageHandle)
-	_heifDecodingOptionsAlloc            func() *heifDecodingOptions
-	_heifDecodingOptionsFree             func(*heifDecodingOptions)
-	_heifImageGetPlaneReadonly           func(*heifImage, int, *int) *uint8
+	_heifGetVersionNumberMajor                 func() uint32
+	_heifGetVersionNumberMinor                 func() uint32
+	_heifCheckFiletype                         func(*uint8, uint64) int
+	_heifContextAlloc                          func() *heifContext
+	_heifContextFree                           func(*heifContext)
+	_heifContextIsTopLevelImageID              func(*heifContext, uint32) int
+	_heifContextGetNumberOfTopLevelImages      func(*heifContext) int
+	_heifContextGetListOfTopLevelImageIDs      func(*heifContext, *uint32, int) int
+	_heifImageHandleGetNumberOfThumbnails      func(*heifImageHandle) int
+	_heifImageHandleGetListOfThumbnailIDs      func(*heifImageHandle, *uint32, int) int
+	_heifImageHandleGetNumberOfAuxiliaryImages func(*heifImageHandle, int) int
+	_heifImageHandleGetListOfAuxiliaryImageIDs func(*heifImageHandle, int, *uint32, int) int
+	_heifImageHandleGetWidth                   func(*heifImageHandle) int
+	_heifImageHandleGetHeight                  func(*heifImageHandle) int
+	_heifImageHandleHasAlphaChannel            func(*heifImageHandle) int
+	_heifImageHandleIsPremultipliedAlpha       func(*heifImageHandle) int
+	_heifImageHandleGetLumaBitsPerPixel        func(*heifImageHandle) int
+	_heifImageHandleRelease                    func(*heifImageHandle)
+	_heifDecodingOptionsAlloc                  func() *heifDecodingOptions
+	_heifDecodingOptionsFree                   func(*heifDecodingOptions)
+	_heifImageGetPlaneReadonly                 func(*heifImage, int, *int) *uint8
+	_heifContextSetMaxDecodingThreads          func(*heifContext, int)
 
 	_heifContextNumberOfSequenceTracks func(*heifContext) int
 	_heifContextGetTrackIds            func(*heifContext, *uint32)
@@ -318,6 +535,40 @@ func heifContextFree(ctx *heifContext) {
 	_heifContextFree(ctx)
 }
 
+func heifContextSetMaxDecodingThreads(ctx *heifContext, n int) {
+	if hasThreads {
+		_heifContextSetMaxDecodingThreads(ctx, n)
+	}
+}
+
+func heifContextIsTopLevelImageID(ctx *heifContext, id uint32) bool {
+	return _heifContextIsTopLevelImageID(ctx, id) != 0
+}
+
+func heifContextGetNumberOfTopLevelImages(ctx *heifContext) int {
+	return _heifContextGetNumberOfTopLevelImages(ctx)
+}
+
+func heifContextGetListOfTopLevelImageIDs(ctx *heifContext, ids []uint32) int {
+	return _heifContextGetListOfTopLevelImageIDs(ctx, &ids[0], len(ids))
+}
+
+func heifImageHandleGetNumberOfThumbnails(handle *heifImageHandle) int {
+	return _heifImageHandleGetNumberOfThumbnails(handle)
+}
+
+func heifImageHandleGetListOfThumbnailIDs(handle *heifImageHandle, ids []uint32) int {
+	return _heifImageHandleGetListOfThumbnailIDs(handle, &ids[0], len(ids))
+}
+
+func heifImageHandleGetNumberOfAuxiliaryImages(handle *heifImageHandle, filter int) int {
+	return _heifImageHandleGetNumberOfAuxiliaryImages(handle, filter)
+}
+
+func heifImageHandleGetListOfAuxiliaryImageIDs(handle *heifImageHandle, filter int, ids []uint32) int {
+	return _heifImageHandleGetListOfAuxiliaryImageIDs(handle, filter, &ids[0], len(ids))
+}
+
 func heifImageHandleGetWidth(handle *heifImageHandle) int {
 	return _heifImageHandleGetWidth(handle)
 }
@@ -326,6 +577,16 @@ func heifImageHandleGetHeight(handle *heifImageHandle) int {
 	return _heifImageHandleGetHeight(handle)
 }
 
+func heifImageHandleHasAlphaChannel(handle *heifImageHandle) bool {
+	ret := _heifImageHandleHasAlphaChannel(handle)
+
+	return ret != 0
+}
+
+func heifImageHandleGetLumaBitsPerPixel(handle *heifImageHandle) int {
+	return _heifImageHandleGetLumaBitsPerPixel(handle)
+}
+
 func heifImageHandleIsPremultipliedAlpha(handle *heifImageHandle) bool {
 	ret := _heifImageHandleIsPremultipliedAlpha(handle)
 
@@ -389,30 +650,43 @@ func heifImageRelease(img *heifImage) {
 }
 
 // decodeDynamicAll decodes a HEIC image sequence via libheif, or a single frame when there is no sequence.
-func decodeDynamicAll(r io.Reader) (*HEIC, error) {
-	data, err := io.ReadAll(r)
+func decodeDynamicAll(ctx context.Context, r io.Reader, opts *Options) (*HEIC, error) {
+	data, err := opts.readInput(r)
 	if err != nil {
-		return nil, fmt.Errorf("read: %w", err)
+		return nil, err
 	}
 
-	if _, ok := parseSequence(data); ok {
+	if info, ok := parseSequence(data); ok {
+		if err := opts.checkSequence(info); err != nil {
+			return nil, err
+		}
+
 		if hasSequence {
-			ctx := heifContextAlloc()
-			defer heifContextFree(ctx)
+			hctx := heifContextAlloc()
+			defer heifContextFree(hctx)
+
+			var depth int
+			if f, err := isobmff.Parse(data); err == nil {
+				depth = fileBitDepth(f, 0)
+			}
 
-			if e := heifContextReadFromMemoryWithoutCopy(ctx, data); e.Code == 0 {
-				if h, ok := decodeSequenceDynamic(ctx); ok {
-					runtime.KeepAlive(data)
+			if e := heifContextReadFromMemoryWithoutCopy(hctx, data); e.Code == 0 {
+				h, err := decodeSequenceDynamic(ctx, hctx, depth, opts)
+				runtime.KeepAlive(data)
+				if err != nil {
+					return nil, err
+				}
+				if h != nil {
 					return h, nil
 				}
 			}
 		}
 
 		// libheif has no sequence support; decode the sequence via WASM.
-		return decodeWasmAll(bytes.NewReader(data))
+		return decodeWasmAll(ctx, bytes.NewReader(data), opts)
 	}
 
-	img, _, err := decodeDynamic(bytes.NewReader(data), false)
+	img, _, err := decodeDynamic(ctx, bytes.NewReader(data), false, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -420,19 +694,21 @@ func decodeDynamicAll(r io.Reader) (*HEIC, error) {
 	return &HEIC{Image: []image.Image{img}, Delay: []float64{0}}, nil
 }
 
-// decodeSequenceDynamic iterates the visual (pict) track, returning each frame as NRGBA with its delay in seconds.
-func decodeSequenceDynamic(ctx *heifContext) (*HEIC, bool) {
-	n := heifContextNumberOfSequenceTracks(ctx)
+// decodeSequenceDynamic iterates the visual (pict) track, returning each frame as NRGBA, or NRGBA64 for depth-bit
+// samples above 8 bits with a 16-bit depth, with its delay in seconds.
+// It returns a nil *HEIC and no error when libheif cannot decode the sequence, and ctx.Err() once ctx is done.
+func decodeSequenceDynamic(ctx context.Context, hctx *heifContext, depth int, opts *Options) (*HEIC, error) {
+	n := heifContextNumberOfSequenceTracks(hctx)
 	if n <= 0 {
-		return nil, false
+		return nil, nil
 	}
 
 	ids := make([]uint32, n)
-	heifContextGetTrackIds(ctx, &ids[0])
+	heifContextGetTrackIds(hctx, &ids[0])
 
 	var track *heifTrack
 	for _, id := range ids {
-		t := heifContextGetTrack(ctx, id)
+		t := heifContextGetTrack(hctx, id)
 		if t == nil {
 			continue
 		}
@@ -443,7 +719,7 @@ func decodeSequenceDynamic(ctx *heifContext) (*HEIC, bool) {
 		heifTrackRelease(t)
 	}
 	if track == nil {
-		return nil, false
+		return nil, nil
 	}
 	defer heifTrackRelease(track)
 
@@ -452,31 +728,61 @@ func decodeSequenceDynamic(ctx *heifContext) (*HEIC, bool) {
 		timescale = 1
 	}
 
+	highBitDepth := opts.BitDepth == 16 && depth > 8
+	chroma := heifChromaInterleavedRGBA
+
 	options := heifDecodingOptionsAlloc()
 	options.ConvertHdrTo8bit = 1
+	if highBitDepth {
+		options.ConvertHdrTo8bit = 0
+		chroma = heifChromaInterleavedRRGGBBAABE
+	}
+	if opts.IgnoreTransformations {
+		options.IgnoreTransformations = 1
+	}
 	defer heifDecodingOptionsFree(options)
 
 	h := &HEIC{}
 	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
 		var himg *heifImage
-		e := heifTrackDecodeNextImage(track, &himg, heifColorspaceRGB, heifChromaInterleavedRGBA, options)
+		e := heifTrackDecodeNextImage(track, &himg, heifColorspaceRGB, chroma, options)
 		if e.Code == heifErrorEndOfSequence {
 			break
 		}
 		if e.Code != 0 {
-			break
+			return nil, e.err(StageDecode)
 		}
 
 		w := heifImageGetPrimaryWidth(himg)
 		ht := heifImageGetPrimaryHeight(himg)
 
+		if err := opts.checkSize(w, ht); err != nil {
+			heifImageRelease(himg)
+			return nil, err
+		}
+		if err := opts.checkFrames(len(h.Image) + 1); err != nil {
+			heifImageRelease(himg)
+			return nil, err
+		}
+
 		var stride int
 		plane := heifImageGetPlaneReadonly(himg, heifChannelInterleaved, &stride)
 		if plane != nil && w > 0 && ht > 0 {
 			src := unsafe.Slice(plane, stride*ht)
-			img := image.NewNRGBA(image.Rect(0, 0, w, ht))
-			for y := 0; y < ht; y++ {
-				copy(img.Pix[y*img.Stride:y*img.Stride+w*4], src[y*stride:y*stride+w*4])
+
+			var img image.Image
+			if highBitDepth {
+				img = rgba64(src, stride, image.Rect(0, 0, w, ht), depth, false)
+			} else {
+				i := image.NewNRGBA(image.Rect(0, 0, w, ht))
+				for y := 0; y < ht; y++ {
+					copy(i.Pix[y*i.Stride:y*i.Stride+w*4], src[y*stride:y*stride+w*4])
+				}
+				img = i
 			}
 
 			h.Image = append(h.Image, img)
@@ -487,10 +793,10 @@ func decodeSequenceDynamic(ctx *heifContext) (*HEIC, bool) {
 	}
 
 	if len(h.Image) == 0 {
-		return nil, false
+		return nil, nil
 	}
 
-	return h, true
+	return h, nil
 }
 
 type heifContext struct{}
@@ -504,6 +810,38 @@ type heifError struct {
 	Message *int8
 }
 
+// err converts e to a *DecodeError for the stage of the failed call, refined by the suberror code.
+func (e heifError) err(stage Stage) error {
+	switch {
+	case e.Subcode == subcodeUnsupportedColorConversion:
+		stage = StageColorConversion
+	case e.Code == CodeInvalidInput:
+		stage = StageParse
+	}
+
+	return &DecodeError{
+		Backend: "libheif",
+		Stage:   stage,
+		Code:    int(e.Code),
+		Subcode: int(e.Subcode),
+		Message: goString(e.Message),
+	}
+}
+
+// goString copies the NUL-terminated C string at p.
+func goString(p *int8) string {
+	if p == nil {
+		return ""
+	}
+
+	n := 0
+	for *(*int8)(unsafe.Add(unsafe.Pointer(p), n)) != 0 {
+		n++
+	}
+
+	return string(unsafe.Slice((*byte)(unsafe.Pointer(p)), n))
+}
+
 type heifDecodingOptions struct {
 	Version               uint8
 	IgnoreTransformations uint8
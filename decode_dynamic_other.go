@@ -5,6 +5,9 @@ package heic
 var (
 	_heifContextReadFromMemoryWithoutCopy          func(*heifContext, *uint8, uint64, *byte) heifError
 	_heifContextGetPrimaryImageHandle              func(*heifContext, **heifImageHandle) heifError
+	_heifContextGetImageHandle                     func(*heifContext, uint32, **heifImageHandle) heifError
+	_heifImageHandleGetThumbnail                   func(*heifImageHandle, uint32, **heifImageHandle) heifError
+	_heifImageHandleGetAuxiliaryImageHandle        func(*heifImageHandle, uint32, **heifImageHandle) heifError
 	_heifImageHandleGetPreferredDecodingColorspace func(*heifImageHandle, *int, *int) heifError
 	_heifDecodeImage                               func(*heifImageHandle, **heifImage, int, int, *heifDecodingOptions) heifError
 	_heifTrackDecodeNextImage                      func(*heifTrack, **heifImage, int, int, *heifDecodingOptions) heifError
@@ -22,6 +25,18 @@ func heifContextGetPrimaryImageHandle(ctx *heifContext, handle **heifImageHandle
 	return _heifContextGetPrimaryImageHandle(ctx, handle)
 }
 
+func heifContextGetImageHandle(ctx *heifContext, id uint32, handle **heifImageHandle) heifError {
+	return _heifContextGetImageHandle(ctx, id, handle)
+}
+
+func heifImageHandleGetThumbnail(handle *heifImageHandle, id uint32, thumb **heifImageHandle) heifError {
+	return _heifImageHandleGetThumbnail(handle, id, thumb)
+}
+
+func heifImageHandleGetAuxiliaryImageHandle(handle *heifImageHandle, id uint32, aux **heifImageHandle) heifError {
+	return _heifImageHandleGetAuxiliaryImageHandle(handle, id, aux)
+}
+
 func heifImageHandleGetPreferredDecodingColorspace(handle *heifImageHandle, colorspace *int, chroma *int) heifError {
 	return _heifImageHandleGetPreferredDecodingColorspace(handle, colorspace, chroma)
 }
@@ -6,6 +6,9 @@ package heic
 var (
 	_heifContextReadFromMemoryWithoutCopy          func(*heifError, *heifContext, *uint8, uint64, *byte) uintptr
 	_heifContextGetPrimaryImageHandle              func(*heifError, *heifContext, **heifImageHandle) uintptr
+	_heifContextGetImageHandle                     func(*heifError, *heifContext, uint32, **heifImageHandle) uintptr
+	_heifImageHandleGetThumbnail                   func(*heifError, *heifImageHandle, uint32, **heifImageHandle) uintptr
+	_heifImageHandleGetAuxiliaryImageHandle        func(*heifError, *heifImageHandle, uint32, **heifImageHandle) uintptr
 	_heifImageHandleGetPreferredDecodingColorspace func(*heifError, *heifImageHandle, *int, *int) uintptr
 	_heifDecodeImage                               func(*heifError, *heifImageHandle, **heifImage, int, int, *heifDecodingOptions) uintptr
 	_heifTrackDecodeNextImage                      func(*heifError, *heifTrack, **heifImage, int, int, *heifDecodingOptions) uintptr
@@ -29,6 +32,24 @@ func heifContextGetPrimaryImageHandle(ctx *heifContext, handle **heifImageHandle
 	return e
 }
 
+func heifContextGetImageHandle(ctx *heifContext, id uint32, handle **heifImageHandle) heifError {
+	var e heifError
+	_heifContextGetImageHandle(&e, ctx, id, handle)
+	return e
+}
+
+func heifImageHandleGetThumbnail(handle *heifImageHandle, id uint32, thumb **heifImageHandle) heifError {
+	var e heifError
+	_heifImageHandleGetThumbnail(&e, handle, id, thumb)
+	return e
+}
+
+func heifImageHandleGetAuxiliaryImageHandle(handle *heifImageHandle, id uint32, aux **heifImageHandle) heifError {
+	var e heifError
+	_heifImageHandleGetAuxiliaryImageHandle(&e, handle, id, aux)
+	return e
+}
+
 func heifImageHandleGetPreferredDecodingColorspace(handle *heifImageHandle, colorspace *int, chroma *int) heifError {
 	var e heifError
 	_heifImageHandleGetPreferredDecodingColorspace(&e, handle, colorspace, chroma)
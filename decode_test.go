@@ -2,15 +2,22 @@ package heic
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
+	"errors"
 	"image"
 	"image/jpeg"
 	"io"
 	"os"
+	"reflect"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/gen2brain/heic/isobmff"
 )
 
 //go:embed testdata/test.heic
@@ -57,7 +64,7 @@ func TestDecodeAll(t *testing.T) {
 }
 
 func TestDecode(t *testing.T) {
-	img, _, err := decode(bytes.NewReader(testHeic), false)
+	img, _, err := decode(context.Background(), bytes.NewReader(testHeic), false, defaultOptions)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -75,7 +82,7 @@ func TestDecode(t *testing.T) {
 }
 
 func TestDecode8(t *testing.T) {
-	img, _, err := decode(bytes.NewReader(testHeic8), false)
+	img, _, err := decode(context.Background(), bytes.NewReader(testHeic8), false, defaultOptions)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -93,7 +100,7 @@ func TestDecode8(t *testing.T) {
 }
 
 func TestDecode12(t *testing.T) {
-	img, _, err := decode(bytes.NewReader(testHeic12), false)
+	img, _, err := decode(context.Background(), bytes.NewReader(testHeic12), false, defaultOptions)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -111,7 +118,7 @@ func TestDecode12(t *testing.T) {
 }
 
 func TestDecodeGray(t *testing.T) {
-	img, _, err := decode(bytes.NewReader(testGray), false)
+	img, _, err := decode(context.Background(), bytes.NewReader(testGray), false, defaultOptions)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -143,7 +150,7 @@ func requireDynamic(t testing.TB) {
 func TestDecodeDynamic(t *testing.T) {
 	requireDynamic(t)
 
-	img, _, err := decodeDynamic(bytes.NewReader(testHeic), false)
+	img, _, err := decodeDynamic(context.Background(), bytes.NewReader(testHeic), false, defaultOptions)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -163,7 +170,7 @@ func TestDecodeDynamic(t *testing.T) {
 func TestDecode8Dynamic(t *testing.T) {
 	requireDynamic(t)
 
-	img, _, err := decodeDynamic(bytes.NewReader(testHeic8), false)
+	img, _, err := decodeDynamic(context.Background(), bytes.NewReader(testHeic8), false, defaultOptions)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -183,7 +190,7 @@ func TestDecode8Dynamic(t *testing.T) {
 func TestDecode12Dynamic(t *testing.T) {
 	requireDynamic(t)
 
-	img, _, err := decodeDynamic(bytes.NewReader(testHeic12), false)
+	img, _, err := decodeDynamic(context.Background(), bytes.NewReader(testHeic12), false, defaultOptions)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -203,7 +210,7 @@ func TestDecode12Dynamic(t *testing.T) {
 func TestDecodeGrayDynamic(t *testing.T) {
 	requireDynamic(t)
 
-	img, _, err := decodeDynamic(bytes.NewReader(testGray), false)
+	img, _, err := decodeDynamic(context.Background(), bytes.NewReader(testGray), false, defaultOptions)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -251,6 +258,295 @@ func TestDecodeConfig(t *testing.T) {
 	})
 }
 
+func TestDecodeWithOptions(t *testing.T) {
+	tests := []struct {
+		opts Options
+		want image.Image
+	}{
+		{Options{Format: FormatNRGBA}, &image.NRGBA{}},
+		{Options{Format: FormatNRGBA, BitDepth: 16}, &image.NRGBA64{}},
+		{Options{Format: FormatGray}, &image.Gray{}},
+		{Options{Format: FormatGray, BitDepth: 16}, &image.Gray16{}},
+		{Options{Format: FormatYCbCr}, &image.YCbCr{}},
+	}
+
+	testBackends(t, func(t *testing.T, backend Backend) {
+		for _, tt := range tests {
+			opts := tt.opts
+			opts.Backend = backend
+
+			img, err := DecodeWithOptions(bytes.NewReader(testHeic8), &opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if g, w := reflect.TypeOf(img), reflect.TypeOf(tt.want); g != w {
+				t.Errorf("format=%d depth=%d: got %v, want %v", opts.Format, opts.BitDepth, g, w)
+			}
+
+			cfg, err := DecodeConfigWithOptions(bytes.NewReader(testHeic8), &opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if g, w := cfg.ColorModel, img.ColorModel(); g != w {
+				t.Errorf("format=%d depth=%d: config color model does not match image", opts.Format, opts.BitDepth)
+			}
+		}
+
+		_, err := DecodeWithOptions(bytes.NewReader(testHeic8), &Options{Backend: backend, MaxWidth: 256})
+		if err == nil {
+			t.Error("expected an error for an image above MaxWidth")
+		}
+	})
+}
+
+func TestDecodeLimits(t *testing.T) {
+	testBackends(t, func(t *testing.T, backend Backend) {
+		for _, opts := range []Options{
+			{MaxPixels: 512*512 - 1},
+			{MaxInputSize: 1024},
+			{MaxWidth: 511},
+		} {
+			opts.Backend = backend
+
+			if _, err := DecodeWithOptions(bytes.NewReader(testHeic8), &opts); !errors.Is(err, ErrLimitExceeded) {
+				t.Errorf("%+v: got %v, want %v", opts, err, ErrLimitExceeded)
+			}
+		}
+
+		opts := &Options{Backend: backend, MaxPixels: 512*512 - 1}
+		if _, err := DecodeConfigWithOptions(bytes.NewReader(testHeic8), opts); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("DecodeConfig: got %v, want %v", err, ErrLimitExceeded)
+		}
+
+		opts = &Options{Backend: backend, MaxFrames: 16}
+		if _, err := DecodeAllWithOptions(bytes.NewReader(testAnim), opts); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("DecodeAll: got %v, want %v", err, ErrLimitExceeded)
+		}
+
+		opts.MaxFrames = 17
+		if _, err := DecodeAllWithOptions(bytes.NewReader(testAnim), opts); err != nil {
+			t.Errorf("DecodeAll: %v", err)
+		}
+	})
+
+	opts := &Options{Backend: BackendWASM, MaxMemoryPages: 32}
+	if _, err := DecodeWithOptions(bytes.NewReader(testHeic), opts); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("MaxMemoryPages: got %v, want %v", err, ErrLimitExceeded)
+	}
+
+	opts.MaxMemoryPages = 4096
+	if _, err := DecodeWithOptions(bytes.NewReader(testHeic), opts); err != nil {
+		t.Errorf("MaxMemoryPages: %v", err)
+	}
+
+	// The pooled instance has grown past the smaller limit, which must hold nonetheless.
+	opts.MaxMemoryPages = 32
+	if _, err := DecodeWithOptions(bytes.NewReader(testHeic), opts); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("MaxMemoryPages after a larger limit: got %v, want %v", err, ErrLimitExceeded)
+	}
+
+	// A limit above the initial memory is hit while decoding rather than on the first allocation.
+	for _, pages := range []uint32{250, 300} {
+		opts.MaxMemoryPages = pages
+		if _, err := DecodeWithOptions(bytes.NewReader(testHeic), opts); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("MaxMemoryPages %d: got %v, want %v", pages, err, ErrLimitExceeded)
+		}
+	}
+
+	opts.MaxMemoryPages = 40
+	if _, err := DecodeAllWithOptions(bytes.NewReader(testAnim), opts); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("DecodeAll with MaxMemoryPages: got %v, want %v", err, ErrLimitExceeded)
+	}
+
+	// The module starts with more pages than this.
+	opts.MaxMemoryPages = 1
+	if _, err := DecodeWithOptions(bytes.NewReader(testHeic8), opts); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("MaxMemoryPages below the minimum: got %v, want %v", err, ErrLimitExceeded)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	// The first file is cut in mdat, the second in the meta box.
+	want := map[Backend][]string{
+		BackendWASM:    {"extent past the end of the file", "truncated box"},
+		BackendDynamic: {"outside of file bounds", "No 'meta' box"},
+	}
+
+	testBackends(t, func(t *testing.T, backend Backend) {
+		for i, data := range [][]byte{testHeic8[:600], testHeic8[:100]} {
+			_, err := DecodeWithOptions(bytes.NewReader(data), &Options{Backend: backend})
+			if !errors.Is(err, ErrDecode) {
+				t.Fatalf("got %v, want %v", err, ErrDecode)
+			}
+
+			var de *DecodeError
+			if !errors.As(err, &de) {
+				t.Fatalf("got %T, want *DecodeError", err)
+			}
+			if de.Stage != StageParse || de.Code != CodeInvalidInput || !strings.Contains(de.Message, want[backend][i]) {
+				t.Errorf("%d bytes: got %+v, want a parse error with %q", len(data), de, want[backend][i])
+			}
+		}
+	})
+}
+
+func TestDecodeErrorHEVC(t *testing.T) {
+	f, err := isobmff.Parse(testHeic8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything after the first NAL unit header of the primary item is garbage.
+	loc := f.Meta.ItemLocation(f.Meta.PrimaryItemID())
+	start := loc.BaseOffset + loc.Extents[0].Offset
+	data := bytes.Clone(testHeic8)
+	for i := start + 6; i < start+loc.Length(); i++ {
+		data[i] = 0xff
+	}
+
+	_, err = DecodeWithOptions(bytes.NewReader(data), &Options{Backend: BackendWASM})
+
+	var de *DecodeError
+	if !errors.As(err, &de) {
+		t.Fatalf("got %v, want *DecodeError", err)
+	}
+	if de.Stage != StageDecode || de.Code != CodeDecoderPlugin {
+		t.Errorf("got %+v, want a decode error", de)
+	}
+	if de.Message == newWasmError(StageDecode).Message {
+		t.Skip("the embedded module does not export last_error; rebuild it with make -C lib")
+	}
+}
+
+func TestDecodeAllError(t *testing.T) {
+	info, ok := parseSequence(testAnim)
+	if !ok {
+		t.Fatal("no sequence")
+	}
+
+	// The first frame is garbage after its NAL unit header.
+	data := bytes.Clone(testAnim)
+	s := info.samples[0]
+	for i := s.offset + int64(info.nalLenSize) + 2; i < s.offset+s.size; i++ {
+		data[i] = 0xff
+	}
+
+	testBackends(t, func(t *testing.T, backend Backend) {
+		_, err := DecodeAllWithOptions(bytes.NewReader(data), &Options{Backend: backend})
+
+		var de *DecodeError
+		if !errors.As(err, &de) {
+			t.Fatalf("got %v, want *DecodeError", err)
+		}
+		if de.Stage != StageDecode {
+			t.Errorf("got %+v, want a decode error", de)
+		}
+	})
+}
+
+func TestDecodeTrailingGarbage(t *testing.T) {
+	garbage := []byte{0, 0, 0, 3, 'j', 'u', 'n', 'k', 0xde, 0xad}
+	still := append(bytes.Clone(testHeic8), garbage...)
+	anim := append(bytes.Clone(testAnim), garbage...)
+
+	testBackends(t, func(t *testing.T, backend Backend) {
+		opts := &Options{Backend: backend}
+
+		img, err := DecodeWithOptions(bytes.NewReader(still), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); b.Dx() != 512 || b.Dy() != 512 {
+			t.Errorf("dims %dx%d, want 512x512", b.Dx(), b.Dy())
+		}
+
+		if cfg, err := DecodeConfigWithOptions(bytes.NewReader(still), opts); err != nil || cfg.Width != 512 {
+			t.Errorf("config %+v, err %v", cfg, err)
+		}
+
+		h, err := DecodeAllWithOptions(bytes.NewReader(anim), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(h.Image) != 17 {
+			t.Errorf("frames=%d, want 17", len(h.Image))
+		}
+	})
+}
+
+func TestDecodeContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	testBothWays(t, func(t *testing.T) {
+		if _, err := DecodeContext(ctx, bytes.NewReader(testHeic8)); !errors.Is(err, context.Canceled) {
+			t.Errorf("DecodeContext: got %v, want %v", err, context.Canceled)
+		}
+
+		if _, err := DecodeAllContext(ctx, bytes.NewReader(testAnim)); !errors.Is(err, context.Canceled) {
+			t.Errorf("DecodeAllContext: got %v, want %v", err, context.Canceled)
+		}
+
+		if _, err := DecodeConfigContext(ctx, bytes.NewReader(testHeic8)); !errors.Is(err, context.Canceled) {
+			t.Errorf("DecodeConfigContext: got %v, want %v", err, context.Canceled)
+		}
+	})
+
+	// A context and limits apply to the same call.
+	opts := &Options{Backend: BackendWASM, MaxPixels: 512*512 - 1}
+	if _, err := DecodeWithOptionsContext(ctx, bytes.NewReader(testHeic8), opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("DecodeWithOptionsContext: got %v, want %v", err, context.Canceled)
+	}
+	if _, err := DecodeWithOptionsContext(context.Background(), bytes.NewReader(testHeic8), opts); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("DecodeWithOptionsContext: got %v, want %v", err, ErrLimitExceeded)
+	}
+	if _, err := DecodeAllWithOptionsContext(ctx, bytes.NewReader(testAnim), opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("DecodeAllWithOptionsContext: got %v, want %v", err, context.Canceled)
+	}
+	if _, err := DecodeConfigWithOptionsContext(context.Background(), bytes.NewReader(testHeic8), opts); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("DecodeConfigWithOptionsContext: got %v, want %v", err, ErrLimitExceeded)
+	}
+	if _, err := DecodeInfoWithOptionsContext(ctx, bytes.NewReader(testHeic8), opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("DecodeInfoWithOptionsContext: got %v, want %v", err, context.Canceled)
+	}
+
+	// A done context closes the pooled module mid-call; the next decode must get a fresh one.
+	if _, _, err := decode(ctx, bytes.NewReader(testHeic8), false, defaultOptions); !errors.Is(err, context.Canceled) {
+		t.Errorf("decode: got %v, want %v", err, context.Canceled)
+	}
+
+	if _, _, err := decode(context.Background(), bytes.NewReader(testHeic8), false, defaultOptions); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeDeadline(t *testing.T) {
+	opts := &Options{Backend: BackendWASM, Concurrency: 1}
+
+	// The first deadline may expire while the module is compiled, the second one while the grid is decoded.
+	for range 2 {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		start := time.Now()
+
+		_, err := decodeImage(ctx, bytes.NewReader(testHeic), opts)
+		cancel()
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+		}
+		if d := time.Since(start); d > 200*time.Millisecond {
+			t.Errorf("returned after %v", d)
+		}
+
+		// Compile the module for the next round.
+		if _, err := decodeImage(context.WithoutCancel(ctx), bytes.NewReader(testHeic8), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 func TestDecodeSync(t *testing.T) {
 	wg := sync.WaitGroup{}
 	ch := make(chan bool, 2)
@@ -261,7 +557,7 @@ func TestDecodeSync(t *testing.T) {
 			ch <- true
 			defer func() { <-ch; wg.Done() }()
 
-			_, _, err := decode(bytes.NewReader(testHeic8), false)
+			_, _, err := decode(context.Background(), bytes.NewReader(testHeic8), false, defaultOptions)
 			if err != nil {
 				t.Error(err)
 				return
@@ -284,7 +580,7 @@ func TestDecodeSyncDynamic(t *testing.T) {
 			ch <- true
 			defer func() { <-ch; wg.Done() }()
 
-			_, _, err := decodeDynamic(bytes.NewReader(testHeic8), false)
+			_, _, err := decodeDynamic(context.Background(), bytes.NewReader(testHeic8), false, defaultOptions)
 			if err != nil {
 				t.Error(err)
 				return
@@ -340,9 +636,20 @@ func testBothWays(t *testing.T, fn func(t *testing.T)) {
 	})
 }
 
+// testBackends runs fn with each backend selected through Options, if possible.
+func testBackends(t *testing.T, fn func(t *testing.T, backend Backend)) {
+	t.Run("wasm", func(t *testing.T) {
+		fn(t, BackendWASM)
+	})
+	t.Run("dynamic", func(t *testing.T) {
+		requireDynamic(t)
+		fn(t, BackendDynamic)
+	})
+}
+
 func BenchmarkDecode(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		_, _, err := decode(bytes.NewReader(testHeic8), false)
+		_, _, err := decode(context.Background(), bytes.NewReader(testHeic8), false, defaultOptions)
 		if err != nil {
 			b.Error(err)
 		}
@@ -353,7 +660,7 @@ func BenchmarkDecodeDynamic(b *testing.B) {
 	requireDynamic(b)
 
 	for i := 0; i < b.N; i++ {
-		_, _, err := decodeDynamic(bytes.NewReader(testHeic8), false)
+		_, _, err := decodeDynamic(context.Background(), bytes.NewReader(testHeic8), false, defaultOptions)
 		if err != nil {
 			b.Error(err)
 		}
@@ -362,7 +669,7 @@ func BenchmarkDecodeDynamic(b *testing.B) {
 
 func BenchmarkDecodeConfig(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		_, _, err := decode(bytes.NewReader(testHeic8), true)
+		_, _, err := decode(context.Background(), bytes.NewReader(testHeic8), true, defaultOptions)
 		if err != nil {
 			b.Error(err)
 		}
@@ -373,7 +680,7 @@ func BenchmarkDecodeConfigDynamic(b *testing.B) {
 	requireDynamic(b)
 
 	for i := 0; i < b.N; i++ {
-		_, _, err := decodeDynamic(bytes.NewReader(testHeic8), true)
+		_, _, err := decodeDynamic(context.Background(), bytes.NewReader(testHeic8), true, defaultOptions)
 		if err != nil {
 			b.Error(err)
 		}
@@ -3,6 +3,7 @@
 package heic
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"image/color"
@@ -10,67 +11,158 @@ import (
 	"sync"
 )
 
-var modPool = sync.Pool{New: func() any { return newModuleRaw() }}
+var modPool sync.Pool
 
-func decode(r io.Reader, configOnly bool) (image.Image, image.Config, error) {
-	var cfg image.Config
+// wasmPageSize is the size of a page of WASM linear memory.
+const wasmPageSize = 65536
 
-	mod := modPool.Get().(*module)
-	defer modPool.Put(mod)
+// wasmMaxPages is the number of pages of the 32-bit WASM address space, the limit when MaxMemoryPages is unset.
+const wasmMaxPages = 65536
 
+// getModule returns a pooled module with its memory capped at opts.MaxMemoryPages.
+func getModule(opts *Options) (*module, error) {
+	limit := int64(wasmMaxPages)
+	if opts.MaxMemoryPages > 0 {
+		limit = int64(opts.MaxMemoryPages)
+	}
+
+	m, _ := modPool.Get().(*module)
+	if m == nil || int64(len(m.memory)/wasmPageSize) > limit {
+		// The memory of a pooled instance never shrinks, so one that has grown past the limit is dropped.
+		m = newModuleRaw()
+	}
+
+	if pages := int64(len(m.memory) / wasmPageSize); pages > limit {
+		modPool.Put(m)
+		return nil, fmt.Errorf("%w: wasm memory of %d pages is below the %d pages of the module", ErrLimitExceeded, limit, pages)
+	}
+
+	m.maxMem = limit
+	m.exceeded = false
+
+	return m, nil
+}
+
+// grow is the memory.grow of the transpiled module, which the build rewrites to call it. It records a failure
+// to grow past the limit set by getModule.
+func (m *module) grow(delta, max int64) int64 {
+	old := memory_grow(&m.memory, delta, max)
+	if old == -1 && delta > 0 {
+		m.exceeded = true
+	}
+
+	return old
+}
+
+// release returns m to the pool, or converts a trap (a panic in the transpiled code) to an error in *err.
+// The instance state is unknown after a trap, so it is dropped. A trap after the memory failed to grow past
+// the limit, which the module aborts on, is reported as ErrLimitExceeded.
+func (m *module) release(opts *Options, err *error) {
+	r := recover()
+	if r == nil {
+		modPool.Put(m)
+		return
+	}
+
+	if m.exceeded && opts.MaxMemoryPages > 0 {
+		*err = fmt.Errorf("%w: wasm memory of %d pages: %v", ErrLimitExceeded, opts.MaxMemoryPages, r)
+		return
+	}
+
+	e := newWasmError(StageDecode)
+	e.Message = fmt.Sprintf("wasm trap: %v", r)
+	*err = e
+}
+
+// allocErr reports a failed malloc, which can only be caused by the memory limit or an exhausted address space.
+func (m *module) allocErr(opts *Options) error {
+	if m.exceeded && opts.MaxMemoryPages > 0 {
+		return fmt.Errorf("%w: wasm memory of %d pages", ErrLimitExceeded, opts.MaxMemoryPages)
+	}
+
+	return ErrMemWrite
+}
+
+// decode cannot interrupt the transpiled module, so ctx is only checked around the calls.
+func decode(ctx context.Context, r io.Reader, configOnly bool, opts *Options) (img image.Image, cfg image.Config, err error) {
 	var data []byte
-	var err error
 	if configOnly {
 		data, err = io.ReadAll(io.LimitReader(r, heifMaxHeaderSize))
 	} else {
-		data, err = io.ReadAll(r)
+		data, err = opts.readInput(r)
+	}
+	if err != nil {
+		return nil, cfg, err
 	}
+
+	mod, err := getModule(opts)
 	if err != nil {
-		return nil, cfg, fmt.Errorf("read: %w", err)
+		return nil, cfg, err
 	}
+	defer mod.release(opts, &err)
 
 	inSize := len(data)
 
 	inPtr := mod.Xmalloc(int32(inSize))
 	if inPtr == 0 {
-		return nil, cfg, ErrMemWrite
+		return nil, cfg, mod.allocErr(opts)
 	}
 	defer mod.Xfree(inPtr)
 	if !mod.write(inPtr, data) {
 		return nil, cfg, ErrMemWrite
 	}
 
-	info := mod.Xmalloc(2 * 4)
+	info := mod.Xmalloc(3 * 4)
 	if info == 0 {
-		return nil, cfg, ErrMemWrite
+		return nil, cfg, mod.allocErr(opts)
 	}
 	defer mod.Xfree(info)
 
-	cfgOnly := int32(0)
-	if configOnly {
-		cfgOnly = 1
+	cfg.ColorModel = color.NRGBAModel
+
+	if configOnly || opts.hasSizeLimit() {
+		mod.Xdecode(inPtr, int32(inSize), 1, info)
+
+		width := load32(mod.memory[info:])
+		height := load32(mod.memory[info+4:])
+		if width == 0 {
+			return nil, image.Config{}, mod.decodeErr(info, StageParse)
+		}
+
+		cfg.Width = int(width)
+		cfg.Height = int(height)
+
+		if err := opts.checkSize(cfg.Width, cfg.Height); err != nil {
+			return nil, image.Config{}, err
+		}
+
+		if configOnly {
+			return nil, cfg, nil
+		}
 	}
 
-	out := mod.Xdecode(inPtr, int32(inSize), cfgOnly, info)
+	if err := ctx.Err(); err != nil {
+		return nil, cfg, err
+	}
+
+	out := mod.Xdecode(inPtr, int32(inSize), 0, info)
+	if out != 0 {
+		defer mod.Xfree(out)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, cfg, err
+	}
 
 	width := load32(mod.memory[info:])
 	height := load32(mod.memory[info+4:])
 
 	cfg.Width = int(width)
 	cfg.Height = int(height)
-	cfg.ColorModel = color.NRGBAModel
-
-	if configOnly {
-		if width == 0 {
-			return nil, image.Config{}, ErrDecode
-		}
-		return nil, cfg, nil
-	}
 
 	if out == 0 {
-		return nil, cfg, ErrDecode
+		return nil, cfg, mod.decodeErr(info, StageDecode)
 	}
-	defer mod.Xfree(out)
 
 	size := int(width) * int(height) * 4
 	pix, ok := mod.read(out, int32(size))
@@ -78,19 +170,22 @@ func decode(r io.Reader, configOnly bool) (image.Image, image.Config, error) {
 		return nil, cfg, ErrMemRead
 	}
 
-	img := image.NewNRGBA(image.Rect(0, 0, int(width), int(height)))
-	copy(img.Pix, pix)
+	nrgba := image.NewNRGBA(image.Rect(0, 0, int(width), int(height)))
+	copy(nrgba.Pix, pix)
 
-	return img, cfg, nil
+	return nrgba, cfg, nil
 }
 
-func decodeSequence(annexb []byte) ([][]byte, int, int, error) {
-	mod := modPool.Get().(*module)
-	defer modPool.Put(mod)
+func decodeSequence(ctx context.Context, annexb []byte, opts *Options) (_ [][]byte, _, _ int, err error) {
+	mod, err := getModule(opts)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer mod.release(opts, &err)
 
 	inPtr := mod.Xmalloc(int32(len(annexb)))
 	if inPtr == 0 {
-		return nil, 0, 0, ErrMemWrite
+		return nil, 0, 0, mod.allocErr(opts)
 	}
 	defer mod.Xfree(inPtr)
 	if !mod.write(inPtr, annexb) {
@@ -99,20 +194,30 @@ func decodeSequence(annexb []byte) ([][]byte, int, int, error) {
 
 	info := mod.Xmalloc(3 * 4)
 	if info == 0 {
-		return nil, 0, 0, ErrMemWrite
+		return nil, 0, 0, mod.allocErr(opts)
 	}
 	defer mod.Xfree(info)
 
+	if err := ctx.Err(); err != nil {
+		return nil, 0, 0, err
+	}
+
 	out := mod.Xdecode_sequence(inPtr, int32(len(annexb)), info)
+	if out != 0 {
+		defer mod.Xfree(out)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, 0, 0, err
+	}
 
 	width := load32(mod.memory[info:])
 	height := load32(mod.memory[info+4:])
 	count := load32(mod.memory[info+8:])
 
 	if out == 0 || count == 0 || width == 0 || height == 0 {
-		return nil, 0, 0, ErrDecode
+		return nil, 0, 0, mod.decodeErr(info, StageDecode)
 	}
-	defer mod.Xfree(out)
 
 	frameSize := int(width) * int(height) * 4
 	data, ok := mod.read(out, int32(frameSize*int(count)))
@@ -130,6 +235,99 @@ func decodeSequence(annexb []byte) ([][]byte, int, int, error) {
 	return frames, int(width), int(height), nil
 }
 
+// planeDecoder is implemented by modules transpiled from a shim that exports decode_planes.
+type planeDecoder interface {
+	Xdecode_planes(v0, v1, v2 int32) int32
+}
+
+// decodePlanes decodes the first frame of an Annex-B stream to its Y, Cb and Cr planes, or returns errNoPlanes
+// when the transpiled module cannot.
+func decodePlanes(ctx context.Context, annexb []byte, opts *Options) (_ *image.YCbCr, err error) {
+	mod, err := getModule(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer mod.release(opts, &err)
+
+	pd, ok := any(mod).(planeDecoder)
+	if !ok {
+		return nil, errNoPlanes
+	}
+
+	inPtr := mod.Xmalloc(int32(len(annexb)))
+	if inPtr == 0 {
+		return nil, mod.allocErr(opts)
+	}
+	defer mod.Xfree(inPtr)
+	if !mod.write(inPtr, annexb) {
+		return nil, ErrMemWrite
+	}
+
+	info := mod.Xmalloc(3 * 4)
+	if info == 0 {
+		return nil, mod.allocErr(opts)
+	}
+	defer mod.Xfree(info)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	out := pd.Xdecode_planes(inPtr, int32(len(annexb)), info)
+	if out != 0 {
+		defer mod.Xfree(out)
+	}
+
+	width := load32(mod.memory[info:])
+	height := load32(mod.memory[info+4:])
+	format := load32(mod.memory[info+8:])
+
+	if out == 0 || width == 0 || height == 0 {
+		return nil, mod.decodeErr(info, StageDecode)
+	}
+
+	data, ok := mod.read(out, int32(planesSize(int(width), int(height), int(format))))
+	if !ok {
+		return nil, ErrMemRead
+	}
+
+	return newPlanes(data, int(width), int(height), int(format))
+}
+
+// lastErrorer is implemented by modules transpiled from a shim that exports last_error.
+type lastErrorer interface {
+	Xlast_error(info int32) int32
+}
+
+// decodeErr returns the shim's last error, using the 3*4 bytes at info, or a generic one for stage. A decode
+// that failed after the memory could not grow past the limit is reported as ErrLimitExceeded.
+func (m *module) decodeErr(info int32, stage Stage) error {
+	if m.exceeded && m.maxMem < wasmMaxPages {
+		return fmt.Errorf("%w: %s: wasm memory of %d pages", ErrLimitExceeded, stage, m.maxMem)
+	}
+
+	e := newWasmError(stage)
+
+	le, ok := any(m).(lastErrorer)
+	if !ok {
+		return e
+	}
+
+	ptr := le.Xlast_error(info)
+	size := load32(m.memory[info:])
+
+	msg, ok := m.read(ptr, int32(size))
+	if !ok || size == 0 {
+		return e
+	}
+
+	e.Stage = Stage(load32(m.memory[info+4:]))
+	e.Code = int(load32(m.memory[info+8:]))
+	e.Message = string(msg)
+
+	return e
+}
+
 func (m *module) write(ptr int32, data []byte) bool {
 	if ptr < 0 || int(ptr)+len(data) > len(m.memory) {
 		return false
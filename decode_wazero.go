@@ -15,50 +15,200 @@ import (
 
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
 )
 
 //go:embed lib/heic.wasm.gz
 var heicWasm []byte
 
 type module struct {
+	rt        *wasmRuntime
 	mod       api.Module
+	mem       *linearMemory
 	alloc     api.Function
 	free      api.Function
 	decode    api.Function
 	decodeSeq api.Function
+	lastError api.Function // nil when the embedded module predates the export.
+	planes    api.Function // nil when the embedded module predates the export.
+
+	// trapped is set once a call fails; the instance state is then unknown and it is not reused.
+	trapped bool
+}
+
+// getModule returns a pooled module with its memory capped at opts.MaxMemoryPages, or the error of the
+// compilation, or ctx.Err() when ctx is done while the module is compiled.
+func getModule(ctx context.Context, opts *Options) (*module, error) {
+	w := wasmRT
+	if err := w.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	limit := uint64(opts.MaxMemoryPages) * wasmPageSize
+	if opts.MaxMemoryPages > 0 && opts.MaxMemoryPages < w.minPages {
+		return nil, fmt.Errorf("%w: wasm memory of %d pages is below the %d pages of the module", ErrLimitExceeded, opts.MaxMemoryPages, w.minPages)
+	}
+
+	m, _ := w.pool.Get().(*module)
+	if m != nil && limit > 0 && uint64(len(m.mem.buf)) > limit {
+		// The memory of a pooled instance never shrinks, so one that has grown past the limit is dropped.
+		m.mod.Close(context.Background())
+		m = nil
+	}
+	if m == nil {
+		var err error
+		if m, err = w.newModule(); err != nil {
+			return nil, err
+		}
+	}
+
+	m.mem.limit = limit
+	m.mem.exceeded = false
+
+	return m, nil
 }
 
-var modPool = sync.Pool{New: func() any { return newModule() }}
+// release returns m to its pool unless it trapped or a done context has closed it.
+func (m *module) release() {
+	if m.trapped || m.mod.IsClosed() {
+		m.mod.Close(context.Background())
+		return
+	}
+
+	m.rt.pool.Put(m)
+}
+
+// callErr returns ctx.Err() when a call was aborted by ctx, otherwise err prefixed with name. A trap after
+// the memory failed to grow past the limit, which the module aborts on, is reported as ErrLimitExceeded.
+func (m *module) callErr(ctx context.Context, opts *Options, name string, err error) error {
+	m.trapped = true
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if m.mem.exceeded {
+		return fmt.Errorf("%w: %s: wasm memory of %d pages: %v", ErrLimitExceeded, name, opts.MaxMemoryPages, err)
+	}
+
+	return fmt.Errorf("%s: %w", name, err)
+}
+
+// allocErr reports a failed malloc, which can only be caused by the memory limit or an exhausted address space.
+func (m *module) allocErr(opts *Options) error {
+	if m.mem.exceeded {
+		return fmt.Errorf("%w: wasm memory of %d pages", ErrLimitExceeded, opts.MaxMemoryPages)
+	}
+
+	return ErrMemWrite
+}
+
+// decodeErr returns the shim's last error, using the 3*4 bytes at infoPtr, or a generic one for stage. A decode
+// that failed after the memory could not grow past the limit is reported as ErrLimitExceeded.
+func (m *module) decodeErr(ctx context.Context, infoPtr uint64, stage Stage) error {
+	if m.mem.exceeded {
+		return fmt.Errorf("%w: %s: wasm memory of %d pages", ErrLimitExceeded, stage, m.mem.limit/wasmPageSize)
+	}
+
+	e := newWasmError(stage)
+	if m.lastError == nil {
+		return e
+	}
+
+	res, err := m.lastError.Call(ctx, infoPtr)
+	if err != nil {
+		return e
+	}
+
+	mem := m.mod.Memory()
+	size, _ := mem.ReadUint32Le(uint32(infoPtr))
+	st, _ := mem.ReadUint32Le(uint32(infoPtr) + 4)
+	code, _ := mem.ReadUint32Le(uint32(infoPtr) + 8)
+
+	msg, ok := mem.Read(uint32(res[0]), size)
+	if !ok || size == 0 {
+		return e
+	}
+
+	e.Stage = Stage(st)
+	e.Code = int(code)
+	e.Message = string(msg)
+
+	return e
+}
 
-func newModule() *module {
-	initWasmOnce()
+func (w *wasmRuntime) newModule() (*module, error) {
+	mem := &linearMemory{}
+	ctx := experimental.WithMemoryAllocator(context.Background(), experimental.MemoryAllocatorFunc(
+		func(_, _ uint64) experimental.LinearMemory {
+			return mem
+		}))
 
-	mod, err := rt.InstantiateModule(context.Background(), cm, mc)
+	mod, err := w.rt.InstantiateModule(ctx, w.cm, mc)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("heic: wasm: %w", err)
 	}
 
 	return &module{
+		rt:        w,
 		mod:       mod,
+		mem:       mem,
 		alloc:     mod.ExportedFunction("malloc"),
 		free:      mod.ExportedFunction("free"),
 		decode:    mod.ExportedFunction("decode"),
 		decodeSeq: mod.ExportedFunction("decode_sequence"),
+		lastError: mod.ExportedFunction("last_error"),
+		planes:    mod.ExportedFunction("decode_planes"),
+	}, nil
+}
+
+// wasmPageSize is the size of a page of WASM linear memory.
+const wasmPageSize = 65536
+
+// linearMemory backs the memory of a module instance and fails to grow it past limit bytes, if set. Each
+// instance has its own, so instances with different limits share the compiled module.
+type linearMemory struct {
+	buf      []byte
+	limit    uint64
+	exceeded bool // Set when the memory failed to grow past limit.
+}
+
+// Reallocate implements experimental.LinearMemory.
+func (l *linearMemory) Reallocate(size uint64) []byte {
+	if l.limit > 0 && size > l.limit {
+		l.exceeded = true
+		return nil
+	}
+
+	if n := uint64(len(l.buf)); size > n {
+		l.buf = append(l.buf, make([]byte, size-n)...)
 	}
+
+	return l.buf[:size]
 }
 
-func decodeSequence(annexb []byte) ([][]byte, int, int, error) {
-	m := modPool.Get().(*module)
-	defer modPool.Put(m)
+// Free implements experimental.LinearMemory.
+func (l *linearMemory) Free() {
+	l.buf = nil
+}
+
+func decodeSequence(ctx context.Context, annexb []byte, opts *Options) ([][]byte, int, int, error) {
+	m, err := getModule(ctx, opts)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer m.release()
 
-	ctx := context.Background()
 	mem := m.mod.Memory()
 
 	res, err := m.alloc.Call(ctx, uint64(len(annexb)))
 	if err != nil {
-		return nil, 0, 0, fmt.Errorf("alloc: %w", err)
+		return nil, 0, 0, m.callErr(ctx, opts, "alloc", err)
 	}
 	inPtr := res[0]
+	if inPtr == 0 {
+		return nil, 0, 0, m.allocErr(opts)
+	}
 	defer m.free.Call(ctx, inPtr)
 
 	if !mem.Write(uint32(inPtr), annexb) {
@@ -67,14 +217,17 @@ func decodeSequence(annexb []byte) ([][]byte, int, int, error) {
 
 	res, err = m.alloc.Call(ctx, 3*4)
 	if err != nil {
-		return nil, 0, 0, fmt.Errorf("alloc: %w", err)
+		return nil, 0, 0, m.callErr(ctx, opts, "alloc", err)
 	}
 	infoPtr := res[0]
+	if infoPtr == 0 {
+		return nil, 0, 0, m.allocErr(opts)
+	}
 	defer m.free.Call(ctx, infoPtr)
 
 	res, err = m.decodeSeq.Call(ctx, inPtr, uint64(len(annexb)), infoPtr)
 	if err != nil {
-		return nil, 0, 0, fmt.Errorf("decode_sequence: %w", err)
+		return nil, 0, 0, m.callErr(ctx, opts, "decode_sequence", err)
 	}
 	outPtr := res[0]
 
@@ -83,7 +236,7 @@ func decodeSequence(annexb []byte) ([][]byte, int, int, error) {
 	count, _ := mem.ReadUint32Le(uint32(infoPtr) + 8)
 
 	if outPtr == 0 || count == 0 || width == 0 || height == 0 {
-		return nil, 0, 0, ErrDecode
+		return nil, 0, 0, m.decodeErr(ctx, infoPtr, StageDecode)
 	}
 	defer m.free.Call(ctx, outPtr)
 
@@ -103,7 +256,69 @@ func decodeSequence(annexb []byte) ([][]byte, int, int, error) {
 	return frames, int(width), int(height), nil
 }
 
-func decode(r io.Reader, configOnly bool) (image.Image, image.Config, error) {
+// decodePlanes decodes the first frame of an Annex-B stream to its Y, Cb and Cr planes, or returns errNoPlanes
+// when the embedded module cannot.
+func decodePlanes(ctx context.Context, annexb []byte, opts *Options) (*image.YCbCr, error) {
+	m, err := getModule(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer m.release()
+
+	if m.planes == nil {
+		return nil, errNoPlanes
+	}
+
+	mem := m.mod.Memory()
+
+	res, err := m.alloc.Call(ctx, uint64(len(annexb)))
+	if err != nil {
+		return nil, m.callErr(ctx, opts, "alloc", err)
+	}
+	inPtr := res[0]
+	if inPtr == 0 {
+		return nil, m.allocErr(opts)
+	}
+	defer m.free.Call(ctx, inPtr)
+
+	if !mem.Write(uint32(inPtr), annexb) {
+		return nil, ErrMemWrite
+	}
+
+	res, err = m.alloc.Call(ctx, 3*4)
+	if err != nil {
+		return nil, m.callErr(ctx, opts, "alloc", err)
+	}
+	infoPtr := res[0]
+	if infoPtr == 0 {
+		return nil, m.allocErr(opts)
+	}
+	defer m.free.Call(ctx, infoPtr)
+
+	res, err = m.planes.Call(ctx, inPtr, uint64(len(annexb)), infoPtr)
+	if err != nil {
+		return nil, m.callErr(ctx, opts, "decode_planes", err)
+	}
+	outPtr := res[0]
+
+	width, _ := mem.ReadUint32Le(uint32(infoPtr))
+	height, _ := mem.ReadUint32Le(uint32(infoPtr) + 4)
+	format, _ := mem.ReadUint32Le(uint32(infoPtr) + 8)
+
+	if outPtr == 0 || width == 0 || height == 0 {
+		return nil, m.decodeErr(ctx, infoPtr, StageDecode)
+	}
+	defer m.free.Call(ctx, outPtr)
+
+	out, ok := mem.Read(uint32(outPtr), uint32(planesSize(int(width), int(height), int(format))))
+	if !ok {
+		return nil, ErrMemRead
+	}
+
+	return newPlanes(out, int(width), int(height), int(format))
+}
+
+func decode(ctx context.Context, r io.Reader, configOnly bool, opts *Options) (image.Image, image.Config, error) {
 	var cfg image.Config
 
 	var data []byte
@@ -111,46 +326,80 @@ func decode(r io.Reader, configOnly bool) (image.Image, image.Config, error) {
 	if configOnly {
 		data, err = io.ReadAll(io.LimitReader(r, heifMaxHeaderSize))
 	} else {
-		data, err = io.ReadAll(r)
+		data, err = opts.readInput(r)
 	}
 	if err != nil {
-		return nil, cfg, fmt.Errorf("read: %w", err)
+		return nil, cfg, err
 	}
 
-	m := modPool.Get().(*module)
-	defer modPool.Put(m)
+	m, err := getModule(ctx, opts)
+	if err != nil {
+		return nil, cfg, err
+	}
+	defer m.release()
 
-	ctx := context.Background()
 	mem := m.mod.Memory()
 
 	inSize := len(data)
 
 	res, err := m.alloc.Call(ctx, uint64(inSize))
 	if err != nil {
-		return nil, cfg, fmt.Errorf("alloc: %w", err)
+		return nil, cfg, m.callErr(ctx, opts, "alloc", err)
 	}
 	inPtr := res[0]
+	if inPtr == 0 {
+		return nil, cfg, m.allocErr(opts)
+	}
 	defer m.free.Call(ctx, inPtr)
 
 	if !mem.Write(uint32(inPtr), data) {
 		return nil, cfg, ErrMemWrite
 	}
 
-	res, err = m.alloc.Call(ctx, 2*4)
+	res, err = m.alloc.Call(ctx, 3*4)
 	if err != nil {
-		return nil, cfg, fmt.Errorf("alloc: %w", err)
+		return nil, cfg, m.callErr(ctx, opts, "alloc", err)
 	}
 	infoPtr := res[0]
+	if infoPtr == 0 {
+		return nil, cfg, m.allocErr(opts)
+	}
 	defer m.free.Call(ctx, infoPtr)
 
-	cfgOnly := uint64(0)
-	if configOnly {
-		cfgOnly = 1
+	cfg.ColorModel = color.NRGBAModel
+
+	if configOnly || opts.hasSizeLimit() {
+		if _, err = m.decode.Call(ctx, inPtr, uint64(inSize), 1, infoPtr); err != nil {
+			return nil, cfg, m.callErr(ctx, opts, "decode", err)
+		}
+
+		width, ok := mem.ReadUint32Le(uint32(infoPtr))
+		if !ok {
+			return nil, cfg, ErrMemRead
+		}
+		height, ok := mem.ReadUint32Le(uint32(infoPtr) + 4)
+		if !ok {
+			return nil, cfg, ErrMemRead
+		}
+		if width == 0 {
+			return nil, image.Config{}, m.decodeErr(ctx, infoPtr, StageParse)
+		}
+
+		cfg.Width = int(width)
+		cfg.Height = int(height)
+
+		if err := opts.checkSize(cfg.Width, cfg.Height); err != nil {
+			return nil, image.Config{}, err
+		}
+
+		if configOnly {
+			return nil, cfg, nil
+		}
 	}
 
-	res, err = m.decode.Call(ctx, inPtr, uint64(inSize), cfgOnly, infoPtr)
+	res, err = m.decode.Call(ctx, inPtr, uint64(inSize), 0, infoPtr)
 	if err != nil {
-		return nil, cfg, fmt.Errorf("decode: %w", err)
+		return nil, cfg, m.callErr(ctx, opts, "decode", err)
 	}
 
 	width, ok := mem.ReadUint32Le(uint32(infoPtr))
@@ -164,18 +413,10 @@ func decode(r io.Reader, configOnly bool) (image.Image, image.Config, error) {
 
 	cfg.Width = int(width)
 	cfg.Height = int(height)
-	cfg.ColorModel = color.NRGBAModel
-
-	if configOnly {
-		if width == 0 {
-			return nil, image.Config{}, ErrDecode
-		}
-		return nil, cfg, nil
-	}
 
 	outPtr := res[0]
 	if outPtr == 0 {
-		return nil, cfg, ErrDecode
+		return nil, cfg, m.decodeErr(ctx, infoPtr, StageDecode)
 	}
 	defer m.free.Call(ctx, outPtr)
 
@@ -191,33 +432,73 @@ func decode(r io.Reader, configOnly bool) (image.Image, image.Config, error) {
 	return img, cfg, nil
 }
 
-var (
-	rt wazero.Runtime
-	cm wazero.CompiledModule
-	mc wazero.ModuleConfig
+// wasmRuntime is the wazero runtime with the compiled module and a pool of its instances. It closes a module
+// when the context of its call is done, so that a deadline aborts a running decode; the checks for it slow
+// down the compiled code also for calls that cannot be cancelled.
+type wasmRuntime struct {
+	once     sync.Once
+	compiled chan struct{} // Closed once the module is compiled, or has failed to.
+	err      error         // Compilation error, set before compiled is closed.
+	rt       wazero.Runtime
+	cm       wazero.CompiledModule
+	minPages uint32 // Initial size of the memory of the module.
+	pool     sync.Pool
+}
 
-	initWasmOnce = sync.OnceFunc(initialize)
-)
+var mc = wazero.NewModuleConfig().WithName("")
+
+// wasmRT compiles the module once on first use.
+var wasmRT = &wasmRuntime{compiled: make(chan struct{})}
+
+// wait compiles the module on first use and waits until it is compiled or ctx is done. Compiling takes
+// longer than many decodes and cannot be interrupted, so it runs on its own goroutine and carries on for
+// the next call when ctx is done. A failed compilation is returned to every caller.
+func (w *wasmRuntime) wait(ctx context.Context) error {
+	w.once.Do(func() {
+		go w.compile()
+	})
+
+	select {
+	case <-w.compiled:
+		return w.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *wasmRuntime) compile() {
+	defer close(w.compiled)
 
-func initialize() {
 	ctx := context.Background()
-	rt = wazero.NewRuntime(ctx)
 
+	bin, err := decompress()
+	if err != nil {
+		w.err = fmt.Errorf("heic: wasm: %w", err)
+		return
+	}
+
+	w.rt = wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	if w.cm, err = w.rt.CompileModule(ctx, bin); err != nil {
+		w.err = fmt.Errorf("heic: wasm: compile: %w", err)
+		return
+	}
+
+	for _, mem := range w.cm.ExportedMemories() {
+		w.minPages = mem.Min()
+	}
+}
+
+func decompress() ([]byte, error) {
 	r, err := gzip.NewReader(bytes.NewReader(heicWasm))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer r.Close()
 
 	var data bytes.Buffer
 	if _, err := data.ReadFrom(r); err != nil {
-		panic(err)
-	}
-
-	cm, err = rt.CompileModule(ctx, data.Bytes())
-	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	mc = wazero.NewModuleConfig().WithName("")
+	return data.Bytes(), nil
 }
@@ -0,0 +1,22 @@
+//go:build !wasm2go
+
+package heic
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCompileError(t *testing.T) {
+	bin := heicWasm
+	defer func() { heicWasm = bin }()
+
+	// A module that fails to compile is an error for every caller, not a panic.
+	heicWasm = []byte("not gzip")
+	w := &wasmRuntime{compiled: make(chan struct{})}
+	for range 2 {
+		if err := w.wait(context.Background()); err == nil {
+			t.Error("no error")
+		}
+	}
+}
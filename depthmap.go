@@ -0,0 +1,328 @@
+package heic
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+// Auxiliary types of a depth map, as used by HEVC and by MPEG-B (CICP) files.
+const (
+	depthURN      = "urn:mpeg:hevc:2015:auxid:2"
+	depthURNMPEGB = "urn:mpeg:mpegB:cicp:systems:auxiliary:depth"
+)
+
+// isDepth reports whether the auxiliary type aux names a depth map.
+func isDepth(aux string) bool {
+	return aux == depthURN || aux == depthURNMPEGB
+}
+
+// ErrNoDepth is returned by DecodeDepth when the primary image has no depth map.
+var ErrNoDepth = errors.New("heic: no depth map")
+
+// DepthType is the depth_representation_type of a depth map: how its samples map to depth.
+type DepthType int
+
+const (
+	// DepthUniformInverseZ samples are uniformly quantized 1/Z, from 1/ZFar to 1/ZNear.
+	DepthUniformInverseZ DepthType = iota
+	// DepthUniformDisparity samples are uniformly quantized disparity, from DMin to DMax.
+	DepthUniformDisparity
+	// DepthUniformZ samples are uniformly quantized Z, from ZNear to ZFar.
+	DepthUniformZ
+	// DepthNonuniformDisparity samples are disparity quantized by a piecewise linear model.
+	DepthNonuniformDisparity
+)
+
+// DepthRepresentation is the depth representation information of a depth map, from its
+// depth_representation_info SEI message. Values whose flag is false are absent.
+type DepthRepresentation struct {
+	Type DepthType
+
+	HasZNear, HasZFar bool
+	ZNear, ZFar       float64 // Nearest and farthest depth.
+
+	HasDMin, HasDMax bool
+	DMin, DMax       float64 // Minimum and maximum disparity.
+
+	DisparityReferenceView int   // View the disparity refers to, with DMin or DMax.
+	NonlinearModel         []int // Piecewise linear model of DepthNonuniformDisparity.
+}
+
+// AppleDepth holds the depth data properties of Apple cameras, from the apdi namespace of the XMP of the
+// depth map. Missing properties are empty.
+type AppleDepth struct {
+	NativeFormat string // Pixel format of the captured data, e.g. hdis for 16-bit disparity or fdep for 32-bit depth.
+	Accuracy     string // relative or absolute.
+	Filtered     bool   // Holes have been filled.
+	Quality      string // e.g. high or low.
+}
+
+// DepthInfo describes the depth map of an image.
+type DepthInfo struct {
+	ID      uint32 // Item ID of the depth map image.
+	AuxType string // Auxiliary type URN.
+
+	Representation *DepthRepresentation // From the depth_representation_info SEI message, or nil.
+	Apple          *AppleDepth          // From the Apple XMP of the depth map, or nil.
+}
+
+// DecodeDepth decodes the depth (or disparity) map of the primary image, an auxiliary image resolved through
+// the auxl item references, as *image.Gray16, with its depth representation information. It returns
+// ErrNoDepth if there is none. As with BitDepth, the WASM decoder returns ErrUnsupported for a depth map coded
+// with more than 8 bits.
+func DecodeDepth(r io.Reader) (*image.Gray16, *DepthInfo, error) {
+	return decodeDepth(context.Background(), r, nil)
+}
+
+// DecodeDepthWithOptions is like DecodeDepth using the backend, limits and transformations of opts; the depth
+// map is always decoded as 16-bit gray without colour conversion.
+func DecodeDepthWithOptions(r io.Reader, opts *Options) (*image.Gray16, *DepthInfo, error) {
+	return decodeDepth(context.Background(), r, opts)
+}
+
+// DecodeDepthWithOptionsContext is like DecodeDepthWithOptions, but aborts the decoding and returns ctx.Err()
+// when ctx is done, as DecodeContext does.
+func DecodeDepthWithOptionsContext(ctx context.Context, r io.Reader, opts *Options) (*image.Gray16, *DepthInfo, error) {
+	return decodeDepth(ctx, r, opts)
+}
+
+func decodeDepth(ctx context.Context, r io.Reader, opts *Options) (*image.Gray16, *DepthInfo, error) {
+	if opts == nil {
+		opts = defaultOptions
+	}
+
+	data, err := opts.readInput(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := isobmff.Parse(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("heic: %w", err)
+	}
+	if f.Meta == nil {
+		return nil, nil, ErrNoMeta
+	}
+
+	info := depthInfo(data, f.Meta)
+	if info == nil {
+		return nil, nil, ErrNoDepth
+	}
+
+	img, err := decodeImage(ctx, bytes.NewReader(data), opts.auxOptions(info.ID, FormatGray, 16))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return img.(*image.Gray16), info, nil
+}
+
+// depthInfo returns the depth map of the primary image of meta, or nil.
+func depthInfo(data []byte, meta *isobmff.Meta) *DepthInfo {
+	for _, aux := range auxImages(meta, meta.PrimaryItemID()) {
+		if !isDepth(aux.Type) {
+			continue
+		}
+
+		id := aux.ID
+		info := &DepthInfo{ID: id, AuxType: aux.Type}
+
+		// The SEI message is in the auxC subtype, or else with the parameter sets.
+		info.Representation = depthSEI(aux.Subtype)
+		if hvcC, ok := meta.ItemProperty(id, "hvcC").(*isobmff.HEVCConfig); ok && info.Representation == nil {
+			for _, nal := range hvcC.ParameterSets() {
+				if info.Representation = depthSEI(nal); info.Representation != nil {
+					break
+				}
+			}
+		}
+
+		info.Apple = appleDepth(itemXMP(data, meta, id))
+
+		return info
+	}
+
+	return nil
+}
+
+// appleDepth returns the apdi properties of the XMP properties props, or nil without any.
+func appleDepth(props map[xml.Name][]string) *AppleDepth {
+	var d AppleDepth
+	var found bool
+
+	for name, v := range map[string]*string{"NativeFormat": &d.NativeFormat, "Accuracy": &d.Accuracy, "Quality": &d.Quality} {
+		if s, ok := xmpText(props, nsAppleDepth, name); ok {
+			*v, found = s, true
+		}
+	}
+
+	if s, ok := xmpText(props, nsAppleDepth, "Filtered"); ok {
+		d.Filtered, _ = strconv.ParseBool(s)
+		found = true
+	}
+
+	if !found {
+		return nil
+	}
+
+	return &d
+}
+
+// depthSEI finds a prefix SEI NAL unit with a depth_representation_info message (payload type 177) in b
+// and parses it, or returns nil.
+func depthSEI(b []byte) *DepthRepresentation {
+	const nalPrefixSEI = 39
+
+	for i := 0; i+3 <= len(b); i++ {
+		if b[i] != nalPrefixSEI<<1 || b[i+1] != 1 || b[i+2] != 177 {
+			continue
+		}
+
+		rbsp := unescapeRBSP(b[i+2:])
+
+		// payloadType is 177; payloadSize is coded as a run of 0xff bytes and a last byte.
+		p := 1
+		size := 0
+		for p < len(rbsp) && rbsp[p] == 0xff {
+			size += 255
+			p++
+		}
+		if p >= len(rbsp) {
+			return nil
+		}
+		size += int(rbsp[p])
+		p++
+
+		if p+size > len(rbsp) {
+			return nil
+		}
+
+		if d, err := parseDepthRepresentation(rbsp[p : p+size]); err == nil {
+			return d
+		}
+	}
+
+	return nil
+}
+
+// unescapeRBSP removes the emulation prevention bytes of a NAL unit.
+func unescapeRBSP(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+
+	zeros := 0
+	for _, c := range b {
+		if zeros >= 2 && c == 3 {
+			zeros = 0
+			continue
+		}
+
+		out = append(out, c)
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+
+	return out
+}
+
+var errSEI = errors.New("depth_representation_info too short")
+
+// parseDepthRepresentation parses a depth_representation_info SEI message payload.
+func parseDepthRepresentation(b []byte) (*DepthRepresentation, error) {
+	r := &bitReader{b: b}
+	d := &DepthRepresentation{}
+
+	d.HasZNear, d.HasZFar, d.HasDMin, d.HasDMax = r.flag(), r.flag(), r.flag(), r.flag()
+	d.Type = DepthType(r.ue())
+	if d.HasDMin || d.HasDMax {
+		d.DisparityReferenceView = r.ue()
+	}
+
+	for _, e := range []struct {
+		has bool
+		v   *float64
+	}{{d.HasZNear, &d.ZNear}, {d.HasZFar, &d.ZFar}, {d.HasDMin, &d.DMin}, {d.HasDMax, &d.DMax}} {
+		if !e.has {
+			continue
+		}
+
+		sign, exp := r.bits(1), r.bits(7)
+		n := r.bits(5) + 1
+		mantissa := float64(r.bits(n))
+
+		switch {
+		case exp == 0:
+			*e.v = math.Ldexp(mantissa, -(30 + n))
+		default:
+			*e.v = math.Ldexp(1+mantissa/math.Exp2(float64(n)), exp-31)
+		}
+		if sign == 1 {
+			*e.v = -*e.v
+		}
+	}
+
+	if d.Type == DepthNonuniformDisparity {
+		n := r.ue() + 1
+		if n > 64 {
+			return nil, errSEI
+		}
+		for range n {
+			d.NonlinearModel = append(d.NonlinearModel, r.ue())
+		}
+	}
+
+	if r.err {
+		return nil, errSEI
+	}
+
+	return d, nil
+}
+
+// bitReader reads the bits of b, most significant first; reading past the end sets err.
+type bitReader struct {
+	b   []byte
+	pos int
+	err bool
+}
+
+func (r *bitReader) bits(n int) int {
+	v := 0
+	for range n {
+		if r.pos >= 8*len(r.b) {
+			r.err = true
+			return 0
+		}
+		v = v<<1 | int(r.b[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+
+	return v
+}
+
+func (r *bitReader) flag() bool {
+	return r.bits(1) == 1
+}
+
+// ue reads an unsigned Exp-Golomb code.
+func (r *bitReader) ue() int {
+	zeros := 0
+	for r.bits(1) == 0 && !r.err {
+		if zeros++; zeros > 31 {
+			r.err = true
+			return 0
+		}
+	}
+
+	return 1<<zeros - 1 + r.bits(zeros)
+}
@@ -0,0 +1,91 @@
+package heic
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"image"
+	"testing"
+)
+
+//go:embed testdata/depth.heic
+var testDepth []byte
+
+func TestDecodeDepth(t *testing.T) {
+	img, info, err := DecodeDepth(bytes.NewReader(testDepth))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.Rect != image.Rect(0, 0, 512, 512) {
+		t.Errorf("bounds %v", img.Rect)
+	}
+	if info.ID != 2 || info.AuxType != depthURN {
+		t.Errorf("info %+v", info)
+	}
+
+	d := info.Representation
+	if d == nil {
+		t.Fatal("no depth representation")
+	}
+	if d.Type != DepthUniformZ || !d.HasZNear || !d.HasZFar || d.HasDMin || d.HasDMax || d.ZNear != 0.5 || d.ZFar != 10 {
+		t.Errorf("representation %+v", d)
+	}
+
+	want := AppleDepth{NativeFormat: "hdis", Accuracy: "relative", Filtered: true}
+	if info.Apple == nil || *info.Apple != want {
+		t.Errorf("apple %+v, want %+v", info.Apple, want)
+	}
+
+	// The depth map is not the alpha plane, and the primary image keeps decoding as usual.
+	if i, err := DecodeInfo(bytes.NewReader(testDepth)); err != nil || i.Alpha {
+		t.Errorf("info %+v, %v", i, err)
+	}
+
+	if _, _, err := DecodeDepth(bytes.NewReader(testHeic8)); !errors.Is(err, ErrNoDepth) {
+		t.Errorf("err = %v, want ErrNoDepth", err)
+	}
+}
+
+func TestParseDepthRepresentation(t *testing.T) {
+	// Nonuniform disparity with a negative zero d_min, in the exponent 0 form, and a two-point model.
+	// 0010 00100 1 | 1 0000000 00000 0 | 010 1 010
+	w := []byte{0b0010_0010, 0b0110_0000, 0b0000_0000, 0b0101_0100}
+	d, err := parseDepthRepresentation(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Type != DepthNonuniformDisparity || !d.HasDMin || d.DMin != 0 || len(d.NonlinearModel) != 2 || d.NonlinearModel[1] != 1 {
+		t.Errorf("representation %+v", d)
+	}
+
+	if _, err := parseDepthRepresentation([]byte{0xf0}); err == nil {
+		t.Error("truncated: no error")
+	}
+}
+
+func TestUnescapeRBSP(t *testing.T) {
+	if got := unescapeRBSP([]byte{1, 0, 0, 3, 1, 0, 3}); !bytes.Equal(got, []byte{1, 0, 0, 1, 0, 3}) {
+		t.Errorf("unescape = %v", got)
+	}
+}
+
+func TestDecodeDepthWithOptions(t *testing.T) {
+	// The limits apply to the 512x512 depth map.
+	opts := &Options{Backend: BackendWASM, MaxPixels: 512*512 - 1}
+	if _, _, err := DecodeDepthWithOptions(bytes.NewReader(testDepth), opts); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("got %v, want %v", err, ErrLimitExceeded)
+	}
+
+	opts = &Options{Backend: BackendWASM, MaxInputSize: int64(len(testDepth)), ColorSpace: ColorSpaceSRGB}
+	if _, _, err := DecodeDepthWithOptions(bytes.NewReader(testDepth), opts); err != nil {
+		t.Error(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := DecodeDepthWithOptionsContext(ctx, bytes.NewReader(testDepth), opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
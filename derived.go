@@ -0,0 +1,182 @@
+package heic
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+// maxDerivation limits the nesting of derived images, which could otherwise reference each other in a loop.
+const maxDerivation = 8
+
+// Overlay is the layout of an overlay (iovl) derived image: its inputs, listed in Item.Inputs, are layered
+// from the bottom up on a Width x Height canvas filled with Fill, with their top-left corners at Offsets.
+type Overlay struct {
+	Width, Height int
+	Fill          color.NRGBA64
+	Offsets       []image.Point // Offset of each input; may be negative or past the canvas.
+}
+
+// parseOverlay parses the ImageOverlay description of an iovl item with n inputs.
+func parseOverlay(b []byte, n int) (*Overlay, error) {
+	if len(b) < 2 || b[0] != 0 {
+		return nil, errors.New("unsupported overlay version")
+	}
+
+	size := 2
+	if b[1]&1 != 0 {
+		size = 4
+	}
+	if len(b) < 10+2*size+2*n*size {
+		return nil, errors.New("overlay description too short")
+	}
+
+	field := func(p int) int {
+		if size == 2 {
+			return int(binary.BigEndian.Uint16(b[p:]))
+		}
+		return int(binary.BigEndian.Uint32(b[p:]))
+	}
+	signed := func(p int) int {
+		if size == 2 {
+			return int(int16(binary.BigEndian.Uint16(b[p:])))
+		}
+		return int(int32(binary.BigEndian.Uint32(b[p:])))
+	}
+
+	o := &Overlay{
+		Fill: color.NRGBA64{
+			R: binary.BigEndian.Uint16(b[2:]),
+			G: binary.BigEndian.Uint16(b[4:]),
+			B: binary.BigEndian.Uint16(b[6:]),
+			A: binary.BigEndian.Uint16(b[8:]),
+		},
+		Width:  field(10),
+		Height: field(10 + size),
+	}
+	if o.Width == 0 || o.Height == 0 {
+		return nil, fmt.Errorf("overlay size %dx%d", o.Width, o.Height)
+	}
+
+	p := 10 + 2*size
+	for range n {
+		o.Offsets = append(o.Offsets, image.Pt(signed(p), signed(p+size)))
+		p += 2 * size
+	}
+
+	return o, nil
+}
+
+// itemOverlay returns the layout of iovl item id and its inputs.
+func itemOverlay(r *bytes.Reader, meta *isobmff.Meta, id uint32) (*Overlay, []uint32, error) {
+	inputs := meta.References(id, "dimg")
+	if len(inputs) == 0 {
+		return nil, nil, itemError(StageParse, CodeInvalidInput, "item %d: overlay without inputs", id)
+	}
+
+	desc, err := meta.ReadItem(r, id)
+	if err != nil {
+		return nil, nil, itemError(StageParse, CodeInvalidInput, "item %d: %v", id, err)
+	}
+
+	o, err := parseOverlay(desc, len(inputs))
+	if err != nil {
+		return nil, nil, itemError(StageParse, CodeInvalidInput, "item %d: %v", id, err)
+	}
+
+	return o, inputs, nil
+}
+
+// decodeOverlayItem decodes the inputs of an iovl item and composites them on its canvas.
+func decodeOverlayItem(ctx context.Context, data []byte, meta *isobmff.Meta, id uint32, opts *Options, depth int) (*image.NRGBA, error) {
+	o, inputs, err := itemOverlay(bytes.NewReader(data), meta, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := opts.checkSize(o.Width, o.Height); err != nil {
+		return nil, err
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, o.Width, o.Height))
+	fill := color.NRGBAModel.Convert(o.Fill).(color.NRGBA)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = fill.R, fill.G, fill.B, fill.A
+	}
+
+	for i, in := range inputs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		src, alpha, err := decodeInput(ctx, data, meta, id, in, opts, depth)
+		if err != nil {
+			return nil, err
+		}
+
+		// Layers without an alpha plane replace the pixels below them.
+		op := draw.Src
+		if alpha {
+			op = draw.Over
+		}
+
+		b := src.Bounds()
+		draw.Draw(dst, b.Sub(b.Min).Add(o.Offsets[i]), src, b.Min, op)
+	}
+
+	return dst, nil
+}
+
+// decodeIdentityItem decodes the input of an iden item, whose own transformations are applied by the caller.
+func decodeIdentityItem(ctx context.Context, data []byte, meta *isobmff.Meta, id uint32, opts *Options, depth int) (*image.NRGBA, error) {
+	inputs := meta.References(id, "dimg")
+	if len(inputs) != 1 {
+		return nil, itemError(StageParse, CodeInvalidInput, "item %d: %d inputs for an identity item", id, len(inputs))
+	}
+
+	src, _, err := decodeInput(ctx, data, meta, id, inputs[0], opts, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	if img, ok := src.(*image.NRGBA); ok {
+		return img, nil
+	}
+
+	img := image.NewNRGBA(src.Bounds())
+	draw.Draw(img, img.Rect, src, img.Rect.Min, draw.Src)
+
+	return img, nil
+}
+
+// decodeInput decodes input item in of derived item id with its alpha plane and transformations. It reports
+// whether the input has an alpha plane.
+func decodeInput(ctx context.Context, data []byte, meta *isobmff.Meta, id, in uint32, opts *Options, depth int) (image.Image, bool, error) {
+	if depth >= maxDerivation {
+		return nil, false, itemError(StageParse, CodeInvalidInput, "item %d: derived images nested too deeply", id)
+	}
+
+	e := meta.Item(in)
+	if e == nil || !imageItemTypes[e.ItemType] {
+		return nil, false, itemError(StageParse, CodeInvalidInput, "item %d: no input image %d", id, in)
+	}
+
+	img, err := decodeItemImage(ctx, data, meta, e, opts, true, depth+1)
+	if err != nil {
+		return nil, false, err
+	}
+
+	aid, prem := alphaItem(meta, in)
+	if prem {
+		return premultiplied(img), true, nil
+	}
+
+	return img, aid != 0, nil
+}
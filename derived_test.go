@@ -0,0 +1,152 @@
+package heic
+
+import (
+	"bytes"
+	_ "embed"
+	"image"
+	"image/color"
+	"image/draw"
+	"reflect"
+	"testing"
+)
+
+//go:embed testdata/overlay.heic
+var testOverlay []byte
+
+//go:embed testdata/iden.heic
+var testIden []byte
+
+func TestItemsDerived(t *testing.T) {
+	items, err := Items(bytes.NewReader(testOverlay))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Overlay{
+		Width:   300,
+		Height:  200,
+		Fill:    color.NRGBA64{B: 0xffff, A: 0xffff},
+		Offsets: []image.Point{{10, 20}, {100, 60}},
+	}
+	if it := items[0]; it.Type != "iovl" || !reflect.DeepEqual(it.Inputs, []uint32{2, 3}) || !reflect.DeepEqual(it.Overlay, want) {
+		t.Errorf("overlay item %+v, layout %+v", it, it.Overlay)
+	}
+
+	items, err = Items(bytes.NewReader(testIden))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if it := items[0]; it.Type != "iden" || !reflect.DeepEqual(it.Inputs, []uint32{2}) || it.Width != 128 || it.Height != 176 {
+		t.Errorf("identity item %+v", it)
+	}
+
+	items, err = Items(bytes.NewReader(testHeic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, it := range items {
+		if it.Type == "grid" && len(it.Inputs) == 0 {
+			t.Errorf("grid item %d without inputs", it.ID)
+		}
+	}
+}
+
+func TestDecodeOverlay(t *testing.T) {
+	layer, err := DecodeItem(bytes.NewReader(testOverlay), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb := layer.Bounds()
+
+	// The Go item decoder composites the layers on the canvas.
+	img, err := DecodeWithOptions(bytes.NewReader(testOverlay), &Options{Backend: BackendWASM, ItemID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlay := img.(*image.NRGBA)
+	if overlay.Rect != image.Rect(0, 0, 300, 200) {
+		t.Fatalf("bounds %v", overlay.Rect)
+	}
+	if c := overlay.NRGBAAt(0, 0); c != (color.NRGBA{B: 0xff, A: 0xff}) {
+		t.Errorf("canvas = %v, want blue", c)
+	}
+	if !equalNRGBA(overlay.SubImage(lb.Add(image.Pt(100, 60))).(*image.NRGBA), translate(layer, image.Pt(100, 60))) {
+		t.Error("top layer differs from its item")
+	}
+
+	testBackends(t, func(t *testing.T, backend Backend) {
+		img, err := DecodeWithOptions(bytes.NewReader(testOverlay), &Options{Backend: backend, Format: FormatNRGBA})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds() != overlay.Rect {
+			t.Fatalf("bounds %v", img.Bounds())
+		}
+		if d := maxDiff(img.(*image.NRGBA), overlay); d > 8 {
+			t.Errorf("differs from the item decoder by %d", d)
+		}
+	})
+}
+
+func TestDecodeIdentity(t *testing.T) {
+	src, err := DecodeItem(bytes.NewReader(testIden), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := DecodeWithOptions(bytes.NewReader(testIden), &Options{Backend: BackendWASM, ItemID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	iden := img.(*image.NRGBA)
+
+	// irot 90, then imir 1, a left-right flip.
+	if want := mirror(rotate(src.(*image.NRGBA), 90), 1); !equalNRGBA(iden, want) {
+		t.Error("identity item differs from its transformed input")
+	}
+
+	img, err = DecodeWithOptions(bytes.NewReader(testIden), &Options{Backend: BackendWASM, ItemID: 1, IgnoreTransformations: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != src.Bounds() {
+		t.Errorf("untransformed bounds %v, want %v", img.Bounds(), src.Bounds())
+	}
+
+	testBackends(t, func(t *testing.T, backend Backend) {
+		img, err := DecodeWithOptions(bytes.NewReader(testIden), &Options{Backend: backend, Format: FormatNRGBA})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds() != iden.Rect {
+			t.Fatalf("bounds %v", img.Bounds())
+		}
+		if d := maxDiff(img.(*image.NRGBA), iden); d > 8 {
+			t.Errorf("differs from the item decoder by %d", d)
+		}
+	})
+}
+
+// translate returns a copy of img moved by p.
+func translate(img image.Image, p image.Point) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(b.Add(p))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+
+	return dst
+}
+
+// maxDiff returns the largest difference between the samples of a and b, which have the same bounds.
+func maxDiff(a, b *image.NRGBA) int {
+	d := 0
+	for y := a.Rect.Min.Y; y < a.Rect.Max.Y; y++ {
+		for x := a.Rect.Min.X; x < a.Rect.Max.X; x++ {
+			i, j := a.PixOffset(x, y), b.PixOffset(x, y)
+			for c := range 4 {
+				d = max(d, int(a.Pix[i+c])-int(b.Pix[j+c]), int(b.Pix[j+c])-int(a.Pix[i+c]))
+			}
+		}
+	}
+
+	return d
+}
@@ -0,0 +1,860 @@
+package heic
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+// MetadataEdit holds the changes EditMetadata makes to the metadata of a HEIC file. The zero value changes
+// nothing.
+type MetadataEdit struct {
+	// RemoveExif removes all EXIF metadata items.
+	RemoveExif bool
+	// Exif, if set, is TIFF data, starting with its byte order, that replaces all EXIF metadata items with
+	// one describing the primary image.
+	Exif []byte
+	// StripGPS removes the GPS IFD from the EXIF metadata, and erases it. XMP metadata is left as is; replace
+	// or remove it too if it may hold a location.
+	StripGPS bool
+	// Orientation, if non-zero, sets the EXIF orientation (1-8). The displayed orientation is that of the irot
+	// and imir properties, which are left as they are.
+	Orientation int
+
+	// RemoveXMP removes all XMP metadata items.
+	RemoveXMP bool
+	// XMP, if set, is an XMP packet that replaces all XMP metadata items with one describing the primary image.
+	XMP []byte
+}
+
+// EditMetadata copies the HEIC file read from r to w with the metadata changed by e, without re-encoding any
+// image. Edited and added metadata items are stored in the idat box; the data of replaced and removed items
+// is cut out of mdat, and the item locations and track chunk offsets are moved to match.
+func EditMetadata(w io.Writer, r io.Reader, e *MetadataEdit) error {
+	if e.Orientation < 0 || e.Orientation > 8 {
+		return fmt.Errorf("heic: invalid orientation %d", e.Orientation)
+	}
+	if e.Exif != nil {
+		if _, err := newExifReader(e.Exif); err != nil {
+			return fmt.Errorf("heic: exif: %w", err)
+		}
+	}
+
+	data, err := defaultOptions.readInput(r)
+	if err != nil {
+		return err
+	}
+
+	f, err := isobmff.Parse(data)
+	if err != nil {
+		return fmt.Errorf("heic: %w", err)
+	}
+	if f.Meta == nil || f.Meta.ItemInfo == nil || f.Meta.Location == nil {
+		return ErrNoMeta
+	}
+
+	ed := &editor{
+		data:  data,
+		file:  f,
+		meta:  f.Meta,
+		drop:  make(map[uint32]bool),
+		moved: make(map[uint32][]byte),
+	}
+	if err := ed.plan(e); err != nil {
+		return fmt.Errorf("heic: edit: %w", err)
+	}
+
+	out, err := ed.build()
+	if err != nil {
+		return fmt.Errorf("heic: edit: %w", err)
+	}
+
+	if _, err := w.Write(out); err != nil {
+		return fmt.Errorf("heic: write: %w", err)
+	}
+
+	return nil
+}
+
+// editor rewrites a HEIC file with some metadata items removed, moved to idat or added.
+type editor struct {
+	data []byte
+	file *isobmff.File
+	meta *isobmff.Meta
+
+	drop  map[uint32]bool   // Removed items.
+	moved map[uint32][]byte // New data of edited items, stored in idat.
+	added []addedItem       // New items, stored in idat, describing the primary image.
+	cuts  []span            // Sorted file ranges of the data of removed and edited items, cut out of mdat.
+}
+
+// addedItem is a new metadata item.
+type addedItem struct {
+	id          uint32
+	typ         string
+	contentType string
+	data        []byte
+}
+
+// span is the file range [off, end).
+type span struct {
+	off, end uint64
+}
+
+// plan decides the items to remove, move and add, and the data to cut.
+func (ed *editor) plan(e *MetadataEdit) error {
+	m := ed.meta
+
+	var next uint32
+	for _, it := range m.Items() {
+		next = max(next, it.ItemID)
+	}
+	for _, l := range m.Location.Items {
+		next = max(next, l.ItemID)
+	}
+	next++
+
+	add := func(typ, contentType string, data []byte) error {
+		if m.PrimaryItemID() == 0 {
+			return errors.New("no primary item to describe")
+		}
+		ed.added = append(ed.added, addedItem{id: next, typ: typ, contentType: contentType, data: data})
+		next++
+
+		return nil
+	}
+
+	for _, it := range m.ItemsOfType("Exif") {
+		switch {
+		case e.RemoveExif || e.Exif != nil:
+			ed.drop[it.ItemID] = true
+		case e.StripGPS || e.Orientation != 0:
+			raw, err := m.ReadItem(bytes.NewReader(ed.data), it.ItemID)
+			if err != nil {
+				return err
+			}
+			if len(raw) < 4 || 4+int(binary.BigEndian.Uint32(raw)) >= len(raw) {
+				return fmt.Errorf("item %d: invalid Exif data", it.ItemID)
+			}
+
+			start := 4 + int(binary.BigEndian.Uint32(raw))
+			tiff, err := e.editTIFF(raw[start:])
+			if err != nil {
+				return fmt.Errorf("item %d: %w", it.ItemID, err)
+			}
+			ed.moved[it.ItemID] = append(slices.Clip(raw[:start]), tiff...)
+		}
+	}
+
+	if e.Exif != nil && !e.RemoveExif {
+		tiff, err := e.editTIFF(e.Exif)
+		if err != nil {
+			return err
+		}
+		if err := add("Exif", "", append(make([]byte, 4), tiff...)); err != nil {
+			return err
+		}
+	}
+
+	for _, it := range m.ItemsOfType("mime") {
+		if isXMPItem(it) && (e.RemoveXMP || e.XMP != nil) {
+			ed.drop[it.ItemID] = true
+		}
+	}
+
+	if e.XMP != nil && !e.RemoveXMP {
+		if err := add("mime", xmpMIME, e.XMP); err != nil {
+			return err
+		}
+	}
+
+	for _, l := range m.Location.Items {
+		if !ed.drop[l.ItemID] && ed.moved[l.ItemID] == nil {
+			continue
+		}
+		if l.ConstructionMethod != isobmff.ConstructionFile || l.DataReferenceIndex != 0 {
+			continue
+		}
+
+		for _, x := range l.Extents {
+			s, err := ed.fileSpan(l, x)
+			if err != nil {
+				return err
+			}
+			if ed.mdat(s) < 0 {
+				return fmt.Errorf("item %d: data outside mdat", l.ItemID)
+			}
+			ed.cuts = append(ed.cuts, s)
+		}
+	}
+
+	// Merge the cuts, as items may share data.
+	slices.SortFunc(ed.cuts, func(a, b span) int {
+		return cmp.Compare(a.off, b.off)
+	})
+	var cuts []span
+	for _, s := range ed.cuts {
+		if n := len(cuts); n > 0 && s.off <= cuts[n-1].end {
+			cuts[n-1].end = max(cuts[n-1].end, s.end)
+			continue
+		}
+		cuts = append(cuts, s)
+	}
+	ed.cuts = cuts
+
+	return nil
+}
+
+// fileSpan returns the file range of extent x of l.
+func (ed *editor) fileSpan(l *isobmff.ItemLocationEntry, x isobmff.Extent) (span, error) {
+	off := l.BaseOffset + x.Offset
+	if off < l.BaseOffset || off > uint64(len(ed.data)) {
+		return span{}, fmt.Errorf("item %d: extent offset %d", l.ItemID, off)
+	}
+
+	n := x.Length
+	if n == 0 {
+		n = uint64(len(ed.data)) - off
+	}
+	if n > uint64(len(ed.data))-off {
+		return span{}, fmt.Errorf("item %d: extent past the end of the file", l.ItemID)
+	}
+
+	return span{off, off + n}, nil
+}
+
+// mdat returns the index of the top-level mdat box whose payload holds s, or -1.
+func (ed *editor) mdat(s span) int {
+	for i, b := range ed.file.Boxes {
+		if b.Type == "mdat" && s.off >= uint64(b.Offset)+uint64(b.HeaderSize) && s.end <= uint64(b.End()) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// build returns the rewritten file.
+func (ed *editor) build() ([]byte, error) {
+	m := ed.meta
+
+	// New locations, with the absolute offsets of file data until the layout is known.
+	var locs []isobmff.ItemLocationEntry
+	var spans [][]span // Original file ranges of the extents of each location, or nil.
+	var idat []byte
+
+	for _, l := range m.Location.Items {
+		if ed.drop[l.ItemID] {
+			continue
+		}
+
+		n := *l
+		n.Extents = slices.Clone(l.Extents)
+		var s []span
+
+		switch {
+		case ed.moved[l.ItemID] != nil:
+			d := ed.moved[l.ItemID]
+			n.ConstructionMethod, n.DataReferenceIndex, n.BaseOffset = isobmff.ConstructionIdat, 0, 0
+			n.Extents = []isobmff.Extent{{Offset: uint64(len(idat)), Length: uint64(len(d))}}
+			idat = append(idat, d...)
+		case l.ConstructionMethod == isobmff.ConstructionIdat:
+			if m.Data == nil {
+				return nil, fmt.Errorf("item %d: no idat", l.ItemID)
+			}
+			for i, x := range n.Extents {
+				off := l.BaseOffset + x.Offset
+				if off < l.BaseOffset || off > uint64(len(m.Data.Data)) || x.Length > uint64(len(m.Data.Data))-off {
+					return nil, fmt.Errorf("item %d: extent outside idat", l.ItemID)
+				}
+				end := off + x.Length
+				if x.Length == 0 {
+					end = uint64(len(m.Data.Data))
+				}
+				n.Extents[i] = isobmff.Extent{Index: x.Index, Offset: uint64(len(idat)), Length: end - off}
+				idat = append(idat, m.Data.Data[off:end]...)
+			}
+			n.BaseOffset = 0
+		case l.ConstructionMethod == isobmff.ConstructionFile && l.DataReferenceIndex == 0:
+			for i, x := range n.Extents {
+				sp, err := ed.fileSpan(l, x)
+				if err != nil {
+					return nil, err
+				}
+				if err := ed.checkKept(l.ItemID, sp); err != nil {
+					return nil, err
+				}
+				n.Extents[i] = isobmff.Extent{Index: x.Index, Offset: sp.off, Length: sp.end - sp.off}
+				s = append(s, sp)
+			}
+			n.BaseOffset = 0
+		}
+
+		locs = append(locs, n)
+		spans = append(spans, s)
+	}
+
+	for _, a := range ed.added {
+		locs = append(locs, isobmff.ItemLocationEntry{
+			ItemID:             a.id,
+			ConstructionMethod: isobmff.ConstructionIdat,
+			Extents:            []isobmff.Extent{{Offset: uint64(len(idat)), Length: uint64(len(a.data))}},
+		})
+		spans = append(spans, nil)
+		idat = append(idat, a.data...)
+	}
+
+	// The field sizes do not depend on the new offsets, so the size of the new meta box is known before the
+	// layout is.
+	wide := uint64(len(ed.data)+len(idat)) > 1<<32-1<<20
+	metaSize := len(ed.metaBox(locs, idat, wide))
+
+	boxes := ed.file.Boxes
+	starts := make([]uint64, len(boxes))
+	var pos uint64
+	for i, b := range boxes {
+		if b.End() > int64(len(ed.data)) {
+			return nil, fmt.Errorf("%s: %w", b.Type, io.ErrUnexpectedEOF)
+		}
+
+		starts[i] = pos
+		switch {
+		case b.Offset == m.Offset:
+			pos += uint64(metaSize)
+		default:
+			pos += uint64(b.Size) - ed.cutIn(b)
+		}
+	}
+
+	// remap returns the new offset of the file data at off, which is not in the meta box.
+	remap := func(off uint64) (uint64, error) {
+		for i, b := range boxes {
+			if off < uint64(b.Offset) || off >= uint64(b.End()) {
+				continue
+			}
+			if b.Offset == m.Offset {
+				return 0, fmt.Errorf("data at %d in the meta box", off)
+			}
+
+			n := starts[i] + off - uint64(b.Offset)
+			for _, c := range ed.cuts {
+				if c.end <= off && c.off >= uint64(b.Offset) {
+					n -= c.end - c.off
+				}
+			}
+
+			return n, nil
+		}
+
+		return 0, fmt.Errorf("data at %d past the end of the file", off)
+	}
+
+	for i := range locs {
+		for j, s := range spans[i] {
+			off, err := remap(s.off)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %w", locs[i].ItemID, err)
+			}
+			locs[i].Extents[j].Offset = off
+		}
+	}
+
+	meta := ed.metaBox(locs, idat, wide)
+	if len(meta) != metaSize {
+		return nil, fmt.Errorf("meta size %d, want %d", len(meta), metaSize)
+	}
+
+	out := make([]byte, 0, pos)
+	for _, b := range boxes {
+		raw := ed.data[b.Offset:b.End()]
+
+		switch {
+		case b.Offset == m.Offset:
+			out = append(out, meta...)
+		case b.Type == "mdat" && ed.cutIn(b) > 0:
+			out = append(out, ed.cutMdat(b)...)
+		case b.Type == "moov":
+			moov := bytes.Clone(raw)
+			if err := patchChunkOffsets(moov[b.HeaderSize:], remap); err != nil {
+				return nil, fmt.Errorf("moov: %w", err)
+			}
+			out = append(out, moov...)
+		default:
+			out = append(out, raw...)
+		}
+	}
+
+	return out, nil
+}
+
+// checkKept checks that the file range s of kept item id is outside the meta box and the cut data.
+func (ed *editor) checkKept(id uint32, s span) error {
+	m := ed.meta
+	if s.off < uint64(m.End()) && s.end > uint64(m.Offset) {
+		return fmt.Errorf("item %d: data in the meta box", id)
+	}
+
+	for _, c := range ed.cuts {
+		if s.off < c.end && s.end > c.off {
+			return fmt.Errorf("item %d: data shared with a removed item", id)
+		}
+	}
+
+	return nil
+}
+
+// cutIn returns the number of bytes cut from box b.
+func (ed *editor) cutIn(b isobmff.Box) uint64 {
+	var n uint64
+	for _, c := range ed.cuts {
+		if c.off >= uint64(b.Offset) && c.end <= uint64(b.End()) {
+			n += c.end - c.off
+		}
+	}
+
+	return n
+}
+
+// cutMdat returns mdat box b without the cut data.
+func (ed *editor) cutMdat(b isobmff.Box) []byte {
+	size := uint64(b.Size) - ed.cutIn(b)
+
+	out := bytes.Clone(ed.data[b.Offset : b.Offset+int64(b.HeaderSize)])
+	switch {
+	case binary.BigEndian.Uint32(out) == 0:
+		// The box extends to the end of the file.
+	case b.HeaderSize == 16:
+		binary.BigEndian.PutUint64(out[8:], size)
+	default:
+		binary.BigEndian.PutUint32(out, uint32(size))
+	}
+
+	pos := uint64(b.Offset) + uint64(b.HeaderSize)
+	for _, c := range ed.cuts {
+		if c.off < pos || c.end > uint64(b.End()) {
+			continue
+		}
+		out = append(out, ed.data[pos:c.off]...)
+		pos = c.end
+	}
+
+	return append(out, ed.data[pos:b.End()]...)
+}
+
+// metaBox returns the new meta box, with iinf, iloc, iref and idat rebuilt and other boxes copied.
+// Offsets and lengths take 8 bytes if wide, else 4.
+func (ed *editor) metaBox(locs []isobmff.ItemLocationEntry, idat []byte, wide bool) []byte {
+	m := ed.meta
+
+	payload := bytes.Clone(ed.data[m.Offset+int64(m.HeaderSize) : m.Offset+int64(m.HeaderSize)+4])
+
+	var hasRef, hasData bool
+	for _, c := range m.Children {
+		switch c.Type {
+		case "iinf":
+			payload = append(payload, ed.itemInfo()...)
+		case "iloc":
+			payload = append(payload, ed.itemLocation(locs, wide)...)
+		case "iref":
+			payload = append(payload, ed.itemReference()...)
+			hasRef = true
+		case "idat":
+			payload = append(payload, newBox("idat", idat)...)
+			hasData = true
+		default:
+			payload = append(payload, ed.data[c.Offset:c.End()]...)
+		}
+	}
+
+	if !hasRef && len(ed.added) > 0 {
+		payload = append(payload, ed.itemReference()...)
+	}
+	if !hasData && len(idat) > 0 {
+		payload = append(payload, newBox("idat", idat)...)
+	}
+
+	return newBox("meta", payload)
+}
+
+// itemInfo returns the iinf box of the kept and added items.
+func (ed *editor) itemInfo() []byte {
+	ii := ed.meta.ItemInfo
+
+	var entries []byte
+	n := 0
+	for _, it := range ii.Entries {
+		if !ed.drop[it.ItemID] {
+			entries = append(entries, ed.data[it.Offset:it.End()]...)
+			n++
+		}
+	}
+
+	for _, a := range ed.added {
+		var p []byte
+		version := uint8(2)
+		if a.id > 0xffff {
+			version = 3
+			p = binary.BigEndian.AppendUint32(p, a.id)
+		} else {
+			p = binary.BigEndian.AppendUint16(p, uint16(a.id))
+		}
+		p = binary.BigEndian.AppendUint16(p, 0) // protection index
+		p = append(p, a.typ...)
+		p = append(p, 0) // name
+		if a.contentType != "" {
+			p = append(append(p, a.contentType...), 0)
+		}
+
+		// Metadata items are hidden.
+		entries = append(entries, newFullBox("infe", version, 1, p)...)
+		n++
+	}
+
+	version := ii.Version
+	if n > 0xffff {
+		version = 1
+	}
+
+	var p []byte
+	if version == 0 {
+		p = binary.BigEndian.AppendUint16(p, uint16(n))
+	} else {
+		p = binary.BigEndian.AppendUint32(p, uint32(n))
+	}
+
+	return newFullBox("iinf", version, ii.Flags, p, entries)
+}
+
+// itemLocation returns the iloc box of locs.
+func (ed *editor) itemLocation(locs []isobmff.ItemLocationEntry, wide bool) []byte {
+	l := ed.meta.Location
+
+	version := l.Version
+	indexSize := l.IndexSize
+	for _, e := range locs {
+		if e.ConstructionMethod != isobmff.ConstructionFile && version == 0 {
+			version = 1
+		}
+		if e.ItemID > 0xffff {
+			version = 2
+		}
+	}
+	if version == 0 {
+		indexSize = 0
+	}
+
+	size := 4
+	baseSize := 0
+	for _, e := range locs {
+		if e.BaseOffset > 0 {
+			baseSize = max(baseSize, 4)
+		}
+		if e.BaseOffset > 0xffffffff {
+			baseSize = 8
+		}
+		for _, x := range e.Extents {
+			if x.Offset > 0xffffffff || x.Length > 0xffffffff {
+				wide = true
+			}
+		}
+	}
+	if wide {
+		size = 8
+	}
+
+	field := func(b []byte, v uint64, n int) []byte {
+		switch n {
+		case 4:
+			return binary.BigEndian.AppendUint32(b, uint32(v))
+		case 8:
+			return binary.BigEndian.AppendUint64(b, v)
+		}
+		return b
+	}
+
+	p := binary.BigEndian.AppendUint16(nil, uint16(size<<12|size<<8|baseSize<<4|indexSize))
+	if version < 2 {
+		p = binary.BigEndian.AppendUint16(p, uint16(len(locs)))
+	} else {
+		p = binary.BigEndian.AppendUint32(p, uint32(len(locs)))
+	}
+
+	for _, e := range locs {
+		if version < 2 {
+			p = binary.BigEndian.AppendUint16(p, uint16(e.ItemID))
+		} else {
+			p = binary.BigEndian.AppendUint32(p, e.ItemID)
+		}
+		if version > 0 {
+			p = binary.BigEndian.AppendUint16(p, uint16(e.ConstructionMethod))
+		}
+		p = binary.BigEndian.AppendUint16(p, e.DataReferenceIndex)
+		p = field(p, e.BaseOffset, baseSize)
+
+		p = binary.BigEndian.AppendUint16(p, uint16(len(e.Extents)))
+		for _, x := range e.Extents {
+			p = field(p, x.Index, indexSize)
+			p = field(p, x.Offset, size)
+			p = field(p, x.Length, size)
+		}
+	}
+
+	return newFullBox("iloc", version, l.Flags, p)
+}
+
+// itemReference returns the iref box without the references of removed items, and with a cdsc reference
+// from each added item to the primary image, or nil if there are no references left.
+func (ed *editor) itemReference() []byte {
+	type reference struct {
+		typ  string
+		from uint32
+		to   []uint32
+	}
+
+	var refs []reference
+	var version uint8
+	var flags uint32
+	if ir := ed.meta.Reference; ir != nil {
+		version, flags = ir.Version, ir.Flags
+		for _, r := range ir.References {
+			if ed.drop[r.FromItemID] {
+				continue
+			}
+
+			to := slices.DeleteFunc(slices.Clone(r.ToItemIDs), func(id uint32) bool { return ed.drop[id] })
+			if len(to) > 0 {
+				refs = append(refs, reference{r.Type, r.FromItemID, to})
+			}
+		}
+	}
+
+	for _, a := range ed.added {
+		refs = append(refs, reference{"cdsc", a.id, []uint32{ed.meta.PrimaryItemID()}})
+		if a.id > 0xffff {
+			version = 1
+		}
+	}
+
+	if len(refs) == 0 {
+		return nil
+	}
+
+	id := func(b []byte, v uint32) []byte {
+		if version == 0 {
+			return binary.BigEndian.AppendUint16(b, uint16(v))
+		}
+		return binary.BigEndian.AppendUint32(b, v)
+	}
+
+	var p []byte
+	for _, r := range refs {
+		b := id(nil, r.from)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(r.to)))
+		for _, to := range r.to {
+			b = id(b, to)
+		}
+		p = append(p, newBox(r.typ, b)...)
+	}
+
+	return newFullBox("iref", version, flags, p)
+}
+
+// editTIFF returns TIFF data with the GPS IFD and orientation edits of e applied. The new IFD0 is appended to
+// the data, so no other offsets change; the old one and the GPS IFD with its values are erased.
+func (e *MetadataEdit) editTIFF(tiff []byte) ([]byte, error) {
+	if !e.StripGPS && e.Orientation == 0 {
+		return tiff, nil
+	}
+
+	reader, err := newExifReader(tiff)
+	if err != nil {
+		return nil, err
+	}
+
+	var order interface {
+		binary.ByteOrder
+		binary.AppendByteOrder
+	} = binary.BigEndian
+	if reader.littleEndian {
+		order = binary.LittleEndian
+	}
+
+	ifd0 := int(reader.uint32(4))
+	if ifd0 < 8 || ifd0+2 > len(tiff) {
+		return nil, errors.New("invalid IFD offset")
+	}
+	n := int(reader.uint16(ifd0))
+	if ifd0+2+n*12+4 > len(tiff) {
+		return nil, errors.New("IFD0 too short")
+	}
+
+	out := bytes.Clone(tiff)
+
+	var entries [][]byte
+	gps := 0
+	for i := range n {
+		entry := tiff[ifd0+2+i*12 : ifd0+2+i*12+12]
+		switch tag := reader.uint16(ifd0 + 2 + i*12); {
+		case e.StripGPS && tag == tagGPSIFDPointer:
+			gps = int(reader.uint32(ifd0 + 2 + i*12 + 8))
+			continue
+		case e.Orientation != 0 && tag == tagOrientation:
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	if e.Orientation != 0 {
+		entry := order.AppendUint16(nil, tagOrientation)
+		entry = order.AppendUint16(entry, typeUnsignedShort)
+		entry = order.AppendUint32(entry, 1)
+		entry = order.AppendUint16(entry, uint16(e.Orientation))
+		entries = append(entries, append(entry, 0, 0))
+	}
+
+	// Entries are sorted by tag.
+	slices.SortStableFunc(entries, func(a, b []byte) int {
+		return cmp.Compare(order.Uint16(a), order.Uint16(b))
+	})
+
+	next := reader.uint32(ifd0 + 2 + n*12)
+
+	if gps > 0 {
+		eraseIFD(out, reader, gps)
+	}
+	clear(out[ifd0 : ifd0+2+n*12+4])
+
+	if len(out)%2 != 0 {
+		out = append(out, 0)
+	}
+	newIFD0 := len(out)
+	out = order.AppendUint16(out, uint16(len(entries)))
+	for _, entry := range entries {
+		out = append(out, entry...)
+	}
+	out = order.AppendUint32(out, next)
+
+	order.PutUint32(out[4:], uint32(newIFD0))
+
+	return out, nil
+}
+
+// eraseIFD zeroes the IFD at offset of the data of reader in out, and the values its entries point to.
+func eraseIFD(out []byte, reader *exifReader, offset int) {
+	if offset < 8 || offset+2 > len(out) {
+		return
+	}
+
+	n := int(reader.uint16(offset))
+	end := min(offset+2+n*12+4, len(out))
+	for i := range n {
+		entry := offset + 2 + i*12
+		if entry+12 > len(out) {
+			break
+		}
+
+		size := getDataSize(reader.uint16(entry+2), reader.uint32(entry+4))
+		if size <= 4 {
+			continue
+		}
+		if v := int(reader.uint32(entry + 8)); v >= 8 && size <= len(out) && v <= len(out)-size {
+			clear(out[v : v+size])
+		}
+	}
+
+	clear(out[offset:end])
+}
+
+// patchChunkOffsets rewrites with remap the stco and co64 chunk offsets of the tracks in b, the payload
+// of a moov box.
+func patchChunkOffsets(b []byte, remap func(uint64) (uint64, error)) error {
+	for off := 0; off < len(b); {
+		if len(b)-off < 8 {
+			return fmt.Errorf("%w: box header", io.ErrUnexpectedEOF)
+		}
+
+		size := uint64(binary.BigEndian.Uint32(b[off:]))
+		typ := string(b[off+4 : off+8])
+		hdr := 8
+		switch size {
+		case 0:
+			size = uint64(len(b) - off)
+		case 1:
+			if len(b)-off < 16 {
+				return fmt.Errorf("%w: %s header", io.ErrUnexpectedEOF, typ)
+			}
+			size = binary.BigEndian.Uint64(b[off+8:])
+			hdr = 16
+		}
+		if size < uint64(hdr) || size > uint64(len(b)-off) {
+			return fmt.Errorf("%w: %s", io.ErrUnexpectedEOF, typ)
+		}
+
+		p := b[off+hdr : off+int(size)]
+		switch typ {
+		case "trak", "mdia", "minf", "stbl":
+			if err := patchChunkOffsets(p, remap); err != nil {
+				return err
+			}
+		case "stco", "co64":
+			width := 4
+			if typ == "co64" {
+				width = 8
+			}
+			if len(p) < 8 || uint64(binary.BigEndian.Uint32(p[4:])) > uint64((len(p)-8)/width) {
+				return fmt.Errorf("%w: %s", io.ErrUnexpectedEOF, typ)
+			}
+
+			for i := range int(binary.BigEndian.Uint32(p[4:])) {
+				q := p[8+i*width:]
+				if width == 4 {
+					v, err := remap(uint64(binary.BigEndian.Uint32(q)))
+					if err != nil {
+						return err
+					}
+					if v > 0xffffffff {
+						return fmt.Errorf("chunk offset %d does not fit stco", v)
+					}
+					binary.BigEndian.PutUint32(q, uint32(v))
+				} else {
+					v, err := remap(binary.BigEndian.Uint64(q))
+					if err != nil {
+						return err
+					}
+					binary.BigEndian.PutUint64(q, v)
+				}
+			}
+		}
+
+		off += int(size)
+	}
+
+	return nil
+}
+
+// newBox returns a box of type typ with payload.
+func newBox(typ string, payload ...[]byte) []byte {
+	n := 8
+	for _, p := range payload {
+		n += len(p)
+	}
+
+	b := binary.BigEndian.AppendUint32(make([]byte, 0, n), uint32(n))
+	b = append(b, typ...)
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+
+	return b
+}
+
+// newFullBox returns a full box of type typ with version, flags and payload.
+func newFullBox(typ string, version uint8, flags uint32, payload ...[]byte) []byte {
+	return newBox(typ, append([][]byte{binary.BigEndian.AppendUint32(nil, uint32(version)<<24|flags&0xffffff)}, payload...)...)
+}
@@ -0,0 +1,209 @@
+package heic
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"image"
+	"testing"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+//go:embed testdata/exif_gps.heic
+var testExifGPS []byte
+
+func TestEditMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EditMetadata(&buf, bytes.NewReader(testExifGPS), &MetadataEdit{StripGPS: true, Orientation: 8}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+
+	ex, err := DecodeExif(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.GPSLatitude != 0 || ex.GPSLongitude != 0 || ex.GPSAltitude != 0 || ex.Orientation != 8 || ex.Make != "GPSCam" || ex.ISOSpeed != 200 {
+		t.Errorf("exif %+v", ex)
+	}
+
+	tags, err := DecodeExifTags(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range tags {
+		if tag.IFD == IFDGPS || tag.ID == tagGPSIFDPointer {
+			t.Errorf("GPS tag %s.%s left", tag.IFD, tag.Name())
+		}
+	}
+
+	// The latitude seconds, 1234/100, are erased rather than left in mdat.
+	if bytes.Contains(out, binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 1234), 100)) {
+		t.Error("GPS data left in the file")
+	}
+
+	// The XMP item follows the cut Exif data in mdat.
+	b, err := DecodeXMP(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x, err := ParseXMP(b); err != nil || x.Rating != 2 {
+		t.Errorf("xmp %+v, err %v", x, err)
+	}
+
+	testBackends(t, func(t *testing.T, backend Backend) {
+		opts := &Options{Backend: backend, Format: FormatNRGBA}
+		want, err := DecodeWithOptions(bytes.NewReader(testExifGPS), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := DecodeWithOptions(bytes.NewReader(out), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalNRGBA(img.(*image.NRGBA), want.(*image.NRGBA)) {
+			t.Error("edited image differs")
+		}
+	})
+}
+
+func TestEditMetadataReplace(t *testing.T) {
+	tiff, _ := buildTIFF([][]tiffEntry{{{tag: tagMake, typ: typeASCIIString, count: 8, value: []byte("NewCam\x00\x00")}}}, 0)
+	packet := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Label="Red"/></rdf:RDF></x:xmpmeta>`)
+
+	// The grid descriptor of test.heic is in idat.
+	var buf bytes.Buffer
+	if err := EditMetadata(&buf, bytes.NewReader(testHeic), &MetadataEdit{Exif: tiff, XMP: packet, Orientation: 1}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+
+	ex, err := DecodeExif(bytes.NewReader(out))
+	if err != nil || ex.Make != "NewCam" || ex.Orientation != 1 {
+		t.Errorf("exif %+v, err %v", ex, err)
+	}
+	if b, err := DecodeXMP(bytes.NewReader(out)); err != nil || !bytes.Equal(b, packet) {
+		t.Errorf("xmp %q, err %v", b, err)
+	}
+
+	want, err := DecodeWithOptions(bytes.NewReader(testHeic), &Options{Backend: BackendWASM, Format: FormatNRGBA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := DecodeWithOptions(bytes.NewReader(out), &Options{Backend: BackendWASM, Format: FormatNRGBA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalNRGBA(img.(*image.NRGBA), want.(*image.NRGBA)) {
+		t.Error("edited grid image differs")
+	}
+
+	// The XMP of the depth map goes, and the depth map moves up in mdat.
+	buf.Reset()
+	if err := EditMetadata(&buf, bytes.NewReader(testDepth), &MetadataEdit{RemoveXMP: true, RemoveExif: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeXMP(bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrNoXMP) {
+		t.Errorf("err = %v, want ErrNoXMP", err)
+	}
+	if len(buf.Bytes()) >= len(testDepth) {
+		t.Errorf("size %d, want less than %d", len(buf.Bytes()), len(testDepth))
+	}
+
+	depth, err := DecodeWithOptions(bytes.NewReader(testDepth), &Options{ItemID: 2, Format: FormatNRGBA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeWithOptions(bytes.NewReader(buf.Bytes()), &Options{ItemID: 2, Format: FormatNRGBA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.(*image.NRGBA).Pix, depth.(*image.NRGBA).Pix) {
+		t.Error("depth map differs")
+	}
+}
+
+func TestEditMetadataLocationVersion(t *testing.T) {
+	// test_exif.heic has a version 0 iloc, which cannot locate data in idat.
+	var buf bytes.Buffer
+	if err := EditMetadata(&buf, bytes.NewReader(testHeicExif), &MetadataEdit{Orientation: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := isobmff.Parse(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := f.Meta.Location.Version; v != 1 {
+		t.Errorf("iloc version %d, want 1", v)
+	}
+
+	ex, err := DecodeExif(bytes.NewReader(buf.Bytes()))
+	if err != nil || ex.Orientation != 3 || ex.Make != "TestCam" || ex.ISOSpeed != 800 {
+		t.Errorf("exif %+v, err %v", ex, err)
+	}
+
+	cfg, err := DecodeConfig(bytes.NewReader(buf.Bytes()))
+	if err != nil || cfg.Width != 480 || cfg.Height != 640 {
+		t.Errorf("config %+v, err %v", cfg, err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+		e    *MetadataEdit
+	}{
+		{"orientation", testHeicExif, &MetadataEdit{Orientation: 9}},
+		{"exif", testHeicExif, &MetadataEdit{Exif: []byte("JPEG")}},
+		{"sequence", testAnim, &MetadataEdit{RemoveExif: true}},
+	} {
+		if err := EditMetadata(&buf, bytes.NewReader(tt.data), tt.e); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestPatchChunkOffsets(t *testing.T) {
+	box := func(typ string, payload ...[]byte) []byte {
+		return newBox(typ, payload...)
+	}
+	u32 := func(v ...uint32) []byte {
+		var b []byte
+		for _, x := range v {
+			b = binary.BigEndian.AppendUint32(b, x)
+		}
+		return b
+	}
+
+	stco := box("stco", u32(0, 2, 1000, 2000))
+	co64 := box("co64", u32(0, 1), binary.BigEndian.AppendUint64(nil, 3000))
+	moov := box("mvhd", make([]byte, 8))
+	moov = append(moov, box("trak", box("mdia", box("minf", box("stbl", stco))))...)
+	moov = append(moov, box("trak", box("mdia", box("minf", box("stbl", co64))))...)
+
+	if err := patchChunkOffsets(moov, func(off uint64) (uint64, error) { return off - 100, nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	var offsets []uint64
+	for _, trak := range []int{bytes.Index(moov, []byte("stco")), bytes.Index(moov, []byte("co64"))} {
+		p := moov[trak+4:]
+		for i := range int(binary.BigEndian.Uint32(p[4:])) {
+			if string(moov[trak:trak+4]) == "stco" {
+				offsets = append(offsets, uint64(binary.BigEndian.Uint32(p[8+4*i:])))
+			} else {
+				offsets = append(offsets, binary.BigEndian.Uint64(p[8+8*i:]))
+			}
+		}
+	}
+	if len(offsets) != 3 || offsets[0] != 900 || offsets[1] != 1900 || offsets[2] != 2900 {
+		t.Errorf("offsets %v", offsets)
+	}
+
+	if err := patchChunkOffsets(moov[:len(moov)-4], func(off uint64) (uint64, error) { return off, nil }); err == nil {
+		t.Error("truncated moov: no error")
+	}
+}
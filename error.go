@@ -0,0 +1,85 @@
+package heic
+
+import (
+	"fmt"
+)
+
+// Stage identifies the decoding step that failed.
+type Stage int
+
+const (
+	// StageParse is the container and header parsing.
+	StageParse Stage = iota
+	// StageDecode is the HEVC decoding.
+	StageDecode
+	// StageColorConversion is the conversion of the decoded planes to the output format.
+	StageColorConversion
+)
+
+func (s Stage) String() string {
+	switch s {
+	case StageParse:
+		return "parse"
+	case StageDecode:
+		return "decode"
+	case StageColorConversion:
+		return "color conversion"
+	default:
+		return fmt.Sprintf("stage %d", int(s))
+	}
+}
+
+// Error codes of DecodeError. Both backends use the numbering of libheif's heif_error_code.
+const (
+	CodeInvalidInput        = 2
+	CodeUnsupportedFiletype = 3
+	CodeUnsupportedFeature  = 4
+	CodeMemoryAllocation    = 6
+	CodeDecoderPlugin       = 7
+)
+
+// libheif suberror codes that change how an error is classified.
+const (
+	subcodeSecurityLimitExceeded      = 1000
+	subcodeUnsupportedColorConversion = 3003
+)
+
+// DecodeError describes why a backend failed to decode an image.
+//
+// It matches ErrDecode with errors.Is, and ErrLimitExceeded when libheif hit one of its security limits.
+type DecodeError struct {
+	Backend string // "wasm" or "libheif".
+	Stage   Stage  // Step that failed.
+	Code    int    // Error code, see CodeInvalidInput and friends.
+	Subcode int    // libheif heif_suberror_code, or 0.
+	Message string // Backend error message.
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("heic: %s: %s: %s", e.Backend, e.Stage, e.Message)
+}
+
+// Unwrap returns ErrDecode.
+func (e *DecodeError) Unwrap() error {
+	return ErrDecode
+}
+
+// Is reports whether target is ErrLimitExceeded and the error was caused by a security limit.
+func (e *DecodeError) Is(target error) bool {
+	return target == ErrLimitExceeded && e.Code == CodeMemoryAllocation && e.Subcode == subcodeSecurityLimitExceeded
+}
+
+// Unsupported reports whether the input is valid but uses a file type, codec or feature the backend cannot decode.
+func (e *DecodeError) Unsupported() bool {
+	return e.Code == CodeUnsupportedFiletype || e.Code == CodeUnsupportedFeature
+}
+
+// newWasmError returns the error reported for stage when the WASM module gives no details.
+func newWasmError(stage Stage) *DecodeError {
+	code := CodeDecoderPlugin
+	if stage == StageParse {
+		code = CodeInvalidInput
+	}
+
+	return &DecodeError{Backend: "wasm", Stage: stage, Code: code, Message: "decode failed"}
+}
@@ -1,6 +1,7 @@
 package heic
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -9,7 +10,10 @@ import (
 // ErrNoExif is returned by DecodeExif when the HEIC image has no EXIF metadata.
 var ErrNoExif = errors.New("heic: no exif data")
 
-// Exif holds the EXIF metadata decoded from a HEIC image.
+// ErrNoExifThumbnail is returned by DecodeExifThumbnail when the EXIF metadata has no JPEG thumbnail.
+var ErrNoExifThumbnail = errors.New("heic: no exif thumbnail")
+
+// Exif holds common EXIF metadata decoded from a HEIC image. DecodeExifTags returns every tag.
 type Exif struct {
 	// Basic image info
 	Orientation int // EXIF orientation (1-8). 1 = normal, values 2-8 indicate rotation/flip.
@@ -43,7 +47,8 @@ type Exif struct {
 }
 
 // DecodeExif reads the EXIF metadata from a HEIC image, or returns ErrNoExif if there is none.
-// Orientation is already applied by the decoder, so Exif.Orientation is informational.
+// Orientation is already applied by the decoder as the irot and imir properties, so Exif.Orientation is
+// informational unless Options.IgnoreTransformations is set.
 func DecodeExif(r io.Reader) (*Exif, error) {
 	tiff := exifPayload(r)
 	if tiff == nil {
@@ -58,6 +63,57 @@ func DecodeExif(r io.Reader) (*Exif, error) {
 	return exif, nil
 }
 
+// DecodeExifThumbnail returns the JPEG thumbnail in IFD1 of the EXIF metadata of a HEIC image, or returns
+// ErrNoExif or ErrNoExifThumbnail if there is none. No image is decoded; see DecodeThumbnail for the
+// thumbnail image items.
+func DecodeExifThumbnail(r io.Reader) ([]byte, error) {
+	tiff := exifPayload(r)
+	if tiff == nil {
+		return nil, ErrNoExif
+	}
+
+	tags, err := parseExifTags(tiff)
+	if err != nil {
+		return nil, fmt.Errorf("heic: %w", err)
+	}
+
+	b := exifThumbnail(tiff, tags)
+	if b == nil {
+		return nil, ErrNoExifThumbnail
+	}
+
+	return b, nil
+}
+
+// exifThumbnail returns a copy of the JPEG thumbnail that the IFD1 tags locate in the TIFF/EXIF data, or nil
+func exifThumbnail(data []byte, tags []ExifTag) []byte {
+	offset, length := -1, 0
+	for _, t := range tags {
+		if t.IFD != IFD1 {
+			continue
+		}
+
+		switch t.ID {
+		case tagJPEGInterchangeFormat:
+			offset = t.Int()
+		case tagJPEGInterchangeFormatLength:
+			length = t.Int()
+		}
+	}
+
+	if offset < 8 || length < 2 || offset > len(data)-length {
+		return nil
+	}
+
+	// A JPEG starts with the SOI marker
+	b := data[offset : offset+length]
+	if b[0] != 0xFF || b[1] != 0xD8 {
+		return nil
+	}
+
+	return bytes.Clone(b)
+}
+
 // EXIF tag constants
 const (
 	// Main IFD tags
@@ -73,6 +129,13 @@ const (
 	tagExifIFDPointer = 0x8769
 	tagGPSIFDPointer  = 0x8825
 
+	// IFD1 tags
+	tagJPEGInterchangeFormat       = 0x0201
+	tagJPEGInterchangeFormatLength = 0x0202
+
+	// Exif SubIFD pointer to the Interop IFD
+	tagInteropIFDPointer = 0xA005
+
 	// EXIF SubIFD tags
 	tagExposureTime     = 0x829A
 	tagFNumber          = 0x829D
@@ -80,6 +143,7 @@ const (
 	tagDateTimeOriginal = 0x9003
 	tagFlash            = 0x9209
 	tagFocalLength      = 0x920A
+	tagMakerNote        = 0x927C
 
 	// GPS SubIFD tags
 	tagGPSLatitudeRef  = 0x0001
@@ -145,22 +209,10 @@ func (r *exifReader) readString(offset, maxLen int) string {
 	return string(r.data[offset:end])
 }
 
-func (r *exifReader) readRational(offset int) float64 {
-	if offset+7 >= len(r.data) {
-		return 0
-	}
-	numerator := r.uint32(offset)
-	denominator := r.uint32(offset + 4)
-	if denominator == 0 {
-		return 0
-	}
-	return float64(numerator) / float64(denominator)
-}
-
-// parseExifData parses the TIFF/EXIF data structure and populates the Exif struct
-func parseExifData(data []byte, exif *Exif) error {
+// newExifReader checks the TIFF header of EXIF data and returns a reader in its byte order
+func newExifReader(data []byte) (*exifReader, error) {
 	if len(data) < 8 {
-		return fmt.Errorf("EXIF data too short")
+		return nil, fmt.Errorf("EXIF data too short")
 	}
 
 	reader := &exifReader{data: data}
@@ -171,248 +223,91 @@ func parseExifData(data []byte, exif *Exif) error {
 	} else if data[0] == 0x4D && data[1] == 0x4D {
 		reader.littleEndian = false // Motorola (big-endian)
 	} else {
-		return fmt.Errorf("invalid EXIF byte order marker")
+		return nil, fmt.Errorf("invalid EXIF byte order marker")
 	}
 
 	// Check magic number (42)
 	if reader.uint16(2) != 42 {
-		return fmt.Errorf("invalid EXIF magic number")
+		return nil, fmt.Errorf("invalid EXIF magic number")
 	}
 
-	// Get offset to first IFD
-	ifdOffset := reader.uint32(4)
-	if ifdOffset < 8 || int(ifdOffset) >= len(data) {
-		return fmt.Errorf("invalid IFD offset")
-	}
-
-	// Parse main IFD (IFD0)
-	exifIFDOffset, gpsIFDOffset := parseIFD(reader, int(ifdOffset), exif)
-
-	// Parse EXIF SubIFD if present
-	if exifIFDOffset > 0 {
-		parseExifSubIFD(reader, exifIFDOffset, exif)
-	}
-
-	// Parse GPS SubIFD if present
-	if gpsIFDOffset > 0 {
-		parseGPSSubIFD(reader, gpsIFDOffset, exif)
-	}
-
-	return nil
+	return reader, nil
 }
 
-// parseIFD parses an Image File Directory and returns pointers to EXIF and GPS SubIFDs
-func parseIFD(reader *exifReader, offset int, exif *Exif) (exifIFDOffset, gpsIFDOffset int) {
-	if offset+1 >= len(reader.data) {
-		return 0, 0
-	}
-
-	numEntries := reader.uint16(offset)
-	offset += 2
-
-	for i := 0; i < int(numEntries); i++ {
-		entryOffset := offset + i*12
-		if entryOffset+11 >= len(reader.data) {
-			break
-		}
-
-		tag := reader.uint16(entryOffset)
-		dataType := reader.uint16(entryOffset + 2)
-		count := reader.uint32(entryOffset + 4)
-		valueOffset := entryOffset + 8
-
-		// For values > 4 bytes, the value field contains an offset
-		dataSize := getDataSize(dataType, count)
-		if dataSize > 4 {
-			valueOffset = int(reader.uint32(valueOffset))
-			if valueOffset >= len(reader.data) {
-				continue
-			}
-		}
-
-		switch tag {
-		case tagOrientation:
-			if dataType == typeUnsignedShort {
-				exif.Orientation = int(reader.uint16(valueOffset))
-			}
-		case tagImageWidth:
-			if dataType == typeUnsignedShort {
-				exif.Width = int(reader.uint16(valueOffset))
-			} else if dataType == typeUnsignedLong {
-				exif.Width = int(reader.uint32(valueOffset))
-			}
-		case tagImageLength:
-			if dataType == typeUnsignedShort {
-				exif.Height = int(reader.uint16(valueOffset))
-			} else if dataType == typeUnsignedLong {
-				exif.Height = int(reader.uint32(valueOffset))
-			}
-		case tagMake:
-			if dataType == typeASCIIString {
-				exif.Make = reader.readString(valueOffset, int(count))
-			}
-		case tagModel:
-			if dataType == typeASCIIString {
-				exif.Model = reader.readString(valueOffset, int(count))
-			}
-		case tagSoftware:
-			if dataType == typeASCIIString {
-				exif.Software = reader.readString(valueOffset, int(count))
-			}
-		case tagDateTime:
-			if dataType == typeASCIIString {
-				exif.DateTime = reader.readString(valueOffset, int(count))
-			}
-		case tagArtist:
-			if dataType == typeASCIIString {
-				exif.Artist = reader.readString(valueOffset, int(count))
-			}
-		case tagCopyright:
-			if dataType == typeASCIIString {
-				exif.Copyright = reader.readString(valueOffset, int(count))
-			}
-		case tagExifIFDPointer:
-			if dataType == typeUnsignedLong {
-				exifIFDOffset = int(reader.uint32(valueOffset))
-			}
-		case tagGPSIFDPointer:
-			if dataType == typeUnsignedLong {
-				gpsIFDOffset = int(reader.uint32(valueOffset))
-			}
-		}
-	}
-
-	return exifIFDOffset, gpsIFDOffset
-}
-
-// parseExifSubIFD parses the EXIF SubIFD for camera settings
-func parseExifSubIFD(reader *exifReader, offset int, exif *Exif) {
-	if offset+1 >= len(reader.data) {
-		return
-	}
-
-	numEntries := reader.uint16(offset)
-	offset += 2
-
-	for i := 0; i < int(numEntries); i++ {
-		entryOffset := offset + i*12
-		if entryOffset+11 >= len(reader.data) {
-			break
-		}
-
-		tag := reader.uint16(entryOffset)
-		dataType := reader.uint16(entryOffset + 2)
-		count := reader.uint32(entryOffset + 4)
-		valueOffset := entryOffset + 8
-
-		dataSize := getDataSize(dataType, count)
-		if dataSize > 4 {
-			valueOffset = int(reader.uint32(valueOffset))
-			if valueOffset >= len(reader.data) {
-				continue
-			}
-		}
-
-		switch tag {
-		case tagExposureTime:
-			if dataType == typeUnsignedRational {
-				exif.ExposureTime = reader.readRational(valueOffset)
-			}
-		case tagFNumber:
-			if dataType == typeUnsignedRational {
-				exif.FNumber = reader.readRational(valueOffset)
-			}
-		case tagISOSpeedRatings:
-			if dataType == typeUnsignedShort {
-				exif.ISOSpeed = int(reader.uint16(valueOffset))
-			}
-		case tagDateTimeOriginal:
-			if dataType == typeASCIIString {
-				exif.DateTimeOriginal = reader.readString(valueOffset, int(count))
-			}
-		case tagFlash:
-			if dataType == typeUnsignedShort {
-				exif.Flash = int(reader.uint16(valueOffset))
-			}
-		case tagFocalLength:
-			if dataType == typeUnsignedRational {
-				exif.FocalLength = reader.readRational(valueOffset)
-			}
-		}
-	}
-}
-
-// parseGPSSubIFD parses the GPS SubIFD for location data
-func parseGPSSubIFD(reader *exifReader, offset int, exif *Exif) {
-	if offset+1 >= len(reader.data) {
-		return
+// parseExifData parses the TIFF/EXIF data structure and populates the Exif struct from its tags
+func parseExifData(data []byte, exif *Exif) error {
+	tags, err := parseExifTags(data)
+	if err != nil {
+		return err
 	}
 
-	numEntries := reader.uint16(offset)
-	offset += 2
-
 	var latRef, lonRef string
 	var latValues, lonValues []float64
-
-	for i := 0; i < int(numEntries); i++ {
-		entryOffset := offset + i*12
-		if entryOffset+11 >= len(reader.data) {
-			break
-		}
-
-		tag := reader.uint16(entryOffset)
-		dataType := reader.uint16(entryOffset + 2)
-		count := reader.uint32(entryOffset + 4)
-		valueOffset := entryOffset + 8
-
-		dataSize := getDataSize(dataType, count)
-		if dataSize > 4 {
-			valueOffset = int(reader.uint32(valueOffset))
-			if valueOffset >= len(reader.data) {
-				continue
-			}
-		}
-
-		switch tag {
-		case tagGPSLatitudeRef:
-			if dataType == typeASCIIString {
-				latRef = reader.readString(valueOffset, 2)
-			}
-		case tagGPSLatitude:
-			if dataType == typeUnsignedRational && count == 3 {
-				latValues = []float64{
-					reader.readRational(valueOffset),
-					reader.readRational(valueOffset + 8),
-					reader.readRational(valueOffset + 16),
+	var altRef int
+
+	for _, t := range tags {
+		switch t.IFD {
+		case IFD0:
+			switch t.ID {
+			case tagOrientation:
+				if v := t.Int(); v != 0 {
+					exif.Orientation = v
 				}
+			case tagImageWidth:
+				exif.Width = t.Int()
+			case tagImageLength:
+				exif.Height = t.Int()
+			case tagMake:
+				exif.Make = t.Text()
+			case tagModel:
+				exif.Model = t.Text()
+			case tagSoftware:
+				exif.Software = t.Text()
+			case tagDateTime:
+				exif.DateTime = t.Text()
+			case tagArtist:
+				exif.Artist = t.Text()
+			case tagCopyright:
+				exif.Copyright = t.Text()
 			}
-		case tagGPSLongitudeRef:
-			if dataType == typeASCIIString {
-				lonRef = reader.readString(valueOffset, 2)
-			}
-		case tagGPSLongitude:
-			if dataType == typeUnsignedRational && count == 3 {
-				lonValues = []float64{
-					reader.readRational(valueOffset),
-					reader.readRational(valueOffset + 8),
-					reader.readRational(valueOffset + 16),
-				}
+		case IFDExif:
+			switch t.ID {
+			case tagExposureTime:
+				exif.ExposureTime = t.Float()
+			case tagFNumber:
+				exif.FNumber = t.Float()
+			case tagISOSpeedRatings:
+				exif.ISOSpeed = t.Int()
+			case tagDateTimeOriginal:
+				exif.DateTimeOriginal = t.Text()
+			case tagFlash:
+				exif.Flash = t.Int()
+			case tagFocalLength:
+				exif.FocalLength = t.Float()
 			}
-		case tagGPSAltitude:
-			if dataType == typeUnsignedRational {
-				alt := reader.readRational(valueOffset)
-				// Check altitude reference (0 = above sea level, 1 = below)
-				if entryOffset+12 < len(reader.data) {
-					altRef := reader.data[valueOffset-4] // Previous tag should be altitude ref
-					if altRef == 1 {
-						alt = -alt
-					}
-				}
-				exif.GPSAltitude = alt
+		case IFDGPS:
+			switch t.ID {
+			case tagGPSLatitudeRef:
+				latRef = t.Text()
+			case tagGPSLatitude:
+				latValues = t.Floats()
+			case tagGPSLongitudeRef:
+				lonRef = t.Text()
+			case tagGPSLongitude:
+				lonValues = t.Floats()
+			case tagGPSAltitudeRef:
+				altRef = t.Int()
+			case tagGPSAltitude:
+				exif.GPSAltitude = t.Float()
 			}
 		}
 	}
 
+	// Altitude reference 1 is below sea level
+	if altRef == 1 {
+		exif.GPSAltitude = -exif.GPSAltitude
+	}
+
 	// Convert GPS coordinates from degrees/minutes/seconds to decimal degrees
 	if len(latValues) == 3 {
 		exif.GPSLatitude = latValues[0] + latValues[1]/60.0 + latValues[2]/3600.0
@@ -426,6 +321,8 @@ func parseGPSSubIFD(reader *exifReader, offset int, exif *Exif) {
 			exif.GPSLongitude = -exif.GPSLongitude
 		}
 	}
+
+	return nil
 }
 
 // getDataSize calculates the size in bytes for a given EXIF data type and count
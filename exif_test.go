@@ -3,12 +3,17 @@ package heic
 import (
 	"bytes"
 	_ "embed"
+	"errors"
+	"image/jpeg"
 	"testing"
 )
 
 //go:embed testdata/test_exif.heic
 var testHeicExif []byte
 
+//go:embed testdata/exif_thumb.heic
+var testExifThumb []byte
+
 func TestDecodeExif(t *testing.T) {
 	ex, err := DecodeExif(bytes.NewReader(testHeicExif))
 	if err != nil {
@@ -24,3 +29,30 @@ func TestDecodeExif(t *testing.T) {
 		t.Errorf("ISOSpeed = %d, want 800", ex.ISOSpeed)
 	}
 }
+
+func TestDecodeExifThumbnail(t *testing.T) {
+	b, err := DecodeExifThumbnail(bytes.NewReader(testExifThumb))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 64 || cfg.Height != 48 {
+		t.Errorf("thumbnail %dx%d, want 64x48", cfg.Width, cfg.Height)
+	}
+
+	ex, err := DecodeExif(bytes.NewReader(testExifThumb))
+	if err != nil || ex.Make != "TestCam" {
+		t.Errorf("exif %+v, err %v", ex, err)
+	}
+
+	if _, err := DecodeExifThumbnail(bytes.NewReader(testHeicExif)); !errors.Is(err, ErrNoExifThumbnail) {
+		t.Errorf("err = %v, want ErrNoExifThumbnail", err)
+	}
+	if _, err := DecodeExifThumbnail(bytes.NewReader(testHeic12)); !errors.Is(err, ErrNoExif) {
+		t.Errorf("err = %v, want ErrNoExif", err)
+	}
+}
@@ -0,0 +1,509 @@
+package heic
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// IFD identifies an image file directory of EXIF metadata.
+type IFD int
+
+// Image file directories.
+const (
+	IFD0         IFD = iota // The primary image.
+	IFD1                    // The thumbnail.
+	IFDExif                 // Camera settings, from IFD0.
+	IFDGPS                  // Location, from IFD0.
+	IFDInterop              // Interoperability, from the Exif IFD.
+	IFDApple                // Apple MakerNote; see DecodeMakerNote.
+	IFDMakerNote            // Other MakerNote.
+)
+
+// String returns the name of the IFD.
+func (d IFD) String() string {
+	switch d {
+	case IFD0:
+		return "IFD0"
+	case IFD1:
+		return "IFD1"
+	case IFDExif:
+		return "Exif"
+	case IFDGPS:
+		return "GPS"
+	case IFDInterop:
+		return "Interop"
+	case IFDApple:
+		return "Apple"
+	case IFDMakerNote:
+		return "MakerNote"
+	}
+
+	return fmt.Sprintf("IFD(%d)", int(d))
+}
+
+// Rational is an unsigned EXIF fraction.
+type Rational struct {
+	Num, Den uint32
+}
+
+// Float64 returns the value of the fraction, or 0 if the denominator is 0.
+func (r Rational) Float64() float64 {
+	if r.Den == 0 {
+		return 0
+	}
+	return float64(r.Num) / float64(r.Den)
+}
+
+// SRational is a signed EXIF fraction.
+type SRational struct {
+	Num, Den int32
+}
+
+// Float64 returns the value of the fraction, or 0 if the denominator is 0.
+func (r SRational) Float64() float64 {
+	if r.Den == 0 {
+		return 0
+	}
+	return float64(r.Num) / float64(r.Den)
+}
+
+// ExifTag is an entry of an EXIF image file directory.
+type ExifTag struct {
+	IFD   IFD
+	ID    uint16
+	Type  uint16 // TIFF field type, e.g. 2 for ASCII or 5 for RATIONAL.
+	Count int    // Number of values, or of bytes for ASCII and UNDEFINED.
+
+	// Value is a string for ASCII, []byte for BYTE and UNDEFINED, and otherwise a slice of uint16, uint32,
+	// Rational, int8, int16, int32, SRational, float32 or float64.
+	Value any
+}
+
+// Name returns the EXIF name of the tag, e.g. LensModel, or its hexadecimal ID if it is unknown.
+func (t ExifTag) Name() string {
+	var names map[uint16]string
+	switch t.IFD {
+	case IFD0, IFD1, IFDExif:
+		names = exifTagNames
+	case IFDGPS:
+		names = gpsTagNames
+	case IFDInterop:
+		names = interopTagNames
+	case IFDApple:
+		names = appleTagNames
+	}
+
+	if n, ok := names[t.ID]; ok {
+		return n
+	}
+
+	return fmt.Sprintf("0x%04X", t.ID)
+}
+
+// Text returns the value of an ASCII tag, or "".
+func (t ExifTag) Text() string {
+	s, _ := t.Value.(string)
+	return s
+}
+
+// Int returns the first value of an integer tag, or 0.
+func (t ExifTag) Int() int {
+	if t.Type == typeUndefined {
+		return 0
+	}
+
+	v, _ := exifInt(t.Value, 0)
+	return int(v)
+}
+
+// Float returns the first value of a numeric tag, or 0.
+func (t ExifTag) Float() float64 {
+	if f := t.Floats(); len(f) > 0 {
+		return f[0]
+	}
+	return 0
+}
+
+// Floats returns the values of a numeric tag, or nil.
+func (t ExifTag) Floats() []float64 {
+	var f []float64
+	switch v := t.Value.(type) {
+	case []Rational:
+		for _, r := range v {
+			f = append(f, r.Float64())
+		}
+	case []SRational:
+		for _, r := range v {
+			f = append(f, r.Float64())
+		}
+	case []float32:
+		for _, x := range v {
+			f = append(f, float64(x))
+		}
+	case []float64:
+		f = append(f, v...)
+	default:
+		if t.Type == typeUndefined {
+			return nil
+		}
+		for i := range t.Count {
+			x, ok := exifInt(t.Value, i)
+			if !ok {
+				break
+			}
+			f = append(f, float64(x))
+		}
+	}
+
+	return f
+}
+
+// exifInt returns value i of an integer tag value.
+func exifInt(value any, i int) (int64, bool) {
+	switch v := value.(type) {
+	case []byte:
+		if i < len(v) {
+			return int64(v[i]), true
+		}
+	case []uint16:
+		if i < len(v) {
+			return int64(v[i]), true
+		}
+	case []uint32:
+		if i < len(v) {
+			return int64(v[i]), true
+		}
+	case []int8:
+		if i < len(v) {
+			return int64(v[i]), true
+		}
+	case []int16:
+		if i < len(v) {
+			return int64(v[i]), true
+		}
+	case []int32:
+		if i < len(v) {
+			return int64(v[i]), true
+		}
+	}
+
+	return 0, false
+}
+
+// DecodeExifTags reads every tag of the EXIF metadata of a HEIC image, in the order of IFD0, the Exif, GPS
+// and Interop IFDs, and IFD1, or returns ErrNoExif if there is none. See DecodeExif for the common tags.
+func DecodeExifTags(r io.Reader) ([]ExifTag, error) {
+	tiff := exifPayload(r)
+	if tiff == nil {
+		return nil, ErrNoExif
+	}
+
+	tags, err := parseExifTags(tiff)
+	if err != nil {
+		return nil, fmt.Errorf("heic: %w", err)
+	}
+
+	return tags, nil
+}
+
+// parseExifTags parses the tags of the IFDs of TIFF/EXIF data.
+func parseExifTags(data []byte) ([]ExifTag, error) {
+	reader, err := newExifReader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	ifdOffset := int(reader.uint32(4))
+	if ifdOffset < 8 || ifdOffset >= len(data) {
+		return nil, fmt.Errorf("invalid IFD offset")
+	}
+
+	var tags []ExifTag
+	seen := make(map[int]bool)
+
+	// walk appends the tags of the IFD at offset and returns the offset of the next IFD.
+	var walk func(ifd IFD, offset int) int
+	walk = func(ifd IFD, offset int) int {
+		if offset < 8 || offset+1 >= len(data) || seen[offset] {
+			return 0
+		}
+		seen[offset] = true
+
+		n := int(reader.uint16(offset))
+		var subs [][2]int
+		for i := range n {
+			entryOffset := offset + 2 + i*12
+			if entryOffset+11 >= len(data) {
+				break
+			}
+
+			tag, ok := reader.tag(ifd, entryOffset)
+			if !ok {
+				continue
+			}
+			tags = append(tags, tag)
+
+			switch {
+			case ifd == IFD0 && tag.ID == tagExifIFDPointer:
+				subs = append(subs, [2]int{int(IFDExif), tag.Int()})
+			case ifd == IFD0 && tag.ID == tagGPSIFDPointer:
+				subs = append(subs, [2]int{int(IFDGPS), tag.Int()})
+			case ifd == IFDExif && tag.ID == tagInteropIFDPointer:
+				subs = append(subs, [2]int{int(IFDInterop), tag.Int()})
+			}
+		}
+
+		for _, s := range subs {
+			walk(IFD(s[0]), s[1])
+		}
+
+		return int(reader.uint32(offset + 2 + n*12))
+	}
+
+	if next := walk(IFD0, ifdOffset); next != 0 {
+		walk(IFD1, next)
+	}
+
+	return tags, nil
+}
+
+// tag reads the IFD entry at offset. It reports false for unknown types and values outside the data.
+func (r *exifReader) tag(ifd IFD, offset int) (ExifTag, bool) {
+	t := ExifTag{
+		IFD:   ifd,
+		ID:    r.uint16(offset),
+		Type:  r.uint16(offset + 2),
+		Count: int(r.uint32(offset + 4)),
+	}
+	if t.Type < typeUnsignedByte || t.Type > typeDoubleFloat || t.Count < 0 || t.Count > len(r.data) {
+		return t, false
+	}
+
+	size := getDataSize(t.Type, uint32(t.Count))
+	valueOffset := offset + 8
+	if size > 4 {
+		valueOffset = int(r.uint32(valueOffset))
+	}
+	if valueOffset < 0 || valueOffset+size > len(r.data) {
+		return t, false
+	}
+
+	b := r.data[valueOffset : valueOffset+size]
+	n := t.Count
+
+	switch t.Type {
+	case typeASCIIString:
+		t.Value = r.readString(valueOffset, n)
+	case typeUnsignedByte, typeUndefined:
+		t.Value = append([]byte(nil), b...)
+	case typeSignedByte:
+		v := make([]int8, n)
+		for i := range v {
+			v[i] = int8(b[i])
+		}
+		t.Value = v
+	case typeUnsignedShort:
+		v := make([]uint16, n)
+		for i := range v {
+			v[i] = r.uint16(valueOffset + 2*i)
+		}
+		t.Value = v
+	case typeSignedShort:
+		v := make([]int16, n)
+		for i := range v {
+			v[i] = int16(r.uint16(valueOffset + 2*i))
+		}
+		t.Value = v
+	case typeUnsignedLong:
+		v := make([]uint32, n)
+		for i := range v {
+			v[i] = r.uint32(valueOffset + 4*i)
+		}
+		t.Value = v
+	case typeSignedLong:
+		v := make([]int32, n)
+		for i := range v {
+			v[i] = int32(r.uint32(valueOffset + 4*i))
+		}
+		t.Value = v
+	case typeUnsignedRational:
+		v := make([]Rational, n)
+		for i := range v {
+			v[i] = Rational{r.uint32(valueOffset + 8*i), r.uint32(valueOffset + 8*i + 4)}
+		}
+		t.Value = v
+	case typeSignedRational:
+		v := make([]SRational, n)
+		for i := range v {
+			v[i] = SRational{int32(r.uint32(valueOffset + 8*i)), int32(r.uint32(valueOffset + 8*i + 4))}
+		}
+		t.Value = v
+	case typeSingleFloat:
+		v := make([]float32, n)
+		for i := range v {
+			v[i] = math.Float32frombits(r.uint32(valueOffset + 4*i))
+		}
+		t.Value = v
+	case typeDoubleFloat:
+		v := make([]float64, n)
+		for i := range v {
+			hi, lo := uint64(r.uint32(valueOffset+8*i)), uint64(r.uint32(valueOffset+8*i+4))
+			if r.littleEndian {
+				hi, lo = lo, hi
+			}
+			v[i] = math.Float64frombits(hi<<32 | lo)
+		}
+		t.Value = v
+	}
+
+	return t, true
+}
+
+// exifTagNames are the names of the tags of IFD0, IFD1 and the Exif IFD.
+var exifTagNames = map[uint16]string{
+	0x00FE: "NewSubfileType",
+	0x0100: "ImageWidth",
+	0x0101: "ImageLength",
+	0x0102: "BitsPerSample",
+	0x0103: "Compression",
+	0x0106: "PhotometricInterpretation",
+	0x010E: "ImageDescription",
+	0x010F: "Make",
+	0x0110: "Model",
+	0x0111: "StripOffsets",
+	0x0112: "Orientation",
+	0x0115: "SamplesPerPixel",
+	0x0116: "RowsPerStrip",
+	0x0117: "StripByteCounts",
+	0x011A: "XResolution",
+	0x011B: "YResolution",
+	0x011C: "PlanarConfiguration",
+	0x0128: "ResolutionUnit",
+	0x012D: "TransferFunction",
+	0x0131: "Software",
+	0x0132: "DateTime",
+	0x013B: "Artist",
+	0x013C: "HostComputer",
+	0x013E: "WhitePoint",
+	0x013F: "PrimaryChromaticities",
+	0x0201: "JPEGInterchangeFormat",
+	0x0202: "JPEGInterchangeFormatLength",
+	0x0211: "YCbCrCoefficients",
+	0x0212: "YCbCrSubSampling",
+	0x0213: "YCbCrPositioning",
+	0x0214: "ReferenceBlackWhite",
+	0x4746: "Rating",
+	0x4749: "RatingPercent",
+	0x8298: "Copyright",
+	0x829A: "ExposureTime",
+	0x829D: "FNumber",
+	0x8769: "ExifIFDPointer",
+	0x8822: "ExposureProgram",
+	0x8824: "SpectralSensitivity",
+	0x8825: "GPSInfoIFDPointer",
+	0x8827: "ISOSpeedRatings",
+	0x8828: "OECF",
+	0x8830: "SensitivityType",
+	0x8832: "RecommendedExposureIndex",
+	0x9000: "ExifVersion",
+	0x9003: "DateTimeOriginal",
+	0x9004: "DateTimeDigitized",
+	0x9010: "OffsetTime",
+	0x9011: "OffsetTimeOriginal",
+	0x9012: "OffsetTimeDigitized",
+	0x9101: "ComponentsConfiguration",
+	0x9102: "CompressedBitsPerPixel",
+	0x9201: "ShutterSpeedValue",
+	0x9202: "ApertureValue",
+	0x9203: "BrightnessValue",
+	0x9204: "ExposureBiasValue",
+	0x9205: "MaxApertureValue",
+	0x9206: "SubjectDistance",
+	0x9207: "MeteringMode",
+	0x9208: "LightSource",
+	0x9209: "Flash",
+	0x920A: "FocalLength",
+	0x9214: "SubjectArea",
+	0x927C: "MakerNote",
+	0x9286: "UserComment",
+	0x9290: "SubSecTime",
+	0x9291: "SubSecTimeOriginal",
+	0x9292: "SubSecTimeDigitized",
+	0xA000: "FlashpixVersion",
+	0xA001: "ColorSpace",
+	0xA002: "PixelXDimension",
+	0xA003: "PixelYDimension",
+	0xA004: "RelatedSoundFile",
+	0xA005: "InteroperabilityIFDPointer",
+	0xA20E: "FocalPlaneXResolution",
+	0xA20F: "FocalPlaneYResolution",
+	0xA210: "FocalPlaneResolutionUnit",
+	0xA214: "SubjectLocation",
+	0xA215: "ExposureIndex",
+	0xA217: "SensingMethod",
+	0xA300: "FileSource",
+	0xA301: "SceneType",
+	0xA302: "CFAPattern",
+	0xA401: "CustomRendered",
+	0xA402: "ExposureMode",
+	0xA403: "WhiteBalance",
+	0xA404: "DigitalZoomRatio",
+	0xA405: "FocalLengthIn35mmFilm",
+	0xA406: "SceneCaptureType",
+	0xA407: "GainControl",
+	0xA408: "Contrast",
+	0xA409: "Saturation",
+	0xA40A: "Sharpness",
+	0xA40C: "SubjectDistanceRange",
+	0xA420: "ImageUniqueID",
+	0xA430: "CameraOwnerName",
+	0xA431: "BodySerialNumber",
+	0xA432: "LensSpecification",
+	0xA433: "LensMake",
+	0xA434: "LensModel",
+	0xA435: "LensSerialNumber",
+	0xA460: "CompositeImage",
+}
+
+// gpsTagNames are the names of the tags of the GPS IFD.
+var gpsTagNames = map[uint16]string{
+	0x0000: "GPSVersionID",
+	0x0001: "GPSLatitudeRef",
+	0x0002: "GPSLatitude",
+	0x0003: "GPSLongitudeRef",
+	0x0004: "GPSLongitude",
+	0x0005: "GPSAltitudeRef",
+	0x0006: "GPSAltitude",
+	0x0007: "GPSTimeStamp",
+	0x0008: "GPSSatellites",
+	0x0009: "GPSStatus",
+	0x000A: "GPSMeasureMode",
+	0x000B: "GPSDOP",
+	0x000C: "GPSSpeedRef",
+	0x000D: "GPSSpeed",
+	0x000E: "GPSTrackRef",
+	0x000F: "GPSTrack",
+	0x0010: "GPSImgDirectionRef",
+	0x0011: "GPSImgDirection",
+	0x0012: "GPSMapDatum",
+	0x0013: "GPSDestLatitudeRef",
+	0x0014: "GPSDestLatitude",
+	0x0015: "GPSDestLongitudeRef",
+	0x0016: "GPSDestLongitude",
+	0x0017: "GPSDestBearingRef",
+	0x0018: "GPSDestBearing",
+	0x0019: "GPSDestDistanceRef",
+	0x001A: "GPSDestDistance",
+	0x001B: "GPSProcessingMethod",
+	0x001C: "GPSAreaInformation",
+	0x001D: "GPSDateStamp",
+	0x001E: "GPSDifferential",
+	0x001F: "GPSHPositioningError",
+}
+
+// interopTagNames are the names of the tags of the Interop IFD.
+var interopTagNames = map[uint16]string{
+	0x0001: "InteroperabilityIndex",
+	0x0002: "InteroperabilityVersion",
+}
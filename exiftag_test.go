@@ -0,0 +1,196 @@
+package heic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeExifTags(t *testing.T) {
+	tags, err := DecodeExifTags(bytes.NewReader(testHeicExif))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, tag := range tags {
+		names = append(names, tag.IFD.String()+"."+tag.Name())
+	}
+	want := []string{
+		"IFD0.Make", "IFD0.Model", "IFD0.Orientation", "IFD0.XResolution", "IFD0.YResolution", "IFD0.ResolutionUnit",
+		"IFD0.YCbCrPositioning", "IFD0.ExifIFDPointer",
+		"Exif.FNumber", "Exif.ISOSpeedRatings", "Exif.ExifVersion", "Exif.ComponentsConfiguration", "Exif.ColorSpace",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("tags %v", names)
+	}
+
+	if v := tags[8].Value; !reflect.DeepEqual(v, []Rational{{28, 5}}) || tags[8].Float() != 5.6 {
+		t.Errorf("FNumber %v", v)
+	}
+	if v := tags[10].Value; !reflect.DeepEqual(v, []byte("0232")) || tags[10].Int() != 0 {
+		t.Errorf("ExifVersion %v", v)
+	}
+
+	if _, err := DecodeExifTags(bytes.NewReader(testHeic12)); !errors.Is(err, ErrNoExif) {
+		t.Errorf("err = %v, want ErrNoExif", err)
+	}
+}
+
+func TestParseExifTags(t *testing.T) {
+	// IFD0 links to IFD1, whose next IFD loops back to IFD0.
+	data, offsets := buildTIFF([][]tiffEntry{
+		{
+			{tag: 0x010F, typ: typeASCIIString, count: 8, value: []byte("TestCam\x00")},
+			{tag: tagExifIFDPointer, typ: typeUnsignedLong, count: 1, ifd: 2},
+			{tag: tagGPSIFDPointer, typ: typeUnsignedLong, count: 1, ifd: 3},
+		},
+		{
+			{tag: 0x0103, typ: typeUnsignedShort, count: 1, value: le(uint16(6))},
+		},
+		{
+			{tag: 0x9204, typ: typeSignedRational, count: 1, value: le(int32(-2), int32(3))},
+			{tag: 0x9290, typ: typeASCIIString, count: 4, value: []byte("123\x00")},
+			{tag: 0x9286, typ: typeUndefined, count: 10, value: []byte("ASCII\x00\x00\x00hi")},
+			{tag: 0xA434, typ: typeASCIIString, count: 12, value: []byte("Prime 50 mm\x00")},
+			{tag: 0x9214, typ: typeSignedShort, count: 3, value: le(int16(-1), int16(2), int16(3))},
+			{tag: 0x9999, typ: typeDoubleFloat, count: 1, value: le(1.5)},
+			{tag: 0x9998, typ: typeSingleFloat, count: 1, value: le(float32(0.25))},
+			{tag: 0x9997, typ: 99, count: 1, value: le(uint32(0))},
+			{tag: tagInteropIFDPointer, typ: typeUnsignedLong, count: 1, ifd: 4},
+		},
+		{
+			{tag: 0x0007, typ: typeUnsignedRational, count: 3, value: le(uint32(10), uint32(1), uint32(20), uint32(1), uint32(305), uint32(10))},
+			{tag: 0x000D, typ: typeUnsignedRational, count: 1, value: le(uint32(5), uint32(2))},
+		},
+		{
+			{tag: 0x0001, typ: typeASCIIString, count: 4, value: []byte("R98\x00")},
+		},
+	}, 0)
+
+	tags, err := parseExifTags(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type entry struct {
+		IFD   IFD
+		Name  string
+		Value any
+	}
+	var got []entry
+	for _, tag := range tags {
+		got = append(got, entry{tag.IFD, tag.Name(), tag.Value})
+	}
+
+	want := []entry{
+		{IFD0, "Make", "TestCam"},
+		{IFD0, "ExifIFDPointer", []uint32{uint32(offsets[2])}},
+		{IFD0, "GPSInfoIFDPointer", []uint32{uint32(offsets[3])}},
+		{IFDExif, "ExposureBiasValue", []SRational{{-2, 3}}},
+		{IFDExif, "SubSecTime", "123"},
+		{IFDExif, "UserComment", []byte("ASCII\x00\x00\x00hi")},
+		{IFDExif, "LensModel", "Prime 50 mm"},
+		{IFDExif, "SubjectArea", []int16{-1, 2, 3}},
+		{IFDExif, "0x9999", []float64{1.5}},
+		{IFDExif, "0x9998", []float32{0.25}},
+		{IFDExif, "InteroperabilityIFDPointer", []uint32{uint32(offsets[4])}},
+		{IFDInterop, "InteroperabilityIndex", "R98"},
+		{IFDGPS, "GPSTimeStamp", []Rational{{10, 1}, {20, 1}, {305, 10}}},
+		{IFDGPS, "GPSSpeed", []Rational{{5, 2}}},
+		{IFD1, "Compression", []uint16{6}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+
+	if f := tags[3].Float(); math.Abs(f+2.0/3) > 1e-9 {
+		t.Errorf("ExposureBiasValue %v", f)
+	}
+	if f := tags[12].Floats(); !reflect.DeepEqual(f, []float64{10, 20, 30.5}) {
+		t.Errorf("GPSTimeStamp %v", f)
+	}
+	if v := tags[7].Int(); v != -1 {
+		t.Errorf("SubjectArea %d", v)
+	}
+
+	ex := &Exif{}
+	if err := parseExifData(data, ex); err != nil || ex.Make != "TestCam" {
+		t.Errorf("exif %+v, err %v", ex, err)
+	}
+
+	if _, err := parseExifTags([]byte("XX\x2a\x00\x08\x00\x00\x00")); err == nil {
+		t.Error("invalid byte order: no error")
+	}
+}
+
+// tiffEntry is an IFD entry of buildTIFF. A non-zero ifd makes it a pointer to that IFD.
+type tiffEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+	ifd      int
+}
+
+// buildTIFF returns little-endian TIFF data with ifds, each followed by its out-of-line values, and their
+// offsets. IFD0 links to IFD1, which links to IFD next.
+func buildTIFF(ifds [][]tiffEntry, next int) ([]byte, []int) {
+	var offsets []int
+	off := 8
+	for _, ifd := range ifds {
+		offsets = append(offsets, off)
+		off += 6 + 12*len(ifd)
+		for _, e := range ifd {
+			if len(e.value) > 4 {
+				off += len(e.value)
+			}
+		}
+	}
+
+	b := []byte("II\x2a\x00\x08\x00\x00\x00")
+	for i, ifd := range ifds {
+		data := offsets[i] + 6 + 12*len(ifd)
+		var extra []byte
+
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(ifd)))
+		for _, e := range ifd {
+			b = binary.LittleEndian.AppendUint16(b, e.tag)
+			b = binary.LittleEndian.AppendUint16(b, e.typ)
+			b = binary.LittleEndian.AppendUint32(b, e.count)
+
+			v := e.value
+			if e.ifd != 0 {
+				v = le(uint32(offsets[e.ifd]))
+			}
+			if len(v) > 4 {
+				b = binary.LittleEndian.AppendUint32(b, uint32(data+len(extra)))
+				extra = append(extra, v...)
+			} else {
+				b = append(b, append(v, make([]byte, 4-len(v))...)...)
+			}
+		}
+
+		link := 0
+		if i == 0 && len(ifds) > 1 {
+			link = offsets[1]
+		} else if i == 1 {
+			link = offsets[next]
+		}
+		b = binary.LittleEndian.AppendUint32(b, uint32(link))
+		b = append(b, extra...)
+	}
+
+	return b, offsets
+}
+
+// le returns the little-endian encoding of values.
+func le(values ...any) []byte {
+	var buf bytes.Buffer
+	for _, v := range values {
+		_ = binary.Write(&buf, binary.LittleEndian, v)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,365 @@
+package heic
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+// appleGainMapURN is the auxiliary type of the HDR gain map of Apple cameras.
+const appleGainMapURN = "urn:com:apple:photo:2020:aux:hdrgainmap"
+
+// ErrNoGainMap is returned by DecodeGainMap when the primary image has no HDR gain map.
+var ErrNoGainMap = errors.New("heic: no gain map")
+
+// GainMap is the HDR gain map of an image: an Apple auxiliary gain map image, or the gain map input of an
+// ISO 21496-1 tone-mapped (tmap) derived image. See ApplyGainMap.
+type GainMap struct {
+	ID    uint32      // Item ID of the gain map image.
+	Image *image.Gray // Gain map samples, often at a lower resolution than the image; colour maps as luma.
+
+	// Headroom is the HDR headroom of an Apple gain map, the ratio of HDR peak to SDR white, from the XMP of
+	// the gain map or else the Apple MakerNote; 0 when unknown or for an ISO gain map.
+	Headroom float64
+
+	// Metadata holds the parameters of an ISO 21496-1 gain map, or nil for an Apple one.
+	Metadata *GainMapMetadata
+
+	// BaseColor is the colour information of the base image, which ApplyGainMap linearises it with.
+	BaseColor ColorInfo
+}
+
+// GainMapMetadata holds the ISO 21496-1 parameters of a gain map. Headrooms and gains are log2 values;
+// per-channel values are in R, G, B order and equal for a single-channel map.
+type GainMapMetadata struct {
+	BaseHeadroom      float64 // HDR headroom of the base image.
+	AlternateHeadroom float64 // HDR headroom of the alternate image the gain map produces.
+
+	Min             [3]float64 // Gain for a gain map value of 0.
+	Max             [3]float64 // Gain for a gain map value of 1.
+	Gamma           [3]float64
+	BaseOffset      [3]float64
+	AlternateOffset [3]float64
+
+	UseBaseColorSpace bool // Apply the gain map in the colour space of the base image.
+}
+
+// DecodeGainMap decodes the HDR gain map of the primary image: the gain map input of a tmap derived image
+// of it, or else its Apple auxiliary gain map image, or returns ErrNoGainMap if there is none.
+func DecodeGainMap(r io.Reader) (*GainMap, error) {
+	return decodeGainMap(context.Background(), r, nil)
+}
+
+// DecodeGainMapWithOptions is like DecodeGainMap using the backend, limits and transformations of opts; the
+// gain map is always decoded as 8-bit gray without colour conversion.
+func DecodeGainMapWithOptions(r io.Reader, opts *Options) (*GainMap, error) {
+	return decodeGainMap(context.Background(), r, opts)
+}
+
+// DecodeGainMapWithOptionsContext is like DecodeGainMapWithOptions, but aborts the decoding and returns
+// ctx.Err() when ctx is done, as DecodeContext does.
+func DecodeGainMapWithOptionsContext(ctx context.Context, r io.Reader, opts *Options) (*GainMap, error) {
+	return decodeGainMap(ctx, r, opts)
+}
+
+func decodeGainMap(ctx context.Context, r io.Reader, opts *Options) (*GainMap, error) {
+	if opts == nil {
+		opts = defaultOptions
+	}
+
+	data, err := opts.readInput(r)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := isobmff.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("heic: %w", err)
+	}
+	if f.Meta == nil {
+		return nil, ErrNoMeta
+	}
+
+	gm, err := gainMap(data, f.Meta)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := decodeImage(ctx, bytes.NewReader(data), opts.auxOptions(gm.ID, FormatGray, 0))
+	if err != nil {
+		return nil, err
+	}
+	gm.Image = img.(*image.Gray)
+
+	return gm, nil
+}
+
+// gainMap returns the gain map of the primary image of meta, without its image.
+func gainMap(data []byte, meta *isobmff.Meta) (*GainMap, error) {
+	primary := meta.PrimaryItemID()
+
+	for _, e := range meta.ItemsOfType("tmap") {
+		refs := meta.References(e.ItemID, "dimg")
+		if len(refs) != 2 || refs[0] != primary {
+			continue
+		}
+
+		b, err := meta.ReadItem(bytes.NewReader(data), e.ItemID)
+		if err != nil {
+			return nil, fmt.Errorf("heic: tmap: %w", err)
+		}
+
+		md, err := parseToneMap(b)
+		if err != nil {
+			return nil, fmt.Errorf("heic: tmap: %w", err)
+		}
+
+		return &GainMap{ID: refs[1], Metadata: md, BaseColor: colorInfo(meta, primary)}, nil
+	}
+
+	for _, aux := range auxImages(meta, primary) {
+		if aux.Type != appleGainMapURN {
+			continue
+		}
+
+		gm := &GainMap{ID: aux.ID, BaseColor: colorInfo(meta, primary)}
+
+		gm.Headroom = xmpHeadroom(data, meta, aux.ID)
+		if gm.Headroom == 0 {
+			if tags, err := parseExifTags(exifPayload(bytes.NewReader(data))); err == nil {
+				gm.Headroom = appleHeadroom(appleMakerNoteTags(exifMakerNote(tags)))
+			}
+		}
+
+		return gm, nil
+	}
+
+	return nil, ErrNoGainMap
+}
+
+// parseToneMap parses the ISO 21496-1 gain map metadata of a tmap item.
+func parseToneMap(b []byte) (*GainMapMetadata, error) {
+	if len(b) < 6 || b[0] != 0 {
+		return nil, errors.New("unsupported version")
+	}
+	if minVersion := binary.BigEndian.Uint16(b[1:]); minVersion != 0 {
+		return nil, fmt.Errorf("unsupported minimum version %d", minVersion)
+	}
+
+	// Only the multichannel and use base colour space flags are supported; the others change the layout or
+	// the direction of the metadata.
+	flags := b[5]
+	if flags&^0xc0 != 0 {
+		return nil, fmt.Errorf("unsupported flags %#x", flags)
+	}
+
+	channels := 1
+	if flags&0x80 != 0 {
+		channels = 3
+	}
+
+	p := b[6:]
+	if len(p) < 16+channels*40 {
+		return nil, errors.New("metadata too short")
+	}
+
+	fraction := func(signed bool) float64 {
+		n, d := binary.BigEndian.Uint32(p), binary.BigEndian.Uint32(p[4:])
+		p = p[8:]
+
+		if d == 0 {
+			return 0
+		}
+		if signed {
+			return float64(int32(n)) / float64(d)
+		}
+		return float64(n) / float64(d)
+	}
+
+	md := &GainMapMetadata{UseBaseColorSpace: flags&0x40 != 0}
+	md.BaseHeadroom = fraction(false)
+	md.AlternateHeadroom = fraction(false)
+
+	for c := range channels {
+		md.Min[c] = fraction(true)
+		md.Max[c] = fraction(true)
+		md.Gamma[c] = fraction(false)
+		md.BaseOffset[c] = fraction(true)
+		md.AlternateOffset[c] = fraction(true)
+	}
+
+	for c := channels; c < 3; c++ {
+		md.Min[c], md.Max[c], md.Gamma[c] = md.Min[0], md.Max[0], md.Gamma[0]
+		md.BaseOffset[c], md.AlternateOffset[c] = md.BaseOffset[0], md.AlternateOffset[0]
+	}
+
+	for c := range 3 {
+		if md.Gamma[c] <= 0 {
+			return nil, fmt.Errorf("gamma %v", md.Gamma[c])
+		}
+	}
+
+	return md, nil
+}
+
+// xmpHeadroom returns the Apple HDRGainMapHeadroom of the XMP describing item id, or 0.
+func xmpHeadroom(data []byte, meta *isobmff.Meta, id uint32) float64 {
+	v, _ := xmpText(itemXMP(data, meta, id), nsHDRGainMap, "HDRGainMapHeadroom")
+	if h, err := strconv.ParseFloat(v, 64); err == nil && h > 0 {
+		return h
+	}
+
+	return 0
+}
+
+// appleHeadroom returns the HDR headroom from the HDRHeadroom and HDRGain tags of an Apple MakerNote, as
+// documented by Apple for rendering its gain maps, or 0.
+func appleHeadroom(tags []ExifTag) float64 {
+	var maker33, maker48 float64
+	var found int
+
+	for _, t := range tags {
+		if t.ID != appleTagHDRHeadroom && t.ID != appleTagHDRGain {
+			continue
+		}
+
+		var v float64
+		switch r := t.Value.(type) {
+		case []Rational:
+			if len(r) == 0 || r[0].Den == 0 {
+				continue
+			}
+			v = r[0].Float64()
+		case []SRational:
+			if len(r) == 0 || r[0].Den == 0 {
+				continue
+			}
+			v = r[0].Float64()
+		default:
+			continue
+		}
+
+		if t.ID == appleTagHDRHeadroom {
+			maker33 = v
+		} else {
+			maker48 = v
+		}
+		found++
+	}
+
+	if found < 2 {
+		return 0
+	}
+
+	var stops float64
+	switch {
+	case maker33 < 1 && maker48 <= 0.01:
+		stops = -20*maker48 + 1.8
+	case maker33 < 1:
+		stops = -0.101*maker48 + 1.601
+	case maker48 <= 0.01:
+		stops = -70*maker48 + 3
+	default:
+		stops = -0.303*maker48 + 2.303
+	}
+
+	return math.Exp2(max(stops, 0))
+}
+
+// ApplyGainMap applies gm to base, the decoded SDR image, and returns its HDR rendition as linear light
+// relative to SDR white, in the primaries of base. The gain map is scaled to the size of base. base is
+// linearised with the colour space of gm.BaseColor, or taken as sRGB when it is unknown.
+//
+// headroom is the HDR headroom of the display, the ratio of its peak to SDR white; the gain is weighted
+// down for a display with less headroom than the gain map, and applied fully when headroom is 0.
+// An Apple gain map without a known Headroom leaves the image as SDR.
+func ApplyGainMap(base image.Image, gm *GainMap, headroom float64) *LinearImage {
+	var src *rgbSpace
+	if gm != nil {
+		src, _, _ = sourceSpace(gm.BaseColor)
+	}
+	if src == nil {
+		src = targetSpace(ColorSpaceSRGB)
+	}
+	img := newConverter(src, newSpace(src.toPCS, transferLinear)).linear(base)
+
+	if gm == nil || gm.Image == nil || gm.Image.Rect.Empty() {
+		return img
+	}
+
+	var gain func(v float64, sdr *[3]float32)
+
+	if md := gm.Metadata; md != nil {
+		w := 1.0
+		if headroom > 0 && md.AlternateHeadroom != md.BaseHeadroom {
+			w = min(max((math.Log2(headroom)-md.BaseHeadroom)/(md.AlternateHeadroom-md.BaseHeadroom), 0), 1)
+		}
+
+		gain = func(v float64, sdr *[3]float32) {
+			for c := range 3 {
+				g := md.Min[c] + (md.Max[c]-md.Min[c])*math.Pow(v, 1/md.Gamma[c])
+				sdr[c] = float32((float64(sdr[c])+md.BaseOffset[c])*math.Exp2(g*w) - md.AlternateOffset[c])
+			}
+		}
+	} else {
+		h := gm.Headroom
+		if h <= 1 {
+			return img
+		}
+
+		w := 1.0
+		if headroom > 0 {
+			w = min(max(math.Log2(headroom)/math.Log2(h), 0), 1)
+		}
+
+		gain = func(v float64, sdr *[3]float32) {
+			k := float32(math.Pow(1+(h-1)*transferSRGB.toLinear(v), w))
+			for c := range 3 {
+				sdr[c] *= k
+			}
+		}
+	}
+
+	b := img.Rect
+	gr := gm.Image.Rect
+	sx, sy := float64(gr.Dx())/float64(b.Dx()), float64(gr.Dy())/float64(b.Dy())
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		gy := (float64(y-b.Min.Y)+0.5)*sy - 0.5
+		for x := b.Min.X; x < b.Max.X; x++ {
+			gx := (float64(x-b.Min.X)+0.5)*sx - 0.5
+
+			i := img.PixOffset(x, y)
+			gain(sampleGray(gm.Image, gx, gy), (*[3]float32)(img.Pix[i:i+3]))
+		}
+	}
+
+	return img
+}
+
+// sampleGray returns the bilinear interpolation of img at (x, y), relative to its bounds, in [0, 1].
+func sampleGray(img *image.Gray, x, y float64) float64 {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+
+	x, y = min(max(x, 0), float64(w-1)), min(max(y, 0), float64(h-1))
+	x0, y0 := int(x), int(y)
+	x1, y1 := min(x0+1, w-1), min(y0+1, h-1)
+	fx, fy := x-float64(x0), y-float64(y0)
+
+	at := func(x, y int) float64 {
+		return float64(img.Pix[y*img.Stride+x])
+	}
+
+	top := at(x0, y0)*(1-fx) + at(x1, y0)*fx
+	bottom := at(x0, y1)*(1-fx) + at(x1, y1)*fx
+
+	return (top*(1-fy) + bottom*fy) / 255
+}
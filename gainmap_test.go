@@ -0,0 +1,174 @@
+package heic
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"image"
+	"math"
+	"testing"
+)
+
+//go:embed testdata/gainmap_apple.heic
+var testGainMapApple []byte
+
+//go:embed testdata/gainmap_iso.heic
+var testGainMapISO []byte
+
+//go:embed testdata/gainmap_iso3.heic
+var testGainMapISO3 []byte
+
+func TestDecodeGainMap(t *testing.T) {
+	gm, err := DecodeGainMap(bytes.NewReader(testGainMapApple))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// maker33 1.01 and maker48 0.005 give 3 - 70*0.005 stops.
+	if gm.ID != 2 || gm.Metadata != nil || math.Abs(gm.Headroom-math.Exp2(2.65)) > 1e-6 {
+		t.Errorf("gain map %d, headroom %v, metadata %+v", gm.ID, gm.Headroom, gm.Metadata)
+	}
+	if gm.Image.Rect != image.Rect(0, 0, 512, 512) {
+		t.Errorf("gain map bounds %v", gm.Image.Rect)
+	}
+
+	gm, err = DecodeGainMap(bytes.NewReader(testGainMapISO))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := GainMapMetadata{
+		AlternateHeadroom: 2,
+		Max:               [3]float64{2, 2, 2},
+		Gamma:             [3]float64{1, 1, 1},
+		BaseOffset:        [3]float64{1.0 / 64, 1.0 / 64, 1.0 / 64},
+		AlternateOffset:   [3]float64{1.0 / 64, 1.0 / 64, 1.0 / 64},
+	}
+	if gm.ID != 2 || gm.Headroom != 0 || gm.Metadata == nil || *gm.Metadata != want {
+		t.Errorf("gain map %d, headroom %v, metadata %+v", gm.ID, gm.Headroom, gm.Metadata)
+	}
+	if gm.Image == nil {
+		t.Fatal("no gain map image")
+	}
+
+	gm, err = DecodeGainMap(bytes.NewReader(testGainMapISO3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = GainMapMetadata{
+		AlternateHeadroom: 3,
+		Min:               [3]float64{0, -0.5, 0.25},
+		Max:               [3]float64{2, 1, 3},
+		Gamma:             [3]float64{1, 2, 0.5},
+		BaseOffset:        [3]float64{1.0 / 64, 1.0 / 32, 0},
+		AlternateOffset:   [3]float64{1.0 / 64, 1.0 / 32, 1.0 / 16},
+		UseBaseColorSpace: true,
+	}
+	if gm.ID != 2 || gm.Metadata == nil || *gm.Metadata != want {
+		t.Errorf("gain map %d, metadata %+v", gm.ID, gm.Metadata)
+	}
+	if gm.Image == nil || gm.Image.Rect != image.Rect(0, 0, 176, 128) {
+		t.Errorf("gain map image %v", gm.Image)
+	}
+
+	if _, err := DecodeGainMap(bytes.NewReader(testHeic8)); !errors.Is(err, ErrNoGainMap) {
+		t.Errorf("err = %v, want ErrNoGainMap", err)
+	}
+}
+
+func TestParseToneMap(t *testing.T) {
+	if _, err := parseToneMap([]byte{1, 0, 0, 0, 0, 0}); err == nil {
+		t.Error("version 1: no error")
+	}
+	if _, err := parseToneMap([]byte{0, 0, 0, 0, 0, 0x80, 0, 0, 0, 0}); err == nil {
+		t.Error("truncated: no error")
+	}
+
+	// The backward direction and common denominator flags change the layout.
+	for _, flags := range []byte{0x04, 0x08, 0x84} {
+		b := append([]byte{0, 0, 0, 0, 0, flags}, make([]byte, 16+3*40)...)
+		if _, err := parseToneMap(b); err == nil {
+			t.Errorf("flags %#x: no error", flags)
+		}
+	}
+}
+
+func TestDecodeGainMapWithOptions(t *testing.T) {
+	// The limits apply to the 176x128 gain map.
+	opts := &Options{Backend: BackendWASM, MaxPixels: 176*128 - 1}
+	if _, err := DecodeGainMapWithOptions(bytes.NewReader(testGainMapISO3), opts); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("got %v, want %v", err, ErrLimitExceeded)
+	}
+
+	// Colour options do not apply to the gain map.
+	opts = &Options{Backend: BackendWASM, MaxPixels: 176 * 128, ColorSpace: ColorSpaceDisplayP3, Format: FormatNRGBA}
+	if _, err := DecodeGainMapWithOptions(bytes.NewReader(testGainMapISO3), opts); err != nil {
+		t.Error(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := DecodeGainMapWithOptionsContext(ctx, bytes.NewReader(testGainMapISO3), opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestApplyGainMap(t *testing.T) {
+	base := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for i := range base.Pix {
+		base.Pix[i] = 255
+	}
+
+	gray := image.NewGray(image.Rect(0, 0, 2, 1))
+	gray.Pix[1] = 255
+
+	// An Apple gain map boosts white by up to its headroom.
+	img := ApplyGainMap(base, &GainMap{Image: gray, Headroom: 4}, 0)
+	if v := img.Pix[0]; math.Abs(float64(v)-1) > 1e-4 {
+		t.Errorf("no gain = %v, want 1", v)
+	}
+	if v := img.Pix[img.PixOffset(3, 0)]; math.Abs(float64(v)-4) > 1e-4 {
+		t.Errorf("full gain = %v, want 4", v)
+	}
+	if a := img.Pix[3]; a != 1 {
+		t.Errorf("alpha = %v", a)
+	}
+
+	// Half of the headroom in stops gives half of the gain.
+	img = ApplyGainMap(base, &GainMap{Image: gray, Headroom: 4}, 2)
+	if v := img.Pix[img.PixOffset(3, 0)]; math.Abs(float64(v)-2) > 1e-4 {
+		t.Errorf("weighted gain = %v, want 2", v)
+	}
+
+	md := &GainMapMetadata{AlternateHeadroom: 2, Max: [3]float64{2, 2, 2}, Gamma: [3]float64{1, 1, 1}}
+	img = ApplyGainMap(base, &GainMap{Image: gray, Metadata: md}, 0)
+	if v := img.Pix[img.PixOffset(3, 1)]; math.Abs(float64(v)-4) > 1e-4 {
+		t.Errorf("ISO gain = %v, want 4", v)
+	}
+	img = ApplyGainMap(base, &GainMap{Image: gray, Metadata: md}, 1)
+	if v := img.Pix[img.PixOffset(3, 1)]; math.Abs(float64(v)-1) > 1e-4 {
+		t.Errorf("SDR display gain = %v, want 1", v)
+	}
+
+	// The base image is linearised with its own transfer function, here BT.709 rather than sRGB.
+	gray.Pix[0] = 0
+	base.Pix[0] = 128
+	img = ApplyGainMap(base, &GainMap{Image: gray, Headroom: 4, BaseColor: ColorInfo{NCLX: &NCLX{1, 1, 1, true}}}, 0)
+	if v, want := img.Pix[0], transferBT709.toLinear(128.0/255); math.Abs(float64(v)-want) > 1e-4 {
+		t.Errorf("BT.709 base = %v, want %v", v, want)
+	}
+
+	gm, err := DecodeGainMap(bytes.NewReader(testGainMapApple))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sdr, err := Decode(bytes.NewReader(testGainMapApple))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img := ApplyGainMap(sdr, gm, 0); img.Rect != sdr.Bounds() {
+		t.Errorf("bounds %v, want %v", img.Rect, sdr.Bounds())
+	}
+}
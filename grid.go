@@ -0,0 +1,481 @@
+package heic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"sync"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+// ErrNoGrid is returned by DecodeGrid and DecodeTile when the primary image is not a grid.
+var ErrNoGrid = errors.New("heic: not a grid image")
+
+// Grid is the layout of a grid derived image: Rows x Columns tiles of the same size placed in row-major
+// order on a canvas, which is cropped to Width x Height.
+type Grid struct {
+	ID            uint32 // Item ID of the grid.
+	Rows, Columns int
+	Width, Height int // Size of the canvas, before the transformations of the grid.
+
+	TileWidth, TileHeight int      // Size of every tile.
+	Tiles                 []uint32 // Item IDs of the tiles in row-major order.
+}
+
+// Tile returns the rectangle of the canvas covered by the tile at column, row, clipped to the canvas.
+func (g *Grid) Tile(column, row int) image.Rectangle {
+	return g.cell(column, row).Intersect(image.Rect(0, 0, g.Width, g.Height))
+}
+
+// cell returns the rectangle of the tile at column, row, which may extend past the canvas.
+func (g *Grid) cell(column, row int) image.Rectangle {
+	x, y := column*g.TileWidth, row*g.TileHeight
+
+	return image.Rect(x, y, x+g.TileWidth, y+g.TileHeight)
+}
+
+// DecodeGrid returns the grid layout of the primary image, or ErrNoGrid if it is not a grid.
+func DecodeGrid(r io.Reader) (*Grid, error) {
+	data, err := io.ReadAll(io.LimitReader(r, heifMaxHeaderSize))
+	if err != nil {
+		return nil, fmt.Errorf("heic: read: %w", err)
+	}
+
+	return primaryGrid(data)
+}
+
+// DecodeTile decodes the tile at column, row of the grid of the primary image, with the transformations of
+// the tile but not those of the grid.
+func DecodeTile(r io.Reader, column, row int) (image.Image, error) {
+	data, err := defaultOptions.readInput(r)
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := primaryGrid(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if column < 0 || column >= g.Columns || row < 0 || row >= g.Rows {
+		return nil, fmt.Errorf("heic: tile %d,%d outside a %dx%d grid", column, row, g.Columns, g.Rows)
+	}
+
+	return decodeImage(context.Background(), bytes.NewReader(data), &Options{ItemID: g.Tiles[row*g.Columns+column]})
+}
+
+// DecodeRegion decodes the rectangle r of the primary image, in the coordinates of the decoded image. Of a
+// grid, only the tiles that intersect r are decoded; other images are decoded whole and cropped.
+func DecodeRegion(rd io.Reader, r image.Rectangle) (image.Image, error) {
+	return DecodeRegionWithOptions(rd, r, nil)
+}
+
+// DecodeRegionWithOptions is like DecodeRegion with opts, whose ItemID selects another image item. Regions are
+// decoded with the WASM decoder, also with BackendAuto, and BackendDynamic returns ErrUnsupported.
+func DecodeRegionWithOptions(rd io.Reader, r image.Rectangle, opts *Options) (image.Image, error) {
+	return decodeRegionInput(context.Background(), rd, r, opts)
+}
+
+// DecodeRegionWithOptionsContext is like DecodeRegionWithOptions, but aborts the decoding and returns ctx.Err()
+// when ctx is done, as DecodeContext does.
+func DecodeRegionWithOptionsContext(ctx context.Context, rd io.Reader, r image.Rectangle, opts *Options) (image.Image, error) {
+	return decodeRegionInput(ctx, rd, r, opts)
+}
+
+func decodeRegionInput(ctx context.Context, rd io.Reader, r image.Rectangle, opts *Options) (image.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if opts == nil {
+		opts = defaultOptions
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.Backend == BackendDynamic {
+		return nil, fmt.Errorf("heic: regions are decoded with the WASM decoder: %w", ErrUnsupported)
+	}
+
+	data, err := opts.readInput(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	conv := colorConverter(data, false, opts)
+	if err := wasmOptions(data, opts, conv); err != nil {
+		return nil, err
+	}
+
+	img, err := decodeRegion(ctx, data, r, conv.decodeOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	return conv.output(img, opts), nil
+}
+
+// primaryGrid returns the grid layout of the primary image of data.
+func primaryGrid(data []byte) (*Grid, error) {
+	f, err := isobmff.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("heic: %w", err)
+	}
+	if f.Meta == nil {
+		return nil, ErrNoMeta
+	}
+
+	if !isGrid(f.Meta) {
+		return nil, ErrNoGrid
+	}
+
+	return gridLayout(data, f.Meta, f.Meta.PrimaryItemID())
+}
+
+// isGrid reports whether the primary image of meta is a grid.
+func isGrid(meta *isobmff.Meta) bool {
+	if meta == nil {
+		return false
+	}
+
+	e := meta.Item(meta.PrimaryItemID())
+
+	return e != nil && e.ItemType == "grid"
+}
+
+// gridLayout returns the layout of grid item id.
+func gridLayout(data []byte, meta *isobmff.Meta, id uint32) (*Grid, error) {
+	desc, err := meta.ReadItem(bytes.NewReader(data), id)
+	if err != nil {
+		return nil, itemError(StageParse, CodeInvalidInput, "item %d: %v", id, err)
+	}
+
+	g, err := parseGrid(desc)
+	if err != nil {
+		return nil, itemError(StageParse, CodeInvalidInput, "item %d: %v", id, err)
+	}
+
+	tiles := meta.References(id, "dimg")
+	if len(tiles) != g.rows*g.columns {
+		return nil, itemError(StageParse, CodeInvalidInput, "item %d: %d tiles for a %dx%d grid", id, len(tiles), g.columns, g.rows)
+	}
+
+	// All tiles have the size of the first one, and together they cover the canvas.
+	tw, th := itemSize(meta, tiles[0])
+	if tw == 0 || th == 0 || g.width > tw*g.columns || g.height > th*g.rows {
+		return nil, itemError(StageParse, CodeInvalidInput, "item %d: %dx%d tiles do not cover %dx%d", id, tw, th, g.width, g.height)
+	}
+
+	return &Grid{
+		ID:         id,
+		Rows:       g.rows,
+		Columns:    g.columns,
+		Width:      g.width,
+		Height:     g.height,
+		TileWidth:  tw,
+		TileHeight: th,
+		Tiles:      tiles,
+	}, nil
+}
+
+// decodeRegion decodes the rectangle r of the image item opts.ItemID, or the primary image, of data, in the
+// coordinates of the image after its transformations, from the coded pixels that r comes from.
+func decodeRegion(ctx context.Context, data []byte, r image.Rectangle, opts *Options) (image.Image, error) {
+	meta, e, err := parseItemMeta(data, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	w, h := codedSize(meta, e.ItemID)
+
+	var ts []Transform
+	if !opts.IgnoreTransformations {
+		ts = transforms(meta.ItemProperties(e.ItemID), w, h)
+	}
+
+	dr, src := sourceRect(ts, w, h, r)
+	if dr.Empty() {
+		return nil, fmt.Errorf("heic: region %v outside the image", r)
+	}
+	if err := opts.checkSize(src.Dx(), src.Dy()); err != nil {
+		return nil, err
+	}
+
+	img, err := decodeItemRegion(ctx, data, meta, e, src, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	aid, prem := alphaItem(meta, e.ItemID)
+	if aid != 0 {
+		ae := meta.Item(aid)
+		if ae == nil {
+			return nil, itemError(StageParse, CodeInvalidInput, "item %d: no alpha item %d", e.ItemID, aid)
+		}
+
+		alpha, err := decodeAlphaRegion(ctx, data, meta, ae, src, w, h, opts)
+		if err != nil {
+			return nil, err
+		}
+		setAlpha(img, alpha)
+	}
+
+	// The region is inside the clean aperture, so only the rotations and mirrorings remain.
+	for _, t := range ts {
+		switch t.Kind {
+		case TransformRotate:
+			img = rotate(img, t.Angle)
+		case TransformMirror:
+			img = mirror(img, uint8(t.Axis))
+		}
+	}
+
+	dst := image.NewNRGBA(dr)
+	draw.Draw(dst, dr, img, img.Rect.Min, draw.Src)
+
+	if prem {
+		return premultiplied(dst), nil
+	}
+
+	return dst, nil
+}
+
+// sourceRect clips r, in the coordinates of a w x h coded image after the transformations ts, to the image
+// and returns it with the rectangle of the coded image it comes from.
+func sourceRect(ts []Transform, w, h int, r image.Rectangle) (image.Rectangle, image.Rectangle) {
+	sizes := make([]image.Point, len(ts))
+	for i, t := range ts {
+		sizes[i] = image.Pt(w, h)
+
+		switch t.Kind {
+		case TransformCrop:
+			w, h = t.Rect.Dx(), t.Rect.Dy()
+		case TransformRotate:
+			if t.Angle == 90 || t.Angle == 270 {
+				w, h = h, w
+			}
+		}
+	}
+
+	r = r.Intersect(image.Rect(0, 0, w, h))
+
+	src := r
+	for i := len(ts) - 1; i >= 0; i-- {
+		w, h := sizes[i].X, sizes[i].Y
+		x0, y0, x1, y1 := src.Min.X, src.Min.Y, src.Max.X, src.Max.Y
+
+		switch t := ts[i]; t.Kind {
+		case TransformCrop:
+			src = src.Add(t.Rect.Min)
+		case TransformRotate:
+			switch t.Angle {
+			case 90:
+				src = image.Rect(w-y1, x0, w-y0, x1)
+			case 180:
+				src = image.Rect(w-x1, h-y1, w-x0, h-y0)
+			case 270:
+				src = image.Rect(y0, h-x1, y1, h-x0)
+			}
+		case TransformMirror:
+			if t.Axis == 0 {
+				src = image.Rect(x0, h-y1, x1, h-y0)
+			} else {
+				src = image.Rect(w-x1, y0, w-x0, y1)
+			}
+		}
+	}
+
+	return r, src
+}
+
+// decodeItemRegion decodes the rectangle r of the coded or grid item e without applying its transformations.
+func decodeItemRegion(ctx context.Context, data []byte, meta *isobmff.Meta, e *isobmff.ItemInfoEntry, r image.Rectangle, opts *Options) (*image.NRGBA, error) {
+	if e.ItemType == "grid" {
+		g, err := gridLayout(data, meta, e.ItemID)
+		if err != nil {
+			return nil, err
+		}
+
+		return decodeGridRegion(ctx, data, meta, g, r, opts)
+	}
+
+	img, err := decodeItemPixels(ctx, data, meta, e, opts, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return img.SubImage(r.Intersect(img.Rect)).(*image.NRGBA), nil
+}
+
+// decodeAlphaRegion decodes the rectangle of the alpha item e that covers src, a rectangle of the w x h colour
+// image. The alpha plane may have another resolution, so src is scaled from its decoded size rather than its
+// ispe, which may disagree with it; setAlpha scales the result to src.
+func decodeAlphaRegion(ctx context.Context, data []byte, meta *isobmff.Meta, e *isobmff.ItemInfoEntry, src image.Rectangle, w, h int, opts *Options) (*image.NRGBA, error) {
+	var g *Grid
+	var full *image.NRGBA
+	var aw, ah int
+
+	if e.ItemType == "grid" {
+		var err error
+		if g, err = gridLayout(data, meta, e.ItemID); err != nil {
+			return nil, err
+		}
+		aw, ah = g.Width, g.Height
+	} else {
+		var err error
+		if full, err = decodeItemPixels(ctx, data, meta, e, opts, 0); err != nil {
+			return nil, err
+		}
+		aw, ah = full.Rect.Dx(), full.Rect.Dy()
+	}
+
+	ar := image.Rect(src.Min.X*aw/w, src.Min.Y*ah/h, (src.Max.X*aw+w-1)/w, (src.Max.Y*ah+h-1)/h)
+
+	var alpha *image.NRGBA
+	if g != nil {
+		var err error
+		if alpha, err = decodeGridRegion(ctx, data, meta, g, ar, opts); err != nil {
+			return nil, err
+		}
+	} else {
+		ar = ar.Add(full.Rect.Min)
+		alpha = full.SubImage(ar.Intersect(full.Rect)).(*image.NRGBA)
+	}
+
+	if alpha.Rect.Empty() || alpha.Rect.Size() != ar.Size() {
+		return nil, itemError(StageDecode, CodeInvalidInput, "item %d: alpha region %v of %dx%d does not cover %v", e.ItemID, alpha.Rect, aw, ah, src)
+	}
+
+	return alpha, nil
+}
+
+// decodeGridItem decodes the tiles of a grid item and places them in row-major order on its output canvas.
+func decodeGridItem(ctx context.Context, data []byte, meta *isobmff.Meta, id uint32, opts *Options) (*image.NRGBA, error) {
+	g, err := gridLayout(data, meta, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := opts.checkSize(g.Width, g.Height); err != nil {
+		return nil, err
+	}
+
+	return decodeGridRegion(ctx, data, meta, g, image.Rect(0, 0, g.Width, g.Height), opts)
+}
+
+// decodeGridRegion decodes the tiles of g that intersect r, a rectangle of its canvas, and returns r.
+func decodeGridRegion(ctx context.Context, data []byte, meta *isobmff.Meta, g *Grid, r image.Rectangle, opts *Options) (*image.NRGBA, error) {
+	r = r.Intersect(image.Rect(0, 0, g.Width, g.Height))
+	dst := image.NewNRGBA(r)
+
+	var idx []int
+	for i := range g.Tiles {
+		if g.cell(i%g.Columns, i/g.Columns).Overlaps(r) {
+			idx = append(idx, i)
+		}
+	}
+
+	// The tiles cover disjoint parts of dst.
+	err := decodeTiles(ctx, idx, opts, func(i int) error {
+		tid := g.Tiles[i]
+
+		tile, err := decodeCodedItem(ctx, data, meta, tid, opts)
+		if err != nil {
+			return err
+		}
+		tile = transform(tile, meta.ItemProperties(tid))
+
+		cell := g.cell(i%g.Columns, i/g.Columns)
+		part := cell.Intersect(r)
+		draw.Draw(dst, part, tile, tile.Rect.Min.Add(part.Min.Sub(cell.Min)), draw.Src)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dst, nil
+}
+
+// decodeTiles calls decode with each tile index of idx on up to opts.Concurrency goroutines, each decoding
+// with its own WASM module instance, and returns the first error. It stops early on an error or when ctx is done.
+func decodeTiles(ctx context.Context, idx []int, opts *Options, decode func(i int) error) error {
+	n := min(opts.concurrency(), len(idx))
+	if n <= 1 {
+		for _, i := range idx {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := decode(i); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range n {
+		wg.Go(func() {
+			for i := range next {
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := decode(i); err != nil {
+					cancel(err)
+				}
+			}
+		})
+	}
+
+	for _, i := range idx {
+		if ctx.Err() != nil {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return context.Cause(ctx)
+}
+
+// grid is an ImageGrid derivation: rows x columns tiles cropped to width x height.
+type grid struct {
+	rows, columns int
+	width, height int
+}
+
+func parseGrid(b []byte) (grid, error) {
+	if len(b) < 8 {
+		return grid{}, errors.New("grid descriptor too short")
+	}
+
+	g := grid{rows: int(b[2]) + 1, columns: int(b[3]) + 1}
+	if b[1]&1 == 0 {
+		g.width = int(b[4])<<8 | int(b[5])
+		g.height = int(b[6])<<8 | int(b[7])
+	} else {
+		if len(b) < 12 {
+			return grid{}, errors.New("grid descriptor too short")
+		}
+		g.width = int(b[4])<<24 | int(b[5])<<16 | int(b[6])<<8 | int(b[7])
+		g.height = int(b[8])<<24 | int(b[9])<<16 | int(b[10])<<8 | int(b[11])
+	}
+
+	if g.width == 0 || g.height == 0 {
+		return grid{}, fmt.Errorf("grid size %dx%d", g.width, g.height)
+	}
+
+	return g, nil
+}
@@ -0,0 +1,266 @@
+package heic
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"testing"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+func TestDecodeGrid(t *testing.T) {
+	g, err := DecodeGrid(bytes.NewReader(testHeic))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g.Width != 1346 || g.Height != 1346 || len(g.Tiles) != g.Rows*g.Columns {
+		t.Fatalf("grid %+v", g)
+	}
+	if g.TileWidth*g.Columns < g.Width || g.TileHeight*g.Rows < g.Height {
+		t.Errorf("%dx%d tiles do not cover the canvas", g.TileWidth, g.TileHeight)
+	}
+
+	last := g.Tile(g.Columns-1, g.Rows-1)
+	if last.Max != image.Pt(g.Width, g.Height) {
+		t.Errorf("last tile %v", last)
+	}
+
+	if _, err := DecodeGrid(bytes.NewReader(testHeic8)); !errors.Is(err, ErrNoGrid) {
+		t.Errorf("err = %v, want ErrNoGrid", err)
+	}
+}
+
+func TestDecodeTile(t *testing.T) {
+	g, err := DecodeGrid(bytes.NewReader(testHeic))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	full, err := DecodeWithOptions(bytes.NewReader(testHeic), &Options{Backend: BackendWASM, Format: FormatNRGBA})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tile, err := DecodeTile(bytes.NewReader(testHeic), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := tile.Bounds(); b.Dx() != g.TileWidth || b.Dy() != g.TileHeight {
+		t.Fatalf("tile bounds %v", b)
+	}
+
+	want := full.(*image.NRGBA).SubImage(g.Tile(1, 0)).(*image.NRGBA)
+	got := image.NewNRGBA(want.Rect)
+	draw.Draw(got, got.Rect, tile, tile.Bounds().Min, draw.Src)
+	if !equalNRGBA(got, want) {
+		t.Error("tile differs from the decoded image")
+	}
+
+	if _, err := DecodeTile(bytes.NewReader(testHeic), g.Columns, 0); err == nil {
+		t.Error("tile outside the grid: no error")
+	}
+}
+
+func TestDecodeRegion(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		data []byte
+		r    image.Rectangle
+	}{
+		{"grid", testHeic, image.Rect(500, 300, 700, 560)},
+		{"rotated", testHeicExif, image.Rect(100, 400, 300, 700)},
+	} {
+		full, err := DecodeWithOptions(bytes.NewReader(tt.data), &Options{Backend: BackendWASM, Format: FormatNRGBA})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		img, err := DecodeRegion(bytes.NewReader(tt.data), tt.r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := tt.r.Intersect(full.Bounds())
+		if img.Bounds() != r {
+			t.Fatalf("%s: bounds %v, want %v", tt.name, img.Bounds(), r)
+		}
+		if !equalNRGBA(img.(*image.NRGBA), full.(*image.NRGBA).SubImage(r).(*image.NRGBA)) {
+			t.Errorf("%s: region differs from the decoded image", tt.name)
+		}
+	}
+
+	if _, err := DecodeRegion(bytes.NewReader(testHeic8), image.Rect(600, 600, 700, 700)); err == nil {
+		t.Error("region outside the image: no error")
+	}
+
+	r := image.Rect(0, 0, 100, 100)
+	if _, err := DecodeRegionWithOptions(bytes.NewReader(testHeic), r, &Options{Backend: BackendDynamic}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("libheif: err = %v, want ErrUnsupported", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := DecodeRegionWithOptionsContext(ctx, bytes.NewReader(testHeic), r, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestDecodeRegionAlpha(t *testing.T) {
+	r := image.Rect(10, 10, 300, 200)
+
+	full, err := DecodeWithOptions(bytes.NewReader(testAlpha), &Options{Backend: BackendWASM, Format: FormatNRGBA})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := DecodeRegionWithOptions(bytes.NewReader(testAlpha), r, &Options{Backend: BackendWASM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalNRGBA(img.(*image.NRGBA), full.(*image.NRGBA).SubImage(r).(*image.NRGBA)) {
+		t.Error("region differs from the decoded image")
+	}
+
+	// The alpha plane is scaled from its decoded size, not from an ispe that disagrees with it.
+	f, err := isobmff.Parse(testAlpha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ispe := f.Meta.ItemProperty(2, "ispe").(*isobmff.ImageSpatialExtents)
+
+	data := bytes.Clone(testAlpha)
+	binary.BigEndian.PutUint32(data[ispe.Offset+12:], 4294901888)
+
+	img, err = DecodeRegionWithOptions(bytes.NewReader(data), r, &Options{Backend: BackendWASM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalNRGBA(img.(*image.NRGBA), full.(*image.NRGBA).SubImage(r).(*image.NRGBA)) {
+		t.Error("region with a wrong alpha ispe differs from the decoded image")
+	}
+}
+
+func TestSourceRect(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 9, 7))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i)
+	}
+
+	props := []isobmff.Property{
+		&isobmff.CleanAperture{WidthN: 7, WidthD: 1, HeightN: 5, HeightD: 1, HorizOffsetN: 1, HorizOffsetD: 1, VertOffsetD: 1},
+		&isobmff.Rotation{Angle: 90},
+		&isobmff.Mirror{Axis: 0},
+		&isobmff.Rotation{Angle: 270},
+		&isobmff.Mirror{Axis: 1},
+	}
+	full := transform(src, props)
+	ts := transforms(props, 9, 7)
+
+	for _, r := range []image.Rectangle{image.Rect(1, 1, 4, 3), image.Rect(0, 2, 7, 5), image.Rect(5, 0, 20, 20)} {
+		dr, sr := sourceRect(ts, 9, 7, r)
+		if dr != r.Intersect(full.Bounds()) {
+			t.Fatalf("%v: clipped to %v", r, dr)
+		}
+
+		img := src.SubImage(sr).(*image.NRGBA)
+		for _, t := range ts {
+			switch t.Kind {
+			case TransformRotate:
+				img = rotate(img, t.Angle)
+			case TransformMirror:
+				img = mirror(img, uint8(t.Axis))
+			}
+		}
+
+		want := full.SubImage(dr).(*image.NRGBA)
+		got := image.NewNRGBA(dr)
+		draw.Draw(got, dr, img, img.Rect.Min, draw.Src)
+		if !equalNRGBA(got, want) {
+			t.Errorf("%v: region from %v differs", r, sr)
+		}
+	}
+}
+
+// equalNRGBA reports whether a and b have the same bounds and pixels.
+func equalNRGBA(a, b *image.NRGBA) bool {
+	if a.Rect != b.Rect {
+		return false
+	}
+
+	for y := a.Rect.Min.Y; y < a.Rect.Max.Y; y++ {
+		i, j := a.PixOffset(a.Rect.Min.X, y), b.PixOffset(b.Rect.Min.X, y)
+		if !bytes.Equal(a.Pix[i:i+4*a.Rect.Dx()], b.Pix[j:j+4*b.Rect.Dx()]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestDecodeGridConcurrency(t *testing.T) {
+	testBackends(t, func(t *testing.T, backend Backend) {
+		var want []byte
+		for _, n := range []int{1, 4} {
+			img, err := DecodeWithOptions(bytes.NewReader(testHeic), &Options{Backend: backend, Format: FormatNRGBA, Concurrency: n})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Bounds().Dx() != 1346 || img.Bounds().Dy() != 1346 {
+				t.Fatalf("concurrency %d: bounds %v", n, img.Bounds())
+			}
+
+			if want == nil {
+				want = img.(*image.NRGBA).Pix
+			} else if !bytes.Equal(img.(*image.NRGBA).Pix, want) {
+				t.Errorf("concurrency %d differs from sequential decoding", n)
+			}
+		}
+
+		var planes *image.YCbCr
+		for _, n := range []int{1, 4} {
+			img, err := DecodeWithOptions(bytes.NewReader(testHeic), &Options{Backend: backend, Format: FormatYCbCr, Concurrency: n})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ycc := img.(*image.YCbCr)
+			if planes == nil {
+				planes = ycc
+			} else if !equalYCbCr(ycc, planes) {
+				t.Errorf("YCbCr concurrency %d differs from sequential decoding", n)
+			}
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := DecodeContext(ctx, bytes.NewReader(testHeic)); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+
+	if _, err := DecodeWithOptions(bytes.NewReader(testHeic), &Options{Concurrency: -1}); err == nil {
+		t.Error("invalid concurrency: no error")
+	}
+}
+
+// equalYCbCr reports whether a and b have the same bounds and samples, ignoring any stride padding.
+func equalYCbCr(a, b *image.YCbCr) bool {
+	if a.Rect != b.Rect || a.SubsampleRatio != b.SubsampleRatio {
+		return false
+	}
+
+	for y := a.Rect.Min.Y; y < a.Rect.Max.Y; y++ {
+		for x := a.Rect.Min.X; x < a.Rect.Max.X; x++ {
+			if a.YCbCrAt(x, y) != b.YCbCrAt(x, y) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,286 @@
+package heic
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+const (
+	// sdrWhite is the luminance of SDR reference white in cd/m², per ITU-R BT.2408. Linear values of HDR
+	// images are relative to it.
+	sdrWhite = 203
+
+	// hdrPeak is the nominal peak luminance in cd/m² of HDR images: the display HLG is rendered for, and the
+	// white point of ToneMapReinhard.
+	hdrPeak = 1000
+)
+
+// SMPTE ST 2084 (PQ) constants.
+const (
+	pqM1 = 2610.0 / 16384
+	pqM2 = 2523.0 / 4096 * 128
+	pqC1 = 3424.0 / 4096
+	pqC2 = 2413.0 / 4096 * 32
+	pqC3 = 2392.0 / 4096 * 32
+)
+
+// ARIB STD-B67 (HLG) constants.
+const (
+	hlgA     = 0.17883277
+	hlgB     = 1 - 4*hlgA
+	hlgC     = 0.55991073
+	hlgGamma = 1.2 // System gamma for a hdrPeak display.
+)
+
+var (
+	// transferPQ maps PQ values to linear light relative to SDR white.
+	transferPQ = transfer{
+		func(v float64) float64 {
+			p := math.Pow(max(v, 0), 1/pqM2)
+			return math.Pow(max(p-pqC1, 0)/(pqC2-pqC3*p), 1/pqM1) * 10000 / sdrWhite
+		},
+		func(v float64) float64 {
+			y := math.Pow(max(v, 0)*sdrWhite/10000, pqM1)
+			return math.Pow((pqC1+pqC2*y)/(1+pqC3*y), pqM2)
+		},
+	}
+
+	// transferHLG maps HLG values to scene linear light in [0, 1]; hlgOOTF renders it for display.
+	transferHLG = transfer{
+		func(v float64) float64 {
+			v = max(v, 0)
+			if v <= 0.5 {
+				return v * v / 3
+			}
+			return (math.Exp((v-hlgC)/hlgA) + hlgB) / 12
+		},
+		func(e float64) float64 {
+			e = max(e, 0)
+			if e <= 1.0/12 {
+				return math.Sqrt(3 * e)
+			}
+			return hlgA*math.Log(12*e-hlgB) + hlgC
+		},
+	}
+)
+
+// hlgOOTF returns the HLG OOTF of ITU-R BT.2100 for a hdrPeak display, from scene linear RGB of colour space
+// s to display linear light relative to SDR white. It scales the colour by a power of its luminance, so
+// hues are kept.
+func hlgOOTF(s *rgbSpace) func([3]float64) [3]float64 {
+	lum := s.toPCS[1]
+
+	return func(v [3]float64) [3]float64 {
+		y := lum[0]*v[0] + lum[1]*v[1] + lum[2]*v[2]
+		if y <= 0 {
+			return [3]float64{}
+		}
+
+		k := math.Pow(y, hlgGamma-1) * hdrPeak / sdrWhite
+
+		return [3]float64{v[0] * k, v[1] * k, v[2] * k}
+	}
+}
+
+// isHDR reports whether t is the nclx code of an HDR transfer function.
+func isHDR(t uint16) bool {
+	return t == 16 || t == 18
+}
+
+// toneMap returns the operator tm on linear RGB of colour space s, or nil for none.
+func toneMap(tm ToneMapping, s *rgbSpace) func([3]float64) [3]float64 {
+	switch tm {
+	case ToneMapClip:
+		return func(v [3]float64) [3]float64 {
+			return [3]float64{min(max(v[0], 0), 1), min(max(v[1], 0), 1), min(max(v[2], 0), 1)}
+		}
+	case ToneMapReinhard:
+		lum := s.toPCS[1]
+		white := float64(hdrPeak) / sdrWhite
+
+		return func(v [3]float64) [3]float64 {
+			y := lum[0]*v[0] + lum[1]*v[1] + lum[2]*v[2]
+			if y <= 0 {
+				return v
+			}
+
+			// Extended Reinhard on luminance, so that hues are kept.
+			k := (1 + y/(white*white)) / (1 + y)
+
+			return [3]float64{v[0] * k, v[1] * k, v[2] * k}
+		}
+	}
+
+	return nil
+}
+
+// LinearImage is an in-memory image of linear-light RGBA samples, in R, G, B, A order. Colour values are
+// relative to SDR reference white, so the highlights of HDR images are above 1; alpha is not premultiplied.
+type LinearImage struct {
+	// Pix holds the image's samples. The sample of channel c of the pixel at (x, y) starts at
+	// Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*4 + c].
+	Pix []float32
+	// Stride is the Pix stride (in samples) between vertically adjacent pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+}
+
+// NewLinearImage returns a new LinearImage with the given bounds.
+func NewLinearImage(r image.Rectangle) *LinearImage {
+	return &LinearImage{Pix: make([]float32, 4*r.Dx()*r.Dy()), Stride: 4 * r.Dx(), Rect: r}
+}
+
+// ColorModel returns color.NRGBA64Model; At clips the samples to [0, 1].
+func (p *LinearImage) ColorModel() color.Model { return color.NRGBA64Model }
+
+func (p *LinearImage) Bounds() image.Rectangle { return p.Rect }
+
+func (p *LinearImage) At(x, y int) color.Color {
+	return p.NRGBA64At(x, y)
+}
+
+func (p *LinearImage) NRGBA64At(x, y int) color.NRGBA64 {
+	if !(image.Point{X: x, Y: y}.In(p.Rect)) {
+		return color.NRGBA64{}
+	}
+
+	s := p.Pix[p.PixOffset(x, y):]
+	c := func(v float32) uint16 { return uint16(min(max(v, 0), 1)*0xffff + 0.5) }
+
+	return color.NRGBA64{R: c(s[0]), G: c(s[1]), B: c(s[2]), A: c(s[3])}
+}
+
+// PixOffset returns the index of the first sample of Pix that corresponds to the pixel at (x, y).
+func (p *LinearImage) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+}
+
+// linear returns img as linear light in the target primaries, tone mapped when c has an operator.
+func (c *converter) linear(img image.Image) *LinearImage {
+	b := img.Bounds()
+	dst := NewLinearImage(b)
+
+	if src, ok := img.(*image.NRGBA); ok {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				s := src.Pix[src.PixOffset(x, y):]
+				v := c.apply([3]float64{c.in8[0][s[0]], c.in8[1][s[1]], c.in8[2][s[2]]})
+
+				d := dst.Pix[dst.PixOffset(x, y):]
+				d[0], d[1], d[2], d[3] = float32(v[0]), float32(v[1]), float32(v[2]), float32(s[3])/0xff
+			}
+		}
+
+		return dst
+	}
+
+	src, ok := img.(*image.NRGBA64)
+	if !ok {
+		src = image.NewNRGBA64(b)
+		draw.Draw(src, b, img, b.Min, draw.Src)
+	}
+
+	t := c.table16()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			i := src.PixOffset(x, y)
+			s := src.Pix[i : i+8 : i+8]
+			r, g, bl, a := uint16(s[0])<<8|uint16(s[1]), uint16(s[2])<<8|uint16(s[3]), uint16(s[4])<<8|uint16(s[5]), uint16(s[6])<<8|uint16(s[7])
+			v := c.apply([3]float64{float64(t[0][r]), float64(t[1][g]), float64(t[2][bl])})
+
+			d := dst.Pix[dst.PixOffset(x, y):]
+			d[0], d[1], d[2], d[3] = float32(v[0]), float32(v[1]), float32(v[2]), float32(a)/0xffff
+		}
+	}
+
+	return dst
+}
+
+// wasmHDR returns ErrUnsupported when c tone maps or linearises an HDR image, as the 8-bit samples of the
+// WASM decoder band visibly once PQ or HLG is linearised.
+func (c *converter) wasmHDR() error {
+	if c == nil || !c.hdr {
+		return nil
+	}
+
+	return fmt.Errorf("heic: tone mapping or linear output of an HDR image needs libheif: %w", ErrUnsupported)
+}
+
+// decodeOptions returns the options to decode with for c: HDR images are decoded at 16 bits, and converted
+// to the format of opts afterwards.
+func (c *converter) decodeOptions(opts *Options) *Options {
+	if c == nil || !c.hdr && opts.Format != FormatLinear {
+		return opts
+	}
+
+	o := *opts
+	o.BitDepth = 16
+	if o.Format == FormatYCbCr || o.Format == FormatLinear {
+		o.Format = FormatAuto
+	}
+
+	return &o
+}
+
+// output converts the colours of img, decoded with c.decodeOptions(opts), and returns it as selected by opts.
+func (c *converter) output(img image.Image, opts *Options) image.Image {
+	if c == nil {
+		return opts.convert(img)
+	}
+
+	if opts.Format == FormatLinear {
+		return c.linear(img)
+	}
+
+	img = c.convert(img)
+	if opts.BitDepth != 16 {
+		img = narrow(img)
+	}
+
+	return opts.convert(img)
+}
+
+// model returns the color model of an image of the native model once converted by c, before opts.convert.
+func (c *converter) model(native color.Model, opts *Options) color.Model {
+	if native == color.YCbCrModel {
+		native = color.NRGBAModel
+	}
+
+	if opts.BitDepth != 16 {
+		switch native {
+		case color.NRGBA64Model:
+			return color.NRGBAModel
+		case color.RGBA64Model:
+			return color.RGBAModel
+		case color.Gray16Model:
+			return color.GrayModel
+		}
+	}
+
+	return native
+}
+
+// narrow returns a 16-bit img as its 8-bit equivalent.
+func narrow(img image.Image) image.Image {
+	b := img.Bounds()
+
+	var dst draw.Image
+	switch img.ColorModel() {
+	case color.NRGBA64Model:
+		dst = image.NewNRGBA(b)
+	case color.RGBA64Model:
+		dst = image.NewRGBA(b)
+	case color.Gray16Model:
+		dst = image.NewGray(b)
+	default:
+		return img
+	}
+
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+
+	return dst
+}
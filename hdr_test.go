@@ -0,0 +1,246 @@
+package heic
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"image"
+	"math"
+	"testing"
+)
+
+//go:embed testdata/hdr_pq.heic
+var testPQ []byte
+
+//go:embed testdata/hdr_hlg.heic
+var testHLG []byte
+
+func TestTransferHDR(t *testing.T) {
+	tests := []struct {
+		name    string
+		tf      transfer
+		v, want float64
+	}{
+		{"PQ black", transferPQ, 0, 0},
+		{"PQ reference white", transferPQ, 0.5806, 1},
+		{"PQ 1000 cd/m²", transferPQ, 0.7518, 1000.0 / 203},
+		{"PQ peak", transferPQ, 1, 10000.0 / 203},
+		{"HLG black", transferHLG, 0, 0},
+		{"HLG reference white", transferHLG, 0.75, 0.2647},
+		{"HLG peak", transferHLG, 1, 1},
+	}
+
+	for _, tt := range tests {
+		got := tt.tf.toLinear(tt.v)
+		if math.Abs(got-tt.want) > 0.01*max(tt.want, 1) {
+			t.Errorf("%s: toLinear(%v) = %v, want %v", tt.name, tt.v, got, tt.want)
+		}
+		if back := tt.tf.fromLinear(got); math.Abs(back-tt.v) > 1e-6 {
+			t.Errorf("%s: fromLinear(%v) = %v, want %v", tt.name, got, back, tt.v)
+		}
+	}
+}
+
+func TestHLGOOTF(t *testing.T) {
+	ootf := hlgOOTF(targetSpace(ColorSpaceBT2020))
+
+	// Reference white and the display peak.
+	for _, tt := range []struct{ e, want float64 }{{0.2647, 1}, {1, 1000.0 / 203}} {
+		if v := ootf([3]float64{tt.e, tt.e, tt.e}); math.Abs(v[1]-tt.want) > 0.01*tt.want {
+			t.Errorf("ootf(%v) = %v, want %v", tt.e, v, tt.want)
+		}
+	}
+
+	// The OOTF scales by luminance, so the ratios between channels are kept.
+	v := ootf([3]float64{0.5, 0.25, 0})
+	if math.Abs(v[0]-2*v[1]) > 1e-9 || v[2] != 0 {
+		t.Errorf("ootf hue = %v", v)
+	}
+
+	if v := ootf([3]float64{}); v != [3]float64{} {
+		t.Errorf("ootf black = %v", v)
+	}
+}
+
+func TestToneMap(t *testing.T) {
+	srgb := targetSpace(ColorSpaceSRGB)
+
+	reinhard := toneMap(ToneMapReinhard, srgb)
+	if v := reinhard([3]float64{1000.0 / 203, 1000.0 / 203, 1000.0 / 203}); math.Abs(v[0]-1) > 1e-3 {
+		t.Errorf("white point = %v, want 1", v)
+	}
+	prev := 0.0
+	for _, y := range []float64{0.1, 0.5, 1, 2, 4} {
+		v := reinhard([3]float64{y, y / 2, 0})
+		if v[0] <= prev || v[0] >= y || math.Abs(v[1]*2-v[0]) > 1e-9 {
+			t.Errorf("reinhard(%v) = %v", y, v)
+		}
+		prev = v[0]
+	}
+
+	if v := toneMap(ToneMapClip, srgb)([3]float64{-1, 0.5, 3}); v != [3]float64{0, 0.5, 1} {
+		t.Errorf("clip = %v", v)
+	}
+
+	if toneMap(ToneMapNone, srgb) != nil {
+		t.Error("none: operator")
+	}
+}
+
+func TestDecodeHDR(t *testing.T) {
+	testBackends(t, func(t *testing.T, backend Backend) {
+		plain, err := DecodeWithOptions(bytes.NewReader(testPQ), &Options{Backend: backend, Format: FormatNRGBA})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Without tone mapping, HDR images are not converted.
+		img, err := DecodeWithOptions(bytes.NewReader(testPQ), &Options{Backend: backend, Format: FormatNRGBA, ColorSpace: ColorSpaceSRGB})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(img.(*image.NRGBA).Pix, plain.(*image.NRGBA).Pix) {
+			t.Error("HDR image converted without tone mapping")
+		}
+
+		opts := &Options{Backend: backend, ToneMapping: ToneMapReinhard}
+
+		// The WASM decoder only outputs 8 bits, which band once PQ or HLG is linearised.
+		if backend == BackendWASM {
+			for _, o := range []*Options{opts, {Backend: backend, Format: FormatLinear}} {
+				if _, err := DecodeWithOptions(bytes.NewReader(testHLG), o); !errors.Is(err, ErrUnsupported) {
+					t.Errorf("format=%d: err = %v, want ErrUnsupported", o.Format, err)
+				}
+				if _, err := DecodeConfigWithOptions(bytes.NewReader(testPQ), o); !errors.Is(err, ErrUnsupported) {
+					t.Errorf("format=%d: config err = %v, want ErrUnsupported", o.Format, err)
+				}
+				if _, err := DecodeAllWithOptions(bytes.NewReader(testPQ), o); !errors.Is(err, ErrUnsupported) {
+					t.Errorf("format=%d: DecodeAll err = %v, want ErrUnsupported", o.Format, err)
+				}
+			}
+			return
+		}
+
+		img, err = DecodeWithOptions(bytes.NewReader(testPQ), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cfg, err := DecodeConfigWithOptions(bytes.NewReader(testPQ), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.ColorModel != img.ColorModel() {
+			t.Errorf("config color model %v, image %v", cfg.ColorModel, img.ColorModel())
+		}
+
+		nrgba, ok := img.(*image.NRGBA)
+		if !ok {
+			t.Fatalf("tone mapped image is %T", img)
+		}
+		if bytes.Equal(nrgba.Pix, plain.(*image.NRGBA).Pix) {
+			t.Error("colours not tone mapped")
+		}
+
+		h, err := DecodeAllWithOptions(bytes.NewReader(testPQ), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(h.Image[0].(*image.NRGBA).Pix, nrgba.Pix) {
+			t.Error("DecodeAll differs from Decode")
+		}
+
+		img, err = DecodeWithOptions(bytes.NewReader(testPQ), &Options{Backend: backend, ToneMapping: ToneMapReinhard, BitDepth: 16})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := img.(*image.NRGBA64); !ok {
+			t.Errorf("16-bit tone mapped image is %T", img)
+		}
+
+		// Linear output keeps the highlights, unless clipped.
+		for _, tm := range []ToneMapping{ToneMapNone, ToneMapClip} {
+			img, err = DecodeWithOptions(bytes.NewReader(testPQ), &Options{Backend: backend, Format: FormatLinear, ToneMapping: tm})
+			if err != nil {
+				t.Fatal(err)
+			}
+			lin, ok := img.(*LinearImage)
+			if !ok {
+				t.Fatalf("linear image is %T", img)
+			}
+			if lin.Rect != plain.Bounds() {
+				t.Errorf("bounds %v, want %v", lin.Rect, plain.Bounds())
+			}
+
+			var peak float32
+			for i, v := range lin.Pix {
+				if i%4 != 3 {
+					peak = max(peak, v)
+				}
+			}
+			if tm == ToneMapNone && peak <= 1 || tm == ToneMapClip && peak > 1 {
+				t.Errorf("tone mapping %d: peak %v", tm, peak)
+			}
+		}
+
+		// The fixtures hold patches of black, reference white, 1000 cd/m² and a red at reference white
+		// luminance (HLG) or level (PQ) in the top half. The HLG OOTF scales the red by its luminance, so the
+		// red channel is at 1; on each channel it would be at 1.3.
+		for _, tt := range []struct {
+			name string
+			data []byte
+		}{
+			{"PQ", testPQ},
+			{"HLG", testHLG},
+		} {
+			img, err := DecodeWithOptions(bytes.NewReader(tt.data), &Options{Backend: backend, Format: FormatLinear})
+			if err != nil {
+				t.Fatal(err)
+			}
+			lin := img.(*LinearImage)
+
+			for _, p := range []struct {
+				x    int
+				want [3]float32
+			}{
+				{16, [3]float32{0, 0, 0}},
+				{48, [3]float32{1, 1, 1}},
+				{80, [3]float32{1000.0 / 203, 1000.0 / 203, 1000.0 / 203}},
+				{112, [3]float32{1, 0, 0}},
+			} {
+				i := lin.PixOffset(p.x, 16)
+				for c, w := range p.want {
+					if v := lin.Pix[i+c]; math.Abs(float64(v-w)) > 0.05*max(float64(w), 1) {
+						t.Errorf("%s: pixel %d,16 = %v, want %v", tt.name, p.x, lin.Pix[i:i+3], p.want)
+						break
+					}
+				}
+			}
+		}
+
+		img, err = DecodeWithOptions(bytes.NewReader(testHLG), &Options{Backend: backend, Format: FormatNRGBA, ToneMapping: ToneMapClip})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(img.(*image.NRGBA).Pix, plain.(*image.NRGBA).Pix) {
+			t.Error("HLG colours not tone mapped")
+		}
+	})
+
+	if _, err := DecodeWithOptions(bytes.NewReader(testPQ), &Options{ToneMapping: 100}); err == nil {
+		t.Error("invalid tone mapping: no error")
+	}
+}
+
+func TestLinearImage(t *testing.T) {
+	img := NewLinearImage(image.Rect(1, 1, 3, 2))
+	copy(img.Pix[4:], []float32{2, 0.5, -1, 1})
+
+	c := img.NRGBA64At(2, 1)
+	if c.R != 0xffff || c.G != 0x8000 || c.B != 0 || c.A != 0xffff {
+		t.Errorf("At = %v", c)
+	}
+	if c := img.NRGBA64At(0, 0); c.A != 0 {
+		t.Errorf("out of bounds = %v", c)
+	}
+}
@@ -7,6 +7,7 @@ package heic
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"image"
@@ -23,27 +24,114 @@ var (
 
 // Decode reads a HEIC image from r; for an image sequence it returns the first frame.
 func Decode(r io.Reader) (image.Image, error) {
-	data, err := io.ReadAll(r)
+	return decodeImage(context.Background(), r, nil)
+}
+
+// DecodeContext is like Decode, but aborts the decoding and returns ctx.Err() when ctx is done. The WASM
+// decoder stops at once, also while its module is compiled on first use; libheif and the wasm2go build cannot
+// be interrupted while they decode an image, so they return when it is done.
+func DecodeContext(ctx context.Context, r io.Reader) (image.Image, error) {
+	return decodeImage(ctx, r, nil)
+}
+
+// DecodeWithOptions reads a HEIC image from r using opts; for an image sequence it returns the first frame.
+func DecodeWithOptions(r io.Reader, opts *Options) (image.Image, error) {
+	return decodeImage(context.Background(), r, opts)
+}
+
+// DecodeWithOptionsContext is like DecodeWithOptions, but aborts the decoding and returns ctx.Err() when ctx is
+// done, as DecodeContext does.
+func DecodeWithOptionsContext(ctx context.Context, r io.Reader, opts *Options) (image.Image, error) {
+	return decodeImage(ctx, r, opts)
+}
+
+func decodeImage(ctx context.Context, r io.Reader, opts *Options) (image.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if opts == nil {
+		opts = defaultOptions
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	useDynamic, err := opts.useDynamic()
 	if err != nil {
-		return nil, fmt.Errorf("heic: read: %w", err)
+		return nil, err
 	}
 
-	if _, ok := parseSequence(data); ok {
-		h, err := DecodeAll(bytes.NewReader(data))
-		if err != nil {
+	data, err := opts.readInput(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.ItemID == 0 {
+		if _, ok := parseSequence(data); ok {
+			h, err := decodeAll(ctx, bytes.NewReader(data), opts)
+			if err != nil {
+				return nil, err
+			}
+
+			return h.Image[0], nil
+		}
+	}
+
+	conv := colorConverter(data, false, opts)
+	dopts := conv.decodeOptions(opts)
+
+	var img image.Image
+	switch {
+	case useDynamic:
+		img, _, err = decodeDynamic(ctx, bytes.NewReader(data), false, dopts)
+		if itemFallback(dopts, err) {
+			if err = wasmOptions(data, opts, conv); err == nil {
+				img, _, err = decodeItem(ctx, data, false, dopts)
+			}
+		}
+	default:
+		if err = wasmOptions(data, opts, conv); err == nil {
+			img, err = decodeWasmImage(ctx, data, dopts)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return conv.output(img, opts), nil
+}
+
+// decodeWasmImage decodes the image item opts.ItemID, or the primary image, of data with the WASM decoder.
+// With FormatYCbCr it returns the decoded planes when it can, and otherwise converts from RGBA to the coded
+// chroma subsampling.
+func decodeWasmImage(ctx context.Context, data []byte, opts *Options) (image.Image, error) {
+	if opts.Format == FormatYCbCr {
+		img, err := decodeYCbCr(ctx, data, opts)
+		if err == nil {
+			return img, nil
+		}
+		if !errors.Is(err, errNoPlanes) {
 			return nil, err
 		}
+	}
 
-		return h.Image[0], nil
+	var img image.Image
+	var err error
+	if opts.ItemID != 0 {
+		img, _, err = decodeItem(ctx, data, false, opts)
+	} else {
+		img, _, err = decodeWasm(ctx, data, false, opts)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	if dynamic && !ForceWasmMode {
-		img, _, err := decodeDynamic(bytes.NewReader(data), false)
-		return img, err
+	if opts.Format == FormatYCbCr {
+		return toYCbCr(img, codedRatio(data, opts.ItemID)), nil
 	}
 
-	img, _, err := decode(bytes.NewReader(data), false)
-	return img, err
+	return img, nil
 }
 
 // HEIC holds the decoded frames of a HEIC image sequence and their per-frame delays in seconds.
@@ -54,44 +142,180 @@ type HEIC struct {
 
 // DecodeAll reads a HEIC image sequence from r and returns all frames; a still image yields one frame.
 func DecodeAll(r io.Reader) (*HEIC, error) {
-	if dynamic && !ForceWasmMode {
-		return decodeDynamicAll(r)
+	return decodeAll(context.Background(), r, nil)
+}
+
+// DecodeAllContext is like DecodeAll, but aborts the decoding and returns ctx.Err() when ctx is done.
+func DecodeAllContext(ctx context.Context, r io.Reader) (*HEIC, error) {
+	return decodeAll(ctx, r, nil)
+}
+
+// DecodeAllWithOptions reads a HEIC image sequence from r using opts and returns all frames.
+func DecodeAllWithOptions(r io.Reader, opts *Options) (*HEIC, error) {
+	return decodeAll(context.Background(), r, opts)
+}
+
+// DecodeAllWithOptionsContext is like DecodeAllWithOptions, but aborts the decoding and returns ctx.Err() when
+// ctx is done, as DecodeContext does.
+func DecodeAllWithOptionsContext(ctx context.Context, r io.Reader, opts *Options) (*HEIC, error) {
+	return decodeAll(ctx, r, opts)
+}
+
+func decodeAll(ctx context.Context, r io.Reader, opts *Options) (*HEIC, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if opts == nil {
+		opts = defaultOptions
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	useDynamic, err := opts.useDynamic()
+	if err != nil {
+		return nil, err
 	}
 
-	return decodeWasmAll(r)
+	data, err := opts.readInput(r)
+	if err != nil {
+		return nil, err
+	}
+
+	_, sequence := parseSequence(data)
+
+	conv := colorConverter(data, sequence, opts)
+	dopts := conv.decodeOptions(opts)
+
+	var h *HEIC
+	if useDynamic {
+		h, err = decodeDynamicAll(ctx, bytes.NewReader(data), dopts)
+	} else if err = wasmOptions(data, opts, conv); err == nil {
+		h, err = decodeWasmAll(ctx, bytes.NewReader(data), dopts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, img := range h.Image {
+		h.Image[i] = conv.output(img, opts)
+	}
+
+	return h, nil
 }
 
 // DecodeConfig returns the color model and dimensions of a HEIC image without decoding the entire image.
 func DecodeConfig(r io.Reader) (image.Config, error) {
+	return decodeConfig(context.Background(), r, nil)
+}
+
+// DecodeConfigContext is like DecodeConfig, but returns ctx.Err() when ctx is done before the configuration is
+// read, as DecodeContext does.
+func DecodeConfigContext(ctx context.Context, r io.Reader) (image.Config, error) {
+	return decodeConfig(ctx, r, nil)
+}
+
+// DecodeConfigWithOptions returns the color model and dimensions of a HEIC image as it would be decoded with opts.
+func DecodeConfigWithOptions(r io.Reader, opts *Options) (image.Config, error) {
+	return decodeConfig(context.Background(), r, opts)
+}
+
+// DecodeConfigWithOptionsContext is like DecodeConfigWithOptions, but returns ctx.Err() when ctx is done, as
+// DecodeConfigContext does.
+func DecodeConfigWithOptionsContext(ctx context.Context, r io.Reader, opts *Options) (image.Config, error) {
+	return decodeConfig(ctx, r, opts)
+}
+
+func decodeConfig(ctx context.Context, r io.Reader, opts *Options) (image.Config, error) {
+	if err := ctx.Err(); err != nil {
+		return image.Config{}, err
+	}
+
+	if opts == nil {
+		opts = defaultOptions
+	}
+	if err := opts.validate(); err != nil {
+		return image.Config{}, err
+	}
+
+	useDynamic, err := opts.useDynamic()
+	if err != nil {
+		return image.Config{}, err
+	}
+
 	data, err := io.ReadAll(io.LimitReader(r, heifMaxHeaderSize))
 	if err != nil {
 		return image.Config{}, fmt.Errorf("heic: read: %w", err)
 	}
 
-	if info, ok := parseSequence(data); ok {
-		return image.Config{ColorModel: color.NRGBAModel, Width: info.width, Height: info.height}, nil
+	if info, ok := parseSequence(data); ok && opts.ItemID == 0 {
+		if err := opts.checkSequence(info); err != nil {
+			return image.Config{}, err
+		}
+
+		return image.Config{ColorModel: opts.colorModel(color.NRGBAModel), Width: info.width, Height: info.height}, nil
 	}
 
+	conv := colorConverter(data, false, opts)
+	dopts := conv.decodeOptions(opts)
+
 	var cfg image.Config
-	if dynamic && !ForceWasmMode {
-		_, cfg, err = decodeDynamic(bytes.NewReader(data), true)
-	} else {
-		_, cfg, err = decode(bytes.NewReader(data), true)
+	switch {
+	case useDynamic:
+		_, cfg, err = decodeDynamic(ctx, bytes.NewReader(data), true, dopts)
+		if itemFallback(dopts, err) {
+			if err = wasmOptions(data, opts, conv); err == nil {
+				_, cfg, err = decodeItem(ctx, data, true, dopts)
+			}
+		}
+	case opts.ItemID != 0:
+		if err = wasmOptions(data, opts, conv); err == nil {
+			_, cfg, err = decodeItem(ctx, data, true, dopts)
+		}
+	default:
+		if err = wasmOptions(data, opts, conv); err == nil {
+			_, cfg, err = decodeWasm(ctx, data, true, dopts)
+		}
 	}
 	if err != nil {
 		return image.Config{}, err
 	}
 
+	if conv != nil {
+		cfg.ColorModel = conv.model(cfg.ColorModel, opts)
+	}
+
+	cfg.ColorModel = opts.colorModel(cfg.ColorModel)
+
 	return cfg, nil
 }
 
+// wasmOptions returns ErrUnsupported for the options the WASM decoder cannot honour for the image in data with
+// the colour conversion conv, as it only outputs 8-bit samples.
+func wasmOptions(data []byte, opts *Options, conv *converter) error {
+	if err := wasmBitDepth(data, opts); err != nil {
+		return err
+	}
+
+	return conv.wasmHDR()
+}
+
+// itemFallback reports whether a libheif failure to decode Options.ItemID should be retried with the WASM
+// decoder, since older libheif versions only decode top-level images, thumbnails and auxiliary images.
+func itemFallback(opts *Options, err error) bool {
+	var e *DecodeError
+
+	return opts.ItemID != 0 && opts.Backend == BackendAuto && errors.As(err, &e) && e.Backend == "libheif" && e.Unsupported()
+}
+
 // ForceWasmMode, if true, forces using the WASM-based decoder even if a
 // dynamic/shared library is available.
 //
 // This exists mainly for testing purposes.
 //
 // It is not safe to change this concurrently with any other use of this
-// package.
+// package; use Options.Backend to select the backend per call.
 var ForceWasmMode bool
 
 // Dynamic returns error (if there was any) during opening dynamic/shared library.
@@ -118,12 +342,13 @@ const (
 	heifChannelAlpha       = 6
 	heifChannelInterleaved = 10
 
-	heifChromaUndefined       = 99
-	heifChromaMonochrome      = 0
-	heifChroma420             = 1
-	heifChroma422             = 2
-	heifChroma444             = 3
-	heifChromaInterleavedRGBA = 11
+	heifChromaUndefined             = 99
+	heifChromaMonochrome            = 0
+	heifChroma420                   = 1
+	heifChroma422                   = 2
+	heifChroma444                   = 3
+	heifChromaInterleavedRGBA       = 11
+	heifChromaInterleavedRRGGBBAABE = 13
 
 	heifFiletypeYesSupported = 1
 )
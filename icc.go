@@ -0,0 +1,156 @@
+package heic
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// errICC is returned for ICC profiles that are not RGB matrix/TRC profiles.
+var errICC = errors.New("heic: unsupported ICC profile")
+
+// parseICC returns the colour space of an RGB matrix/TRC ICC profile: its colorants, which are relative to
+// the D50 profile connection space, and its tone curves. Profiles with only lookup tables are not supported.
+func parseICC(b []byte) (*rgbSpace, error) {
+	if len(b) < 132 || string(b[36:40]) != "acsp" || string(b[16:20]) != "RGB " || string(b[20:24]) != "XYZ " {
+		return nil, errICC
+	}
+
+	tags := make(map[string][]byte)
+
+	n := int(binary.BigEndian.Uint32(b[128:]))
+	for i := 0; i < n; i++ {
+		e := 132 + 12*i
+		if e+12 > len(b) {
+			return nil, errICC
+		}
+
+		off := uint64(binary.BigEndian.Uint32(b[e+4:]))
+		size := uint64(binary.BigEndian.Uint32(b[e+8:]))
+		if off+size > uint64(len(b)) {
+			return nil, errICC
+		}
+
+		tags[string(b[e:e+4])] = b[off : off+size]
+	}
+
+	s := &rgbSpace{}
+
+	for i, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		xyz, ok := iccXYZ(tags[sig])
+		if !ok {
+			return nil, errICC
+		}
+		for j := range 3 {
+			s.toPCS[j][i] = xyz[j]
+		}
+	}
+
+	for i, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		curve, ok := iccCurve(tags[sig])
+		if !ok {
+			return nil, errICC
+		}
+		s.toLinear[i] = curve
+	}
+
+	return s, nil
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// iccXYZ decodes an XYZType tag.
+func iccXYZ(b []byte) ([3]float64, bool) {
+	if len(b) < 20 || string(b[:4]) != "XYZ " {
+		return [3]float64{}, false
+	}
+
+	return [3]float64{s15Fixed16(b[8:]), s15Fixed16(b[12:]), s15Fixed16(b[16:])}, true
+}
+
+// iccCurve decodes a curveType or parametricCurveType tag to a function from encoded to linear values.
+func iccCurve(b []byte) (func(float64) float64, bool) {
+	if len(b) < 12 {
+		return nil, false
+	}
+
+	switch string(b[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(b[8:]))
+		if len(b) < 12+2*n {
+			return nil, false
+		}
+
+		switch n {
+		case 0:
+			return func(v float64) float64 { return v }, true
+		case 1:
+			g := float64(binary.BigEndian.Uint16(b[12:])) / 256
+			return func(v float64) float64 { return math.Pow(v, g) }, true
+		}
+
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(b[12+2*i:])) / 65535
+		}
+
+		return func(v float64) float64 {
+			x := min(max(v, 0), 1) * float64(n-1)
+			i := min(int(x), n-2)
+			return table[i] + (table[i+1]-table[i])*(x-float64(i))
+		}, true
+
+	case "para":
+		counts := [...]int{1, 3, 4, 5, 7}
+
+		kind := int(binary.BigEndian.Uint16(b[8:]))
+		if kind >= len(counts) || len(b) < 12+4*counts[kind] {
+			return nil, false
+		}
+
+		var p [7]float64
+		for i := range counts[kind] {
+			p[i] = s15Fixed16(b[12+4*i:])
+		}
+		g, a, bb, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+
+		pow := func(v float64) float64 { return math.Pow(max(v, 0), g) }
+
+		switch kind {
+		case 0:
+			return pow, true
+		case 1:
+			return func(v float64) float64 {
+				if v >= -bb/a {
+					return pow(a*v + bb)
+				}
+				return 0
+			}, true
+		case 2:
+			return func(v float64) float64 {
+				if v >= -bb/a {
+					return pow(a*v+bb) + c
+				}
+				return c
+			}, true
+		case 3:
+			return func(v float64) float64 {
+				if v >= d {
+					return pow(a*v + bb)
+				}
+				return c * v
+			}, true
+		default:
+			return func(v float64) float64 {
+				if v >= d {
+					return pow(a*v+bb) + e
+				}
+				return c*v + f
+			}, true
+		}
+	}
+
+	return nil, false
+}
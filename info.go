@@ -0,0 +1,100 @@
+package heic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+// Info describes a HEIC image as DecodeConfig does, together with properties of the coded image.
+type Info struct {
+	image.Config
+
+	BitDepth int // Bits per luma sample as coded, e.g. 8, 10 or 12; 0 when unknown.
+
+	Alpha         bool // The image has an alpha plane (an auxl item, or an auxv track for a sequence).
+	Premultiplied bool // The colour samples are premultiplied by alpha (a prem reference); decoded as *image.RGBA.
+
+	// Transforms lists the clap, irot and imir properties of the image in the order they apply to the coded
+	// image, e.g. to rebuild the display geometry of an image decoded with IgnoreTransformations.
+	Transforms []Transform
+}
+
+// DecodeInfo returns the configuration of a HEIC image and its coded properties without decoding the image.
+func DecodeInfo(r io.Reader) (Info, error) {
+	return decodeInfo(context.Background(), r, nil)
+}
+
+// DecodeInfoWithOptions is like DecodeInfo for the image as it would be decoded with opts.
+func DecodeInfoWithOptions(r io.Reader, opts *Options) (Info, error) {
+	return decodeInfo(context.Background(), r, opts)
+}
+
+// DecodeInfoWithOptionsContext is like DecodeInfoWithOptions, but returns ctx.Err() when ctx is done, as
+// DecodeConfigContext does.
+func DecodeInfoWithOptionsContext(ctx context.Context, r io.Reader, opts *Options) (Info, error) {
+	return decodeInfo(ctx, r, opts)
+}
+
+func decodeInfo(ctx context.Context, r io.Reader, opts *Options) (Info, error) {
+	if opts == nil {
+		opts = defaultOptions
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, heifMaxHeaderSize))
+	if err != nil {
+		return Info{}, fmt.Errorf("heic: read: %w", err)
+	}
+
+	cfg, err := decodeConfig(ctx, bytes.NewReader(data), opts)
+	if err != nil {
+		return Info{}, err
+	}
+
+	info := Info{Config: cfg}
+
+	if f, err := isobmff.Parse(data); err == nil {
+		info.BitDepth = fileBitDepth(f, opts.ItemID)
+		info.Alpha, info.Premultiplied = fileAlpha(f, opts.ItemID)
+		info.Transforms = fileTransforms(f, opts.ItemID)
+	}
+
+	return info, nil
+}
+
+// fileTransforms returns the transformation properties of item id (the primary image when 0) of f; a
+// sequence has none.
+func fileTransforms(f *isobmff.File, id uint32) []Transform {
+	if id == 0 && f.Movie != nil && f.Movie.Track("pict") != nil || f.Meta == nil {
+		return nil
+	}
+	if id == 0 {
+		id = f.Meta.PrimaryItemID()
+	}
+
+	w, h := codedSize(f.Meta, id)
+
+	return transforms(f.Meta.ItemProperties(id), w, h)
+}
+
+// fileBitDepth returns the coded luma bit depth of item id (the primary image, or the sequence when 0) of f, or 0.
+func fileBitDepth(f *isobmff.File, id uint32) int {
+	if id == 0 && f.Movie != nil {
+		if pict := f.Movie.Track("pict"); pict != nil && pict.SampleEntry != nil && pict.SampleEntry.HEVCConfig != nil {
+			return pict.SampleEntry.HEVCConfig.BitDepthLuma
+		}
+	}
+
+	if f.Meta == nil {
+		return 0
+	}
+	if id == 0 {
+		id = f.Meta.PrimaryItemID()
+	}
+
+	return bitDepth(f.Meta, id)
+}
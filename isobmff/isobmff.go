@@ -0,0 +1,343 @@
+// Package isobmff parses the ISO base media file format (ISO/IEC 14496-12) boxes used by HEIF files
+// (ISO/IEC 23008-12) into a typed tree, without decoding any image data.
+package isobmff
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Errors .
+var (
+	ErrInvalid   = errors.New("isobmff: invalid box")
+	ErrTruncated = errors.New("isobmff: truncated box")
+)
+
+// Box is the header of a box and its position in the file.
+type Box struct {
+	Type       string // Four-character code.
+	Offset     int64  // Absolute offset of the box header.
+	Size       int64  // Size including the header.
+	HeaderSize int    // Size of the header: 8, or 16 with a 64-bit size.
+}
+
+// Header returns the box header; it lets typed boxes satisfy Property.
+func (b Box) Header() Box {
+	return b
+}
+
+// End returns the absolute offset just past the box.
+func (b Box) End() int64 {
+	return b.Offset + b.Size
+}
+
+// FullBox is a box header followed by a version and flags.
+type FullBox struct {
+	Box
+	Version uint8
+	Flags   uint32
+}
+
+// File is the parsed box tree of a HEIF file.
+type File struct {
+	Boxes    []Box     // Top-level boxes in file order, including mdat and unknown boxes.
+	FileType *FileType // ftyp
+	Meta     *Meta     // meta, nil for a file without items.
+	Movie    *Movie    // moov, nil for a file without tracks.
+}
+
+// FileType is the ftyp box.
+type FileType struct {
+	Box
+	MajorBrand       string
+	MinorVersion     uint32
+	CompatibleBrands []string
+}
+
+// HasBrand reports whether brand is the major or one of the compatible brands.
+func (f *FileType) HasBrand(brand string) bool {
+	if f.MajorBrand == brand {
+		return true
+	}
+
+	for _, b := range f.CompatibleBrands {
+		if b == brand {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Parse parses the top-level boxes of data and the ftyp, meta and moov trees.
+//
+// A trailing box other than ftyp, meta or moov may extend past the end of data, so a file prefix
+// holding only the headers parses as well. Parsing stops at an invalid box header after the first box,
+// such as trailing garbage, since the boxes after it cannot be located.
+func Parse(data []byte) (*File, error) {
+	f := &File{}
+
+	off := 0
+	for off < len(data) {
+		b, hdr, err := readHeader(data[off:], int64(off))
+		if err != nil {
+			if len(f.Boxes) > 0 {
+				break
+			}
+			return nil, err
+		}
+
+		end := off + int(b.Size)
+		if b.Size > int64(len(data)-off) {
+			switch b.Type {
+			case "ftyp", "meta", "moov":
+				return nil, fmt.Errorf("%w: %s", ErrTruncated, b.Type)
+			}
+			end = len(data)
+		}
+
+		payload := data[off+hdr : end]
+
+		switch b.Type {
+		case "ftyp":
+			f.FileType, err = parseFileType(b, payload)
+		case "meta":
+			f.Meta, err = parseMeta(b, payload)
+		case "moov":
+			f.Movie, err = parseMovie(b, payload)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		f.Boxes = append(f.Boxes, b)
+		off = end
+	}
+
+	return f, nil
+}
+
+// ParseMeta parses a complete meta box, including its header, that starts at offset in the file.
+func ParseMeta(data []byte, offset int64) (*Meta, error) {
+	b, hdr, err := readHeader(data, offset)
+	if err != nil {
+		return nil, err
+	}
+	if b.Type != "meta" {
+		return nil, fmt.Errorf("%w: %s is not meta", ErrInvalid, b.Type)
+	}
+	if b.Size > int64(len(data)) {
+		return nil, fmt.Errorf("%w: meta", ErrTruncated)
+	}
+
+	return parseMeta(b, data[hdr:b.Size])
+}
+
+// readHeader reads the box header at the start of b, located at offset in the file.
+// A size of zero extends the box to the end of b.
+func readHeader(b []byte, offset int64) (Box, int, error) {
+	if len(b) < 8 {
+		return Box{}, 0, fmt.Errorf("%w: header", ErrTruncated)
+	}
+
+	box := Box{
+		Type:       string(b[4:8]),
+		Offset:     offset,
+		Size:       int64(binary.BigEndian.Uint32(b[0:4])),
+		HeaderSize: 8,
+	}
+
+	switch box.Size {
+	case 0:
+		box.Size = int64(len(b))
+	case 1:
+		if len(b) < 16 {
+			return Box{}, 0, fmt.Errorf("%w: %s header", ErrTruncated, box.Type)
+		}
+		size := binary.BigEndian.Uint64(b[8:16])
+		if size > 1<<62 {
+			return Box{}, 0, fmt.Errorf("%w: %s size %d", ErrInvalid, box.Type, size)
+		}
+		box.Size = int64(size)
+		box.HeaderSize = 16
+	}
+
+	if box.Size < int64(box.HeaderSize) {
+		return Box{}, 0, fmt.Errorf("%w: %s size %d", ErrInvalid, box.Type, box.Size)
+	}
+
+	return box, box.HeaderSize, nil
+}
+
+// children calls fn for each child box in b, the payload of a box whose children start at offset.
+func children(b []byte, offset int64, fn func(box Box, payload []byte) error) error {
+	off := 0
+	for off < len(b) {
+		box, hdr, err := readHeader(b[off:], offset+int64(off))
+		if err != nil {
+			return err
+		}
+		if box.Size > int64(len(b)-off) {
+			return fmt.Errorf("%w: %s", ErrTruncated, box.Type)
+		}
+
+		if err := fn(box, b[off+hdr:off+int(box.Size)]); err != nil {
+			return err
+		}
+
+		off += int(box.Size)
+	}
+
+	return nil
+}
+
+// payloadOffset returns the absolute offset of the payload of box.
+func payloadOffset(box Box) int64 {
+	return box.Offset + int64(box.HeaderSize)
+}
+
+func parseFileType(box Box, p []byte) (*FileType, error) {
+	r := &reader{b: p, box: box.Type}
+
+	f := &FileType{
+		Box:          box,
+		MajorBrand:   r.fourCC(),
+		MinorVersion: r.u32(),
+	}
+	for r.err == nil && r.len() >= 4 {
+		f.CompatibleBrands = append(f.CompatibleBrands, r.fourCC())
+	}
+
+	return f, r.err
+}
+
+// readFullBox reads the version and flags at the start of p.
+func readFullBox(box Box, r *reader) FullBox {
+	v := r.u32()
+
+	return FullBox{Box: box, Version: uint8(v >> 24), Flags: v & 0xffffff}
+}
+
+// reader reads big-endian fields from a box payload, recording the first out-of-bounds read.
+type reader struct {
+	b   []byte
+	off int
+	box string
+	err error
+}
+
+func (r *reader) len() int {
+	return len(r.b) - r.off
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || n > r.len() {
+		if r.err == nil {
+			r.err = fmt.Errorf("%w: %s", ErrTruncated, r.box)
+		}
+		return nil
+	}
+
+	b := r.b[r.off : r.off+n : r.off+n]
+	r.off += n
+
+	return b
+}
+
+func (r *reader) u8() uint8 {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+
+	return b[0]
+}
+
+func (r *reader) u16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *reader) u32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *reader) u64() uint64 {
+	b := r.bytes(8)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(b)
+}
+
+// uint reads an unsigned integer of size 0, 1, 2, 4 or 8 bytes.
+func (r *reader) uint(size int) uint64 {
+	switch size {
+	case 0:
+		return 0
+	case 1:
+		return uint64(r.u8())
+	case 2:
+		return uint64(r.u16())
+	case 4:
+		return uint64(r.u32())
+	case 8:
+		return r.u64()
+	}
+
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: %s field size %d", ErrInvalid, r.box, size)
+	}
+
+	return 0
+}
+
+func (r *reader) fourCC() string {
+	return string(r.bytes(4))
+}
+
+// string reads a NUL-terminated string; a missing terminator ends the string at the end of the payload.
+func (r *reader) string() string {
+	if r.err != nil {
+		return ""
+	}
+
+	start := r.off
+	for r.off < len(r.b) && r.b[r.off] != 0 {
+		r.off++
+	}
+	s := string(r.b[start:r.off])
+	if r.off < len(r.b) {
+		r.off++
+	}
+
+	return s
+}
+
+// rest returns the remaining payload.
+func (r *reader) rest() []byte {
+	return r.bytes(r.len())
+}
+
+// capacity returns n capped at limit, for preallocating from untrusted counts.
+func capacity(n uint64, limit int) int {
+	if limit < 0 {
+		return 0
+	}
+	if n > uint64(limit) {
+		return limit
+	}
+
+	return int(n)
+}
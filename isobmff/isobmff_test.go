@@ -0,0 +1,297 @@
+package isobmff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+)
+
+func readFile(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile("../testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestParseGrid(t *testing.T) {
+	data := readFile(t, "test.heic")
+
+	f, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.FileType == nil || f.FileType.MajorBrand != "heic" || !f.FileType.HasBrand("mif1") {
+		t.Fatalf("ftyp = %+v", f.FileType)
+	}
+	if f.Meta == nil || f.Movie != nil {
+		t.Fatal("want meta and no moov")
+	}
+
+	last := f.Boxes[len(f.Boxes)-1]
+	if last.Type != "mdat" || last.End() != int64(len(data)) {
+		t.Errorf("last box = %+v, file size %d", last, len(data))
+	}
+
+	m := f.Meta
+	if m.Handler.HandlerType != "pict" {
+		t.Errorf("handler = %q", m.Handler.HandlerType)
+	}
+	if m.PrimaryItemID() != 10 {
+		t.Errorf("primary = %d, want 10", m.PrimaryItemID())
+	}
+
+	grid := m.Item(10)
+	if grid == nil || grid.ItemType != "grid" || grid.Hidden() {
+		t.Fatalf("item 10 = %+v", grid)
+	}
+	if tiles := m.References(10, "dimg"); len(tiles) != 9 || tiles[0] != 1 || tiles[8] != 9 {
+		t.Errorf("dimg = %v", tiles)
+	}
+	if by := m.ReferencedBy(10, "cdsc"); len(by) != 1 || by[0] != 11 {
+		t.Errorf("cdsc to 10 = %v", by)
+	}
+
+	ispe, ok := m.ItemProperty(10, "ispe").(*ImageSpatialExtents)
+	if !ok || ispe.Width != 1346 || ispe.Height != 1346 {
+		t.Errorf("grid ispe = %+v", ispe)
+	}
+	if rot, ok := m.ItemProperty(10, "irot").(*Rotation); !ok || rot.Angle != 0 {
+		t.Errorf("grid irot = %+v", rot)
+	}
+	if colr, ok := m.ItemProperty(10, "colr").(*ColourInformation); !ok || colr.ColourType != ColourTypeNCLX {
+		t.Errorf("grid colr = %+v", colr)
+	}
+
+	tile := m.Item(1)
+	if tile == nil || tile.ItemType != "hvc1" || !tile.Hidden() {
+		t.Fatalf("item 1 = %+v", tile)
+	}
+	hvcC, ok := m.ItemProperty(1, "hvcC").(*HEVCConfig)
+	if !ok {
+		t.Fatal("tile has no hvcC")
+	}
+	if hvcC.NALLengthSize != 4 || hvcC.BitDepthLuma != 8 || hvcC.ChromaFormat != 1 || len(hvcC.ParameterSets()) != 3 {
+		t.Errorf("hvcC = %+v", hvcC)
+	}
+
+	// The grid descriptor is stored in idat or mdat; either way ReadItem resolves it.
+	desc, err := m.ReadItem(bytes.NewReader(data), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(desc) < 8 || desc[2] != 2 || desc[3] != 2 {
+		t.Errorf("grid descriptor = %x, want 3x3", desc)
+	}
+
+	for _, b := range m.Children {
+		if b.Offset < f.Boxes[1].Offset || b.End() > f.Boxes[1].End() {
+			t.Errorf("child %s at %d+%d outside meta", b.Type, b.Offset, b.Size)
+		}
+	}
+}
+
+func TestParseItems(t *testing.T) {
+	data := readFile(t, "test8.heic")
+
+	f, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := f.Meta
+
+	if exif := m.ItemsOfType("Exif"); len(exif) != 1 || exif[0].ItemID != 2 {
+		t.Fatalf("Exif items = %v", exif)
+	}
+
+	xmp := m.Item(3)
+	if xmp == nil || xmp.ItemType != "mime" || xmp.ContentType != "application/rdf+xml" {
+		t.Fatalf("item 3 = %+v", xmp)
+	}
+
+	b, err := m.ReadItem(bytes.NewReader(data), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte("x:xmpmeta")) {
+		t.Errorf("XMP item = %q", b)
+	}
+
+	pixi, ok := m.ItemProperty(1, "pixi").(*PixelInformation)
+	if !ok || len(pixi.BitsPerChannel) != 3 || pixi.BitsPerChannel[0] != 8 {
+		t.Errorf("pixi = %+v", pixi)
+	}
+
+	f, err = Parse(readFile(t, "test_exif.heic"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rot, ok := f.Meta.ItemProperty(1, "irot").(*Rotation); !ok || rot.Angle != 270 {
+		t.Errorf("irot = %+v, want 270", rot)
+	}
+}
+
+func TestParseSequence(t *testing.T) {
+	data := readFile(t, "anim.heic")
+
+	f, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Movie == nil || len(f.Movie.Tracks) != 2 {
+		t.Fatal("want moov with two tracks")
+	}
+
+	pict := f.Movie.Track("pict")
+	if pict == nil || pict.SampleEntry == nil || pict.SampleEntry.HEVCConfig == nil {
+		t.Fatal("no pict track with hvcC")
+	}
+	if pict.SampleEntry.Width != 176 || pict.SampleEntry.Height != 128 {
+		t.Errorf("size = %dx%d, want 176x128", pict.SampleEntry.Width, pict.SampleEntry.Height)
+	}
+
+	samples := pict.Samples(len(data))
+	if len(samples) != 17 {
+		t.Fatalf("samples = %d, want 17", len(samples))
+	}
+	for i, s := range samples {
+		if s.Offset+s.Size > int64(len(data)) {
+			t.Errorf("sample %d at %d+%d past end", i, s.Offset, s.Size)
+		}
+		if float64(s.Duration)/float64(pict.Timescale) != 0.08 {
+			t.Errorf("sample %d duration = %d/%d", i, s.Duration, pict.Timescale)
+		}
+	}
+
+	if got := pict.Samples(5); len(got) != 5 {
+		t.Errorf("limited samples = %d, want 5", len(got))
+	}
+
+	alpha := f.Movie.Track("auxv")
+	if alpha == nil || len(alpha.References) != 1 || alpha.References[0].TrackIDs[0] != pict.ID {
+		t.Errorf("auxv track = %+v", alpha)
+	}
+}
+
+func TestParsePrefix(t *testing.T) {
+	data := readFile(t, "test.heic")
+
+	f, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A prefix ending inside mdat still parses.
+	mdat := f.Boxes[len(f.Boxes)-1]
+	if _, err := Parse(data[:mdat.Offset+100]); err != nil {
+		t.Errorf("prefix: %v", err)
+	}
+
+	// A prefix ending inside meta does not.
+	meta := f.Boxes[1]
+	if _, err := Parse(data[:meta.Offset+100]); !errors.Is(err, ErrTruncated) {
+		t.Errorf("truncated meta: err = %v, want ErrTruncated", err)
+	}
+
+	m, err := ParseMeta(data[meta.Offset:meta.End()], meta.Offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Location.Items[0].Extents[0].Offset == 0 && m.Location.Items[0].BaseOffset == 0 {
+		t.Error("iloc without offsets")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	data := readFile(t, "test8.heic")
+
+	f, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := f.Boxes[1]
+
+	// Corrupting the size of every box inside meta must never panic.
+	for off := meta.Offset + 12; off+4 <= meta.End(); off++ {
+		b := bytes.Clone(data)
+		b[off], b[off+1], b[off+2], b[off+3] = 0xff, 0xff, 0xff, 0xf0
+		_, _ = Parse(b)
+	}
+}
+
+func TestParseTrailingGarbage(t *testing.T) {
+	data := readFile(t, "test8.heic")
+
+	want, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, garbage := range [][]byte{{0, 0, 0, 3, 'j', 'u', 'n', 'k', 0xde, 0xad}, {0xde, 0xad}} {
+		f, err := Parse(append(bytes.Clone(data), garbage...))
+		if err != nil {
+			t.Fatalf("%x: %v", garbage, err)
+		}
+		if len(f.Boxes) != len(want.Boxes) || f.Meta == nil {
+			t.Errorf("%x: %d boxes, meta %v", garbage, len(f.Boxes), f.Meta != nil)
+		}
+	}
+
+	// Garbage in place of the first box is still an error.
+	if _, err := Parse([]byte{0, 0, 0, 3, 'j', 'u', 'n', 'k'}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("err = %v, want ErrInvalid", err)
+	}
+}
+
+func testBox(typ string, payload ...[]byte) []byte {
+	p := bytes.Join(payload, nil)
+	return append(binary.BigEndian.AppendUint32(nil, uint32(8+len(p))), append([]byte(typ), p...)...)
+}
+
+func TestParseItemLocationLimits(t *testing.T) {
+	u16 := func(v int) []byte { return binary.BigEndian.AppendUint16(nil, uint16(v)) }
+
+	file := func(boxes ...[]byte) []byte {
+		hdlr := testBox("hdlr", make([]byte, 8), []byte("pict"), make([]byte, 13))
+		return append(testBox("ftyp", []byte("heic"), make([]byte, 4), []byte("mif1")),
+			testBox("meta", append([][]byte{make([]byte, 4), hdlr}, boxes...)...)...)
+	}
+
+	// Extents without offset and length fields take no bytes, so a small iloc could list millions of them.
+	iloc := [][]byte{make([]byte, 4), u16(0), u16(2000)}
+	for i := range 2000 {
+		iloc = append(iloc, u16(i+1), u16(0), u16(0xffff))
+	}
+	if _, err := Parse(file(testBox("iloc", iloc...))); !errors.Is(err, ErrInvalid) {
+		t.Errorf("empty extents: err = %v, want ErrInvalid", err)
+	}
+
+	// Items constructed from 200 extents of the item before them, nested 8 deep, would read item 1 200^8 times.
+	iloc = [][]byte{{1, 0, 0, 0}, u16(0x0001), u16(9), u16(1), u16(0), u16(0), u16(1), {0}}
+	var iref [][]byte
+	for id := 2; id <= 9; id++ {
+		iloc = append(iloc, u16(id), u16(ConstructionItem), u16(0), u16(200))
+		for range 200 {
+			iloc = append(iloc, []byte{1})
+		}
+		iref = append(iref, testBox("iloc", u16(id), u16(1), u16(id-1)))
+	}
+
+	data := file(testBox("iloc", iloc...), testBox("iref", append([][]byte{make([]byte, 4)}, iref...)...))
+	f, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Meta.ReadItem(bytes.NewReader(data), 9); !errors.Is(err, ErrInvalid) {
+		t.Errorf("construction: err = %v, want ErrInvalid", err)
+	}
+	if b, err := f.Meta.ReadItem(bytes.NewReader(data), 2); err != nil || len(b) != 200*len(data) {
+		t.Errorf("item 2: %d bytes, err %v", len(b), err)
+	}
+}
@@ -0,0 +1,636 @@
+package isobmff
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Meta is the meta box holding the items of a HEIF file.
+type Meta struct {
+	FullBox
+	Handler    *Handler        // hdlr
+	Primary    *PrimaryItem    // pitm
+	ItemInfo   *ItemInfo       // iinf
+	Location   *ItemLocation   // iloc
+	Reference  *ItemReference  // iref
+	Properties *ItemProperties // iprp
+	Data       *ItemData       // idat
+	Groups     *GroupList      // grpl
+	Children   []Box           // All child boxes in file order.
+}
+
+// Handler is the hdlr box.
+type Handler struct {
+	FullBox
+	HandlerType string
+	Name        string
+}
+
+// PrimaryItem is the pitm box.
+type PrimaryItem struct {
+	FullBox
+	ItemID uint32
+}
+
+// ItemInfo is the iinf box.
+type ItemInfo struct {
+	FullBox
+	Entries []*ItemInfoEntry
+}
+
+// ItemInfoEntry is an infe box.
+type ItemInfoEntry struct {
+	FullBox
+	ItemID          uint32
+	ProtectionIndex uint16
+	ItemType        string // Four-character code, empty before version 2.
+	Name            string
+	ContentType     string // For mime items.
+	ContentEncoding string // For mime items.
+	URIType         string // For uri items.
+}
+
+// Hidden reports whether the item is not intended to be displayed on its own.
+func (e *ItemInfoEntry) Hidden() bool {
+	return e.Flags&1 != 0
+}
+
+// ItemLocation is the iloc box.
+type ItemLocation struct {
+	FullBox
+	OffsetSize     int
+	LengthSize     int
+	BaseOffsetSize int
+	IndexSize      int
+	Items          []*ItemLocationEntry
+}
+
+// maxExtents bounds the extents of an iloc box.
+const maxExtents = 1 << 16
+
+// maxConstruction bounds the bytes that ReadItem reads to construct an item from other items, which may
+// reference each other many times over; the extents it resolves are bounded by maxExtents.
+const maxConstruction = 1 << 26
+
+// Construction methods of ItemLocationEntry.
+const (
+	ConstructionFile = 0 // Extents are file offsets.
+	ConstructionIdat = 1 // Extents are offsets in the idat box.
+	ConstructionItem = 2 // Extents are offsets in another item.
+)
+
+// ItemLocationEntry is the location of one item.
+type ItemLocationEntry struct {
+	ItemID             uint32
+	ConstructionMethod int
+	DataReferenceIndex uint16
+	BaseOffset         uint64
+	Extents            []Extent
+}
+
+// Extent is a contiguous piece of item data.
+type Extent struct {
+	Index  uint64
+	Offset uint64 // Relative to BaseOffset.
+	Length uint64 // Zero means up to the end of the source.
+}
+
+// Length returns the total length of the extents.
+func (l *ItemLocationEntry) Length() uint64 {
+	var n uint64
+	for _, e := range l.Extents {
+		n += e.Length
+	}
+
+	return n
+}
+
+// ItemReference is the iref box.
+type ItemReference struct {
+	FullBox
+	References []*Reference
+}
+
+// Reference is a single item type reference box; its Type is the reference type, e.g. thmb, auxl, dimg or cdsc.
+type Reference struct {
+	Box
+	FromItemID uint32
+	ToItemIDs  []uint32
+}
+
+// ItemData is the idat box.
+type ItemData struct {
+	Box
+	Data []byte
+}
+
+// GroupList is the grpl box.
+type GroupList struct {
+	Box
+	Groups []*EntityGroup
+}
+
+// EntityGroup is an entity to group box; its Type is the grouping type, e.g. altr or ster.
+type EntityGroup struct {
+	FullBox
+	GroupID   uint32
+	EntityIDs []uint32
+}
+
+// Item returns the info entry of item id, or nil.
+func (m *Meta) Item(id uint32) *ItemInfoEntry {
+	if m.ItemInfo == nil {
+		return nil
+	}
+
+	for _, e := range m.ItemInfo.Entries {
+		if e.ItemID == id {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// Items returns the info entries of all items, in iinf order.
+func (m *Meta) Items() []*ItemInfoEntry {
+	if m.ItemInfo == nil {
+		return nil
+	}
+
+	return m.ItemInfo.Entries
+}
+
+// ItemsOfType returns the info entries of items of type typ, in iinf order.
+func (m *Meta) ItemsOfType(typ string) []*ItemInfoEntry {
+	var items []*ItemInfoEntry
+	for _, e := range m.Items() {
+		if e.ItemType == typ {
+			items = append(items, e)
+		}
+	}
+
+	return items
+}
+
+// PrimaryItemID returns the ID of the primary item, or 0.
+func (m *Meta) PrimaryItemID() uint32 {
+	if m.Primary == nil {
+		return 0
+	}
+
+	return m.Primary.ItemID
+}
+
+// ItemLocation returns the location of item id, or nil.
+func (m *Meta) ItemLocation(id uint32) *ItemLocationEntry {
+	if m.Location == nil {
+		return nil
+	}
+
+	for _, l := range m.Location.Items {
+		if l.ItemID == id {
+			return l
+		}
+	}
+
+	return nil
+}
+
+// References returns the items referenced by item from with reference type typ, in reference order.
+func (m *Meta) References(from uint32, typ string) []uint32 {
+	if m.Reference == nil {
+		return nil
+	}
+
+	var ids []uint32
+	for _, r := range m.Reference.References {
+		if r.Type == typ && r.FromItemID == from {
+			ids = append(ids, r.ToItemIDs...)
+		}
+	}
+
+	return ids
+}
+
+// ReferencedBy returns the items that reference item to with reference type typ.
+func (m *Meta) ReferencedBy(to uint32, typ string) []uint32 {
+	if m.Reference == nil {
+		return nil
+	}
+
+	var ids []uint32
+	for _, r := range m.Reference.References {
+		if r.Type != typ {
+			continue
+		}
+		for _, id := range r.ToItemIDs {
+			if id == to {
+				ids = append(ids, r.FromItemID)
+				break
+			}
+		}
+	}
+
+	return ids
+}
+
+// ItemProperties returns the properties associated with item id, in association order.
+func (m *Meta) ItemProperties(id uint32) []Property {
+	if m.Properties == nil || m.Properties.Container == nil {
+		return nil
+	}
+
+	var props []Property
+	for _, idx := range m.Properties.Indexes(id) {
+		if p := m.Properties.Container.Property(idx.Index); p != nil {
+			props = append(props, p)
+		}
+	}
+
+	return props
+}
+
+// ItemProperty returns the first property of item id with type typ, or nil.
+func (m *Meta) ItemProperty(id uint32, typ string) Property {
+	for _, p := range m.ItemProperties(id) {
+		if p.Header().Type == typ {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// ReadItem reads the data of item id from r, the whole file.
+// Data in the idat box and in other items is resolved from the parsed tree.
+func (m *Meta) ReadItem(r io.ReaderAt, id uint32) ([]byte, error) {
+	return m.readItem(r, id, 0, &construction{})
+}
+
+// construction counts the work of constructing an item from other items.
+type construction struct {
+	bytes   int
+	extents int
+}
+
+func (m *Meta) readItem(r io.ReaderAt, id uint32, depth int, c *construction) ([]byte, error) {
+	if depth > 8 {
+		return nil, fmt.Errorf("%w: iloc: item %d: construction nested too deeply", ErrInvalid, id)
+	}
+
+	loc := m.ItemLocation(id)
+	if loc == nil {
+		return nil, fmt.Errorf("%w: iloc: no location for item %d", ErrInvalid, id)
+	}
+
+	var src io.ReaderAt
+	switch loc.ConstructionMethod {
+	case ConstructionFile:
+		if loc.DataReferenceIndex != 0 {
+			return nil, fmt.Errorf("%w: iloc: item %d: external data reference", ErrInvalid, id)
+		}
+		src = r
+	case ConstructionIdat:
+		if m.Data == nil {
+			return nil, fmt.Errorf("%w: iloc: item %d: no idat", ErrInvalid, id)
+		}
+		src = bytes.NewReader(m.Data.Data)
+	case ConstructionItem:
+		// Each extent index names a referenced item (iloc references, 1-based).
+		refs := m.References(id, "iloc")
+		var buf bytes.Buffer
+		for _, e := range loc.Extents {
+			if e.Index == 0 || e.Index > uint64(len(refs)) {
+				return nil, fmt.Errorf("%w: iloc: item %d: extent index %d", ErrInvalid, id, e.Index)
+			}
+			if c.extents++; c.extents > maxExtents {
+				return nil, fmt.Errorf("%w: iloc: item %d: construction exceeds %d extents", ErrInvalid, id, maxExtents)
+			}
+			data, err := m.readItem(r, refs[e.Index-1], depth+1, c)
+			if err != nil {
+				return nil, err
+			}
+			if c.bytes += len(data); c.bytes > maxConstruction {
+				return nil, fmt.Errorf("%w: iloc: item %d: construction exceeds %d bytes", ErrInvalid, id, maxConstruction)
+			}
+			p, err := extent(bytes.NewReader(data), loc.BaseOffset, e)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(p)
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("%w: iloc: item %d: construction method %d", ErrInvalid, id, loc.ConstructionMethod)
+	}
+
+	if len(loc.Extents) == 1 {
+		return extent(src, loc.BaseOffset, loc.Extents[0])
+	}
+
+	var buf bytes.Buffer
+	for _, e := range loc.Extents {
+		p, err := extent(src, loc.BaseOffset, e)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(p)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// extent reads e from src. The buffer grows with the data actually read, so a bogus length cannot force a large allocation.
+func extent(src io.ReaderAt, base uint64, e Extent) ([]byte, error) {
+	off := base + e.Offset
+	if off < base || off > 1<<62 || e.Length > 1<<62 {
+		return nil, fmt.Errorf("%w: iloc: extent offset %d", ErrInvalid, off)
+	}
+
+	n := int64(e.Length)
+	if n == 0 {
+		n = 1<<62 - int64(off)
+	}
+
+	data, err := io.ReadAll(io.NewSectionReader(src, int64(off), n))
+	if err != nil {
+		return nil, err
+	}
+	if e.Length != 0 && uint64(len(data)) != e.Length {
+		return nil, fmt.Errorf("%w: item data at %d", ErrTruncated, off)
+	}
+
+	return data, nil
+}
+
+func parseMeta(box Box, p []byte) (*Meta, error) {
+	r := &reader{b: p, box: box.Type}
+	m := &Meta{FullBox: readFullBox(box, r)}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	err := children(p[r.off:], payloadOffset(box)+int64(r.off), func(b Box, p []byte) error {
+		m.Children = append(m.Children, b)
+
+		var err error
+		switch b.Type {
+		case "hdlr":
+			m.Handler, err = parseHandler(b, p)
+		case "pitm":
+			m.Primary, err = parsePrimaryItem(b, p)
+		case "iinf":
+			m.ItemInfo, err = parseItemInfo(b, p)
+		case "iloc":
+			m.Location, err = parseItemLocation(b, p)
+		case "iref":
+			m.Reference, err = parseItemReference(b, p)
+		case "iprp":
+			m.Properties, err = parseItemProperties(b, p)
+		case "idat":
+			m.Data = &ItemData{Box: b, Data: p}
+		case "grpl":
+			m.Groups, err = parseGroupList(b, p)
+		}
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func parseHandler(box Box, p []byte) (*Handler, error) {
+	r := &reader{b: p, box: box.Type}
+
+	h := &Handler{FullBox: readFullBox(box, r)}
+	r.u32() // pre_defined
+	h.HandlerType = r.fourCC()
+	r.bytes(12) // reserved
+	h.Name = r.string()
+
+	return h, r.err
+}
+
+func parsePrimaryItem(box Box, p []byte) (*PrimaryItem, error) {
+	r := &reader{b: p, box: box.Type}
+
+	pi := &PrimaryItem{FullBox: readFullBox(box, r)}
+	if pi.Version == 0 {
+		pi.ItemID = uint32(r.u16())
+	} else {
+		pi.ItemID = r.u32()
+	}
+
+	return pi, r.err
+}
+
+func parseItemInfo(box Box, p []byte) (*ItemInfo, error) {
+	r := &reader{b: p, box: box.Type}
+
+	ii := &ItemInfo{FullBox: readFullBox(box, r)}
+	if ii.Version == 0 {
+		r.u16()
+	} else {
+		r.u32()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	// The entry count is not trusted; the entries are the infe children.
+	err := children(p[r.off:], payloadOffset(box)+int64(r.off), func(b Box, p []byte) error {
+		if b.Type != "infe" {
+			return nil
+		}
+
+		e, err := parseItemInfoEntry(b, p)
+		if err != nil {
+			return err
+		}
+		ii.Entries = append(ii.Entries, e)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ii, nil
+}
+
+func parseItemInfoEntry(box Box, p []byte) (*ItemInfoEntry, error) {
+	r := &reader{b: p, box: box.Type}
+
+	e := &ItemInfoEntry{FullBox: readFullBox(box, r)}
+
+	switch e.Version {
+	case 0, 1:
+		e.ItemID = uint32(r.u16())
+		e.ProtectionIndex = r.u16()
+		e.Name = r.string()
+		e.ContentType = r.string()
+		e.ContentEncoding = r.string()
+	case 2, 3:
+		if e.Version == 2 {
+			e.ItemID = uint32(r.u16())
+		} else {
+			e.ItemID = r.u32()
+		}
+		e.ProtectionIndex = r.u16()
+		e.ItemType = r.fourCC()
+		e.Name = r.string()
+
+		switch e.ItemType {
+		case "mime":
+			e.ContentType = r.string()
+			e.ContentEncoding = r.string()
+		case "uri ":
+			e.URIType = r.string()
+		}
+	default:
+		return nil, fmt.Errorf("%w: infe version %d", ErrInvalid, e.Version)
+	}
+
+	return e, r.err
+}
+
+func parseItemLocation(box Box, p []byte) (*ItemLocation, error) {
+	r := &reader{b: p, box: box.Type}
+
+	l := &ItemLocation{FullBox: readFullBox(box, r)}
+	if l.Version > 2 {
+		return nil, fmt.Errorf("%w: iloc version %d", ErrInvalid, l.Version)
+	}
+
+	sizes := r.u16()
+	l.OffsetSize = int(sizes >> 12)
+	l.LengthSize = int(sizes >> 8 & 0xf)
+	l.BaseOffsetSize = int(sizes >> 4 & 0xf)
+	if l.Version > 0 {
+		l.IndexSize = int(sizes & 0xf)
+	}
+
+	var count uint32
+	if l.Version < 2 {
+		count = uint32(r.u16())
+	} else {
+		count = r.u32()
+	}
+
+	// Each item takes at least 6 bytes.
+	l.Items = make([]*ItemLocationEntry, 0, capacity(uint64(count), r.len()/6))
+
+	var extents int
+
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		e := &ItemLocationEntry{}
+		if l.Version < 2 {
+			e.ItemID = uint32(r.u16())
+		} else {
+			e.ItemID = r.u32()
+		}
+		if l.Version > 0 {
+			e.ConstructionMethod = int(r.u16() & 0xf)
+		}
+		e.DataReferenceIndex = r.u16()
+		e.BaseOffset = r.uint(l.BaseOffsetSize)
+
+		// An extent without fields reads nothing, so only the box size would bound their number.
+		n := int(r.u16())
+		size := l.IndexSize + l.OffsetSize + l.LengthSize
+		if size == 0 && n > 1 {
+			return nil, fmt.Errorf("%w: iloc: item %d: %d empty extents", ErrInvalid, e.ItemID, n)
+		}
+		if extents += n; extents > maxExtents {
+			return nil, fmt.Errorf("%w: iloc: more than %d extents", ErrInvalid, maxExtents)
+		}
+		e.Extents = make([]Extent, 0, capacity(uint64(n), r.len()/max(size, 1)))
+		for j := 0; j < n && r.err == nil; j++ {
+			var x Extent
+			if l.IndexSize > 0 {
+				x.Index = r.uint(l.IndexSize)
+			}
+			x.Offset = r.uint(l.OffsetSize)
+			x.Length = r.uint(l.LengthSize)
+			e.Extents = append(e.Extents, x)
+		}
+
+		l.Items = append(l.Items, e)
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return l, nil
+}
+
+func parseItemReference(box Box, p []byte) (*ItemReference, error) {
+	r := &reader{b: p, box: box.Type}
+
+	ir := &ItemReference{FullBox: readFullBox(box, r)}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	idSize := 2
+	if ir.Version > 0 {
+		idSize = 4
+	}
+
+	err := children(p[r.off:], payloadOffset(box)+int64(r.off), func(b Box, p []byte) error {
+		rr := &reader{b: p, box: b.Type}
+
+		ref := &Reference{Box: b, FromItemID: uint32(rr.uint(idSize))}
+		n := int(rr.u16())
+		ref.ToItemIDs = make([]uint32, 0, capacity(uint64(n), rr.len()/idSize))
+		for i := 0; i < n && rr.err == nil; i++ {
+			ref.ToItemIDs = append(ref.ToItemIDs, uint32(rr.uint(idSize)))
+		}
+		if rr.err != nil {
+			return rr.err
+		}
+
+		ir.References = append(ir.References, ref)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ir, nil
+}
+
+func parseGroupList(box Box, p []byte) (*GroupList, error) {
+	gl := &GroupList{Box: box}
+
+	err := children(p, payloadOffset(box), func(b Box, p []byte) error {
+		r := &reader{b: p, box: b.Type}
+
+		g := &EntityGroup{FullBox: readFullBox(b, r)}
+		g.GroupID = r.u32()
+		n := r.u32()
+		g.EntityIDs = make([]uint32, 0, capacity(uint64(n), r.len()/4))
+		for i := uint32(0); i < n && r.err == nil; i++ {
+			g.EntityIDs = append(g.EntityIDs, r.u32())
+		}
+		if r.err != nil {
+			return r.err
+		}
+
+		gl.Groups = append(gl.Groups, g)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return gl, nil
+}
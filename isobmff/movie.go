@@ -0,0 +1,341 @@
+package isobmff
+
+import (
+	"fmt"
+)
+
+// Movie is the moov box holding the tracks of an image sequence.
+type Movie struct {
+	Box
+	Timescale uint32 // From mvhd.
+	Duration  uint64 // From mvhd, in Timescale units.
+	Tracks    []*Track
+}
+
+// Track is a trak box with its media header, handler, sample description and sample tables.
+type Track struct {
+	Box
+	ID          uint32            // From tkhd.
+	HandlerType string            // From hdlr, e.g. pict for an image sequence or auxv for auxiliary video.
+	Timescale   uint32            // From mdhd.
+	Duration    uint64            // From mdhd, in Timescale units.
+	References  []*TrackReference // From tref.
+	SampleEntry *SampleEntry      // First sample entry of stsd, or nil.
+
+	SampleSize    uint32   // Size of every sample, or 0 when SampleSizes lists them.
+	SampleCount   uint32   // From stsz.
+	SampleSizes   []uint32 // From stsz.
+	ChunkOffsets  []uint64 // From stco or co64.
+	SampleToChunk []SampleToChunk
+	TimeToSample  []TimeToSample
+	SyncSamples   []uint32 // From stss, 1-based; nil when every sample is a sync sample.
+}
+
+// TrackReference is a track reference type box; its Type is the reference type, e.g. auxl.
+type TrackReference struct {
+	Box
+	TrackIDs []uint32
+}
+
+// SampleEntry is a visual sample entry of stsd, e.g. hvc1.
+type SampleEntry struct {
+	Box
+	Width, Height int
+	HEVCConfig    *HEVCConfig          // hvcC, or nil.
+	AuxType       *AuxiliaryType       // auxi, the auxiliary type of an auxv track, or nil.
+	Colours       []*ColourInformation // colr boxes.
+}
+
+// SampleToChunk is an stsc entry.
+type SampleToChunk struct {
+	FirstChunk             uint32
+	SamplesPerChunk        uint32
+	SampleDescriptionIndex uint32
+}
+
+// TimeToSample is an stts entry.
+type TimeToSample struct {
+	Count uint32
+	Delta uint32
+}
+
+// Sample is the location and duration of one sample.
+type Sample struct {
+	Offset   int64
+	Size     int64
+	Duration uint32 // In the track Timescale.
+}
+
+// Track returns the first track with handler type typ, or nil.
+func (m *Movie) Track(typ string) *Track {
+	for _, t := range m.Tracks {
+		if t.HandlerType == typ {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// Samples resolves the sample tables into the location and duration of each sample, in decoding order.
+// At most max samples are returned; callers bound it by the file size, since each sample occupies at least a byte.
+func (t *Track) Samples(max int) []Sample {
+	n := capacity(uint64(t.SampleCount), max)
+	if t.SampleSize == 0 {
+		n = min(n, len(t.SampleSizes))
+	}
+
+	size := func(i int) int64 {
+		if t.SampleSize != 0 {
+			return int64(t.SampleSize)
+		}
+		return int64(t.SampleSizes[i])
+	}
+
+	samples := make([]Sample, 0, n)
+
+	for ci, off := range t.ChunkOffsets {
+		chunk := uint32(ci + 1)
+
+		// The last stsc entry whose first chunk is at or before this one applies.
+		var perChunk uint32
+		for _, e := range t.SampleToChunk {
+			if e.FirstChunk > chunk {
+				break
+			}
+			perChunk = e.SamplesPerChunk
+		}
+
+		o := int64(off)
+		for k := uint32(0); k < perChunk && len(samples) < n; k++ {
+			s := size(len(samples))
+			samples = append(samples, Sample{Offset: o, Size: s})
+			o += s
+		}
+
+		if len(samples) == n {
+			break
+		}
+	}
+
+	i := 0
+	for _, e := range t.TimeToSample {
+		for c := uint32(0); c < e.Count && i < len(samples); c++ {
+			samples[i].Duration = e.Delta
+			i++
+		}
+	}
+
+	return samples
+}
+
+func parseMovie(box Box, p []byte) (*Movie, error) {
+	m := &Movie{Box: box}
+
+	err := children(p, payloadOffset(box), func(b Box, p []byte) error {
+		switch b.Type {
+		case "mvhd":
+			r := &reader{b: p, box: b.Type}
+			fb := readFullBox(b, r)
+			if fb.Version == 1 {
+				r.bytes(16)
+				m.Timescale = r.u32()
+				m.Duration = r.u64()
+			} else {
+				r.bytes(8)
+				m.Timescale = r.u32()
+				m.Duration = uint64(r.u32())
+			}
+			return r.err
+		case "trak":
+			t, err := parseTrack(b, p)
+			if err != nil {
+				return err
+			}
+			m.Tracks = append(m.Tracks, t)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func parseTrack(box Box, p []byte) (*Track, error) {
+	t := &Track{Box: box}
+
+	var walk func(b Box, p []byte) error
+	walk = func(b Box, p []byte) error {
+		r := &reader{b: p, box: b.Type}
+
+		switch b.Type {
+		case "mdia", "minf", "stbl":
+			return children(p, payloadOffset(b), walk)
+
+		case "tkhd":
+			fb := readFullBox(b, r)
+			if fb.Version == 1 {
+				r.bytes(16)
+			} else {
+				r.bytes(8)
+			}
+			t.ID = r.u32()
+
+		case "tref":
+			return children(p, payloadOffset(b), func(b Box, p []byte) error {
+				r := &reader{b: p, box: b.Type}
+				ref := &TrackReference{Box: b, TrackIDs: make([]uint32, 0, len(p)/4)}
+				for r.len() >= 4 {
+					ref.TrackIDs = append(ref.TrackIDs, r.u32())
+				}
+				t.References = append(t.References, ref)
+				return nil
+			})
+
+		case "mdhd":
+			fb := readFullBox(b, r)
+			if fb.Version == 1 {
+				r.bytes(16)
+				t.Timescale = r.u32()
+				t.Duration = r.u64()
+			} else {
+				r.bytes(8)
+				t.Timescale = r.u32()
+				t.Duration = uint64(r.u32())
+			}
+
+		case "hdlr":
+			h, err := parseHandler(b, p)
+			if err != nil {
+				return err
+			}
+			t.HandlerType = h.HandlerType
+
+		case "stsd":
+			readFullBox(b, r)
+			r.u32() // entry_count
+			if r.err != nil {
+				return r.err
+			}
+			return children(p[r.off:], payloadOffset(b)+int64(r.off), func(b Box, p []byte) error {
+				if t.SampleEntry != nil {
+					return nil
+				}
+				e, err := parseSampleEntry(b, p)
+				if err != nil {
+					return err
+				}
+				t.SampleEntry = e
+				return nil
+			})
+
+		case "stsz":
+			readFullBox(b, r)
+			t.SampleSize = r.u32()
+			t.SampleCount = r.u32()
+			if t.SampleSize == 0 {
+				t.SampleSizes = make([]uint32, 0, capacity(uint64(t.SampleCount), r.len()/4))
+				for i := uint32(0); i < t.SampleCount && r.err == nil; i++ {
+					t.SampleSizes = append(t.SampleSizes, r.u32())
+				}
+			}
+
+		case "stco", "co64":
+			readFullBox(b, r)
+			n := r.u32()
+			size := 4
+			if b.Type == "co64" {
+				size = 8
+			}
+			t.ChunkOffsets = make([]uint64, 0, capacity(uint64(n), r.len()/size))
+			for i := uint32(0); i < n && r.err == nil; i++ {
+				t.ChunkOffsets = append(t.ChunkOffsets, r.uint(size))
+			}
+
+		case "stsc":
+			readFullBox(b, r)
+			n := r.u32()
+			t.SampleToChunk = make([]SampleToChunk, 0, capacity(uint64(n), r.len()/12))
+			for i := uint32(0); i < n && r.err == nil; i++ {
+				t.SampleToChunk = append(t.SampleToChunk, SampleToChunk{
+					FirstChunk:             r.u32(),
+					SamplesPerChunk:        r.u32(),
+					SampleDescriptionIndex: r.u32(),
+				})
+			}
+
+		case "stts":
+			readFullBox(b, r)
+			n := r.u32()
+			t.TimeToSample = make([]TimeToSample, 0, capacity(uint64(n), r.len()/8))
+			for i := uint32(0); i < n && r.err == nil; i++ {
+				t.TimeToSample = append(t.TimeToSample, TimeToSample{Count: r.u32(), Delta: r.u32()})
+			}
+
+		case "stss":
+			readFullBox(b, r)
+			n := r.u32()
+			t.SyncSamples = make([]uint32, 0, capacity(uint64(n), r.len()/4))
+			for i := uint32(0); i < n && r.err == nil; i++ {
+				t.SyncSamples = append(t.SyncSamples, r.u32())
+			}
+		}
+
+		return r.err
+	}
+
+	if err := children(p, payloadOffset(box), walk); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// parseSampleEntry parses a VisualSampleEntry and its hvcC, auxi and colr children.
+func parseSampleEntry(box Box, p []byte) (*SampleEntry, error) {
+	e := &SampleEntry{Box: box}
+
+	// SampleEntry (8 bytes) and VisualSampleEntry fields up to the children (70 bytes).
+	const header = 78
+	if len(p) < header {
+		return e, nil // Not a visual sample entry.
+	}
+
+	r := &reader{b: p, box: box.Type}
+	r.bytes(24)
+	e.Width = int(r.u16())
+	e.Height = int(r.u16())
+
+	err := children(p[header:], payloadOffset(box)+header, func(b Box, p []byte) error {
+		switch b.Type {
+		case "hvcC":
+			c, err := parseHEVCConfig(b, p)
+			if err != nil {
+				return err
+			}
+			e.HEVCConfig = c
+		case "auxi":
+			r := &reader{b: p, box: b.Type}
+			a := &AuxiliaryType{FullBox: readFullBox(b, r)}
+			a.AuxType = r.string()
+			e.AuxType = a
+			return r.err
+		case "colr":
+			c, err := parseProperty(b, p)
+			if err != nil {
+				return err
+			}
+			e.Colours = append(e.Colours, c.(*ColourInformation))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", box.Type, err)
+	}
+
+	return e, nil
+}
@@ -0,0 +1,363 @@
+package isobmff
+
+import (
+	"fmt"
+)
+
+// Property is an item property from the ipco box. Properties without a typed form are *RawProperty.
+type Property interface {
+	Header() Box
+}
+
+// ItemProperties is the iprp box.
+type ItemProperties struct {
+	Box
+	Container    *PropertyContainer     // ipco
+	Associations []*PropertyAssociation // ipma boxes
+}
+
+// PropertyContainer is the ipco box.
+type PropertyContainer struct {
+	Box
+	Properties []Property
+}
+
+// Property returns the property with the 1-based index used by ipma, or nil.
+func (c *PropertyContainer) Property(index int) Property {
+	if index < 1 || index > len(c.Properties) {
+		return nil
+	}
+
+	return c.Properties[index-1]
+}
+
+// PropertyAssociation is an ipma box.
+type PropertyAssociation struct {
+	FullBox
+	Entries []*AssociationEntry
+}
+
+// AssociationEntry lists the properties of one item.
+type AssociationEntry struct {
+	ItemID     uint32
+	Properties []PropertyIndex
+}
+
+// PropertyIndex refers to a property in ipco.
+type PropertyIndex struct {
+	Index     int // 1-based, 0 means no property.
+	Essential bool
+}
+
+// Indexes returns the property indexes of item id from all ipma boxes.
+func (p *ItemProperties) Indexes(id uint32) []PropertyIndex {
+	var idx []PropertyIndex
+	for _, a := range p.Associations {
+		for _, e := range a.Entries {
+			if e.ItemID == id {
+				idx = append(idx, e.Properties...)
+			}
+		}
+	}
+
+	return idx
+}
+
+// RawProperty is a property without a typed form; Data is its payload.
+type RawProperty struct {
+	Box
+	Data []byte
+}
+
+// ImageSpatialExtents is the ispe property.
+type ImageSpatialExtents struct {
+	FullBox
+	Width  uint32
+	Height uint32
+}
+
+// HEVCConfig is the hvcC property, the HEVC decoder configuration record.
+type HEVCConfig struct {
+	Box
+	ConfigurationVersion uint8
+	ProfileSpace         uint8
+	TierFlag             bool
+	ProfileIDC           uint8
+	LevelIDC             uint8
+	ChromaFormat         uint8 // 0 monochrome, 1 4:2:0, 2 4:2:2, 3 4:4:4.
+	BitDepthLuma         int
+	BitDepthChroma       int
+	NALLengthSize        int
+	Arrays               []NALArray
+}
+
+// NALArray holds the parameter set NAL units of one type.
+type NALArray struct {
+	Complete bool
+	Type     uint8
+	Units    [][]byte
+}
+
+// ParameterSets returns the NAL units of all arrays in order, typically VPS, SPS and PPS.
+func (c *HEVCConfig) ParameterSets() [][]byte {
+	var units [][]byte
+	for _, a := range c.Arrays {
+		units = append(units, a.Units...)
+	}
+
+	return units
+}
+
+// Colour types of ColourInformation.
+const (
+	ColourTypeNCLX = "nclx"
+	ColourTypeICC  = "prof"
+	ColourTypeRICC = "rICC"
+)
+
+// ColourInformation is the colr property.
+type ColourInformation struct {
+	Box
+	ColourType string
+	// nclx
+	ColourPrimaries         uint16
+	TransferCharacteristics uint16
+	MatrixCoefficients      uint16
+	FullRange               bool
+	// prof and rICC
+	ICC []byte
+}
+
+// Rotation is the irot property.
+type Rotation struct {
+	Box
+	Angle int // Counter-clockwise rotation in degrees: 0, 90, 180 or 270.
+}
+
+// Mirror is the imir property.
+type Mirror struct {
+	Box
+	Axis uint8 // 0 exchanges the top and bottom of the image, 1 its left and right.
+}
+
+// CleanAperture is the clap property. The aperture is centred on the image centre shifted by the offsets.
+type CleanAperture struct {
+	Box
+	WidthN, WidthD             uint32
+	HeightN, HeightD           uint32
+	HorizOffsetN, HorizOffsetD int32
+	VertOffsetN, VertOffsetD   int32
+}
+
+// AuxiliaryType is the auxC property.
+type AuxiliaryType struct {
+	FullBox
+	AuxType string // URN, e.g. urn:mpeg:hevc:2015:auxid:1 for alpha.
+	Subtype []byte
+}
+
+// PixelInformation is the pixi property.
+type PixelInformation struct {
+	FullBox
+	BitsPerChannel []uint8
+}
+
+// RelativeLocation is the rloc property.
+type RelativeLocation struct {
+	FullBox
+	HorizontalOffset uint32
+	VerticalOffset   uint32
+}
+
+func parseItemProperties(box Box, p []byte) (*ItemProperties, error) {
+	ip := &ItemProperties{Box: box}
+
+	err := children(p, payloadOffset(box), func(b Box, p []byte) error {
+		switch b.Type {
+		case "ipco":
+			c, err := parsePropertyContainer(b, p)
+			if err != nil {
+				return err
+			}
+			ip.Container = c
+		case "ipma":
+			a, err := parsePropertyAssociation(b, p)
+			if err != nil {
+				return err
+			}
+			ip.Associations = append(ip.Associations, a)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ip, nil
+}
+
+func parsePropertyContainer(box Box, p []byte) (*PropertyContainer, error) {
+	c := &PropertyContainer{Box: box}
+
+	err := children(p, payloadOffset(box), func(b Box, p []byte) error {
+		prop, err := parseProperty(b, p)
+		if err != nil {
+			return err
+		}
+		c.Properties = append(c.Properties, prop)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func parsePropertyAssociation(box Box, p []byte) (*PropertyAssociation, error) {
+	r := &reader{b: p, box: box.Type}
+
+	a := &PropertyAssociation{FullBox: readFullBox(box, r)}
+
+	n := r.u32()
+	// Each entry takes at least 3 bytes.
+	a.Entries = make([]*AssociationEntry, 0, capacity(uint64(n), r.len()/3))
+
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		e := &AssociationEntry{}
+		if a.Version < 1 {
+			e.ItemID = uint32(r.u16())
+		} else {
+			e.ItemID = r.u32()
+		}
+
+		count := int(r.u8())
+		e.Properties = make([]PropertyIndex, 0, count)
+		for j := 0; j < count && r.err == nil; j++ {
+			if a.Flags&1 != 0 {
+				v := r.u16()
+				e.Properties = append(e.Properties, PropertyIndex{Index: int(v & 0x7fff), Essential: v&0x8000 != 0})
+			} else {
+				v := r.u8()
+				e.Properties = append(e.Properties, PropertyIndex{Index: int(v & 0x7f), Essential: v&0x80 != 0})
+			}
+		}
+
+		a.Entries = append(a.Entries, e)
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return a, nil
+}
+
+func parseProperty(box Box, p []byte) (Property, error) {
+	r := &reader{b: p, box: box.Type}
+
+	switch box.Type {
+	case "ispe":
+		ispe := &ImageSpatialExtents{FullBox: readFullBox(box, r)}
+		ispe.Width = r.u32()
+		ispe.Height = r.u32()
+		return ispe, r.err
+
+	case "hvcC":
+		return parseHEVCConfig(box, p)
+
+	case "colr":
+		c := &ColourInformation{Box: box, ColourType: r.fourCC()}
+		switch c.ColourType {
+		case ColourTypeNCLX:
+			c.ColourPrimaries = r.u16()
+			c.TransferCharacteristics = r.u16()
+			c.MatrixCoefficients = r.u16()
+			c.FullRange = r.u8()&0x80 != 0
+		case ColourTypeICC, ColourTypeRICC:
+			c.ICC = r.rest()
+		}
+		return c, r.err
+
+	case "irot":
+		return &Rotation{Box: box, Angle: int(r.u8()&3) * 90}, r.err
+
+	case "imir":
+		return &Mirror{Box: box, Axis: r.u8() & 1}, r.err
+
+	case "clap":
+		return &CleanAperture{
+			Box:          box,
+			WidthN:       r.u32(),
+			WidthD:       r.u32(),
+			HeightN:      r.u32(),
+			HeightD:      r.u32(),
+			HorizOffsetN: int32(r.u32()),
+			HorizOffsetD: int32(r.u32()),
+			VertOffsetN:  int32(r.u32()),
+			VertOffsetD:  int32(r.u32()),
+		}, r.err
+
+	case "auxC":
+		a := &AuxiliaryType{FullBox: readFullBox(box, r)}
+		a.AuxType = r.string()
+		a.Subtype = r.rest()
+		return a, r.err
+
+	case "pixi":
+		px := &PixelInformation{FullBox: readFullBox(box, r)}
+		n := int(r.u8())
+		px.BitsPerChannel = r.bytes(n)
+		return px, r.err
+
+	case "rloc":
+		rl := &RelativeLocation{FullBox: readFullBox(box, r)}
+		rl.HorizontalOffset = r.u32()
+		rl.VerticalOffset = r.u32()
+		return rl, r.err
+	}
+
+	return &RawProperty{Box: box, Data: p}, nil
+}
+
+func parseHEVCConfig(box Box, p []byte) (*HEVCConfig, error) {
+	r := &reader{b: p, box: box.Type}
+
+	c := &HEVCConfig{Box: box, ConfigurationVersion: r.u8()}
+
+	b := r.u8()
+	c.ProfileSpace = b >> 6
+	c.TierFlag = b&0x20 != 0
+	c.ProfileIDC = b & 0x1f
+	r.bytes(4 + 6) // compatibility and constraint indicator flags
+	c.LevelIDC = r.u8()
+	r.bytes(2 + 1) // min_spatial_segmentation_idc, parallelismType
+	c.ChromaFormat = r.u8() & 3
+	c.BitDepthLuma = int(r.u8()&7) + 8
+	c.BitDepthChroma = int(r.u8()&7) + 8
+	r.bytes(2) // avgFrameRate
+	c.NALLengthSize = int(r.u8()&3) + 1
+
+	n := int(r.u8())
+	for i := 0; i < n && r.err == nil; i++ {
+		b := r.u8()
+		a := NALArray{Complete: b&0x80 != 0, Type: b & 0x3f}
+
+		count := int(r.u16())
+		for j := 0; j < count && r.err == nil; j++ {
+			if unit := r.bytes(int(r.u16())); unit != nil {
+				a.Units = append(a.Units, unit)
+			}
+		}
+
+		c.Arrays = append(c.Arrays, a)
+	}
+
+	if r.err != nil {
+		return nil, fmt.Errorf("hvcC: %w", r.err)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,335 @@
+package heic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+// ErrNoMeta is returned when the file has no meta box, e.g. an image sequence without still images.
+var ErrNoMeta = errors.New("heic: no meta box")
+
+// ErrNoItem is returned when Options.ItemID does not name an image item.
+var ErrNoItem = errors.New("heic: no such image item")
+
+// Item describes an image item of a HEIF file.
+type Item struct {
+	ID      uint32
+	Type    string // Item type (codec), e.g. hvc1 or av01, or grid, iovl, iden and tmap for derived images.
+	Width   int    // Width as decoded, after the transformations.
+	Height  int    // Height as decoded, after the transformations.
+	Hidden  bool   // Not intended to be displayed on its own, e.g. a grid tile.
+	Primary bool
+
+	ThumbnailOf uint32 // ID of the image this item is a thumbnail of, or 0.
+	AuxiliaryOf uint32 // ID of the image this item is an auxiliary image of, or 0.
+	AuxType     string // Auxiliary type URN of an auxiliary image, e.g. of an alpha plane, depth map or matte.
+
+	// Inputs lists the IDs of the input images of a derived image in order: the tiles of a grid, the layers of
+	// an overlay from the bottom up, the source of an identity (iden) item, which only transforms it, or the
+	// base image and gain map of a tone-mapped (tmap) image.
+	Inputs []uint32
+
+	// Overlay is the layout of an overlay (iovl) item, or nil. It is also nil when the layout is stored in the
+	// media data instead of the meta box, as Items does not read past it.
+	Overlay *Overlay
+}
+
+// imageItemTypes are the item types that hold or derive an image.
+var imageItemTypes = map[string]bool{
+	"hvc1": true,
+	"av01": true,
+	"avc1": true,
+	"vvc1": true,
+	"jpeg": true,
+	"j2k1": true,
+	"unci": true,
+	"grid": true,
+	"iovl": true,
+	"iden": true,
+	"tmap": true,
+}
+
+// Items lists the image items of a HEIC file in file order. Decode any of them with DecodeItem.
+func Items(r io.Reader) ([]Item, error) {
+	meta, _, err := readMeta(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return items(meta), nil
+}
+
+// DecodeItem decodes the image item with the given ID, as listed by Items.
+func DecodeItem(r io.Reader, id uint32) (image.Image, error) {
+	return decodeImage(context.Background(), r, &Options{ItemID: id})
+}
+
+func items(meta *isobmff.Meta) []Item {
+	primary := meta.PrimaryItemID()
+
+	var out []Item
+	for _, e := range meta.Items() {
+		if !imageItemTypes[e.ItemType] {
+			continue
+		}
+
+		it := Item{
+			ID:      e.ItemID,
+			Type:    e.ItemType,
+			Hidden:  e.Hidden(),
+			Primary: e.ItemID == primary,
+		}
+		it.Width, it.Height = itemSize(meta, e.ItemID)
+
+		if refs := meta.References(e.ItemID, "thmb"); len(refs) > 0 {
+			it.ThumbnailOf = refs[0]
+		}
+		if refs := meta.References(e.ItemID, "auxl"); len(refs) > 0 {
+			it.AuxiliaryOf = refs[0]
+		}
+		if aux, ok := meta.ItemProperty(e.ItemID, "auxC").(*isobmff.AuxiliaryType); ok {
+			it.AuxType = aux.AuxType
+		}
+
+		switch e.ItemType {
+		case "grid", "iovl", "iden", "tmap":
+			it.Inputs = meta.References(e.ItemID, "dimg")
+		}
+		if e.ItemType == "iovl" {
+			it.Overlay, _, _ = itemOverlay(bytes.NewReader(nil), meta, e.ItemID)
+		}
+
+		out = append(out, it)
+	}
+
+	return out
+}
+
+// itemSize returns the size of item id from its ispe property after the transformations, or 0, 0 without ispe.
+func itemSize(meta *isobmff.Meta, id uint32) (int, int) {
+	var w, h int
+
+	for _, p := range meta.ItemProperties(id) {
+		switch p := p.(type) {
+		case *isobmff.ImageSpatialExtents:
+			w, h = int(p.Width), int(p.Height)
+		case *isobmff.CleanAperture:
+			r := clapRect(p, w, h)
+			w, h = r.Dx(), r.Dy()
+		case *isobmff.Rotation:
+			if p.Angle == 90 || p.Angle == 270 {
+				w, h = h, w
+			}
+		}
+	}
+
+	return w, h
+}
+
+// codedSize returns the size of item id from its ispe property, or 0, 0 without ispe.
+func codedSize(meta *isobmff.Meta, id uint32) (int, int) {
+	if ispe, ok := meta.ItemProperty(id, "ispe").(*isobmff.ImageSpatialExtents); ok {
+		return int(ispe.Width), int(ispe.Height)
+	}
+
+	return 0, 0
+}
+
+// parseItemMeta parses data and returns its meta box and the image item opts.ItemID, or the primary image.
+func parseItemMeta(data []byte, opts *Options) (*isobmff.Meta, *isobmff.ItemInfoEntry, error) {
+	f, err := isobmff.Parse(data)
+	if err != nil {
+		return nil, nil, &DecodeError{Backend: "wasm", Stage: StageParse, Code: CodeInvalidInput, Message: err.Error()}
+	}
+	if f.Meta == nil {
+		return nil, nil, ErrNoMeta
+	}
+
+	id := opts.ItemID
+	if id == 0 {
+		id = f.Meta.PrimaryItemID()
+	}
+
+	e := f.Meta.Item(id)
+	if e == nil || !imageItemTypes[e.ItemType] {
+		return nil, nil, fmt.Errorf("%w: %d", ErrNoItem, id)
+	}
+
+	return f.Meta, e, nil
+}
+
+// decodeItem decodes the image item opts.ItemID, or the primary image, from data. The coded items are decoded
+// with the WASM HEVC decoder; derived images are assembled and the transformations applied here.
+func decodeItem(ctx context.Context, data []byte, configOnly bool, opts *Options) (image.Image, image.Config, error) {
+	cfg := image.Config{ColorModel: color.NRGBAModel}
+
+	meta, e, err := parseItemMeta(data, opts)
+	if err != nil {
+		return nil, cfg, err
+	}
+
+	if opts.IgnoreTransformations {
+		cfg.Width, cfg.Height = codedSize(meta, e.ItemID)
+	} else {
+		cfg.Width, cfg.Height = itemSize(meta, e.ItemID)
+	}
+	if err := opts.checkSize(cfg.Width, cfg.Height); err != nil {
+		return nil, image.Config{}, err
+	}
+
+	if configOnly {
+		if _, prem := alphaItem(meta, e.ItemID); prem {
+			cfg.ColorModel = color.RGBAModel
+		}
+		return nil, cfg, nil
+	}
+
+	img, err := decodeItemImage(ctx, data, meta, e, opts, !opts.IgnoreTransformations, 0)
+	if err != nil {
+		return nil, cfg, err
+	}
+
+	b := img.Bounds()
+	cfg.Width, cfg.Height = b.Dx(), b.Dy()
+
+	if _, prem := alphaItem(meta, e.ItemID); prem {
+		cfg.ColorModel = color.RGBAModel
+		return premultiplied(img), cfg, nil
+	}
+
+	return img, cfg, nil
+}
+
+// decodeItemImage decodes item e with its alpha plane and, if transformations is set, its transformations.
+// depth is the nesting of e in derived images.
+func decodeItemImage(ctx context.Context, data []byte, meta *isobmff.Meta, e *isobmff.ItemInfoEntry, opts *Options, transformations bool, depth int) (*image.NRGBA, error) {
+	img, err := decodeItemPixels(ctx, data, meta, e, opts, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	if aid, _ := alphaItem(meta, e.ItemID); aid != 0 {
+		ae := meta.Item(aid)
+		if ae == nil {
+			return nil, itemError(StageParse, CodeInvalidInput, "item %d: no alpha item %d", e.ItemID, aid)
+		}
+
+		alpha, err := decodeItemPixels(ctx, data, meta, ae, opts, depth)
+		if err != nil {
+			return nil, err
+		}
+		setAlpha(img, alpha)
+	}
+
+	if transformations {
+		img = transform(img, meta.ItemProperties(e.ItemID))
+	}
+
+	return img, nil
+}
+
+// decodeItemPixels decodes the coded or derived item e without applying its transformations.
+func decodeItemPixels(ctx context.Context, data []byte, meta *isobmff.Meta, e *isobmff.ItemInfoEntry, opts *Options, depth int) (*image.NRGBA, error) {
+	switch e.ItemType {
+	case "hvc1":
+		return decodeCodedItem(ctx, data, meta, e.ItemID, opts)
+	case "grid":
+		return decodeGridItem(ctx, data, meta, e.ItemID, opts)
+	case "iovl":
+		return decodeOverlayItem(ctx, data, meta, e.ItemID, opts, depth)
+	case "iden":
+		return decodeIdentityItem(ctx, data, meta, e.ItemID, opts, depth)
+	}
+
+	return nil, itemError(StageParse, CodeUnsupportedFeature, "item %d: unsupported item type %s", e.ItemID, e.ItemType)
+}
+
+// decodeCodedItem decodes a single hvc1 item, cropped to its ispe size, by feeding its parameter sets
+// and data to the WASM video decoder as an Annex-B stream.
+func decodeCodedItem(ctx context.Context, data []byte, meta *isobmff.Meta, id uint32, opts *Options) (*image.NRGBA, error) {
+	annexb, err := itemAnnexB(data, meta, id)
+	if err != nil {
+		return nil, err
+	}
+
+	frames, w, h, err := decodeSequence(ctx, annexb, opts)
+	if err != nil {
+		return nil, err
+	}
+	// The coded size comes from the bitstream, which may not match ispe.
+	if err := opts.checkSize(w, h); err != nil {
+		return nil, err
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	copy(img.Pix, frames[0])
+
+	if ispe, ok := meta.ItemProperty(id, "ispe").(*isobmff.ImageSpatialExtents); ok {
+		r := image.Rect(0, 0, int(ispe.Width), int(ispe.Height))
+		if r.In(img.Rect) && r != img.Rect {
+			img = img.SubImage(r).(*image.NRGBA)
+		}
+	}
+
+	return img, nil
+}
+
+// itemAnnexB returns the hvc1 item id as an Annex-B stream: its parameter sets followed by its data.
+func itemAnnexB(data []byte, meta *isobmff.Meta, id uint32) ([]byte, error) {
+	hvcC, ok := meta.ItemProperty(id, "hvcC").(*isobmff.HEVCConfig)
+	if !ok {
+		return nil, itemError(StageParse, CodeInvalidInput, "item %d: no hvcC", id)
+	}
+
+	payload, err := meta.ReadItem(bytes.NewReader(data), id)
+	if err != nil {
+		return nil, itemError(StageParse, CodeInvalidInput, "item %d: %v", id, err)
+	}
+
+	info := &seqInfo{
+		nalLenSize: hvcC.NALLengthSize,
+		params:     hvcC.ParameterSets(),
+		samples:    []seqSample{{offset: 0, size: int64(len(payload))}},
+	}
+
+	return info.annexB(payload), nil
+}
+
+// checkItemData rejects a file the WASM decoder is given whole, parsed as f with error perr, when it cannot be
+// parsed or its items locate data past its end, as in a truncated file. The decoder reports neither as a
+// parse error, and decodes some truncated files.
+func checkItemData(f *isobmff.File, perr error, size int) error {
+	if perr != nil {
+		return itemError(StageParse, CodeInvalidInput, "%v", perr)
+	}
+	if f.Meta == nil || f.Meta.Location == nil {
+		return itemError(StageParse, CodeInvalidInput, "no meta box")
+	}
+
+	for _, l := range f.Meta.Location.Items {
+		if l.ConstructionMethod != isobmff.ConstructionFile || l.DataReferenceIndex != 0 {
+			continue
+		}
+
+		for _, x := range l.Extents {
+			off := l.BaseOffset + x.Offset
+			if off < l.BaseOffset || off > uint64(size) || x.Length > uint64(size)-off {
+				return itemError(StageParse, CodeInvalidInput, "item %d: extent past the end of the file", l.ItemID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// itemError returns a WASM-backend DecodeError for a file the Go item decoder cannot handle.
+func itemError(stage Stage, code int, format string, args ...any) *DecodeError {
+	return &DecodeError{Backend: "wasm", Stage: stage, Code: code, Message: fmt.Sprintf(format, args...)}
+}
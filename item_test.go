@@ -0,0 +1,173 @@
+package heic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"slices"
+	"testing"
+)
+
+func TestItems(t *testing.T) {
+	items, err := Items(bytes.NewReader(testHeic))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 10 {
+		t.Fatalf("items = %d, want 10", len(items))
+	}
+
+	for _, it := range items[:9] {
+		if it.Type != "hvc1" || !it.Hidden || it.Primary || it.Width != 512 || it.Height != 512 {
+			t.Errorf("tile = %+v", it)
+		}
+	}
+
+	grid := items[9]
+	if grid.ID != 10 || grid.Type != "grid" || grid.Hidden || !grid.Primary || grid.Width != 1346 || grid.Height != 1346 {
+		t.Errorf("grid = %+v", grid)
+	}
+
+	// Exif and XMP items are not images.
+	items, err = Items(bytes.NewReader(testHeic8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].ID != 1 || !items[0].Primary {
+		t.Errorf("items = %+v", items)
+	}
+
+	// Dimensions are reported after rotation.
+	items, err = Items(bytes.NewReader(testHeicExif))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Width != 480 || items[0].Height != 640 {
+		t.Errorf("rotated item = %+v, want 480x640", items)
+	}
+
+	// A tone-mapped image lists its base image and gain map as inputs.
+	items, err = Items(bytes.NewReader(testGainMapISO))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 3 || items[2].Type != "tmap" || !slices.Equal(items[2].Inputs, []uint32{1, 2}) {
+		t.Errorf("tmap items = %+v", items)
+	}
+
+	if _, err := Items(bytes.NewReader(testAnim)); !errors.Is(err, ErrNoMeta) {
+		t.Errorf("sequence: err = %v, want ErrNoMeta", err)
+	}
+}
+
+func TestDecodeItem(t *testing.T) {
+	testBackends(t, func(t *testing.T, backend Backend) {
+		img, err := DecodeWithOptions(bytes.NewReader(testHeic), &Options{Backend: backend, ItemID: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds() != image.Rect(0, 0, 1346, 1346) {
+			t.Errorf("grid bounds = %v", img.Bounds())
+		}
+
+		cfg, err := DecodeConfigWithOptions(bytes.NewReader(testHeic), &Options{Backend: backend, ItemID: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Width != 1346 || cfg.Height != 1346 {
+			t.Errorf("config = %dx%d", cfg.Width, cfg.Height)
+		}
+
+		_, err = DecodeWithOptions(bytes.NewReader(testHeic), &Options{Backend: backend, ItemID: 11})
+		if !errors.Is(err, ErrNoItem) {
+			t.Errorf("Exif item: err = %v, want ErrNoItem", err)
+		}
+
+		_, err = DecodeWithOptions(bytes.NewReader(testHeic), &Options{Backend: backend, ItemID: 10, MaxPixels: 1000})
+		if !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("limit: err = %v, want ErrLimitExceeded", err)
+		}
+	})
+
+	// A hidden tile is not a top-level image for libheif, so Auto decodes it via WASM.
+	img, err := DecodeItem(bytes.NewReader(testHeic), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 512, 512) {
+		t.Errorf("tile bounds = %v", img.Bounds())
+	}
+
+	// The size limit applies to the coded size, also when ispe claims less.
+	small := bytes.Clone(testHeic8)
+	ispe := bytes.Index(small, []byte("ispe")) + 8
+	binary.BigEndian.PutUint32(small[ispe:], 16)
+	binary.BigEndian.PutUint32(small[ispe+4:], 16)
+	_, err = DecodeWithOptions(bytes.NewReader(small), &Options{Backend: BackendWASM, ItemID: 1, MaxPixels: 1000})
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("coded size limit: err = %v, want ErrLimitExceeded", err)
+	}
+
+	if err := Dynamic(); err == nil {
+		// Only recent libheif versions resolve a tile.
+		img, err := DecodeWithOptions(bytes.NewReader(testHeic), &Options{Backend: BackendDynamic, ItemID: 1})
+		var de *DecodeError
+		switch {
+		case err == nil && img.Bounds() != image.Rect(0, 0, 512, 512):
+			t.Errorf("dynamic tile bounds = %v", img.Bounds())
+		case err != nil && (!errors.As(err, &de) || !de.Unsupported()):
+			t.Errorf("dynamic tile: err = %v, want unsupported DecodeError", err)
+		}
+
+		// A depth map is an auxiliary image, which libheif resolves through its main image.
+		img, err = DecodeWithOptions(bytes.NewReader(testDepth), &Options{Backend: BackendDynamic, ItemID: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := DecodeWithOptions(bytes.NewReader(testDepth), &Options{Backend: BackendWASM, ItemID: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds() != want.Bounds() {
+			t.Errorf("dynamic depth bounds = %v, want %v", img.Bounds(), want.Bounds())
+		}
+	}
+}
+
+func TestDecodeItemMatchesPrimary(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+		id   uint32
+	}{
+		{"grid", testHeic, 10},
+		{"rotated", testHeicExif, 1},
+		{"gray", testGray, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			want, err := DecodeWithOptions(bytes.NewReader(tc.data), &Options{Backend: BackendWASM})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := DecodeWithOptions(bytes.NewReader(tc.data), &Options{Backend: BackendWASM, ItemID: tc.id})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w, g := want.(*image.NRGBA), got.(*image.NRGBA)
+			if w.Rect != g.Rect {
+				t.Fatalf("bounds = %v, want %v", g.Rect, w.Rect)
+			}
+			for y := 0; y < w.Rect.Dy(); y++ {
+				for x := 0; x < w.Rect.Dx(); x++ {
+					if w.NRGBAAt(x, y) != g.NRGBAAt(x, y) {
+						t.Fatalf("pixel %d,%d = %v, want %v", x, y, g.NRGBAAt(x, y), w.NRGBAAt(x, y))
+					}
+				}
+			}
+		})
+	}
+}
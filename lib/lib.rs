@@ -1,9 +1,49 @@
 use std::alloc::{alloc, dealloc, Layout};
+use std::cell::RefCell;
+use std::fmt::Display;
 
 use heic::{DecoderConfig, ImageInfo, PixelLayout};
 
 const HDR: usize = 8;
 
+// Stages and codes reported by last_error; codes follow libheif's heif_error_code numbering.
+const STAGE_PARSE: u32 = 0;
+const STAGE_DECODE: u32 = 1;
+const STAGE_COLOR_CONVERSION: u32 = 2;
+
+const CODE_INVALID_INPUT: u32 = 2;
+const CODE_UNSUPPORTED_FEATURE: u32 = 4;
+const CODE_DECODER_PLUGIN: u32 = 7;
+
+thread_local! {
+    static LAST_ERROR: RefCell<(u32, u32, String)> = RefCell::new((0, 0, String::new()));
+}
+
+fn set_error(stage: u32, code: u32, err: impl Display) {
+    let msg = err.to_string();
+    let code = if msg.to_ascii_lowercase().contains("unsupported") {
+        CODE_UNSUPPORTED_FEATURE
+    } else {
+        code
+    };
+    LAST_ERROR.with(|e| *e.borrow_mut() = (stage, code, msg));
+}
+
+/// Returns the message of the last failed call and writes its length, stage and code to info.
+/// The message is owned by the module and valid until the next call.
+#[no_mangle]
+pub extern "C" fn last_error(info: *mut u32) -> *const u8 {
+    LAST_ERROR.with(|e| {
+        let e = e.borrow();
+        unsafe {
+            *info.add(0) = e.2.len() as u32;
+            *info.add(1) = e.0;
+            *info.add(2) = e.1;
+        }
+        e.2.as_ptr()
+    })
+}
+
 #[no_mangle]
 pub extern "C" fn malloc(size: usize) -> *mut u8 {
     if size == 0 {
@@ -43,14 +83,24 @@ pub extern "C" fn decode(in_ptr: *const u8, in_len: i32, config_only: i32, info:
                 *info.add(0) = i.width;
                 *info.add(1) = i.height;
             },
-            Err(_) => unsafe { *info.add(0) = 0 },
+            Err(e) => {
+                set_error(STAGE_PARSE, CODE_INVALID_INPUT, e);
+                unsafe { *info.add(0) = 0 }
+            }
         }
         return std::ptr::null_mut();
     }
 
     let out = match DecoderConfig::new().decode(input, PixelLayout::Rgba8) {
         Ok(o) => o,
-        Err(_) => return std::ptr::null_mut(),
+        Err(e) => {
+            // The header parses for a file that fails later in the HEVC decoder.
+            match ImageInfo::from_bytes(input) {
+                Ok(_) => set_error(STAGE_DECODE, CODE_DECODER_PLUGIN, e),
+                Err(_) => set_error(STAGE_PARSE, CODE_INVALID_INPUT, e),
+            }
+            return std::ptr::null_mut();
+        }
     };
 
     unsafe {
@@ -74,10 +124,14 @@ pub extern "C" fn decode_sequence(in_ptr: *const u8, in_len: i32, info: *mut u32
     let mut dec = heic::VideoDecoder::new(16);
     let frames = match dec.decode_annex_b(input) {
         Ok(f) => f,
-        Err(_) => return std::ptr::null_mut(),
+        Err(e) => {
+            set_error(STAGE_DECODE, CODE_DECODER_PLUGIN, e);
+            return std::ptr::null_mut();
+        }
     };
 
     if frames.is_empty() {
+        set_error(STAGE_DECODE, CODE_DECODER_PLUGIN, "no frames decoded");
         unsafe { *info.add(2) = 0 };
         return std::ptr::null_mut();
     }
@@ -86,7 +140,10 @@ pub extern "C" fn decode_sequence(in_ptr: *const u8, in_len: i32, info: *mut u32
     for f in &frames {
         match f.to_rgba() {
             Ok(r) => buffers.push(r),
-            Err(_) => return std::ptr::null_mut(),
+            Err(e) => {
+                set_error(STAGE_COLOR_CONVERSION, CODE_DECODER_PLUGIN, e);
+                return std::ptr::null_mut();
+            }
         }
     }
 
@@ -112,3 +169,68 @@ pub extern "C" fn decode_sequence(in_ptr: *const u8, in_len: i32, info: *mut u32
 
     p
 }
+
+/// Decodes the first frame of an Annex-B stream and returns its cropped Y, Cb and Cr planes, one after
+/// the other, scaled to 8 bits; a monochrome frame has only Y. Writes the width, height and chroma format
+/// (0 monochrome, 1 4:2:0, 2 4:2:2, 3 4:4:4) to info.
+#[no_mangle]
+pub extern "C" fn decode_planes(in_ptr: *const u8, in_len: i32, info: *mut u32) -> *mut u8 {
+    let input = unsafe { std::slice::from_raw_parts(in_ptr, in_len as usize) };
+
+    let mut dec = heic::VideoDecoder::new(16);
+    let frames = match dec.decode_annex_b(input) {
+        Ok(f) => f,
+        Err(e) => {
+            set_error(STAGE_DECODE, CODE_DECODER_PLUGIN, e);
+            return std::ptr::null_mut();
+        }
+    };
+
+    let f = match frames.first() {
+        Some(f) => f,
+        None => {
+            set_error(STAGE_DECODE, CODE_DECODER_PLUGIN, "no frames decoded");
+            return std::ptr::null_mut();
+        }
+    };
+
+    let format = f.chroma_format as usize;
+    let (sx, sy) = match format {
+        1 => (2, 2),
+        2 => (2, 1),
+        _ => (1, 1),
+    };
+
+    let (w, h) = (f.cropped_width() as usize, f.cropped_height() as usize);
+    let (cw, ch) = if format == 0 { (0, 0) } else { ((w + sx - 1) / sx, (h + sy - 1) / sy) };
+    let (left, top) = (f.crop_left as usize, f.crop_top as usize);
+    let shift = f.bit_depth.saturating_sub(8);
+
+    let stride = f.width as usize;
+    let cstride = (stride + sx - 1) / sx;
+
+    let mut out: Vec<u8> = Vec::with_capacity(w * h + 2 * cw * ch);
+    for y in 0..h {
+        let row = &f.y_plane[(top + y) * stride + left..];
+        out.extend(row[..w].iter().map(|&v| (v >> shift) as u8));
+    }
+    for plane in [&f.cb_plane, &f.cr_plane] {
+        for y in 0..ch {
+            let row = &plane[(top / sy + y) * cstride + left / sx..];
+            out.extend(row[..cw].iter().map(|&v| (v >> shift) as u8));
+        }
+    }
+
+    unsafe {
+        *info.add(0) = w as u32;
+        *info.add(1) = h as u32;
+        *info.add(2) = format as u32;
+    }
+
+    let p = malloc(out.len());
+    if p.is_null() {
+        return p;
+    }
+    unsafe { std::ptr::copy_nonoverlapping(out.as_ptr(), p, out.len()) };
+    p
+}
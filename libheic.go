@@ -17,6 +17,7 @@ type module struct {
 	elements [][]any
 	memory   []byte
 	maxMem   int64
+	exceeded bool
 	g0       int32
 	g1       int32
 	g2       int32
@@ -85119,7 +85120,7 @@ func (m *module) fn314(v0, v1, v2 int32) {
 				p1 = 1
 			}
 			v2 = t0 + p1
-			t2 := int32(memory_grow(&m.memory, int64(v2), m.maxMem))
+			t2 := int32(m.grow(int64(v2), m.maxMem))
 			v3 = t2
 			if v3 != i32(-1) {
 				goto l0
@@ -0,0 +1,193 @@
+package heic
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// appleMakerNoteHeader starts an Apple MakerNote, followed by its version, byte order and IFD.
+const appleMakerNoteHeader = "Apple iOS\x00"
+
+// Apple MakerNote tags
+const (
+	appleTagAccelerationVector = 0x0008
+	appleTagBurstUUID          = 0x000B
+	appleTagContentIdentifier  = 0x0011
+	appleTagHDRHeadroom        = 0x0021
+	appleTagHDRGain            = 0x0030
+)
+
+// ErrNoMakerNote is returned by DecodeMakerNote when the EXIF metadata of a HEIC image has no MakerNote.
+var ErrNoMakerNote = errors.New("heic: no maker note")
+
+// MakerNote is the EXIF MakerNote of a HEIC image. Apple MakerNotes are decoded; others, such as those of
+// Samsung, are read as a plain IFD when they are one.
+type MakerNote struct {
+	Make string    // Apple, or the Make tag of IFD0.
+	Tags []ExifTag // Every tag, in IFDApple for an Apple MakerNote or else IFDMakerNote.
+
+	// Apple
+	ContentIdentifier  string     // Pairs a Live Photo still with its video, which has the same identifier.
+	BurstUUID          string     // Shared by the photos of a burst.
+	AccelerationVector [3]float64 // Device acceleration in g, along its X, Y and Z axes.
+	HDRHeadroom        float64    // HDR headroom of the gain map, as GainMap.Headroom; 0 when unknown.
+}
+
+// DecodeMakerNote reads the MakerNote of the EXIF metadata of a HEIC image, or returns ErrNoExif or
+// ErrNoMakerNote if there is none. An unrecognised MakerNote gives a MakerNote without tags.
+func DecodeMakerNote(r io.Reader) (*MakerNote, error) {
+	tiff := exifPayload(r)
+	if tiff == nil {
+		return nil, ErrNoExif
+	}
+
+	mn, err := parseMakerNote(tiff)
+	if err != nil {
+		return nil, fmt.Errorf("heic: %w", err)
+	}
+	if mn == nil {
+		return nil, ErrNoMakerNote
+	}
+
+	return mn, nil
+}
+
+// parseMakerNote decodes the MakerNote of TIFF/EXIF data, or returns nil if there is none.
+func parseMakerNote(data []byte) (*MakerNote, error) {
+	tags, err := parseExifTags(data)
+	if err != nil {
+		return nil, err
+	}
+
+	b := exifMakerNote(tags)
+	if b == nil {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(string(b), appleMakerNoteHeader) {
+		tags := appleMakerNoteTags(b)
+
+		mn := &MakerNote{Make: "Apple", Tags: tags, HDRHeadroom: appleHeadroom(tags)}
+		for _, t := range tags {
+			switch t.ID {
+			case appleTagContentIdentifier:
+				mn.ContentIdentifier = t.Text()
+			case appleTagBurstUUID:
+				mn.BurstUUID = t.Text()
+			case appleTagAccelerationVector:
+				if f := t.Floats(); len(f) == 3 {
+					copy(mn.AccelerationVector[:], f)
+				}
+			}
+		}
+
+		return mn, nil
+	}
+
+	mn := &MakerNote{}
+	for _, t := range tags {
+		if t.IFD == IFD0 && t.ID == tagMake {
+			mn.Make = strings.TrimSpace(t.Text())
+		}
+	}
+
+	// Samsung writes the MakerNote as an IFD without a header. Models disagree on the base of its offsets,
+	// so they are taken from the start of the MakerNote, as ExifTool does.
+	mn.Tags = ifdTags(&exifReader{data: b, littleEndian: data[0] == 'I'}, IFDMakerNote, 0)
+
+	return mn, nil
+}
+
+// exifMakerNote returns the MakerNote among the tags of TIFF/EXIF data, or nil if there is none.
+func exifMakerNote(tags []ExifTag) []byte {
+	for _, t := range tags {
+		if t.IFD == IFDExif && t.ID == tagMakerNote {
+			b, _ := t.Value.([]byte)
+			return b
+		}
+	}
+
+	return nil
+}
+
+// appleMakerNoteTags returns the tags of an Apple MakerNote, whose offsets are from its start, or nil.
+func appleMakerNoteTags(mn []byte) []ExifTag {
+	if len(mn) < 16 || !strings.HasPrefix(string(mn), appleMakerNoteHeader) {
+		return nil
+	}
+
+	var reader *exifReader
+	switch string(mn[12:14]) {
+	case "MM":
+		reader = &exifReader{data: mn}
+	case "II":
+		reader = &exifReader{data: mn, littleEndian: true}
+	default:
+		return nil
+	}
+
+	return ifdTags(reader, IFDApple, 14)
+}
+
+// ifdTags returns the tags of the IFD at offset, or nil if it does not fit the data.
+func ifdTags(reader *exifReader, ifd IFD, offset int) []ExifTag {
+	if offset+1 >= len(reader.data) {
+		return nil
+	}
+
+	n := int(reader.uint16(offset))
+	if n == 0 || offset+2+n*12 > len(reader.data) {
+		return nil
+	}
+
+	var tags []ExifTag
+	for i := range n {
+		if tag, ok := reader.tag(ifd, offset+2+i*12); ok {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+// appleTagNames are the names of the tags of an Apple MakerNote, as ExifTool reports them.
+var appleTagNames = map[uint16]string{
+	0x0001: "MakerNoteVersion",
+	0x0002: "AEMatrix",
+	0x0003: "RunTime",
+	0x0004: "AEStable",
+	0x0005: "AETarget",
+	0x0006: "AEAverage",
+	0x0007: "AFStable",
+	0x0008: "AccelerationVector",
+	0x000A: "HDRImageType",
+	0x000B: "BurstUUID",
+	0x000C: "FocusDistanceRange",
+	0x000F: "OISMode",
+	0x0011: "ContentIdentifier",
+	0x0014: "ImageCaptureType",
+	0x0015: "ImageUniqueID",
+	0x0017: "LivePhotoVideoIndex",
+	0x0019: "ImageProcessingFlags",
+	0x001A: "QualityHint",
+	0x001D: "LuminanceNoiseAmplitude",
+	0x001F: "PhotosAppFeatureFlags",
+	0x0020: "ImageCaptureRequestID",
+	0x0021: "HDRHeadroom",
+	0x0023: "AFPerformance",
+	0x0025: "SceneFlags",
+	0x0026: "SignalToNoiseRatioType",
+	0x0027: "SignalToNoiseRatio",
+	0x002B: "PhotoIdentifier",
+	0x002D: "ColorTemperature",
+	0x002E: "CameraType",
+	0x002F: "FocusPosition",
+	0x0030: "HDRGain",
+	0x0038: "AFMeasuredDepth",
+	0x003D: "AFConfidence",
+	0x0040: "SemanticStyle",
+	0x0041: "SemanticStyleRenderingVer",
+	0x0042: "SemanticStylePreset",
+}
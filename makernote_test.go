@@ -0,0 +1,122 @@
+package heic
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+//go:embed testdata/samsung.heic
+var testSamsung []byte
+
+func TestDecodeMakerNote(t *testing.T) {
+	mn, err := DecodeMakerNote(bytes.NewReader(testGainMapApple))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mn.Make != "Apple" || len(mn.Tags) != 2 || mn.Tags[0].Name() != "HDRHeadroom" || mn.Tags[1].Name() != "HDRGain" {
+		t.Fatalf("maker note %+v", mn)
+	}
+	if math.Abs(mn.HDRHeadroom-math.Exp2(2.65)) > 1e-6 {
+		t.Errorf("headroom %v", mn.HDRHeadroom)
+	}
+
+	mn, err = DecodeMakerNote(bytes.NewReader(testSamsung))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mn.Make != "samsung" || len(mn.Tags) != 3 || !reflect.DeepEqual(mn.Tags[2].Value, []uint16{0, 4, 4, 4, 4}) {
+		t.Errorf("maker note %+v", mn)
+	}
+
+	if _, err := DecodeMakerNote(bytes.NewReader(testHeicExif)); !errors.Is(err, ErrNoMakerNote) {
+		t.Errorf("err = %v, want ErrNoMakerNote", err)
+	}
+	if _, err := DecodeMakerNote(bytes.NewReader(testHeic12)); !errors.Is(err, ErrNoExif) {
+		t.Errorf("err = %v, want ErrNoExif", err)
+	}
+}
+
+func TestParseMakerNote(t *testing.T) {
+	const (
+		contentID = "5D6B2C1E-0F3A-4B8C-9D7E-6A5B4C3D2E1F"
+		burstID   = "A1B2C3D4-E5F6-4789-8ABC-DEF012345678"
+	)
+
+	// Big-endian Apple MakerNote whose values follow its IFD, at offsets from its start.
+	be := binary.BigEndian
+	apple := []byte("Apple iOS\x00\x00\x01MM")
+	apple = be.AppendUint16(apple, 3)
+	for _, e := range []struct {
+		tag, typ uint16
+		count    uint32
+		offset   uint32
+	}{
+		{appleTagAccelerationVector, typeSignedRational, 3, 56},
+		{appleTagBurstUUID, typeASCIIString, 37, 80},
+		{appleTagContentIdentifier, typeASCIIString, 37, 117},
+	} {
+		apple = be.AppendUint16(apple, e.tag)
+		apple = be.AppendUint16(apple, e.typ)
+		apple = be.AppendUint32(apple, e.count)
+		apple = be.AppendUint32(apple, e.offset)
+	}
+	apple = be.AppendUint32(apple, 0)
+	for _, v := range []int32{-1, 2, -12, 100, 983, 1000} {
+		apple = be.AppendUint32(apple, uint32(v))
+	}
+	apple = append(apple, burstID+"\x00"+contentID+"\x00"...)
+
+	data, _ := buildTIFF([][]tiffEntry{
+		{
+			{tag: tagMake, typ: typeASCIIString, count: 6, value: []byte("Apple\x00")},
+			{tag: tagExifIFDPointer, typ: typeUnsignedLong, count: 1, ifd: 1},
+		},
+		{
+			{tag: tagMakerNote, typ: typeUndefined, count: uint32(len(apple)), value: apple},
+		},
+	}, 0)
+
+	mn, err := parseMakerNote(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mn.Make != "Apple" || mn.ContentIdentifier != contentID || mn.BurstUUID != burstID || mn.HDRHeadroom != 0 {
+		t.Errorf("maker note %+v", mn)
+	}
+	if want := [3]float64{-0.5, -0.12, 0.983}; mn.AccelerationVector != want {
+		t.Errorf("acceleration %v, want %v", mn.AccelerationVector, want)
+	}
+
+	// Samsung MakerNote: a plain IFD whose offsets are from its start.
+	samsung := le(uint16(2),
+		uint16(0x0001), uint16(typeUndefined), uint32(4), []byte("0100"),
+		uint16(0x0021), uint16(typeUnsignedShort), uint32(5), uint32(30),
+		uint32(0),
+		uint16(0), uint16(1), uint16(2), uint16(3), uint16(4))
+	data, _ = buildTIFF([][]tiffEntry{
+		{
+			{tag: tagMake, typ: typeASCIIString, count: 8, value: []byte("samsung\x00")},
+			{tag: tagExifIFDPointer, typ: typeUnsignedLong, count: 1, ifd: 1},
+		},
+		{
+			{tag: tagMakerNote, typ: typeUndefined, count: uint32(len(samsung)), value: samsung},
+		},
+	}, 0)
+
+	mn, err = parseMakerNote(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ExifTag{
+		{IFD: IFDMakerNote, ID: 0x0001, Type: typeUndefined, Count: 4, Value: []byte("0100")},
+		{IFD: IFDMakerNote, ID: 0x0021, Type: typeUnsignedShort, Count: 5, Value: []uint16{0, 1, 2, 3, 4}},
+	}
+	if mn.Make != "samsung" || !reflect.DeepEqual(mn.Tags, want) {
+		t.Errorf("maker note %+v", mn)
+	}
+}
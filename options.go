@@ -0,0 +1,358 @@
+package heic
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"runtime"
+)
+
+// Backend selects the decoder implementation used for a call.
+type Backend int
+
+const (
+	// BackendAuto uses the dynamic library when available (and ForceWasmMode is not set), otherwise WASM.
+	BackendAuto Backend = iota
+	// BackendWASM always uses the embedded WASM decoder.
+	BackendWASM
+	// BackendDynamic always uses the libheif dynamic library and fails when it is not available.
+	BackendDynamic
+)
+
+// Format selects the pixel format of the decoded image.
+type Format int
+
+const (
+	// FormatAuto returns the backend's native format: YCbCr, Gray or NRGBA from libheif, NRGBA from WASM.
+	FormatAuto Format = iota
+	// FormatNRGBA returns *image.NRGBA, or *image.NRGBA64 with a 16-bit depth.
+	FormatNRGBA
+	// FormatYCbCr returns *image.YCbCr; it is always 8-bit. The WASM decoder returns the decoded planes of
+	// images without transformations; otherwise, or when its module lacks decode_planes, it converts from RGBA
+	// to the chroma subsampling of the image as coded.
+	FormatYCbCr
+	// FormatGray returns *image.Gray, or *image.Gray16 with a 16-bit depth.
+	FormatGray
+	// FormatLinear returns *LinearImage: linear-light float32 samples in the primaries of ColorSpace, or of the
+	// image with ColorSpaceNone. HDR highlights are kept above 1 unless ToneMapping is set. The WASM decoder
+	// returns ErrUnsupported for HDR images, as it only outputs 8 bits.
+	FormatLinear
+)
+
+// ColorSpace selects the colour space to convert decoded colours to.
+type ColorSpace int
+
+const (
+	// ColorSpaceNone keeps the decoded colours in the colour space of the image.
+	ColorSpaceNone ColorSpace = iota
+	// ColorSpaceSRGB converts to sRGB.
+	ColorSpaceSRGB
+	// ColorSpaceLinearSRGB converts to sRGB primaries with a linear transfer function.
+	ColorSpaceLinearSRGB
+	// ColorSpaceDisplayP3 converts to Display P3: P3 primaries, D65 white and the sRGB transfer function.
+	ColorSpaceDisplayP3
+	// ColorSpaceBT2020 converts to BT.2020 primaries with the BT.709 transfer function.
+	ColorSpaceBT2020
+)
+
+// ToneMapping selects how HDR images, with the PQ or HLG transfer function, are mapped to SDR.
+type ToneMapping int
+
+const (
+	// ToneMapNone leaves HDR images as decoded, with their PQ or HLG coded values clamped to the output depth.
+	ToneMapNone ToneMapping = iota
+	// ToneMapClip clips linear light above SDR reference white.
+	ToneMapClip
+	// ToneMapReinhard compresses highlights with the extended Reinhard operator on luminance, with a
+	// 1000 cd/m² white point.
+	ToneMapReinhard
+)
+
+// Options configures a single decode call. A nil *Options decodes the same way as Decode.
+//
+// Unlike ForceWasmMode, Options are per call, so goroutines may decode with different settings concurrently.
+type Options struct {
+	// Backend selects the decoder implementation.
+	Backend Backend
+
+	// ItemID, if non-zero, decodes the image item with this ID instead of the primary image; see Items.
+	ItemID uint32
+
+	// MaxWidth and MaxHeight, if non-zero, reject images larger than the given dimensions.
+	MaxWidth  int
+	MaxHeight int
+
+	// MaxPixels, if non-zero, rejects images with more than this many pixels.
+	MaxPixels int
+
+	// MaxFrames, if non-zero, rejects image sequences with more than this many frames.
+	MaxFrames int
+
+	// MaxInputSize, if non-zero, rejects inputs larger than this many bytes.
+	MaxInputSize int64
+
+	// MaxMemoryPages, if non-zero, caps the linear memory of each WASM module instance at this many 64 KiB
+	// pages. A cap below the initial memory of the module fails with ErrLimitExceeded.
+	MaxMemoryPages uint32
+
+	// IgnoreTransformations returns the coded image without applying the irot, imir and clap properties,
+	// which Info.Transforms lists.
+	IgnoreTransformations bool
+
+	// Format selects the pixel format of the decoded image.
+	Format Format
+
+	// ColorSpace, if set, converts the decoded colours from the colour space given by the ICC profile or the
+	// nclx parameters of the image (see DecodeColorInfo) to this one, in Go for either backend. Images without
+	// colour information, or with a profile that has only lookup tables, are left as decoded, and so are HDR
+	// images without ToneMapping. A YCbCr image is converted to NRGBA first.
+	ColorSpace ColorSpace
+
+	// ToneMapping, if set, maps HDR images to SDR in ColorSpace, or sRGB with ColorSpaceNone; SDR images are not
+	// affected. Linear light is relative to SDR reference white (203 cd/m²), and HLG is rendered for a
+	// 1000 cd/m² display with the OOTF on luminance. HDR images are decoded at 16 bits for it, so the WASM
+	// decoder, which only outputs 8 bits, returns ErrUnsupported.
+	ToneMapping ToneMapping
+
+	// Concurrency is the number of grid tiles decoded at once: by as many WASM module instances, or libheif
+	// decoding threads. 0 uses runtime.GOMAXPROCS(0); 1 decodes the tiles one after the other.
+	Concurrency int
+
+	// BitDepth selects 8 (the default when 0) or 16 bits per channel. With 16, libheif keeps the full precision
+	// of 10 and 12-bit images; the WASM decoder only outputs 8 bits, so it returns ErrUnsupported for them.
+	BitDepth int
+}
+
+// ErrUnsupported is returned when the selected backend cannot honour an option.
+var ErrUnsupported = errors.New("heic: unsupported option")
+
+// ErrLimitExceeded is returned when an image or its decoding exceeds a limit set in Options.
+var ErrLimitExceeded = errors.New("heic: limit exceeded")
+
+var defaultOptions = &Options{}
+
+// validate reports invalid option values.
+func (o *Options) validate() error {
+	switch o.BitDepth {
+	case 0, 8, 16:
+	default:
+		return fmt.Errorf("heic: invalid bit depth %d", o.BitDepth)
+	}
+
+	if o.ColorSpace < ColorSpaceNone || o.ColorSpace > ColorSpaceBT2020 {
+		return fmt.Errorf("heic: invalid color space %d", o.ColorSpace)
+	}
+
+	if o.ToneMapping < ToneMapNone || o.ToneMapping > ToneMapReinhard {
+		return fmt.Errorf("heic: invalid tone mapping %d", o.ToneMapping)
+	}
+
+	if o.Concurrency < 0 {
+		return fmt.Errorf("heic: invalid concurrency %d", o.Concurrency)
+	}
+
+	if o.Format == FormatYCbCr && o.BitDepth == 16 {
+		return fmt.Errorf("heic: 16-bit depth is not available for YCbCr: %w", ErrUnsupported)
+	}
+
+	return nil
+}
+
+// auxOptions returns the options to decode the auxiliary data of item id, such as a gain or depth map, in format
+// at bitDepth: the backend, limits, transformations and concurrency of o, without its colour handling.
+func (o *Options) auxOptions(id uint32, format Format, bitDepth int) *Options {
+	return &Options{
+		Backend:               o.Backend,
+		ItemID:                id,
+		MaxWidth:              o.MaxWidth,
+		MaxHeight:             o.MaxHeight,
+		MaxPixels:             o.MaxPixels,
+		MaxFrames:             o.MaxFrames,
+		MaxInputSize:          o.MaxInputSize,
+		MaxMemoryPages:        o.MaxMemoryPages,
+		IgnoreTransformations: o.IgnoreTransformations,
+		Format:                format,
+		Concurrency:           o.Concurrency,
+		BitDepth:              bitDepth,
+	}
+}
+
+// useDynamic resolves the backend, returning an error when the dynamic library is required but missing.
+func (o *Options) useDynamic() (bool, error) {
+	switch o.Backend {
+	case BackendWASM:
+		return false, nil
+	case BackendDynamic:
+		if !dynamic {
+			return false, dynamicErr
+		}
+		return true, nil
+	default:
+		return dynamic && !ForceWasmMode, nil
+	}
+}
+
+// concurrency returns the number of grid tiles to decode at once.
+func (o *Options) concurrency() int {
+	if o.Concurrency > 0 {
+		return o.Concurrency
+	}
+
+	return runtime.GOMAXPROCS(0)
+}
+
+// hasSizeLimit reports whether the image dimensions must be known before decoding.
+func (o *Options) hasSizeLimit() bool {
+	return o.MaxWidth > 0 || o.MaxHeight > 0 || o.MaxPixels > 0
+}
+
+// checkSize rejects dimensions above MaxWidth, MaxHeight or MaxPixels.
+func (o *Options) checkSize(width, height int) error {
+	if (o.MaxWidth > 0 && width > o.MaxWidth) || (o.MaxHeight > 0 && height > o.MaxHeight) {
+		return fmt.Errorf("%w: image %dx%d exceeds %dx%d", ErrLimitExceeded, width, height, o.MaxWidth, o.MaxHeight)
+	}
+
+	if o.MaxPixels > 0 && int64(width)*int64(height) > int64(o.MaxPixels) {
+		return fmt.Errorf("%w: image %dx%d exceeds %d pixels", ErrLimitExceeded, width, height, o.MaxPixels)
+	}
+
+	return nil
+}
+
+// checkFrames rejects sequences with more than MaxFrames frames.
+func (o *Options) checkFrames(n int) error {
+	if o.MaxFrames > 0 && n > o.MaxFrames {
+		return fmt.Errorf("%w: %d frames exceed %d", ErrLimitExceeded, n, o.MaxFrames)
+	}
+
+	return nil
+}
+
+// checkSequence rejects a parsed sequence whose frame size or count exceeds the limits.
+func (o *Options) checkSequence(info *seqInfo) error {
+	if err := o.checkSize(info.width, info.height); err != nil {
+		return err
+	}
+
+	return o.checkFrames(len(info.samples))
+}
+
+// readInput reads all of r, failing with ErrLimitExceeded when it is larger than MaxInputSize.
+func (o *Options) readInput(r io.Reader) ([]byte, error) {
+	if o.MaxInputSize > 0 {
+		r = io.LimitReader(r, o.MaxInputSize+1)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("heic: read: %w", err)
+	}
+
+	if o.MaxInputSize > 0 && int64(len(data)) > o.MaxInputSize {
+		return nil, fmt.Errorf("%w: input exceeds %d bytes", ErrLimitExceeded, o.MaxInputSize)
+	}
+
+	return data, nil
+}
+
+// colorModel returns the color model of an image decoded as native with opts applied.
+func (o *Options) colorModel(native color.Model) color.Model {
+	switch o.Format {
+	case FormatNRGBA:
+		if o.BitDepth == 16 {
+			return color.NRGBA64Model
+		}
+		return color.NRGBAModel
+	case FormatYCbCr:
+		return color.YCbCrModel
+	case FormatGray:
+		if o.BitDepth == 16 {
+			return color.Gray16Model
+		}
+		return color.GrayModel
+	case FormatLinear:
+		return color.NRGBA64Model
+	}
+
+	if o.BitDepth == 16 {
+		switch native {
+		case color.GrayModel, color.Gray16Model:
+			return color.Gray16Model
+		case color.RGBAModel, color.RGBA64Model:
+			return color.RGBA64Model
+		default:
+			return color.NRGBA64Model
+		}
+	}
+
+	return native
+}
+
+// convert returns img in the format and bit depth selected by opts, copying only when needed.
+func (o *Options) convert(img image.Image) image.Image {
+	model := o.colorModel(img.ColorModel())
+	if model == img.ColorModel() {
+		return img
+	}
+
+	b := img.Bounds()
+
+	var dst draw.Image
+	switch model {
+	case color.NRGBAModel:
+		dst = image.NewNRGBA(b)
+	case color.NRGBA64Model:
+		dst = image.NewNRGBA64(b)
+	case color.RGBA64Model:
+		dst = image.NewRGBA64(b)
+	case color.GrayModel:
+		dst = image.NewGray(b)
+	case color.Gray16Model:
+		dst = image.NewGray16(b)
+	case color.YCbCrModel:
+		return toYCbCr(img, image.YCbCrSubsampleRatio444)
+	default:
+		return img
+	}
+
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+
+	return dst
+}
+
+// toYCbCr converts img to an *image.YCbCr with the given subsampling, averaging the chroma of the pixels
+// that share a chroma sample.
+func toYCbCr(img image.Image, ratio image.YCbCrSubsampleRatio) *image.YCbCr {
+	b := img.Bounds()
+	dst := image.NewYCbCr(b, ratio)
+
+	cb := make([]int, len(dst.Cb))
+	cr := make([]int, len(dst.Cr))
+	n := make([]int, len(dst.Cb))
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bb, _ := img.At(x, y).RGBA()
+			yy, u, v := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(bb>>8))
+
+			dst.Y[dst.YOffset(x, y)] = yy
+
+			i := dst.COffset(x, y)
+			cb[i] += int(u)
+			cr[i] += int(v)
+			n[i]++
+		}
+	}
+
+	for i := range n {
+		if n[i] > 0 {
+			dst.Cb[i] = uint8((cb[i] + n[i]/2) / n[i])
+			dst.Cr[i] = uint8((cr[i] + n[i]/2) / n[i])
+		}
+	}
+
+	return dst
+}
@@ -3,6 +3,7 @@
 package heic
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"io"
@@ -13,11 +14,11 @@ var (
 	dynamicErr = fmt.Errorf("heic: dynamic disabled")
 )
 
-func decodeDynamic(r io.Reader, configOnly bool) (image.Image, image.Config, error) {
+func decodeDynamic(ctx context.Context, r io.Reader, configOnly bool, opts *Options) (image.Image, image.Config, error) {
 	return nil, image.Config{}, dynamicErr
 }
 
-func decodeDynamicAll(r io.Reader) (*HEIC, error) {
+func decodeDynamicAll(ctx context.Context, r io.Reader, opts *Options) (*HEIC, error) {
 	return nil, dynamicErr
 }
 
@@ -0,0 +1,471 @@
+//go:build ignore
+
+// Command gen builds the synthetic HEIF test fixtures of testdata. Each generator is in the gen_<name>.go file
+// added with its fixtures, which describes them. Run it from the module root with the names of the generators
+// to run, e.g.:
+//
+//	go run testdata/gen*.go thumb alpha
+//
+// Most fixtures reuse the HEVC bitstreams of the original files, test8.heic (a 512x512 image), gray.heic (a
+// 512x512 gray image) and the first frame of anim.heic (176x128), in boxes written with the helpers of this
+// file; the others are encoded from synthetic planes with encode.
+//
+// The images encoded with libheif need libheif.so.1 built with the x265 encoder; they are coded lossy at the
+// highest quality in 4:2:0, as the WASM decoder does not support lossless, 4:4:4 or monochrome RExt streams.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+type item struct {
+	id     uint32
+	typ    string
+	name   string
+	ctype  string // mime content type
+	hidden bool
+	data   []byte
+	idat   bool
+	props  [][]byte // raw property boxes
+	essent []bool
+}
+
+type ref struct {
+	typ  string
+	from uint32
+	to   []uint32
+}
+
+type file struct {
+	brands  []string
+	primary uint32
+	items   []item
+	refs    []ref
+	groups  [][]byte // raw entity group boxes
+	extra   [][]byte // extra top-level boxes after meta (e.g. moov)
+}
+
+func box(typ string, payload ...[]byte) []byte {
+	var b bytes.Buffer
+	n := 8
+	for _, p := range payload {
+		n += len(p)
+	}
+	binary.Write(&b, binary.BigEndian, uint32(n))
+	b.WriteString(typ)
+	for _, p := range payload {
+		b.Write(p)
+	}
+	return b.Bytes()
+}
+
+func full(typ string, v uint8, flags uint32, payload ...[]byte) []byte {
+	h := make([]byte, 4)
+	binary.BigEndian.PutUint32(h, uint32(v)<<24|flags)
+	return box(typ, append([][]byte{h}, payload...)...)
+}
+
+func u16(v int) []byte        { b := make([]byte, 2); binary.BigEndian.PutUint16(b, uint16(v)); return b }
+func u32(v uint32) []byte     { b := make([]byte, 4); binary.BigEndian.PutUint32(b, v); return b }
+func cat(bs ...[]byte) []byte { return bytes.Join(bs, nil) }
+
+func (f *file) meta(mdatStart uint32) []byte {
+	var infes [][]byte
+	for _, it := range f.items {
+		var fl uint32
+		if it.hidden {
+			fl = 1
+		}
+		p := cat(u16(int(it.id)), u16(0), []byte(it.typ), []byte(it.name), []byte{0})
+		if it.typ == "mime" {
+			p = cat(p, []byte(it.ctype), []byte{0})
+		}
+		infes = append(infes, full("infe", 2, fl, p))
+	}
+	iinf := full("iinf", 0, 0, append([][]byte{u16(len(f.items))}, infes...)...)
+
+	// iloc v1, offset 4, length 4, base 0, index 0
+	var iloc bytes.Buffer
+	iloc.Write([]byte{0x44, 0x00})
+	iloc.Write(u16(len(f.items)))
+	off := mdatStart + 8
+	idatOff := uint32(0)
+	for _, it := range f.items {
+		iloc.Write(u16(int(it.id)))
+		if it.idat {
+			iloc.Write(u16(1))
+		} else {
+			iloc.Write(u16(0))
+		}
+		iloc.Write(u16(0)) // data ref
+		iloc.Write(u16(1))
+		if it.idat {
+			iloc.Write(u32(idatOff))
+			idatOff += uint32(len(it.data))
+		} else {
+			iloc.Write(u32(off))
+			off += uint32(len(it.data))
+		}
+		iloc.Write(u32(uint32(len(it.data))))
+	}
+	ilocBox := full("iloc", 1, 0, iloc.Bytes())
+
+	var refs [][]byte
+	for _, r := range f.refs {
+		p := cat(u16(int(r.from)), u16(len(r.to)))
+		for _, t := range r.to {
+			p = cat(p, u16(int(t)))
+		}
+		refs = append(refs, box(r.typ, p))
+	}
+
+	var ipco [][]byte
+	var ipma bytes.Buffer
+	ipma.Write(u32(uint32(len(f.items))))
+	for _, it := range f.items {
+		ipma.Write(u16(int(it.id)))
+		ipma.WriteByte(byte(len(it.props)))
+		for i, p := range it.props {
+			ipco = append(ipco, p)
+			v := byte(len(ipco))
+			if i < len(it.essent) && it.essent[i] {
+				v |= 0x80
+			}
+			ipma.WriteByte(v)
+		}
+	}
+	iprp := box("iprp", box("ipco", ipco...), full("ipma", 0, 0, ipma.Bytes()))
+
+	var idat []byte
+	for _, it := range f.items {
+		if it.idat {
+			idat = append(idat, it.data...)
+		}
+	}
+
+	children := [][]byte{
+		full("hdlr", 0, 0, u32(0), []byte("pict"), make([]byte, 12), []byte{0}),
+		full("pitm", 0, 0, u16(int(f.primary))),
+		iinf,
+		ilocBox,
+	}
+	if len(refs) > 0 {
+		children = append(children, full("iref", 0, 0, refs...))
+	}
+	children = append(children, iprp)
+	if idat != nil {
+		children = append(children, box("idat", idat))
+	}
+	if len(f.groups) > 0 {
+		children = append(children, box("grpl", f.groups...))
+	}
+	return full("meta", 0, 0, children...)
+}
+
+func (f *file) build() []byte {
+	var brands [][]byte
+	for _, b := range f.brands {
+		brands = append(brands, []byte(b))
+	}
+	ftyp := box("ftyp", append([][]byte{[]byte(f.brands[0]), u32(0)}, brands...)...)
+
+	var extra []byte
+	for _, e := range f.extra {
+		extra = append(extra, e...)
+	}
+
+	meta := f.meta(0)
+	start := uint32(len(ftyp) + len(meta) + len(extra))
+	meta = f.meta(start)
+
+	var mdat [][]byte
+	for _, it := range f.items {
+		if !it.idat {
+			mdat = append(mdat, it.data)
+		}
+	}
+
+	return cat(ftyp, meta, extra, box("mdat", mdat...))
+}
+
+// source is an existing file with its parsed tree.
+type source struct {
+	data []byte
+	f    *isobmff.File
+}
+
+func load(name string) source {
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		panic(err)
+	}
+	f, err := isobmff.Parse(data)
+	if err != nil {
+		panic(err)
+	}
+	return source{data, f}
+}
+
+func (s source) raw(b isobmff.Box) []byte {
+	return s.data[b.Offset:b.End()]
+}
+
+// prop returns the raw bytes of property typ of item id.
+func (s source) prop(id uint32, typ string) []byte {
+	p := s.f.Meta.ItemProperty(id, typ)
+	if p == nil {
+		panic(fmt.Sprintf("no %s on %d", typ, id))
+	}
+	return s.raw(p.Header())
+}
+
+func (s source) itemData(id uint32) []byte {
+	b, err := s.f.Meta.ReadItem(bytes.NewReader(s.data), id)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// frame returns the hvcC box and the first sample of the pict track.
+func (s source) frame() ([]byte, []byte) {
+	t := s.f.Movie.Track("pict")
+	smp := t.Samples(len(s.data))[0]
+	return s.raw(t.SampleEntry.HEVCConfig.Box), s.data[smp.Offset : smp.Offset+smp.Size]
+}
+
+func ispe(w, h int) []byte { return full("ispe", 0, 0, u32(uint32(w)), u32(uint32(h))) }
+
+func auxC(urn string) []byte { return full("auxC", 0, 0, []byte(urn), []byte{0}) }
+
+func nclx(primaries, transfer, matrix int, full bool) []byte {
+	var f byte
+	if full {
+		f = 0x80
+	}
+	return box("colr", []byte("nclx"), u16(primaries), u16(transfer), u16(matrix), []byte{f})
+}
+
+// heicFile returns a heic file of items, with item 1 as the primary image.
+func heicFile(items ...item) *file {
+	return &file{brands: []string{"heic", "mif1", "heic", "miaf"}, primary: 1, items: items}
+}
+
+// t8Primary returns the 512x512 image of test8.heic as item 1.
+func t8Primary() item {
+	t8 := load("test8.heic")
+	return item{id: 1, typ: "hvc1", data: t8.itemData(1), props: [][]byte{t8.prop(1, "hvcC"), t8.prop(1, "ispe"), t8.prop(1, "pixi")}, essent: []bool{true}}
+}
+
+// grayImage returns the 512x512 image of gray.heic as item id.
+func grayImage(id uint32) item {
+	gray := load("gray.heic")
+	return item{id: id, typ: "hvc1", data: gray.itemData(1), props: [][]byte{gray.prop(1, "hvcC"), gray.prop(1, "ispe")}, essent: []bool{true}}
+}
+
+// animFrame returns the 176x128 first frame of anim.heic as item id.
+func animFrame(id uint32) item {
+	hvcC, sample := load("anim.heic").frame()
+	return item{id: id, typ: "hvc1", data: sample, props: [][]byte{hvcC, ispe(176, 128)}, essent: []bool{true}}
+}
+
+// auxImage returns the image item it as a hidden auxiliary image with the auxC property aux.
+func auxImage(it item, aux []byte) item {
+	it.hidden = true
+	it.props = append(it.props[:len(it.props):len(it.props)], aux)
+	it.essent = []bool{true, false, true}
+	return it
+}
+
+// exifItem returns an Exif item with the payload exif, which starts with the offset of the TIFF header.
+func exifItem(id uint32, exif []byte) item {
+	return item{id: id, typ: "Exif", hidden: true, data: exif}
+}
+
+// xmpItem returns an XMP item with the packet xmp.
+func xmpItem(id uint32, xmp string) item {
+	return item{id: id, typ: "mime", ctype: "application/rdf+xml", hidden: true, data: []byte(xmp)}
+}
+
+func write(name string, b []byte) {
+	if err := os.WriteFile("testdata/"+name, b, 0o644); err != nil {
+		panic(err)
+	}
+	if _, err := isobmff.Parse(b); err != nil {
+		panic(err)
+	}
+}
+
+var generators = map[string]func(){}
+
+func main() {
+	for _, name := range os.Args[1:] {
+		gen, ok := generators[name]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "gen: unknown generator %s\n", name)
+			os.Exit(2)
+		}
+		gen()
+	}
+}
+
+// planes is an image for encode: a luma and optional chroma planes of the given bit depth, with the chroma
+// planes at full resolution. A chroma of 0 encodes a monochrome image.
+type planes struct {
+	w, h, depth int
+	chroma      int // heif_chroma: 0 monochrome, 1 4:2:0, 3 4:4:4
+	y, cb, cr   []uint16
+
+	primaries, transfer, matrix int
+	fullRange                   bool
+}
+
+type heifError struct {
+	code, subcode uint32
+	message       *byte
+}
+
+func (e heifError) check(what string) {
+	if e.code != 0 {
+		panic(fmt.Sprintf("libheif: %s: %d/%d %s", what, e.code, e.subcode, cstring(e.message)))
+	}
+}
+
+func cstring(p *byte) string {
+	var b []byte
+	for ; p != nil && *p != 0; p = (*byte)(unsafe.Add(unsafe.Pointer(p), 1)) {
+		b = append(b, *p)
+	}
+	return string(b)
+}
+
+// encode encodes p at the highest quality with the HEVC encoder of libheif, which must be built with x265, and
+// returns the file. It is not lossless, since the WASM decoder does not support lossless coding. The 4:2:0 chroma
+// planes are subsampled by taking every other sample.
+func encode(p planes) []byte {
+	lib, err := purego.Dlopen("libheif.so.1", purego.RTLD_NOW|purego.RTLD_GLOBAL)
+	if err != nil {
+		panic(err)
+	}
+
+	var (
+		contextAlloc        func() uintptr
+		getEncoderForFormat func(uintptr, int, *uintptr) heifError
+		setQuality          func(uintptr, int) heifError
+		setParameterString  func(uintptr, string, string) heifError
+		imageCreate         func(int, int, int, int, *uintptr) heifError
+		addPlane            func(uintptr, int, int, int, int) heifError
+		getPlane            func(uintptr, int, *int) *byte
+		nclxAlloc           func() unsafe.Pointer
+		nclxSetPrimaries    func(unsafe.Pointer, uint16) heifError
+		nclxSetTransfer     func(unsafe.Pointer, uint16) heifError
+		nclxSetMatrix       func(unsafe.Pointer, uint16) heifError
+		setNclx             func(uintptr, unsafe.Pointer) heifError
+		encodeImage         func(uintptr, uintptr, uintptr, uintptr, *uintptr) heifError
+		writeToFile         func(uintptr, string) heifError
+	)
+	purego.RegisterLibFunc(&contextAlloc, lib, "heif_context_alloc")
+	purego.RegisterLibFunc(&getEncoderForFormat, lib, "heif_context_get_encoder_for_format")
+	purego.RegisterLibFunc(&setQuality, lib, "heif_encoder_set_lossy_quality")
+	purego.RegisterLibFunc(&setParameterString, lib, "heif_encoder_set_parameter_string")
+	purego.RegisterLibFunc(&imageCreate, lib, "heif_image_create")
+	purego.RegisterLibFunc(&addPlane, lib, "heif_image_add_plane")
+	purego.RegisterLibFunc(&getPlane, lib, "heif_image_get_plane")
+	purego.RegisterLibFunc(&nclxAlloc, lib, "heif_nclx_color_profile_alloc")
+	purego.RegisterLibFunc(&nclxSetPrimaries, lib, "heif_nclx_color_profile_set_color_primaries")
+	purego.RegisterLibFunc(&nclxSetTransfer, lib, "heif_nclx_color_profile_set_transfer_characteristics")
+	purego.RegisterLibFunc(&nclxSetMatrix, lib, "heif_nclx_color_profile_set_matrix_coefficients")
+	purego.RegisterLibFunc(&setNclx, lib, "heif_image_set_nclx_color_profile")
+	purego.RegisterLibFunc(&encodeImage, lib, "heif_context_encode_image")
+	purego.RegisterLibFunc(&writeToFile, lib, "heif_context_write_to_file")
+
+	ctx := contextAlloc()
+
+	var enc uintptr
+	getEncoderForFormat(ctx, 1, &enc).check("encoder")
+	setQuality(enc, 100).check("quality")
+	if p.chroma == 3 {
+		setParameterString(enc, "chroma", "444").check("chroma")
+	}
+
+	colorspace := 0
+	if p.chroma == 0 {
+		colorspace = 2
+	}
+	var img uintptr
+	imageCreate(p.w, p.h, colorspace, p.chroma, &img).check("image")
+
+	fill := func(channel int, samples []uint16) {
+		w, h, step := p.w, p.h, 1
+		if p.chroma == 1 && (channel == 1 || channel == 2) {
+			w, h, step = (p.w+1)/2, (p.h+1)/2, 2
+		}
+		addPlane(img, channel, w, h, p.depth).check("plane")
+
+		var stride int
+		base := unsafe.Pointer(getPlane(img, channel, &stride))
+		for y := range h {
+			for x := range w {
+				v := samples[y*step*p.w+x*step]
+				if p.depth > 8 {
+					*(*uint16)(unsafe.Add(base, y*stride+2*x)) = v
+				} else {
+					*(*uint8)(unsafe.Add(base, y*stride+x)) = uint8(v)
+				}
+			}
+		}
+	}
+	fill(0, p.y)
+	if p.chroma != 0 {
+		fill(1, p.cb)
+		fill(2, p.cr)
+	}
+
+	if p.primaries != 0 {
+		nclx := nclxAlloc()
+		nclxSetPrimaries(nclx, uint16(p.primaries)).check("primaries")
+		nclxSetTransfer(nclx, uint16(p.transfer)).check("transfer")
+		nclxSetMatrix(nclx, uint16(p.matrix)).check("matrix")
+		// struct heif_color_profile_nclx: version, then three enums, then full_range_flag.
+		*(*uint8)(unsafe.Add(nclx, 16)) = uint8(boolInt(p.fullRange))
+		setNclx(img, nclx).check("nclx")
+	}
+
+	var handle uintptr
+	encodeImage(ctx, img, enc, 0, &handle).check("encode")
+
+	name := filepath.Join(os.TempDir(), "gen.heic")
+	writeToFile(ctx, name).check("write")
+	defer os.Remove(name)
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// parse parses a file built by encode as a source.
+func parse(data []byte) source {
+	f, err := isobmff.Parse(data)
+	if err != nil {
+		panic(err)
+	}
+	return source{data, f}
+}
@@ -0,0 +1,59 @@
+//go:build ignore
+
+package main
+
+func alphaFile(prem bool) *file {
+	f := heicFile(t8Primary(), auxImage(grayImage(2), auxC("urn:mpeg:hevc:2015:auxid:1")))
+	f.refs = []ref{{"auxl", 2, []uint32{1}}}
+	if prem {
+		f.refs = append(f.refs, ref{"prem", 1, []uint32{2}})
+	}
+	return f
+}
+
+// alpha builds alpha.heic, test8.heic with gray.heic as its alpha plane, and alpha_prem.heic, a 128x128 image
+// with premultiplied alpha, encoded with libheif.
+func init() {
+	generators["alpha"] = func() {
+		write("alpha.heic", alphaFile(false).build())
+		write("alpha_prem.heic", premultipliedFile())
+	}
+}
+
+// premultipliedFile encodes a 128x128 image with premultiplied alpha: a horizontal alpha ramp over a vertical
+// color ramp scaled to at most 200/255 of alpha, and black below alpha 32, so coding errors keep color <= alpha.
+// The alpha plane is coded as 4:2:0 with neutral chroma, as the WASM decoder does not decode monochrome RExt.
+func premultipliedFile() []byte {
+	const n = 128
+	color := planes{w: n, h: n, depth: 8, chroma: 1, primaries: 1, transfer: 13, matrix: 6, fullRange: true}
+	alpha := planes{w: n, h: n, depth: 8, chroma: 1}
+	for y := range n {
+		for x := range n {
+			a := float64(x * 255 / (n - 1))
+			r, g, b := 200.0, 200.0*float64(y)/(n-1), 200.0*float64(n-1-y)/(n-1)
+			if a < 32 {
+				r, g, b = 0, 0, 0
+			}
+			r, g, b = r*a/255, g*a/255, b*a/255
+
+			l := 0.299*r + 0.587*g + 0.114*b
+			color.y = append(color.y, uint16(l+0.5))
+			color.cb = append(color.cb, uint16(128+(b-l)/1.772+0.5))
+			color.cr = append(color.cr, uint16(128+(r-l)/1.402+0.5))
+
+			alpha.y = append(alpha.y, uint16(a))
+			alpha.cb = append(alpha.cb, 128)
+			alpha.cr = append(alpha.cr, 128)
+		}
+	}
+
+	c, a := parse(encode(color)), parse(encode(alpha))
+	cid, aid := c.f.Meta.PrimaryItemID(), a.f.Meta.PrimaryItemID()
+
+	f := heicFile(
+		item{id: 1, typ: "hvc1", data: c.itemData(cid), props: [][]byte{c.prop(cid, "hvcC"), ispe(n, n), nclx(1, 13, 6, true)}, essent: []bool{true}},
+		auxImage(item{id: 2, typ: "hvc1", data: a.itemData(aid), props: [][]byte{a.prop(aid, "hvcC"), ispe(n, n)}}, auxC("urn:mpeg:hevc:2015:auxid:1")),
+	)
+	f.refs = []ref{{"auxl", 2, []uint32{1}}, {"prem", 1, []uint32{2}}}
+	return f.build()
+}
@@ -0,0 +1,64 @@
+//go:build ignore
+
+package main
+
+// bitWriter writes bits most significant first.
+type bitWriter struct {
+	b []byte
+	n int
+}
+
+func (w *bitWriter) put(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.b = append(w.b, 0)
+		}
+		w.b[len(w.b)-1] |= byte((v>>i)&1) << (7 - w.n%8)
+		w.n++
+	}
+}
+
+func (w *bitWriter) ue(v int) {
+	n := 0
+	for (v+1)>>n > 1 {
+		n++
+	}
+	w.put(0, n)
+	w.put(v+1, n+1)
+}
+
+// depthSEI returns a prefix SEI NAL unit with a depth_representation_info message of uniform Z from
+// 0.5 to 10.
+func depthSEI() []byte {
+	w := &bitWriter{}
+	w.put(0b1100, 4) // z_near, z_far
+	w.ue(2)
+	w.put(0, 1)
+	w.put(30, 7) // 0.5 = 2^(30-31)
+	w.put(0, 5)
+	w.put(0, 1)
+	w.put(0, 1)
+	w.put(34, 7) // 10 = 2^(34-31) * 1.25
+	w.put(1, 5)
+	w.put(1, 2)
+	payload := w.b
+	return cat([]byte{39 << 1, 1, 177, byte(len(payload))}, payload, []byte{0x80})
+}
+
+// depth builds depth.heic, test8.heic with gray.heic as a depth map with depth representation info and XMP.
+func init() {
+	generators["depth"] = func() {
+		sei := depthSEI()
+		xmp := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+			`<rdf:Description rdf:about="" xmlns:apdi="http://ns.apple.com/depthData/1.0/" apdi:NativeFormat="hdis" apdi:Accuracy="relative" apdi:Filtered="true"/>` +
+			`</rdf:RDF></x:xmpmeta>`
+
+		f := heicFile(
+			t8Primary(),
+			auxImage(grayImage(2), full("auxC", 0, 0, []byte("urn:mpeg:hevc:2015:auxid:2"), []byte{0}, u32(uint32(len(sei))), sei)),
+			xmpItem(3, xmp),
+		)
+		f.refs = []ref{{"auxl", 2, []uint32{1}}, {"cdsc", 3, []uint32{2}}}
+		write("depth.heic", f.build())
+	}
+}
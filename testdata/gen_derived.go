@@ -0,0 +1,32 @@
+//go:build ignore
+
+package main
+
+// derived builds overlay.heic, an iovl of two anim.heic frames on a blue canvas, and iden.heic, a rotated and
+// mirrored iden of a frame.
+func init() {
+	generators["derived"] = func() {
+		frame := func(id uint32) item {
+			it := animFrame(id)
+			it.hidden = true
+			return it
+		}
+
+		// Blue canvas, two overlapping layers.
+		iovl := cat([]byte{0, 0}, u16(0), u16(0), u16(0xffff), u16(0xffff), u16(300), u16(200), u16(10), u16(20), u16(100), u16(60))
+		f := heicFile(
+			item{id: 1, typ: "iovl", data: iovl, idat: true, props: [][]byte{ispe(300, 200)}},
+			frame(2),
+			frame(3),
+		)
+		f.refs = []ref{{"dimg", 1, []uint32{2, 3}}}
+		write("overlay.heic", f.build())
+
+		f = heicFile(
+			item{id: 1, typ: "iden", props: [][]byte{ispe(176, 128), box("irot", []byte{1}), box("imir", []byte{1})}, essent: []bool{false, true, true}},
+			frame(2),
+		)
+		f.refs = []ref{{"dimg", 1, []uint32{2}}}
+		write("iden.heic", f.build())
+	}
+}
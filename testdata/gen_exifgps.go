@@ -0,0 +1,48 @@
+//go:build ignore
+
+package main
+
+// gpsExif returns an Exif item payload with a GPS IFD.
+func gpsExif() []byte {
+	entry := func(tag, typ int, count uint32, value []byte) []byte {
+		return cat(u16(tag), u16(typ), u32(count), value)
+	}
+	tiff := cat([]byte("MM\x00\x2a"), u32(8),
+		// IFD0 at 8
+		u16(4),
+		entry(0x010f, 2, 7, u32(62)),
+		entry(0x0112, 3, 1, cat(u16(1), u16(0))),
+		entry(0x8769, 4, 1, u32(70)),
+		entry(0x8825, 4, 1, u32(88)),
+		u32(0),
+		[]byte("GPSCam\x00\x00"),
+		// Exif IFD at 70
+		u16(1),
+		entry(0x8827, 3, 1, cat(u16(200), u16(0))),
+		u32(0),
+		// GPS IFD at 88
+		u16(6),
+		entry(0x0001, 2, 2, []byte("N\x00\x00\x00")),
+		entry(0x0002, 5, 3, u32(166)),
+		entry(0x0003, 2, 2, []byte("E\x00\x00\x00")),
+		entry(0x0004, 5, 3, u32(190)),
+		entry(0x0005, 1, 1, []byte{0, 0, 0, 0}),
+		entry(0x0006, 5, 1, u32(214)),
+		u32(0),
+		u32(50), u32(1), u32(5), u32(1), u32(1234), u32(100),
+		u32(14), u32(1), u32(25), u32(1), u32(4321), u32(100),
+		u32(312), u32(1))
+	return cat(u32(0), tiff)
+}
+
+// exifgps builds exif_gps.heic, test8.heic with Exif holding a GPS IFD, and XMP.
+func init() {
+	generators["exifgps"] = func() {
+		xmp := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+			`<rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Rating="2"/></rdf:RDF></x:xmpmeta>`
+
+		f := heicFile(t8Primary(), exifItem(2, gpsExif()), xmpItem(3, xmp))
+		f.refs = []ref{{"cdsc", 2, []uint32{1}}, {"cdsc", 3, []uint32{1}}}
+		write("exif_gps.heic", f.build())
+	}
+}
@@ -0,0 +1,47 @@
+//go:build ignore
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+)
+
+// thumbExif returns an Exif item payload whose IFD1 holds a 64x48 JPEG thumbnail.
+func thumbExif() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 5), 128, 255})
+		}
+	}
+	var jb bytes.Buffer
+	if err := jpeg.Encode(&jb, img, &jpeg.Options{Quality: 80}); err != nil {
+		panic(err)
+	}
+
+	tiff := cat([]byte("MM\x00\x2a"), u32(8),
+		u16(2),
+		u16(0x010f), u16(2), u32(8), u32(38),
+		u16(0x0112), u16(3), u32(1), u16(1), u16(0),
+		u32(46),
+		[]byte("TestCam\x00"),
+		u16(3),
+		u16(0x0103), u16(3), u32(1), u16(6), u16(0),
+		u16(0x0201), u16(4), u32(1), u32(88),
+		u16(0x0202), u16(4), u32(1), u32(uint32(jb.Len())),
+		u32(0),
+		jb.Bytes())
+	return cat(u32(0), tiff)
+}
+
+// exifthumb builds exif_thumb.heic, test8.heic with Exif holding a JPEG thumbnail in IFD1.
+func init() {
+	generators["exifthumb"] = func() {
+		f := heicFile(t8Primary(), exifItem(2, thumbExif()))
+		f.refs = []ref{{"cdsc", 2, []uint32{1}}}
+		write("exif_thumb.heic", f.build())
+	}
+}
@@ -0,0 +1,60 @@
+//go:build ignore
+
+package main
+
+// appleExif returns an Exif item payload whose MakerNote holds the Apple HDR headroom tags 33 and 48.
+func appleExif(maker33, maker48 [2]uint32) []byte {
+	mn := cat([]byte("Apple iOS\x00\x00\x01MM"), u16(2),
+		u16(0x21), u16(10), u32(1), u32(44),
+		u16(0x30), u16(10), u32(1), u32(52),
+		u32(0), u32(maker33[0]), u32(maker33[1]), u32(maker48[0]), u32(maker48[1]))
+	tiff := cat([]byte("MM\x00\x2a"), u32(8),
+		u16(1), u16(0x8769), u16(4), u32(1), u32(26), u32(0),
+		u16(1), u16(0x927c), u16(7), u32(uint32(len(mn))), u32(44), u32(0),
+		mn)
+	return cat(u32(0), tiff)
+}
+
+func frac(n int32, d uint32) []byte { return cat(u32(uint32(n)), u32(d)) }
+
+// gainmap builds gainmap_apple.heic, test8.heic with gray.heic as an Apple gain map and the MakerNote headroom
+// tags; gainmap_iso.heic, the same images as the base and gain map of a single-channel ISO 21496-1 tmap item;
+// and gainmap_iso3.heic, test8.heic with the anim.heic frame as the gain map of a three-channel tmap item.
+func init() {
+	generators["gainmap"] = func() {
+		t8 := load("test8.heic")
+
+		apple := heicFile(
+			t8Primary(),
+			auxImage(grayImage(2), auxC("urn:com:apple:photo:2020:aux:hdrgainmap")),
+			exifItem(3, appleExif([2]uint32{101, 100}, [2]uint32{5, 1000})),
+		)
+		apple.refs = []ref{{"auxl", 2, []uint32{1}}, {"cdsc", 3, []uint32{1}}}
+		write("gainmap_apple.heic", apple.build())
+
+		tmap := cat([]byte{0}, u16(0), u16(0), []byte{0},
+			frac(0, 1), frac(2, 1),
+			frac(0, 1), frac(2, 1), frac(1, 1), frac(1, 64), frac(1, 64))
+		gain := grayImage(2)
+		gain.hidden = true
+		iso := heicFile(t8Primary(), gain, item{id: 3, typ: "tmap", data: tmap, idat: true, props: [][]byte{t8.prop(1, "ispe")}})
+		iso.brands = append(iso.brands, "tmap")
+		iso.refs = []ref{{"dimg", 3, []uint32{1, 2}}}
+		iso.groups = [][]byte{full("altr", 0, 0, u32(100), u32(2), u32(3), u32(1))}
+		write("gainmap_iso.heic", iso.build())
+
+		// Multichannel, in the colour space of the base image.
+		tmap3 := cat([]byte{0}, u16(0), u16(0), []byte{0xc0},
+			frac(0, 1), frac(3, 1),
+			frac(0, 1), frac(2, 1), frac(1, 1), frac(1, 64), frac(1, 64),
+			frac(-1, 2), frac(1, 1), frac(2, 1), frac(1, 32), frac(1, 32),
+			frac(1, 4), frac(3, 1), frac(1, 2), frac(0, 1), frac(1, 16))
+		gain = animFrame(2)
+		gain.hidden = true
+		iso3 := heicFile(t8Primary(), gain, item{id: 3, typ: "tmap", data: tmap3, idat: true, props: [][]byte{t8.prop(1, "ispe")}})
+		iso3.brands = append(iso3.brands, "tmap")
+		iso3.refs = iso.refs
+		iso3.groups = iso.groups
+		write("gainmap_iso3.heic", iso3.build())
+	}
+}
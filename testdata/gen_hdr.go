@@ -0,0 +1,74 @@
+//go:build ignore
+
+package main
+
+import "math"
+
+// hdr builds hdr_pq.heic and hdr_hlg.heic, 10-bit BT.2020 images with PQ and HLG patches, encoded with libheif.
+func init() {
+	generators["hdr"] = func() {
+		write("hdr_pq.heic", hdrFile(16, pqOETF))
+		write("hdr_hlg.heic", hdrFile(18, hlgOETF))
+	}
+}
+
+// pqOETF encodes linear light relative to 203 cd/m² SDR white with the SMPTE ST 2084 inverse EOTF.
+func pqOETF(v float64) float64 {
+	const m1, m2, c1, c2, c3 = 2610.0 / 16384, 2523.0 / 4096 * 128, 3424.0 / 4096, 2413.0 / 4096 * 32, 2392.0 / 4096 * 32
+	y := math.Pow(v*203/10000, m1)
+	return math.Pow((c1+c2*y)/(1+c3*y), m2)
+}
+
+// hlgOETF encodes scene linear light in [0, 1] with the ARIB STD-B67 OETF.
+func hlgOETF(e float64) float64 {
+	const a, b, c = 0.17883277, 1 - 4*0.17883277, 0.55991073
+	if e <= 1.0/12 {
+		return math.Sqrt(3 * e)
+	}
+	return a*math.Log(12*e-b) + c
+}
+
+// hdrFile encodes a 10-bit BT.2020 128x64 image with the given transfer: the top half has gray patches
+// of linear light 0, 1 (SDR white), 1000/203 and a red patch of 1 in PQ, or of the scene light that the HLG
+// OOTF renders as those on a 1000 cd/m² display; the bottom half is a gray ramp over the same range.
+func hdrFile(transfer int, oetf func(float64) float64) []byte {
+	const w, h = 128, 64
+
+	// Scene light of HLG for display light 1 and 1000/203, and for the red patch that renders to 1.
+	white, peak, red := 1.0, 1000.0/203, 1.0
+	if transfer == 18 {
+		white, peak = math.Pow(203.0/1000, 1/1.2), 1
+		red = math.Pow(203.0/1000/math.Pow(0.2627, 0.2), 1/1.2)
+	}
+
+	p := planes{w: w, h: h, depth: 10, chroma: 1, primaries: 9, transfer: transfer, matrix: 9}
+	for y := range h {
+		for x := range w {
+			var r, g, b float64
+			switch {
+			case y >= h/2:
+				r = peak * float64(x) / (w - 1)
+				g, b = r, r
+			case x < 32:
+			case x < 64:
+				r, g, b = white, white, white
+			case x < 96:
+				r, g, b = peak, peak, peak
+			default:
+				r = red
+			}
+			r, g, b = oetf(r), oetf(g), oetf(b)
+
+			l := 0.2627*r + 0.6780*g + 0.0593*b
+			p.y = append(p.y, uint16(64+876*l+0.5))
+			p.cb = append(p.cb, uint16(512+896*(b-l)/1.8814+0.5))
+			p.cr = append(p.cr, uint16(512+896*(r-l)/1.4746+0.5))
+		}
+	}
+
+	src := parse(encode(p))
+	id := src.f.Meta.PrimaryItemID()
+
+	f := heicFile(item{id: 1, typ: "hvc1", data: src.itemData(id), props: [][]byte{src.prop(id, "hvcC"), ispe(w, h), nclx(9, transfer, 9, false)}, essent: []bool{true}})
+	return f.build()
+}
@@ -0,0 +1,17 @@
+//go:build ignore
+
+package main
+
+// mattes builds mattes.heic, test8.heic with three Apple mattes of the anim.heic frame.
+func init() {
+	generators["mattes"] = func() {
+		f := heicFile(
+			t8Primary(),
+			auxImage(animFrame(2), auxC("urn:com:apple:photo:2018:aux:portraiteffectsmatte")),
+			auxImage(animFrame(3), auxC("urn:com:apple:photo:2019:aux:semanticskinmatte")),
+			auxImage(animFrame(4), auxC("urn:com:apple:photo:2019:aux:semantichairmatte")),
+		)
+		f.refs = []ref{{"auxl", 2, []uint32{1}}, {"auxl", 3, []uint32{1}}, {"auxl", 4, []uint32{1}}}
+		write("mattes.heic", f.build())
+	}
+}
@@ -0,0 +1,62 @@
+//go:build ignore
+
+package main
+
+// s15 encodes v as an ICC s15Fixed16Number.
+func s15(v float64) []byte { return u32(uint32(int32(v * 65536))) }
+
+// displayP3ICC returns a minimal ICC v4 matrix/TRC profile of Display P3 with the sRGB curve.
+func displayP3ICC() []byte {
+	xyz := func(x, y, z float64) []byte { return cat([]byte("XYZ "), u32(0), s15(x), s15(y), s15(z)) }
+	para := cat([]byte("para"), u32(0), u16(3), u16(0), s15(2.4), s15(1/1.055), s15(0.055/1.055), s15(1/12.92), s15(0.04045))
+	desc := cat([]byte("mluc"), u32(0), u32(1), u32(12), []byte("enUS"), u32(uint32(2*len("Display P3"))), u32(28))
+	for _, r := range "Display P3" {
+		desc = append(desc, 0, byte(r))
+	}
+
+	type tag struct {
+		sig  string
+		data []byte
+	}
+	tags := []tag{
+		{"desc", desc},
+		{"wtpt", xyz(0.964203, 1.0, 0.824905)},
+		{"rXYZ", xyz(0.515121, 0.241196, -0.001053)},
+		{"gXYZ", xyz(0.291977, 0.692245, 0.041885)},
+		{"bXYZ", xyz(0.157104, 0.066574, 0.784073)},
+		{"rTRC", para},
+		{"gTRC", para},
+		{"bTRC", para},
+	}
+
+	pad := func(b []byte) []byte {
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+		return b
+	}
+
+	off := 128 + 4 + 12*len(tags)
+	var table, data []byte
+	for _, t := range tags {
+		d := pad(t.data)
+		table = cat(table, []byte(t.sig), u32(uint32(off+len(data))), u32(uint32(len(t.data))))
+		data = cat(data, d)
+	}
+
+	size := off + len(data)
+	header := cat(u32(uint32(size)), []byte("none"), u32(0x04300000), []byte("mntr"), []byte("RGB "), []byte("XYZ "),
+		make([]byte, 12), []byte("acsp"), make([]byte, 28), s15(0.9642), s15(1.0), s15(0.8249), make([]byte, 48))
+
+	return cat(header, u32(uint32(len(tags))), table, data)
+}
+
+// p3 builds p3.heic, test8.heic with a Display P3 ICC profile and nclx colour.
+func init() {
+	generators["p3"] = func() {
+		p := t8Primary()
+		p.props = [][]byte{p.props[0], p.props[1], box("colr", []byte("prof"), displayP3ICC()), nclx(12, 13, 6, true)}
+		f := heicFile(p)
+		write("p3.heic", f.build())
+	}
+}
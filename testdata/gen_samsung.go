@@ -0,0 +1,41 @@
+//go:build ignore
+
+package main
+
+// samsungExif returns an Exif item payload with a Samsung MakerNote, a plain IFD whose PictureWizard value
+// is stored after it, at an offset from the start of the MakerNote.
+func samsungExif() []byte {
+	entry := func(tag, typ int, count uint32, value []byte) []byte {
+		return cat(u16(tag), u16(typ), u32(count), value)
+	}
+	makerNote := cat(
+		u16(3),
+		entry(0x0001, 7, 4, []byte("0100")),
+		entry(0x0002, 4, 1, u32(0x2000)),
+		entry(0x0021, 3, 5, u32(42)),
+		u32(0),
+		u16(0), u16(4), u16(4), u16(4), u16(4))
+	tiff := cat([]byte("MM\x00\x2a"), u32(8),
+		// IFD0 at 8
+		u16(2),
+		entry(0x010f, 2, 8, u32(38)),
+		entry(0x8769, 4, 1, u32(46)),
+		u32(0),
+		[]byte("samsung\x00"),
+		// Exif IFD at 46
+		u16(1),
+		entry(0x927c, 7, uint32(len(makerNote)), u32(64)),
+		u32(0),
+		// MakerNote at 64
+		makerNote)
+	return cat(u32(0), tiff)
+}
+
+// samsung builds samsung.heic, test8.heic with Exif holding a Samsung MakerNote.
+func init() {
+	generators["samsung"] = func() {
+		f := heicFile(t8Primary(), exifItem(2, samsungExif()))
+		f.refs = []ref{{"cdsc", 2, []uint32{1}}}
+		write("samsung.heic", f.build())
+	}
+}
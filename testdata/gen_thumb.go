@@ -0,0 +1,12 @@
+//go:build ignore
+
+package main
+
+// thumb builds thumb.heic, test8.heic with the anim.heic frame as its thumbnail.
+func init() {
+	generators["thumb"] = func() {
+		f := heicFile(t8Primary(), animFrame(2))
+		f.refs = []ref{{"thmb", 2, []uint32{1}}}
+		write("thumb.heic", f.build())
+	}
+}
@@ -0,0 +1,38 @@
+//go:build ignore
+
+package main
+
+// xmp builds xmp.heic, test8.heic with an XMP packet in idat.
+func init() {
+	generators["xmp"] = func() {
+		xmp := `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/"
+    xmlns:HDRGainMap="http://ns.apple.com/HDRGainMap/1.0/"
+    xmp:Rating="4"
+    xmp:CreatorTool="TestCam 1.0"
+    xmp:CreateDate="2024-05-01T10:20:30"
+    HDRGainMap:HDRGainMapHeadroom="3.5">
+   <dc:title><rdf:Alt><rdf:li xml:lang="de">Brücke</rdf:li><rdf:li xml:lang="x-default">Bridge</rdf:li></rdf:Alt></dc:title>
+   <dc:creator><rdf:Seq><rdf:li>Jane Doe</rdf:li></rdf:Seq></dc:creator>
+   <dc:subject><rdf:Bag><rdf:li>river</rdf:li><rdf:li>night</rdf:li></rdf:Bag></dc:subject>
+   <dc:rights><rdf:Alt><rdf:li xml:lang="x-default">CC BY 4.0</rdf:li></rdf:Alt></dc:rights>
+   <xmp:Label>Green</xmp:Label>
+   <photoshop:City>Prague</photoshop:City>
+   <photoshop:Country>Czechia</photoshop:Country>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+		meta := xmpItem(2, xmp)
+		meta.idat = true
+		f := heicFile(t8Primary(), meta)
+		f.refs = []ref{{"cdsc", 2, []uint32{1}}}
+		write("xmp.heic", f.build())
+	}
+}
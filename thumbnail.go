@@ -0,0 +1,160 @@
+package heic
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/draw"
+	"io"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+// DecodeThumbnail decodes the thumbnail of the primary image, resolved through the thmb item references,
+// without decoding the primary image. Of several thumbnails it picks the smallest one covering maxSize.
+// Without a thumbnail it decodes the primary image (or the first frame of a sequence) instead. See
+// DecodeExifThumbnail for the JPEG thumbnail of the EXIF metadata, which needs no decoding.
+//
+// An image larger than maxSize x maxSize is downscaled to fit, preserving the aspect ratio, and returned
+// as *image.NRGBA; a smaller one is returned as decoded. A maxSize of 0 disables the downscaling.
+func DecodeThumbnail(r io.Reader, maxSize int) (image.Image, error) {
+	return decodeThumbnail(context.Background(), r, maxSize, nil)
+}
+
+// DecodeThumbnailWithOptions is like DecodeThumbnail using opts, whose limits apply to the decoded thumbnail
+// and whose ItemID selects the image item to find the thumbnail of.
+func DecodeThumbnailWithOptions(r io.Reader, maxSize int, opts *Options) (image.Image, error) {
+	return decodeThumbnail(context.Background(), r, maxSize, opts)
+}
+
+// DecodeThumbnailWithOptionsContext is like DecodeThumbnailWithOptions, but aborts the decoding and returns
+// ctx.Err() when ctx is done, as DecodeContext does.
+func DecodeThumbnailWithOptionsContext(ctx context.Context, r io.Reader, maxSize int, opts *Options) (image.Image, error) {
+	return decodeThumbnail(ctx, r, maxSize, opts)
+}
+
+func decodeThumbnail(ctx context.Context, r io.Reader, maxSize int, opts *Options) (image.Image, error) {
+	if opts == nil {
+		opts = defaultOptions
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	data, err := opts.readInput(r)
+	if err != nil {
+		return nil, err
+	}
+
+	o := *opts
+	if f, err := isobmff.Parse(data); err == nil && f.Meta != nil {
+		id := opts.ItemID
+		if id == 0 {
+			id = f.Meta.PrimaryItemID()
+		}
+
+		if tid := thumbnailID(f.Meta, id, maxSize); tid != 0 {
+			o.ItemID = tid
+		}
+	}
+
+	img, err := decodeImage(ctx, bytes.NewReader(data), &o)
+	if err != nil {
+		return nil, err
+	}
+
+	return fit(img, maxSize), nil
+}
+
+// thumbnailID returns the image item with a thmb reference to id that best covers maxSize, or 0.
+func thumbnailID(meta *isobmff.Meta, id uint32, maxSize int) uint32 {
+	var best uint32
+	var bestSize int
+
+	for _, tid := range meta.ReferencedBy(id, "thmb") {
+		if e := meta.Item(tid); e == nil || !imageItemTypes[e.ItemType] {
+			continue
+		}
+
+		w, h := itemSize(meta, tid)
+		size := max(w, h)
+
+		switch {
+		case best == 0:
+		case maxSize > 0 && size >= maxSize && (bestSize < maxSize || size < bestSize):
+		case (maxSize <= 0 || bestSize < maxSize) && size > bestSize:
+		default:
+			continue
+		}
+
+		best, bestSize = tid, size
+	}
+
+	return best
+}
+
+// fit downscales img to fit within maxSize x maxSize, or returns it unchanged when it already fits.
+func fit(img image.Image, maxSize int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if maxSize <= 0 || (w <= maxSize && h <= maxSize) {
+		return img
+	}
+
+	dw, dh := maxSize, maxSize
+	if w > h {
+		dh = max(1, (h*maxSize+w/2)/w)
+	} else {
+		dw = max(1, (w*maxSize+h/2)/h)
+	}
+
+	return resize(img, dw, dh)
+}
+
+// resize scales img down to dw x dh with a box filter, averaging in premultiplied alpha.
+func resize(img image.Image, dw, dh int) *image.NRGBA {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		src = image.NewRGBA(image.Rect(0, 0, sw, sh))
+		draw.Draw(src, src.Rect, img, b.Min, draw.Src)
+	} else {
+		src = src.SubImage(b).(*image.RGBA)
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+
+			var r, g, bb, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(src.Rect.Min.X+x0, src.Rect.Min.Y+sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint64(src.Pix[i])
+					g += uint64(src.Pix[i+1])
+					bb += uint64(src.Pix[i+2])
+					a += uint64(src.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+
+			d := dst.PixOffset(x, y)
+			if a == 0 {
+				continue
+			}
+			dst.Pix[d] = uint8((r*255 + a/2) / a)
+			dst.Pix[d+1] = uint8((g*255 + a/2) / a)
+			dst.Pix[d+2] = uint8((bb*255 + a/2) / a)
+			dst.Pix[d+3] = uint8((a + n/2) / n)
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,98 @@
+package heic
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"image"
+	"testing"
+)
+
+//go:embed testdata/thumb.heic
+var testThumb []byte
+
+func TestDecodeThumbnail(t *testing.T) {
+	testBothWays(t, func(t *testing.T) {
+		img, err := DecodeThumbnail(bytes.NewReader(testThumb), 256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds() != image.Rect(0, 0, 176, 128) {
+			t.Errorf("thumbnail bounds = %v, want 176x128", img.Bounds())
+		}
+
+		img, err = DecodeThumbnail(bytes.NewReader(testThumb), 88)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds() != image.Rect(0, 0, 88, 64) {
+			t.Errorf("downscaled thumbnail bounds = %v, want 88x64", img.Bounds())
+		}
+
+		// Without a thumbnail the primary image is downscaled.
+		img, err = DecodeThumbnail(bytes.NewReader(testHeic8), 256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds() != image.Rect(0, 0, 256, 256) {
+			t.Errorf("fallback bounds = %v, want 256x256", img.Bounds())
+		}
+	})
+}
+
+func TestDecodeThumbnailWithOptions(t *testing.T) {
+	// The limits apply to the 176x128 thumbnail, not to the 512x512 primary image.
+	opts := &Options{Backend: BackendWASM, MaxPixels: 176 * 128}
+	if _, err := DecodeThumbnailWithOptions(bytes.NewReader(testThumb), 256, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range []*Options{
+		{Backend: BackendWASM, MaxPixels: 176*128 - 1},
+		{Backend: BackendWASM, MaxInputSize: 1024},
+	} {
+		if _, err := DecodeThumbnailWithOptions(bytes.NewReader(testThumb), 256, opts); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("%+v: got %v, want %v", opts, err, ErrLimitExceeded)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := DecodeThumbnailWithOptionsContext(ctx, bytes.NewReader(testThumb), 256, opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestThumbnailItem(t *testing.T) {
+	items, err := Items(bytes.NewReader(testThumb))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 2 || items[1].ThumbnailOf != 1 || items[0].ThumbnailOf != 0 {
+		t.Errorf("items = %+v", items)
+	}
+}
+
+func TestResize(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 2; y++ {
+			if x < 2 {
+				src.Pix[src.PixOffset(x, y)+0] = 200
+				src.Pix[src.PixOffset(x, y)+3] = 255
+			}
+		}
+	}
+
+	dst := resize(src, 2, 1)
+
+	// Fully transparent pixels do not darken the average.
+	if c := dst.NRGBAAt(0, 0); c.R != 200 || c.A != 255 {
+		t.Errorf("opaque = %v", c)
+	}
+	if c := dst.NRGBAAt(1, 0); c.A != 0 {
+		t.Errorf("transparent = %v", c)
+	}
+}
@@ -0,0 +1,153 @@
+package heic
+
+import (
+	"image"
+	"math"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+// TransformKind is the kind of a transformation property of an image.
+type TransformKind int
+
+const (
+	// TransformCrop crops the image to its clean aperture (a clap property).
+	TransformCrop TransformKind = iota
+	// TransformRotate rotates the image counter-clockwise (an irot property).
+	TransformRotate
+	// TransformMirror flips the image (an imir property).
+	TransformMirror
+)
+
+// Transform is a transformation property of an image. The transformations are applied in order to the coded
+// image to give the displayed one, unless Options.IgnoreTransformations is set.
+type Transform struct {
+	Kind TransformKind
+
+	Angle int             // Rotation angle of TransformRotate in degrees: 0, 90, 180 or 270.
+	Axis  int             // Mirror axis of TransformMirror: 0 flips top-bottom, 1 flips left-right.
+	Rect  image.Rectangle // Clean aperture of TransformCrop, in the image as transformed by the preceding properties.
+}
+
+// transforms returns the transformation properties of props, an item of w x h coded pixels.
+func transforms(props []isobmff.Property, w, h int) []Transform {
+	var out []Transform
+
+	for _, p := range props {
+		switch p := p.(type) {
+		case *isobmff.CleanAperture:
+			r := clapRect(p, w, h)
+			w, h = r.Dx(), r.Dy()
+			out = append(out, Transform{Kind: TransformCrop, Rect: r})
+		case *isobmff.Rotation:
+			if p.Angle == 90 || p.Angle == 270 {
+				w, h = h, w
+			}
+			out = append(out, Transform{Kind: TransformRotate, Angle: p.Angle})
+		case *isobmff.Mirror:
+			out = append(out, Transform{Kind: TransformMirror, Axis: int(p.Axis)})
+		}
+	}
+
+	return out
+}
+
+// transform applies the clap, irot and imir properties to img in property order.
+func transform(img *image.NRGBA, props []isobmff.Property) *image.NRGBA {
+	for _, p := range props {
+		switch p := p.(type) {
+		case *isobmff.CleanAperture:
+			b := img.Bounds()
+			r := clapRect(p, b.Dx(), b.Dy()).Add(b.Min)
+			img = img.SubImage(r).(*image.NRGBA)
+		case *isobmff.Rotation:
+			img = rotate(img, p.Angle)
+		case *isobmff.Mirror:
+			img = mirror(img, p.Axis)
+		}
+	}
+
+	return img
+}
+
+// clapRect returns the clean aperture of a w x h image, clamped to the image.
+func clapRect(c *isobmff.CleanAperture, w, h int) image.Rectangle {
+	if c.WidthD == 0 || c.HeightD == 0 || c.HorizOffsetD == 0 || c.VertOffsetD == 0 {
+		return image.Rect(0, 0, w, h)
+	}
+
+	cw := float64(c.WidthN) / float64(c.WidthD)
+	ch := float64(c.HeightN) / float64(c.HeightD)
+
+	// The aperture is centred on the image centre shifted by the offsets.
+	cx := float64(c.HorizOffsetN)/float64(c.HorizOffsetD) + float64(w-1)/2
+	cy := float64(c.VertOffsetN)/float64(c.VertOffsetD) + float64(h-1)/2
+
+	left := int(math.Round(cx - (cw-1)/2))
+	top := int(math.Round(cy - (ch-1)/2))
+
+	return image.Rect(left, top, left+int(math.Round(cw)), top+int(math.Round(ch))).Intersect(image.Rect(0, 0, w, h))
+}
+
+// rotate returns img rotated counter-clockwise by angle degrees, a multiple of 90.
+func rotate(img *image.NRGBA, angle int) *image.NRGBA {
+	angle = (angle%360 + 360) % 360
+	if angle == 0 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	dw, dh := w, h
+	if angle != 180 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch angle {
+			case 90:
+				sx, sy = w-1-y, x
+			case 180:
+				sx, sy = w-1-x, h-1-y
+			case 270:
+				sx, sy = y, h-1-x
+			}
+
+			s := img.PixOffset(b.Min.X+sx, b.Min.Y+sy)
+			d := dst.PixOffset(x, y)
+			copy(dst.Pix[d:d+4], img.Pix[s:s+4])
+		}
+	}
+
+	return dst
+}
+
+// mirror returns img flipped top-bottom for axis 0 or left-right for axis 1, as libheif does.
+func mirror(img *image.NRGBA, axis uint8) *image.NRGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		sy := y
+		if axis == 0 {
+			sy = h - 1 - y
+		}
+		for x := 0; x < w; x++ {
+			sx := x
+			if axis == 1 {
+				sx = w - 1 - x
+			}
+
+			s := img.PixOffset(b.Min.X+sx, b.Min.Y+sy)
+			d := dst.PixOffset(x, y)
+			copy(dst.Pix[d:d+4], img.Pix[s:s+4])
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,94 @@
+package heic
+
+import (
+	"bytes"
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+func TestIgnoreTransformations(t *testing.T) {
+	testBackends(t, func(t *testing.T, backend Backend) {
+		img, err := DecodeWithOptions(bytes.NewReader(testHeicExif), &Options{Backend: backend, Format: FormatNRGBA})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); b.Dx() != 480 || b.Dy() != 640 {
+			t.Fatalf("bounds %v, want 480x640", b)
+		}
+
+		opts := &Options{Backend: backend, Format: FormatNRGBA, IgnoreTransformations: true}
+		coded, err := DecodeWithOptions(bytes.NewReader(testHeicExif), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := coded.Bounds(); b.Dx() != 640 || b.Dy() != 480 {
+			t.Fatalf("coded bounds %v, want 640x480", b)
+		}
+
+		cfg, err := DecodeConfigWithOptions(bytes.NewReader(testHeicExif), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Width != 640 || cfg.Height != 480 {
+			t.Errorf("config %dx%d, want 640x480", cfg.Width, cfg.Height)
+		}
+
+		// Applying the listed transformations gives the displayed image.
+		info, err := DecodeInfoWithOptions(bytes.NewReader(testHeicExif), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []Transform{{Kind: TransformRotate, Angle: 270}}; !reflect.DeepEqual(info.Transforms, want) {
+			t.Fatalf("transforms %+v, want %+v", info.Transforms, want)
+		}
+
+		if got := rotate(coded.(*image.NRGBA), info.Transforms[0].Angle); !bytes.Equal(got.Pix, img.(*image.NRGBA).Pix) {
+			t.Error("rotated coded image differs from the decoded image")
+		}
+
+		h, err := DecodeAllWithOptions(bytes.NewReader(testHeicExif), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := h.Image[0].Bounds(); b.Dx() != 640 || b.Dy() != 480 {
+			t.Errorf("DecodeAll bounds %v, want 640x480", b)
+		}
+
+		img, err = DecodeWithOptions(bytes.NewReader(testHeicExif), &Options{Backend: backend, Format: FormatYCbCr, IgnoreTransformations: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); b.Dx() != 640 || b.Dy() != 480 {
+			t.Errorf("YCbCr bounds %v, want 640x480", b)
+		}
+	})
+}
+
+func TestTransforms(t *testing.T) {
+	props := []isobmff.Property{
+		&isobmff.ImageSpatialExtents{Width: 64, Height: 32},
+		&isobmff.CleanAperture{WidthN: 48, WidthD: 1, HeightN: 16, HeightD: 1, HorizOffsetD: 1, VertOffsetD: 1},
+		&isobmff.Rotation{Angle: 90},
+		&isobmff.Mirror{Axis: 1},
+	}
+
+	want := []Transform{
+		{Kind: TransformCrop, Rect: image.Rect(8, 8, 56, 24)},
+		{Kind: TransformRotate, Angle: 90},
+		{Kind: TransformMirror, Axis: 1},
+	}
+	if got := transforms(props, 64, 32); !reflect.DeepEqual(got, want) {
+		t.Errorf("transforms %+v, want %+v", got, want)
+	}
+
+	info, err := DecodeInfo(bytes.NewReader(testHeic8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Transforms != nil {
+		t.Errorf("transforms %+v, want none", info.Transforms)
+	}
+}
@@ -0,0 +1,257 @@
+package heic
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+// xmpMIME is the content type of XMP metadata items.
+const xmpMIME = "application/rdf+xml"
+
+// ErrNoXMP is returned by DecodeXMP when the HEIC image has no XMP metadata.
+var ErrNoXMP = errors.New("heic: no xmp data")
+
+// Namespaces of the XMP properties of XMP, GainMap and AppleDepth.
+const (
+	nsRDF        = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsDC         = "http://purl.org/dc/elements/1.1/"
+	nsXMP        = "http://ns.adobe.com/xap/1.0/"
+	nsPhotoshop  = "http://ns.adobe.com/photoshop/1.0/"
+	nsHDRGainMap = "http://ns.apple.com/HDRGainMap/1.0/"
+	nsAppleDepth = "http://ns.apple.com/depthData/1.0/"
+)
+
+// XMP holds common properties of an XMP packet. Missing properties are empty.
+type XMP struct {
+	// Dublin Core (dc)
+	Title       string   // Title, in the default language.
+	Description string   // Description, in the default language.
+	Creator     []string // Authors, in order.
+	Subject     []string // Keywords.
+	Rights      string   // Copyright notice, in the default language.
+
+	// XMP basic (xmp)
+	Rating      float64 // User rating: -1 for rejected, 0 for unrated, or 1 to 5.
+	Label       string  // Colour label, e.g. Red or Green.
+	CreatorTool string  // Software that created the resource.
+	CreateDate  string  // ISO 8601 dates.
+	ModifyDate  string
+
+	// Photoshop (photoshop)
+	Headline    string
+	DateCreated string
+	City        string
+	State       string
+	Country     string
+	Credit      string
+	Source      string
+
+	// HDRGainMapHeadroom is the HDR headroom of an Apple gain map, from its HDRGainMap namespace; see GainMap.
+	HDRGainMapHeadroom float64
+}
+
+// DecodeXMP returns the XMP packet of a HEIC image, or ErrNoXMP if there is none. It prefers the packet that
+// describes the primary image; see ParseXMP.
+func DecodeXMP(r io.Reader) ([]byte, error) {
+	meta, pos, err := readMeta(r)
+	if err != nil {
+		return nil, err
+	}
+
+	b := xmpFromMeta(r, meta, pos)
+	if b == nil {
+		return nil, ErrNoXMP
+	}
+
+	return b, nil
+}
+
+// xmpFromMeta resolves the XMP item of meta, that of the primary image first, and reads it from r at absolute pos.
+func xmpFromMeta(r io.Reader, meta *isobmff.Meta, pos int64) []byte {
+	var ids []uint32
+	for _, id := range meta.ReferencedBy(meta.PrimaryItemID(), "cdsc") {
+		if isXMPItem(meta.Item(id)) {
+			ids = append(ids, id)
+		}
+	}
+	for _, e := range meta.ItemsOfType("mime") {
+		if isXMPItem(e) {
+			ids = append(ids, e.ItemID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return streamItem(r, meta, pos, ids[0])
+}
+
+// isXMPItem reports whether e is an XMP metadata item.
+func isXMPItem(e *isobmff.ItemInfoEntry) bool {
+	return e != nil && e.ItemType == "mime" && e.ContentType == xmpMIME
+}
+
+// ParseXMP parses the common properties of an XMP packet, as returned by DecodeXMP. Properties may be
+// written as attributes or elements of any rdf:Description.
+func ParseXMP(b []byte) (*XMP, error) {
+	props, err := xmpProperties(b)
+	if err != nil {
+		return nil, fmt.Errorf("heic: xmp: %w", err)
+	}
+
+	x := &XMP{}
+
+	text := func(ns, name string) string {
+		v, _ := xmpText(props, ns, name)
+		return v
+	}
+	number := func(ns, name string) float64 {
+		f, _ := strconv.ParseFloat(text(ns, name), 64)
+		return f
+	}
+
+	x.Title = text(nsDC, "title")
+	x.Description = text(nsDC, "description")
+	x.Creator = props[xml.Name{Space: nsDC, Local: "creator"}]
+	x.Subject = props[xml.Name{Space: nsDC, Local: "subject"}]
+	x.Rights = text(nsDC, "rights")
+
+	x.Rating = number(nsXMP, "Rating")
+	x.Label = text(nsXMP, "Label")
+	x.CreatorTool = text(nsXMP, "CreatorTool")
+	x.CreateDate = text(nsXMP, "CreateDate")
+	x.ModifyDate = text(nsXMP, "ModifyDate")
+
+	x.Headline = text(nsPhotoshop, "Headline")
+	x.DateCreated = text(nsPhotoshop, "DateCreated")
+	x.City = text(nsPhotoshop, "City")
+	x.State = text(nsPhotoshop, "State")
+	x.Country = text(nsPhotoshop, "Country")
+	x.Credit = text(nsPhotoshop, "Credit")
+	x.Source = text(nsPhotoshop, "Source")
+
+	x.HDRGainMapHeadroom = number(nsHDRGainMap, "HDRGainMapHeadroom")
+
+	return x, nil
+}
+
+// xmpProperties returns the simple and array properties of the rdf:Description elements of an XMP packet by
+// name. The items of an array are in order, with the default language of an alternative first.
+func xmpProperties(b []byte) (map[xml.Name][]string, error) {
+	props := make(map[xml.Name][]string)
+
+	d := xml.NewDecoder(bytes.NewReader(b))
+	d.Strict = false
+
+	var stack []xml.Name
+	var prop xml.Name // The property element being read, and its depth in stack.
+	var propDepth int
+	var text strings.Builder
+	var lang string
+	var items []string
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			parent := xml.Name{}
+			if len(stack) > 0 {
+				parent = stack[len(stack)-1]
+			}
+			stack = append(stack, t.Name)
+
+			switch {
+			case propDepth == 0 && t.Name == xml.Name{Space: nsRDF, Local: "Description"}:
+				for _, a := range t.Attr {
+					if a.Name.Space != "" && a.Name.Space != nsRDF && a.Name.Space != "xmlns" {
+						props[a.Name] = append(props[a.Name], strings.TrimSpace(a.Value))
+					}
+				}
+			case propDepth == 0 && parent == xml.Name{Space: nsRDF, Local: "Description"}:
+				prop, propDepth = t.Name, len(stack)
+				text.Reset()
+				items = nil
+			case propDepth != 0 && t.Name == xml.Name{Space: nsRDF, Local: "li"}:
+				text.Reset()
+				lang = ""
+				for _, a := range t.Attr {
+					if a.Name.Local == "lang" {
+						lang = a.Value
+					}
+				}
+			}
+		case xml.CharData:
+			if propDepth != 0 {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			switch {
+			case propDepth != 0 && len(stack) == propDepth:
+				if v := strings.TrimSpace(text.String()); items == nil && v != "" {
+					items = []string{v}
+				}
+				props[prop] = append(props[prop], items...)
+				propDepth = 0
+			case propDepth != 0 && t.Name == xml.Name{Space: nsRDF, Local: "li"}:
+				v := strings.TrimSpace(text.String())
+				if lang == "x-default" {
+					items = append([]string{v}, items...)
+				} else {
+					items = append(items, v)
+				}
+				text.Reset()
+			}
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	return props, nil
+}
+
+// xmpText returns the value of the simple property of props with namespace ns and local name name, or the
+// first item of an array, and whether it is set.
+func xmpText(props map[xml.Name][]string, ns, name string) (string, bool) {
+	v, ok := props[xml.Name{Space: ns, Local: name}]
+	if len(v) == 0 {
+		return "", ok
+	}
+
+	return v[0], true
+}
+
+// itemXMP returns the properties of the XMP packet of the mime item describing item id through a cdsc
+// reference, or nil.
+func itemXMP(data []byte, meta *isobmff.Meta, id uint32) map[xml.Name][]string {
+	for _, xid := range meta.ReferencedBy(id, "cdsc") {
+		if !isXMPItem(meta.Item(xid)) {
+			continue
+		}
+
+		b, err := meta.ReadItem(bytes.NewReader(data), xid)
+		if err != nil {
+			continue
+		}
+		if props, err := xmpProperties(b); err == nil {
+			return props
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package heic
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+//go:embed testdata/xmp.heic
+var testXMP []byte
+
+func TestXMPText(t *testing.T) {
+	const head = `<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"><rdf:Description xmlns:HDRGainMap="http://ns.apple.com/HDRGainMap/1.0/" xmlns:x="urn:x"`
+
+	for _, xmp := range []string{
+		head + ` HDRGainMap:HDRGainMapVersion="131072" HDRGainMap:HDRGainMapHeadroom="3.5"/></rdf:RDF>`,
+		head + ` HDRGainMap:HDRGainMapHeadroom = '3.5'/></rdf:RDF>`,
+		head + `><HDRGainMap:HDRGainMapHeadroom> 3.5 </HDRGainMap:HDRGainMapHeadroom></rdf:Description></rdf:RDF>`,
+	} {
+		props, err := xmpProperties([]byte(xmp))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, ok := xmpText(props, nsHDRGainMap, "HDRGainMapHeadroom"); v != "3.5" || !ok {
+			t.Errorf("%s: %q, %v", xmp, v, ok)
+		}
+	}
+
+	// The local name in another namespace or in a comment is not the property.
+	for _, xmp := range []string{
+		head + ` x:HDRGainMapHeadroom="1"/></rdf:RDF>`,
+		head + `><!-- <HDRGainMap:HDRGainMapHeadroom>1</HDRGainMap:HDRGainMapHeadroom> --></rdf:Description></rdf:RDF>`,
+	} {
+		props, err := xmpProperties([]byte(xmp))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, ok := xmpText(props, nsHDRGainMap, "HDRGainMapHeadroom"); ok {
+			t.Errorf("%s: %q", xmp, v)
+		}
+	}
+}
+
+func TestDecodeXMP(t *testing.T) {
+	// The packet of xmp.heic is in idat, that of depth.heic in mdat.
+	b, err := DecodeXMP(bytes.NewReader(testXMP))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, err := ParseXMP(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &XMP{
+		Title:              "Bridge",
+		Creator:            []string{"Jane Doe"},
+		Subject:            []string{"river", "night"},
+		Rights:             "CC BY 4.0",
+		Rating:             4,
+		Label:              "Green",
+		CreatorTool:        "TestCam 1.0",
+		CreateDate:         "2024-05-01T10:20:30",
+		City:               "Prague",
+		Country:            "Czechia",
+		HDRGainMapHeadroom: 3.5,
+	}
+	if !reflect.DeepEqual(x, want) {
+		t.Errorf("got %+v\nwant %+v", x, want)
+	}
+
+	b, err = DecodeXMP(bytes.NewReader(testDepth))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(`apdi:NativeFormat="hdis"`)) {
+		t.Errorf("depth XMP %q", b)
+	}
+
+	// An Exiv2 packet, with the rating as an attribute of rdf:Description.
+	b, err = DecodeXMP(bytes.NewReader(testHeic8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x, err := ParseXMP(b); err != nil || x.Rating != 5 {
+		t.Errorf("test8 XMP %+v, err %v", x, err)
+	}
+
+	if _, err := DecodeXMP(bytes.NewReader(testHeicExif)); !errors.Is(err, ErrNoXMP) {
+		t.Errorf("err = %v, want ErrNoXMP", err)
+	}
+
+	if _, err := ParseXMP([]byte("<x:xmpmeta><rdf:RDF>")); err == nil {
+		t.Error("truncated packet: no error")
+	}
+}
+
+func TestParseXMPNested(t *testing.T) {
+	// The fields of a structure, written as attributes of a nested rdf:Description, are not top-level properties.
+	xmp := `<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+ <rdf:Description xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:x="urn:x" xmp:Rating="3">
+  <x:History><rdf:Seq><rdf:li><rdf:Description xmp:Rating="1" xmp:Label="Red"/></rdf:li></rdf:Seq></x:History>
+  <x:Derived rdf:parseType="Resource"><xmp:Label>Blue</xmp:Label></x:Derived>
+ </rdf:Description>
+</rdf:RDF>`
+
+	x, err := ParseXMP([]byte(xmp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Rating != 3 || x.Label != "" {
+		t.Errorf("rating %v, label %q", x.Rating, x.Label)
+	}
+}
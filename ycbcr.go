@@ -0,0 +1,248 @@
+package heic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+
+	"github.com/gen2brain/heic/isobmff"
+)
+
+// errNoPlanes is returned by decodePlanes when the WASM module does not export decode_planes, and by
+// decodeYCbCr for images it cannot return as planes; callers then convert from RGBA instead.
+var errNoPlanes = fmt.Errorf("heic: wasm: YCbCr planes: %w", ErrUnsupported)
+
+// subsampleRatio returns the image.YCbCrSubsampleRatio of an HEVC chroma format; monochrome is returned as
+// 4:2:0 with neutral chroma.
+func subsampleRatio(format int) (image.YCbCrSubsampleRatio, bool) {
+	switch format {
+	case 0, 1:
+		return image.YCbCrSubsampleRatio420, true
+	case 2:
+		return image.YCbCrSubsampleRatio422, true
+	case 3:
+		return image.YCbCrSubsampleRatio444, true
+	}
+
+	return 0, false
+}
+
+// codedRatio returns the chroma subsampling coded in the hvcC of item id (the primary image when 0) of data,
+// or of the first tile of a grid, or 4:4:4 when unknown.
+func codedRatio(data []byte, id uint32) image.YCbCrSubsampleRatio {
+	f, err := isobmff.Parse(data)
+	if err != nil || f.Meta == nil {
+		return image.YCbCrSubsampleRatio444
+	}
+	if id == 0 {
+		id = f.Meta.PrimaryItemID()
+	}
+
+	if e := f.Meta.Item(id); e != nil && e.ItemType == "grid" {
+		if tiles := f.Meta.References(id, "dimg"); len(tiles) > 0 {
+			id = tiles[0]
+		}
+	}
+
+	if hvcC, ok := f.Meta.ItemProperty(id, "hvcC").(*isobmff.HEVCConfig); ok {
+		if ratio, ok := subsampleRatio(int(hvcC.ChromaFormat)); ok {
+			return ratio
+		}
+	}
+
+	return image.YCbCrSubsampleRatio444
+}
+
+// planesSize returns the size of the planes written by decode_planes for a w x h frame of the chroma format.
+func planesSize(w, h, format int) int {
+	if format == 0 {
+		return w * h
+	}
+
+	ratio, _ := subsampleRatio(format)
+	_, _, cw, ch := yCbCrSize(image.Rect(0, 0, w, h), ratio)
+
+	return w*h + 2*cw*ch
+}
+
+// newPlanes copies the planes written by decode_planes into an *image.YCbCr.
+func newPlanes(b []byte, w, h, format int) (*image.YCbCr, error) {
+	ratio, ok := subsampleRatio(format)
+	if !ok {
+		return nil, fmt.Errorf("heic: wasm: chroma format %d", format)
+	}
+	if len(b) < planesSize(w, h, format) {
+		return nil, ErrMemRead
+	}
+
+	img := image.NewYCbCr(image.Rect(0, 0, w, h), ratio)
+	n := copy(img.Y, b[:w*h])
+
+	if format == 0 {
+		for i := range img.Cb {
+			img.Cb[i] = 128
+			img.Cr[i] = 128
+		}
+		return img, nil
+	}
+
+	n += copy(img.Cb, b[n:n+len(img.Cb)])
+	copy(img.Cr, b[n:n+len(img.Cr)])
+
+	return img, nil
+}
+
+// decodeYCbCr decodes the image item opts.ItemID, or the primary image, of data to its Y, Cb and Cr planes
+// without a colour conversion. It supports coded and grid items without transformations, or with
+// IgnoreTransformations.
+func decodeYCbCr(ctx context.Context, data []byte, opts *Options) (*image.YCbCr, error) {
+	f, err := isobmff.Parse(data)
+	if err != nil || f.Meta == nil {
+		return nil, errNoPlanes
+	}
+	meta := f.Meta
+
+	id := opts.ItemID
+	if id == 0 {
+		id = meta.PrimaryItemID()
+	}
+
+	e := meta.Item(id)
+	if e == nil || !opts.IgnoreTransformations && hasTransform(meta.ItemProperties(id)) {
+		return nil, errNoPlanes
+	}
+
+	if err := opts.checkSize(itemSize(meta, id)); err != nil {
+		return nil, err
+	}
+
+	switch e.ItemType {
+	case "hvc1":
+		return decodeCodedPlanes(ctx, data, meta, id, opts)
+	case "grid":
+		return decodeGridPlanes(ctx, data, meta, id, opts)
+	}
+
+	return nil, errNoPlanes
+}
+
+// hasTransform reports whether props rotate, mirror or crop the image.
+func hasTransform(props []isobmff.Property) bool {
+	for _, p := range props {
+		switch p := p.(type) {
+		case *isobmff.Rotation:
+			if p.Angle != 0 {
+				return true
+			}
+		case *isobmff.Mirror, *isobmff.CleanAperture:
+			return true
+		}
+	}
+
+	return false
+}
+
+// decodeCodedPlanes decodes a single hvc1 item to its planes, cropped to its ispe size.
+func decodeCodedPlanes(ctx context.Context, data []byte, meta *isobmff.Meta, id uint32, opts *Options) (*image.YCbCr, error) {
+	annexb, err := itemAnnexB(data, meta, id)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := decodePlanes(ctx, annexb, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if ispe, ok := meta.ItemProperty(id, "ispe").(*isobmff.ImageSpatialExtents); ok {
+		r := image.Rect(0, 0, int(ispe.Width), int(ispe.Height))
+		if r.In(img.Rect) && r != img.Rect {
+			img = img.SubImage(r).(*image.YCbCr)
+		}
+	}
+
+	return img, nil
+}
+
+// decodeGridPlanes decodes the tiles of a grid item and places their planes in row-major order. The tiles
+// must share a subsampling and, when subsampled, have even dimensions so that the chroma samples line up.
+func decodeGridPlanes(ctx context.Context, data []byte, meta *isobmff.Meta, id uint32, opts *Options) (*image.YCbCr, error) {
+	desc, err := meta.ReadItem(bytes.NewReader(data), id)
+	if err != nil {
+		return nil, itemError(StageParse, CodeInvalidInput, "item %d: %v", id, err)
+	}
+
+	g, err := parseGrid(desc)
+	if err != nil {
+		return nil, itemError(StageParse, CodeInvalidInput, "item %d: %v", id, err)
+	}
+
+	tiles := meta.References(id, "dimg")
+	if len(tiles) != g.rows*g.columns {
+		return nil, itemError(StageParse, CodeInvalidInput, "item %d: %d tiles for a %dx%d grid", id, len(tiles), g.columns, g.rows)
+	}
+
+	for _, tid := range tiles {
+		if e := meta.Item(tid); e == nil || e.ItemType != "hvc1" || hasTransform(meta.ItemProperties(tid)) {
+			return nil, errNoPlanes
+		}
+	}
+
+	planes := make([]*image.YCbCr, len(tiles))
+	idx := make([]int, len(tiles))
+	for i := range idx {
+		idx[i] = i
+	}
+
+	err = decodeTiles(ctx, idx, opts, func(i int) (err error) {
+		planes[i], err = decodeCodedPlanes(ctx, data, meta, tiles[i], opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var dst *image.YCbCr
+	var tw, th int
+	for i, tile := range planes {
+		if i == 0 {
+			tw, th = tile.Rect.Dx(), tile.Rect.Dy()
+			if g.width > tw*g.columns || g.height > th*g.rows {
+				return nil, itemError(StageParse, CodeInvalidInput, "item %d: %dx%d tiles do not cover %dx%d", id, tw, th, g.width, g.height)
+			}
+			if (tile.SubsampleRatio != image.YCbCrSubsampleRatio444 && tw%2 != 0) || (tile.SubsampleRatio == image.YCbCrSubsampleRatio420 && th%2 != 0) {
+				return nil, errNoPlanes
+			}
+			dst = image.NewYCbCr(image.Rect(0, 0, tw*g.columns, th*g.rows), tile.SubsampleRatio)
+		}
+
+		if tile.SubsampleRatio != dst.SubsampleRatio || tile.Rect.Dx() != tw || tile.Rect.Dy() != th {
+			return nil, errNoPlanes
+		}
+
+		pastePlanes(dst, tile, (i%g.columns)*tw, (i/g.columns)*th)
+	}
+
+	return dst.SubImage(image.Rect(0, 0, g.width, g.height)).(*image.YCbCr), nil
+}
+
+// pastePlanes copies the planes of src into dst with the top-left corner at x, y.
+func pastePlanes(dst, src *image.YCbCr, x, y int) {
+	b := src.Rect
+	w, h := b.Dx(), b.Dy()
+
+	for j := 0; j < h; j++ {
+		si := src.YOffset(b.Min.X, b.Min.Y+j)
+		di := dst.YOffset(x, y+j)
+		copy(dst.Y[di:di+w], src.Y[si:si+w])
+	}
+
+	_, _, cw, ch := yCbCrSize(b, src.SubsampleRatio)
+	for j := 0; j < ch; j++ {
+		si := src.COffset(b.Min.X, b.Min.Y) + j*src.CStride
+		di := dst.COffset(x, y) + j*dst.CStride
+		copy(dst.Cb[di:di+cw], src.Cb[si:si+cw])
+		copy(dst.Cr[di:di+cw], src.Cr[si:si+cw])
+	}
+}
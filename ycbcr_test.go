@@ -0,0 +1,159 @@
+package heic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestNewPlanes(t *testing.T) {
+	// A 3x3 4:2:0 frame has 2x2 chroma planes.
+	b := []byte{
+		1, 2, 3, 4, 5, 6, 7, 8, 9,
+		10, 11, 12, 13,
+		20, 21, 22, 23,
+	}
+	if n := planesSize(3, 3, 1); n != len(b) {
+		t.Fatalf("planes size = %d, want %d", n, len(b))
+	}
+
+	img, err := newPlanes(b, 3, 3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.SubsampleRatio != image.YCbCrSubsampleRatio420 || img.Rect != image.Rect(0, 0, 3, 3) {
+		t.Fatalf("image = %v %v", img.SubsampleRatio, img.Rect)
+	}
+	if c := img.YCbCrAt(2, 2); c.Y != 9 || c.Cb != 13 || c.Cr != 23 {
+		t.Errorf("pixel 2,2 = %v", c)
+	}
+
+	// Monochrome has neutral chroma.
+	img, err = newPlanes(b[:9], 3, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := img.YCbCrAt(1, 1); c.Y != 5 || c.Cb != 128 || c.Cr != 128 {
+		t.Errorf("monochrome pixel = %v", c)
+	}
+
+	if _, err := newPlanes(b[:10], 3, 3, 1); err == nil {
+		t.Error("short planes: no error")
+	}
+	if _, err := newPlanes(b, 3, 3, 7); err == nil {
+		t.Error("chroma format 7: no error")
+	}
+}
+
+func TestPastePlanes(t *testing.T) {
+	dst := image.NewYCbCr(image.Rect(0, 0, 8, 4), image.YCbCrSubsampleRatio420)
+
+	tile := image.NewYCbCr(image.Rect(0, 0, 4, 4), image.YCbCrSubsampleRatio420)
+	for i := range tile.Y {
+		tile.Y[i] = 1
+	}
+	for i := range tile.Cb {
+		tile.Cb[i], tile.Cr[i] = 2, 3
+	}
+
+	pastePlanes(dst, tile, 4, 0)
+
+	if c := dst.YCbCrAt(0, 0); c.Y != 0 || c.Cb != 0 {
+		t.Errorf("left = %v", c)
+	}
+	if c := dst.YCbCrAt(7, 3); c.Y != 1 || c.Cb != 2 || c.Cr != 3 {
+		t.Errorf("right = %v", c)
+	}
+}
+
+func TestToYCbCr(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for i := 0; i < len(src.Pix); i += 4 {
+		copy(src.Pix[i:], []byte{255, 0, 0, 255})
+	}
+	src.SetNRGBA(1, 0, color.NRGBA{0, 0, 255, 255})
+
+	img := toYCbCr(src, image.YCbCrSubsampleRatio420)
+	if img.SubsampleRatio != image.YCbCrSubsampleRatio420 || len(img.Cb) != 2 {
+		t.Fatalf("image = %v with %d chroma samples", img.SubsampleRatio, len(img.Cb))
+	}
+
+	// The first chroma sample averages three red pixels and a blue one, the second covers two red pixels.
+	_, rcb, rcr := color.RGBToYCbCr(255, 0, 0)
+	_, bcb, bcr := color.RGBToYCbCr(0, 0, 255)
+	if cb, cr := int(img.Cb[0]), int(img.Cr[0]); cb != (3*int(rcb)+int(bcb)+2)/4 || cr != (3*int(rcr)+int(bcr)+2)/4 {
+		t.Errorf("chroma = %d, %d", cb, cr)
+	}
+	if img.Cb[1] != rcb || img.Cr[1] != rcr {
+		t.Errorf("edge chroma = %d, %d", img.Cb[1], img.Cr[1])
+	}
+}
+
+func TestDecodeYCbCr(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+		rect image.Rectangle
+	}{
+		{"grid", testHeic, image.Rect(0, 0, 1346, 1346)},
+		{"coded", testHeic8, image.Rect(0, 0, 512, 512)},
+		{"rotated", testHeicExif, image.Rect(0, 0, 480, 640)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			img, err := DecodeWithOptions(bytes.NewReader(tc.data), &Options{Backend: BackendWASM, Format: FormatYCbCr})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			y, ok := img.(*image.YCbCr)
+			if !ok {
+				t.Fatalf("image = %T, want *image.YCbCr", img)
+			}
+			if y.Rect.Size() != tc.rect.Size() {
+				t.Errorf("bounds = %v, want %v", y.Rect, tc.rect)
+			}
+
+			if y.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+				t.Errorf("subsample ratio = %v, want 4:2:0", y.SubsampleRatio)
+			}
+		})
+	}
+}
+
+func TestDecodeYCbCrPlanes(t *testing.T) {
+	if _, err := decodeYCbCr(context.Background(), testHeic8, &Options{Format: FormatYCbCr}); errors.Is(err, errNoPlanes) {
+		t.Skip("the embedded module does not export decode_planes; rebuild it with make -C lib")
+	}
+
+	requireDynamic(t)
+
+	img, err := DecodeWithOptions(bytes.NewReader(testHeic8), &Options{Backend: BackendWASM, Format: FormatYCbCr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := DecodeWithOptions(bytes.NewReader(testHeic8), &Options{Backend: BackendDynamic, Format: FormatYCbCr})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The decoded planes are returned as they are, so they match libheif's samples exactly; a conversion
+	// from RGBA would not.
+	got, ok := img.(*image.YCbCr)
+	w, wok := want.(*image.YCbCr)
+	if !ok || !wok {
+		t.Fatalf("images = %T, %T, want *image.YCbCr", img, want)
+	}
+	if got.SubsampleRatio != w.SubsampleRatio || got.Rect != w.Rect {
+		t.Fatalf("got %v %v, want %v %v", got.SubsampleRatio, got.Rect, w.SubsampleRatio, w.Rect)
+	}
+	for y := got.Rect.Min.Y; y < got.Rect.Max.Y; y++ {
+		for x := got.Rect.Min.X; x < got.Rect.Max.X; x++ {
+			if g, e := got.YCbCrAt(x, y), w.YCbCrAt(x, y); g != e {
+				t.Fatalf("pixel %d,%d = %v, want %v", x, y, g, e)
+			}
+		}
+	}
+}